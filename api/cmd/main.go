@@ -1,16 +1,96 @@
 package main
 
 import (
+	"database/sql"
+	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
+	_ "time/tzdata" // TIMEZONE must resolve in images without a zoneinfo database
 
+	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
+	_ "github.com/lib/pq"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/handler"
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/store"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/connstr"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/cycletime"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/envfile"
 )
 
+// getEnv retrieves an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
 func main() {
+	// Load .env file if it exists (ignored in Docker, where env vars are set directly)
+	_ = godotenv.Load()
+
+	pgPassword, err := envfile.Get("PG_PASSWORD", "postgres")
+	if err != nil {
+		log.Fatalf("failed to load configuration: %v", err)
+	}
+	dsn := connstr.Postgres(getEnv("PG_HOST", "localhost"), getEnv("PG_PORT", "5432"), getEnv("PG_USER", "postgres"),
+		pgPassword, getEnv("PG_DB", "oee"))
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		log.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		log.Fatalf("failed to ping database: %v", err)
+	}
+
+	policy, err := oee.LoadPolicy(os.Getenv)
+	if err != nil {
+		log.Fatalf("failed to load OEE policy: %v", err)
+	}
+	log.Printf("OEE policy: %+v", policy)
+
+	cycleTimes, err := cycletime.Parse(os.Getenv("CYCLE_TIMES"))
+	if err != nil {
+		log.Fatalf("invalid CYCLE_TIMES: %v", err)
+	}
+
+	cacheTTL, err := strconv.ParseFloat(getEnv("OEE_CACHE_TTL", "5"), 64)
+	if err != nil || cacheTTL < 0 {
+		log.Fatalf("invalid OEE_CACHE_TTL: must be a non-negative number of seconds")
+	}
+
+	st := store.New(db)
+	if fromInspections, err := strconv.ParseBool(getEnv("QUALITY_FROM_INSPECTIONS", "false")); err != nil {
+		log.Fatalf("invalid QUALITY_FROM_INSPECTIONS: %v", err)
+	} else if fromInspections {
+		st.GradeByInspection()
+		log.Printf("Grading inspected parts by their latest inspection")
+	}
+
+	location, err := time.LoadLocation(getEnv("TIMEZONE", "Local"))
+	if err != nil {
+		log.Fatalf("invalid TIMEZONE: %v", err)
+	}
+
 	e := echo.New()
 	e.GET("/", func(c echo.Context) error {
 		return c.String(http.StatusOK, "Hello, World!")
 	})
-	
-	e.Logger.Fatal(e.Start(":3001"))
-}
\ No newline at end of file
+	handler.New(st, handler.Options{
+		Policy:      policy,
+		CycleTimes:  cycleTimes,
+		APIToken:    os.Getenv("API_TOKEN"),
+		AdminToken:  os.Getenv("ADMIN_TOKEN"),
+		OEECacheTTL: time.Duration(cacheTTL * float64(time.Second)),
+		Location:    location,
+	}).Register(e)
+
+	e.Logger.Fatal(e.Start(getEnv("API_ADDR", ":3001")))
+}
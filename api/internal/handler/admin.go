@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// rollupBucket is the width of a row in oee_hourly.
+const rollupBucket = time.Hour
+
+// States a rollup rebuild moves through.
+const (
+	rebuildRunning = "running"
+	rebuildDone    = "done"
+	rebuildFailed  = "failed"
+)
+
+// RebuildJob is the progress of one POST /admin/rebuild-rollups request.
+type RebuildJob struct {
+	ID           int        `json:"id"`
+	From         time.Time  `json:"from"`
+	To           time.Time  `json:"to"`
+	State        string     `json:"state"`
+	TotalBuckets int        `json:"total_buckets"`
+	DoneBuckets  int        `json:"done_buckets"`
+	Error        string     `json:"error,omitempty"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+// rebuilds tracks rollup rebuild jobs. Only one runs at a time; finished
+// jobs are kept so their outcome can still be read.
+type rebuilds struct {
+	mu      sync.Mutex
+	jobs    map[int]*RebuildJob
+	nextID  int
+	running bool
+}
+
+// start registers a new running job, or returns false if one is running.
+func (r *rebuilds) start(from, to time.Time, total int) (RebuildJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running {
+		return RebuildJob{}, false
+	}
+	if r.jobs == nil {
+		r.jobs = make(map[int]*RebuildJob)
+	}
+	r.nextID++
+	job := &RebuildJob{
+		ID:           r.nextID,
+		From:         from,
+		To:           to,
+		State:        rebuildRunning,
+		TotalBuckets: total,
+		StartedAt:    time.Now().UTC(),
+	}
+	r.jobs[job.ID] = job
+	r.running = true
+	return *job, true
+}
+
+// update applies fn to job id under the lock.
+func (r *rebuilds) update(id int, fn func(*RebuildJob)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(r.jobs[id])
+}
+
+// finish records the outcome of job id and frees the slot for the next one.
+func (r *rebuilds) finish(id int, err error) {
+	r.update(id, func(job *RebuildJob) {
+		now := time.Now().UTC()
+		job.FinishedAt = &now
+		job.State = rebuildDone
+		if err != nil {
+			job.State = rebuildFailed
+			job.Error = err.Error()
+		}
+		r.running = false
+	})
+}
+
+// get returns a copy of job id.
+func (r *rebuilds) get(id int) (RebuildJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok {
+		return RebuildJob{}, false
+	}
+	return *job, true
+}
+
+// RebuildRollups handles POST /admin/rebuild-rollups?from=...&to=... It
+// recomputes oee_hourly for every machine over the hours touching [from, to)
+// in the background and answers 202 with the job to poll.
+func (h *Handler) RebuildRollups(c echo.Context) error {
+	from, to, err := windowParams(c)
+	if err != nil {
+		return err
+	}
+	from = from.Truncate(rollupBucket)
+	if t := to.Truncate(rollupBucket); t.Before(to) {
+		to = t.Add(rollupBucket)
+	}
+
+	machines, err := h.store.Machines(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	buckets := int(to.Sub(from) / rollupBucket)
+	job, ok := h.rebuilds.start(from, to, buckets*len(machines))
+	if !ok {
+		return echo.NewHTTPError(http.StatusConflict, "a rollup rebuild is already running")
+	}
+
+	// The job outlives the request, so it must not use its context.
+	go func() {
+		err := h.rebuildRollups(context.Background(), job.ID, machines, from, to)
+		if err != nil {
+			log.Printf("rollup rebuild %d failed: %v", job.ID, err)
+		} else {
+			log.Printf("rollup rebuild %d finished: %d buckets", job.ID, job.TotalBuckets)
+		}
+		h.rebuilds.finish(job.ID, err)
+	}()
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// rebuildRollups recomputes and upserts each machine's hourly OEE from raw
+// events, bumping the job's progress after every bucket.
+func (h *Handler) rebuildRollups(ctx context.Context, jobID int, keys []machineid.Key, from, to time.Time) error {
+	for _, key := range keys {
+		machine, err := h.store.Machine(ctx, key)
+		if err != nil {
+			return err
+		}
+		for bucket := from; bucket.Before(to); bucket = bucket.Add(rollupBucket) {
+			end := bucket.Add(rollupBucket)
+			totals, err := h.store.ProductionTotals(ctx, key, bucket, end)
+			if err != nil {
+				return err
+			}
+			result, err := h.calculate(ctx, machine, oee.Interval{Start: bucket, End: end}, totals, h.policy)
+			if err != nil {
+				return err
+			}
+			if err := h.store.UpsertHourly(ctx, key, bucket, result); err != nil {
+				return err
+			}
+			h.rebuilds.update(jobID, func(job *RebuildJob) { job.DoneBuckets++ })
+		}
+	}
+	return nil
+}
+
+// GetRebuildJob handles GET /admin/rebuild-rollups/:id
+func (h *Handler) GetRebuildJob(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "id must be an integer")
+	}
+	job, ok := h.rebuilds.get(id)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "not found")
+	}
+	return c.JSON(http.StatusOK, job)
+}
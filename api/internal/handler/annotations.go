@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/store"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// downtimeID identifies the stop machine entered at start, by the status
+// event that started it: "<machine>@<RFC 3339 time>", with the machine as
+// machineid.Key writes it, e.g. "1@2025-11-05T10:00:00.123456Z" or
+// "plant-a:1@2025-11-05T10:00:00.123456Z".
+func downtimeID(machine machineid.Key, start time.Time) string {
+	return machine.String() + "@" + start.UTC().Format(time.RFC3339Nano)
+}
+
+// parseDowntimeID reads an id made by downtimeID.
+func parseDowntimeID(id string) (machineid.Key, time.Time, error) {
+	const format = "id must be [<site>:]<machine_id>@<RFC 3339 start time>"
+	i := strings.LastIndex(id, "@")
+	if i < 0 {
+		return machineid.Key{}, time.Time{}, echo.NewHTTPError(http.StatusBadRequest, format)
+	}
+	machine, err := machineid.Parse(id[:i])
+	if err != nil {
+		return machineid.Key{}, time.Time{}, echo.NewHTTPError(http.StatusBadRequest, format)
+	}
+	start, err := time.Parse(time.RFC3339Nano, id[i+1:])
+	if err != nil {
+		return machineid.Key{}, time.Time{}, echo.NewHTTPError(http.StatusBadRequest, format)
+	}
+	return machine, start, nil
+}
+
+// annotateDowntimeRequest is the body accepted by POST /downtime/:id/annotate.
+type annotateDowntimeRequest struct {
+	Reason  string `json:"reason"`
+	Comment string `json:"comment"`
+	Planned *bool  `json:"planned"`
+}
+
+// AnnotateDowntime handles POST /downtime/:id/annotate.
+//
+// It records an operator's confirmed reason, comment and planned or
+// unplanned classification for the stop with the given id, as listed by
+// GET /timeline, replacing any earlier annotation of it. The id must name
+// a status event the machine was stopped in.
+func (h *Handler) AnnotateDowntime(c echo.Context) error {
+	machine, start, err := parseDowntimeID(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	var req annotateDowntimeRequest
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	req.Reason, req.Comment = strings.TrimSpace(req.Reason), strings.TrimSpace(req.Comment)
+	if req.Reason == "" && req.Comment == "" && req.Planned == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "reason, comment or planned is required")
+	}
+
+	ctx := c.Request().Context()
+	status, err := h.store.StatusAt(ctx, machine, start)
+	if err != nil {
+		return storeError(err)
+	}
+	if status == oee.StatusRunning {
+		return echo.NewHTTPError(http.StatusBadRequest, "the machine was running, not down, at that time")
+	}
+	a := store.DowntimeAnnotation{
+		Site:      machine.Site,
+		MachineID: machine.ID,
+		StartTime: start.UTC(),
+		Reason:    req.Reason,
+		Comment:   req.Comment,
+		Planned:   req.Planned,
+	}
+	if err := h.store.AnnotateDowntime(ctx, &a); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, a)
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+const (
+	maxBatchMachines = 500
+	// batchWorkers is how many machines of a batch are computed at once,
+	// so a large batch doesn't take every database connection.
+	batchWorkers = 8
+)
+
+// batchOEERequest is the body of POST /oee/batch.
+type batchOEERequest struct {
+	MachineIDs []int `json:"machine_ids"`
+	// Site is the site the machine IDs are reported at, as with GET /oee.
+	Site string `json:"site"`
+	// From and To are RFC 3339 timestamps, defaulting as on GET /oee.
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// BatchOEEResult is the OEE of one machine of a batch, or why it couldn't
+// be computed.
+type BatchOEEResult struct {
+	MachineID int `json:"machine_id"`
+	// Status is the status GET /oee would have answered for the machine.
+	Status int          `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	OEE    *OEEResponse `json:"oee,omitempty"`
+}
+
+// BatchOEEResponse is the body returned by POST /oee/batch.
+type BatchOEEResponse struct {
+	From    time.Time        `json:"from"`
+	To      time.Time        `json:"to"`
+	Results []BatchOEEResult `json:"results"`
+}
+
+// BatchOEE handles POST /oee/batch with a body such as
+// {"machine_ids": [1, 2, 3], "from": "...", "to": "..."}.
+//
+// It computes GET /oee for each machine over the shared window, a few at
+// a time, and answers the results in request order. A machine that fails,
+// say because it is unknown, gets the status and message GET /oee would
+// have answered in its result rather than failing the batch. The
+// micro_stop_threshold and planned_seconds query parameters apply to every
+// machine, and the results share GET /oee's cache.
+func (h *Handler) BatchOEE(c echo.Context) error {
+	var req batchOEERequest
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if len(req.MachineIDs) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "machine_ids is required")
+	}
+	if len(req.MachineIDs) > maxBatchMachines {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("machine_ids may list at most %d machines", maxBatchMachines))
+	}
+	from, to, err := parseWindow(req.From, req.To, "from", "to", time.Now().UTC(), defaultWindow)
+	if err != nil {
+		return err
+	}
+	policy, err := h.policyParams(c)
+	if err != nil {
+		return err
+	}
+	planned, err := plannedSecondsParam(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	window := oee.Interval{Start: from, End: to}
+	results := make([]BatchOEEResult, len(req.MachineIDs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(batchWorkers, len(req.MachineIDs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				key := oeeCacheKey{machine: machineid.Key{Site: req.Site, ID: req.MachineIDs[i]}, from: req.From, to: req.To, microStopThreshold: policy.MicroStopThreshold, plannedTime: planned}
+				resp, err := h.machineOEE(ctx, key, window, policy)
+				results[i] = batchResult(req.MachineIDs[i], resp, err)
+			}
+		}()
+	}
+	for i := range req.MachineIDs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return c.JSON(http.StatusOK, BatchOEEResponse{From: from, To: to, Results: results})
+}
+
+// batchResult returns the result of the machine reported as machineID,
+// which GET /oee would have answered with resp or err. Errors other than
+// HTTP errors are logged and reported as internal, as the server does for
+// a single request.
+func batchResult(machineID int, resp OEEResponse, err error) BatchOEEResult {
+	if err == nil {
+		return BatchOEEResult{MachineID: machineID, Status: http.StatusOK, OEE: &resp}
+	}
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return BatchOEEResult{MachineID: machineID, Status: he.Code, Error: fmt.Sprint(he.Message)}
+	}
+	log.Printf("batch OEE for machine %d failed: %v", machineID, err)
+	return BatchOEEResult{MachineID: machineID, Status: http.StatusInternalServerError, Error: http.StatusText(http.StatusInternalServerError)}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// Lookups in the GET /oee cache.
+var (
+	oeeCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "oee_api_oee_cache_hits_total",
+		Help: "GET /oee requests answered from the cache.",
+	})
+	oeeCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "oee_api_oee_cache_misses_total",
+		Help: "GET /oee requests computed because the cache had no fresh response.",
+	})
+)
+
+// oeeCacheKey identifies a GET /oee response. The window is kept as given,
+// so requests that leave "to" at now share a response for as long as it
+// is fresh.
+type oeeCacheKey struct {
+	machine            machineid.Key
+	from, to           string
+	microStopThreshold time.Duration
+	plannedTime        time.Duration
+}
+
+type cachedOEE struct {
+	resp    OEEResponse
+	expires time.Time
+}
+
+// oeeCache holds GET /oee responses for a short TTL, so the panels of a
+// dashboard polling the same machines share one calculation each instead
+// of each querying the database. Entries only expire: the API doesn't see
+// events arrive, so a response can be up to the TTL behind them. A nil
+// cache caches nothing.
+type oeeCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[oeeCacheKey]cachedOEE
+}
+
+// newOEECache returns a cache keeping responses for ttl, or nil if ttl is
+// not positive.
+func newOEECache(ttl time.Duration) *oeeCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &oeeCache{ttl: ttl, entries: map[oeeCacheKey]cachedOEE{}}
+}
+
+// get returns the fresh response for key, if there is one.
+func (c *oeeCache) get(key oeeCacheKey) (OEEResponse, bool) {
+	if c == nil {
+		return OEEResponse{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		oeeCacheMisses.Inc()
+		return OEEResponse{}, false
+	}
+	oeeCacheHits.Inc()
+	return e.resp, true
+}
+
+// put stores resp for key, and drops the responses that have expired so
+// the cache only grows with the windows being polled.
+func (c *oeeCache) put(key oeeCacheKey, resp OEEResponse) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedOEE{resp: resp, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+)
+
+// CompareResponse is the body returned by GET /oee/compare.
+type CompareResponse struct {
+	Site                  string        `json:"site,omitempty"`
+	MachineID             int           `json:"machine_id"`
+	MicroStopThresholdSec float64       `json:"micro_stop_threshold_sec"`
+	A                     CompareWindow `json:"a"`
+	B                     CompareWindow `json:"b"`
+	// Delta is B less A; null unless both windows have production.
+	Delta *OEEDelta `json:"delta"`
+}
+
+// CompareWindow is one side of a comparison. A window without production
+// carries no result, since its zero ratios would read as a real drop.
+type CompareWindow struct {
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+	HasProduction bool      `json:"has_production"`
+	*oee.Result
+}
+
+// OEEDelta is the change in each ratio from one window to another; positive
+// is an improvement.
+type OEEDelta struct {
+	Availability   float64 `json:"availability"`
+	Performance    float64 `json:"performance"`
+	Quality        float64 `json:"quality"`
+	OEE            float64 `json:"oee"`
+	FirstPassYield float64 `json:"first_pass_yield"`
+	FinalYield     float64 `json:"final_yield"`
+}
+
+// CompareOEE handles GET /oee/compare?machine_id=1&a_from=...&a_to=...&b_from=...&b_to=...
+//
+// Window b defaults like the window of GET /oee, and window a to one of the
+// same length ending where b starts, so without any times it compares the
+// last 24 hours with the 24 hours before. micro_stop_threshold applies to
+// both.
+func (h *Handler) CompareOEE(c echo.Context) error {
+	policy, err := h.policyParams(c)
+	if err != nil {
+		return err
+	}
+	key, err := machineParam(c)
+	if err != nil {
+		return err
+	}
+	bFrom, bTo, err := namedWindowParams(c, "b_from", "b_to", time.Now().UTC(), defaultWindow)
+	if err != nil {
+		return err
+	}
+	aFrom, aTo, err := namedWindowParams(c, "a_from", "a_to", bFrom, bTo.Sub(bFrom))
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	machine, err := h.store.Machine(ctx, key)
+	if err != nil {
+		return storeError(err)
+	}
+	resp := CompareResponse{
+		Site:                  key.Site,
+		MachineID:             key.ID,
+		MicroStopThresholdSec: policy.MicroStopThreshold.Seconds(),
+	}
+	for _, w := range []struct {
+		out      *CompareWindow
+		from, to time.Time
+	}{{&resp.A, aFrom, aTo}, {&resp.B, bFrom, bTo}} {
+		*w.out = CompareWindow{From: w.from, To: w.to}
+		totals, err := h.store.ProductionTotals(ctx, key, w.from, w.to)
+		if err != nil {
+			return err
+		}
+		if totals.Good+totals.Reworked+totals.Scrapped == 0 {
+			continue
+		}
+		result, err := h.calculate(ctx, machine, oee.Interval{Start: w.from, End: w.to}, totals, policy)
+		if err != nil {
+			return err
+		}
+		w.out.HasProduction, w.out.Result = true, &result
+	}
+
+	if a, b := resp.A.Result, resp.B.Result; a != nil && b != nil {
+		resp.Delta = &OEEDelta{
+			Availability:   b.Availability - a.Availability,
+			Performance:    b.Performance - a.Performance,
+			Quality:        b.Quality - a.Quality,
+			OEE:            b.OEE - a.OEE,
+			FirstPassYield: b.FirstPassYield - a.FirstPassYield,
+			FinalYield:     b.FinalYield - a.FinalYield,
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/store"
+)
+
+// DimensionsResponse is the body returned by GET /dimensions. From and To
+// are omitted when the values cover all the data.
+type DimensionsResponse struct {
+	From *time.Time `json:"from,omitempty"`
+	To   *time.Time `json:"to,omitempty"`
+	store.Dimensions
+}
+
+// GetDimensions handles GET /dimensions?from=...&to=..., the machines,
+// sites and products to offer as filters. Without from and to it covers all
+// the data; with either, the window defaults as for /oee.
+func (h *Handler) GetDimensions(c echo.Context) error {
+	var window *oee.Interval
+	var resp DimensionsResponse
+	if c.QueryParam("from") != "" || c.QueryParam("to") != "" {
+		from, to, err := windowParams(c)
+		if err != nil {
+			return err
+		}
+		window = &oee.Interval{Start: from, End: to}
+		resp.From, resp.To = &from, &to
+	}
+
+	d, err := h.store.Dimensions(c.Request().Context(), window)
+	if err != nil {
+		return err
+	}
+	resp.Dimensions = d
+	return c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// ParetoResponse is the body returned by GET /downtime/pareto.
+type ParetoResponse struct {
+	Site         string               `json:"site,omitempty"`
+	MachineID    *int                 `json:"machine_id,omitempty"`
+	From         time.Time            `json:"from"`
+	To           time.Time            `json:"to"`
+	Planned      *bool                `json:"planned,omitempty"`
+	RankBy       string               `json:"rank_by"`
+	TotalSeconds float64              `json:"total_seconds"`
+	TotalCount   int                  `json:"total_count"`
+	Reasons      []oee.DowntimeReason `json:"reasons"`
+}
+
+// GetDowntimePareto handles
+// GET /downtime/pareto?machine_id=1&from=...&to=...&planned=false&rank_by=count
+//
+// It ranks downtime reasons over the window for one machine, or for every
+// machine when machine_id is omitted. planned=true keeps only stop time
+// inside planned downtime windows and planned=false only stop time outside
+// them; rank_by is "duration" (default) or "count".
+func (h *Handler) GetDowntimePareto(c echo.Context) error {
+	var machine *machineid.Key
+	if c.QueryParam("machine_id") != "" {
+		key, err := machineParam(c)
+		if err != nil {
+			return err
+		}
+		machine = &key
+	}
+	from, to, err := windowParams(c)
+	if err != nil {
+		return err
+	}
+	var planned *bool
+	if raw := c.QueryParam("planned"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "planned must be true or false")
+		}
+		planned = &v
+	}
+	rankBy := c.QueryParam("rank_by")
+	switch rankBy {
+	case "":
+		rankBy = "duration"
+	case "duration", "count":
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "rank_by must be duration or count")
+	}
+	ctx := c.Request().Context()
+
+	history, err := h.store.StatusHistory(ctx, machine, from, to)
+	if err != nil {
+		return err
+	}
+	window := oee.Interval{Start: from, End: to}
+	var stops []oee.Stop
+	for key, changes := range history {
+		machineStops := oee.Stops(changes, window)
+		if planned != nil {
+			windows, err := h.store.ListPlannedDowntime(ctx, key, from, to)
+			if err != nil {
+				return err
+			}
+			intervals := make([]oee.Interval, 0, len(windows))
+			for _, pd := range windows {
+				intervals = append(intervals, oee.Interval{Start: pd.StartTime, End: pd.EndTime})
+			}
+			inside, outside := oee.SplitPlanned(machineStops, intervals)
+			machineStops = outside
+			if *planned {
+				machineStops = inside
+			}
+		}
+		stops = append(stops, machineStops...)
+	}
+
+	resp := ParetoResponse{
+		From:       from,
+		To:         to,
+		Planned:    planned,
+		RankBy:     rankBy,
+		TotalCount: len(stops),
+		Reasons:    oee.Pareto(stops, rankBy == "count"),
+	}
+	if machine != nil {
+		resp.Site, resp.MachineID = machine.Site, &machine.ID
+	}
+	for _, st := range stops {
+		resp.TotalSeconds += st.Duration().Seconds()
+	}
+	return c.JSON(http.StatusOK, resp)
+}
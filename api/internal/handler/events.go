@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/store"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// Page size limits for the event-listing endpoints.
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// EventPage is the body returned by the event-listing endpoints. Next is the
+// cursor for the following page and is empty on the last page.
+type EventPage[T any] struct {
+	Events []T    `json:"events"`
+	Next   string `json:"next,omitempty"`
+}
+
+// ListStatusEvents handles GET /events/status?machine_id=1&after=...&limit=N
+func (h *Handler) ListStatusEvents(c echo.Context) error {
+	machine, after, limit, err := pageParams(c)
+	if err != nil {
+		return err
+	}
+	// Fetch one extra row to learn whether another page exists.
+	events, err := h.store.ListStatusEvents(c.Request().Context(), machine, after, limit+1)
+	if err != nil {
+		return err
+	}
+	page := EventPage[store.StatusEvent]{Events: events}
+	if len(events) > limit {
+		page.Events = events[:limit]
+		last := page.Events[limit-1]
+		page.Next = formatCursor(store.Cursor{Time: last.Time, Machine: machineid.Key{Site: last.Site, ID: last.MachineID}})
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// ListProductionEvents handles GET /events/production?machine_id=1&after=...&limit=N
+func (h *Handler) ListProductionEvents(c echo.Context) error {
+	machine, after, limit, err := pageParams(c)
+	if err != nil {
+		return err
+	}
+	events, err := h.store.ListProductionEvents(c.Request().Context(), machine, after, limit+1)
+	if err != nil {
+		return err
+	}
+	page := EventPage[store.ProductionEvent]{Events: events}
+	if len(events) > limit {
+		page.Events = events[:limit]
+		last := page.Events[limit-1]
+		page.Next = formatCursor(store.Cursor{Time: last.Time, Machine: machineid.Key{Site: last.Site, ID: last.MachineID}})
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// pageParams reads the optional machine_id and site filter, the "after"
+// cursor and the page size.
+func pageParams(c echo.Context) (machine *machineid.Key, after store.Cursor, limit int, err error) {
+	if c.QueryParam("machine_id") != "" {
+		key, err := machineParam(c)
+		if err != nil {
+			return nil, after, 0, err
+		}
+		machine = &key
+	}
+
+	if raw := c.QueryParam("after"); raw != "" {
+		if after, err = parseCursor(raw); err != nil {
+			return nil, after, 0, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	limit = defaultPageSize
+	if raw := c.QueryParam("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return nil, after, 0, echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+		}
+	}
+	return machine, after, limit, nil
+}
+
+// parseCursor parses a "<RFC 3339 time>,<machine>" cursor, with the
+// machine as machineid.Key writes it.
+func parseCursor(raw string) (store.Cursor, error) {
+	ts, key, ok := strings.Cut(raw, ",")
+	if !ok {
+		return store.Cursor{}, fmt.Errorf("after must be <time>,<machine>")
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return store.Cursor{}, fmt.Errorf("after: invalid time %q", ts)
+	}
+	machine, err := machineid.Parse(key)
+	if err != nil {
+		return store.Cursor{}, fmt.Errorf("after: %w", err)
+	}
+	return store.Cursor{Time: t, Machine: machine}, nil
+}
+
+// formatCursor is the inverse of parseCursor.
+func formatCursor(c store.Cursor) string {
+	return c.Time.UTC().Format(time.RFC3339Nano) + "," + c.Machine.String()
+}
@@ -0,0 +1,179 @@
+// Package handler contains the HTTP handlers for the OEE API.
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/store"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/cycletime"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/httpauth"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// defaultWindow is used when a request does not specify "from".
+const defaultWindow = 24 * time.Hour
+
+// Options configures a Handler.
+type Options struct {
+	// Policy is the OEE convention reported unless a request overrides it.
+	Policy oee.Policy
+	// CycleTimes overrides machines' ideal cycle time per product.
+	CycleTimes cycletime.Matrix
+	// APIToken is the bearer token required by every route except /healthz
+	// and /admin. Empty leaves them open.
+	APIToken string
+	// AdminToken is the bearer token required by the /admin routes, which
+	// are not mounted when it is empty.
+	AdminToken string
+	// OEECacheTTL is how long GET /oee responses are reused for the same
+	// machine and window; zero computes every request.
+	OEECacheTTL time.Duration
+	// Location is the time zone the shift schedule is in.
+	Location *time.Location
+}
+
+// Handler serves the API routes.
+type Handler struct {
+	store      *store.Store
+	policy     oee.Policy
+	cycleTimes cycletime.Matrix
+	apiToken   string
+	adminToken string
+	rebuilds   rebuilds
+	cache      *oeeCache
+	location   *time.Location
+}
+
+// New returns a Handler backed by s and configured by opts.
+func New(s *store.Store, opts Options) *Handler {
+	return &Handler{
+		store:      s,
+		policy:     opts.Policy,
+		cycleTimes: opts.CycleTimes,
+		apiToken:   opts.APIToken,
+		adminToken: opts.AdminToken,
+		cache:      newOEECache(opts.OEECacheTTL),
+		location:   opts.Location,
+	}
+}
+
+// Register mounts all routes on e.
+func (h *Handler) Register(e *echo.Echo) {
+	e.GET("/healthz", echo.WrapHandler(http.HandlerFunc(httpauth.Healthz)))
+
+	api := e.Group("", RequireToken(h.apiToken))
+	api.GET("/oee", h.GetOEE)
+	api.POST("/oee/batch", h.BatchOEE)
+	api.GET("/oee/trend", h.GetOEETrend)
+	api.GET("/oee/losses", h.GetOEELosses)
+	api.GET("/oee/compare", h.CompareOEE)
+	api.GET("/oee/worst", h.GetWorstOEE)
+	api.GET("/oee/by-shift", h.GetOEEByShift)
+	api.GET("/downtime/pareto", h.GetDowntimePareto)
+	api.POST("/downtime/:id/annotate", h.AnnotateDowntime)
+	api.GET("/timeline", h.GetTimeline)
+	api.GET("/metrics/live", h.GetLiveMetrics)
+	api.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+	api.GET("/dimensions", h.GetDimensions)
+	api.GET("/parts/:serial", h.GetPart)
+
+	api.GET("/events/status", h.ListStatusEvents)
+	api.GET("/events/production", h.ListProductionEvents)
+
+	api.GET("/planned-downtime", h.ListPlannedDowntime)
+	api.POST("/planned-downtime", h.CreatePlannedDowntime)
+	api.DELETE("/planned-downtime/:id", h.DeletePlannedDowntime)
+
+	// Admin routes check their own token instead of the API one.
+	if h.adminToken != "" {
+		admin := e.Group("/admin", RequireToken(h.adminToken))
+		admin.POST("/rebuild-rollups", h.RebuildRollups)
+		admin.GET("/rebuild-rollups/:id", h.GetRebuildJob)
+	}
+}
+
+// RequireToken returns middleware that answers 401 to requests without
+// "Authorization: Bearer <token>". An empty token lets every request through,
+// so it can be applied unconditionally to a group or a single route.
+func RequireToken(token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if token == "" {
+			return next
+		}
+		return func(c echo.Context) error {
+			if !httpauth.Authorized(c.Request(), token) {
+				httpauth.Challenge(c.Response())
+				return echo.ErrUnauthorized
+			}
+			return next(c)
+		}
+	}
+}
+
+// machineParam reads the required machine_id query parameter and the
+// optional site one, which defaults to the site of machines that don't
+// report one.
+func machineParam(c echo.Context) (machineid.Key, error) {
+	raw := c.QueryParam("machine_id")
+	if raw == "" {
+		return machineid.Key{}, echo.NewHTTPError(http.StatusBadRequest, "machine_id is required")
+	}
+	id, err := strconv.Atoi(raw)
+	if err != nil {
+		return machineid.Key{}, echo.NewHTTPError(http.StatusBadRequest, "machine_id must be an integer")
+	}
+	return machineid.Key{Site: c.QueryParam("site"), ID: id}, nil
+}
+
+// windowParams reads the optional RFC 3339 "from" and "to" query parameters.
+// "to" defaults to now and "from" to defaultWindow before "to".
+func windowParams(c echo.Context) (from, to time.Time, err error) {
+	return namedWindowParams(c, "from", "to", time.Now().UTC(), defaultWindow)
+}
+
+// namedWindowParams reads a window from the optional RFC 3339 query
+// parameters fromName and toName. toName defaults to defaultTo and fromName
+// to length before the end of the window.
+func namedWindowParams(c echo.Context, fromName, toName string, defaultTo time.Time, length time.Duration) (from, to time.Time, err error) {
+	return parseWindow(c.QueryParam(fromName), c.QueryParam(toName), fromName, toName, defaultTo, length)
+}
+
+// parseWindow parses a window from the optional RFC 3339 timestamps rawFrom
+// and rawTo, named fromName and toName in errors. to defaults to defaultTo
+// and from to length before the end of the window.
+func parseWindow(rawFrom, rawTo, fromName, toName string, defaultTo time.Time, length time.Duration) (from, to time.Time, err error) {
+	to = defaultTo
+	if rawTo != "" {
+		if to, err = time.Parse(time.RFC3339, rawTo); err != nil {
+			return from, to, echo.NewHTTPError(http.StatusBadRequest, toName+" must be an RFC 3339 timestamp")
+		}
+	}
+	from = to.Add(-length)
+	if rawFrom != "" {
+		if from, err = time.Parse(time.RFC3339, rawFrom); err != nil {
+			return from, to, echo.NewHTTPError(http.StatusBadRequest, fromName+" must be an RFC 3339 timestamp")
+		}
+	}
+	if !to.After(from) {
+		return from, to, echo.NewHTTPError(http.StatusBadRequest, toName+" must be after "+fromName)
+	}
+	return from, to, nil
+}
+
+// storeError maps store errors onto HTTP errors.
+func storeError(err error) error {
+	if errors.Is(err, store.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "not found")
+	}
+	if errors.Is(err, store.ErrAmbiguous) {
+		return echo.NewHTTPError(http.StatusBadRequest, "matches several machines; pass machine_id")
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/store"
+)
+
+const (
+	defaultLiveWindow = 15 * time.Minute
+	maxLiveWindow     = 24 * time.Hour
+)
+
+// LiveResponse is the body returned by GET /metrics/live.
+type LiveResponse struct {
+	Site      string    `json:"site,omitempty"`
+	MachineID int       `json:"machine_id"`
+	WindowSec float64   `json:"window_sec"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	// Status is the state the machine is in now and StatusSince when it
+	// entered it; both are omitted if it never reported a status.
+	Status      string     `json:"status,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	StatusSince *time.Time `json:"status_since,omitempty"`
+	// PlannedDowntime is the planned downtime window the machine is in
+	// now, if any, so alerting that polls this can hold off during
+	// scheduled maintenance.
+	PlannedDowntime *store.PlannedDowntime `json:"planned_downtime,omitempty"`
+	Availability    float64                `json:"availability"`
+	Performance     float64                `json:"performance"`
+	Quality         float64                `json:"quality"`
+	OEE             float64                `json:"oee"`
+	TotalCount      int                    `json:"total_count"`
+}
+
+// GetLiveMetrics handles GET /metrics/live?machine_id=1&window=15m.
+//
+// It reports the three OEE factors over a trailing window ending now, for
+// gauges that poll every few seconds. It reads the raw events, so the
+// current state counts up to the moment of the request, and every query is
+// a range scan of the window on the (site, machine_id, time) indexes. An idle
+// machine costs no more than a busy one: the state it is idling in is a
+// single index lookup however long ago it began, and so is the planned
+// downtime window it is in, if any.
+func (h *Handler) GetLiveMetrics(c echo.Context) error {
+	key, err := machineParam(c)
+	if err != nil {
+		return err
+	}
+	window := defaultLiveWindow
+	if raw := c.QueryParam("window"); raw != "" {
+		if window, err = time.ParseDuration(raw); err != nil || window <= 0 || window > maxLiveWindow {
+			return echo.NewHTTPError(http.StatusBadRequest, "window must be a duration such as 15m, up to 24h")
+		}
+	}
+	policy, err := h.policyParams(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	machine, err := h.store.Machine(ctx, key)
+	if err != nil {
+		return storeError(err)
+	}
+	to := time.Now().UTC()
+	from := to.Add(-window)
+	totals, err := h.store.ProductionTotals(ctx, key, from, to)
+	if err != nil {
+		return err
+	}
+	result, err := h.calculate(ctx, machine, oee.Interval{Start: from, End: to}, totals, policy)
+	if err != nil {
+		return err
+	}
+
+	resp := LiveResponse{
+		Site:         key.Site,
+		MachineID:    key.ID,
+		WindowSec:    window.Seconds(),
+		From:         from,
+		To:           to,
+		Availability: result.Availability,
+		Performance:  result.Performance,
+		Quality:      result.Quality,
+		OEE:          result.OEE,
+		TotalCount:   result.TotalCount,
+	}
+	current, err := h.store.CurrentStatus(ctx, key, to)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+	case err != nil:
+		return err
+	default:
+		resp.Status, resp.Reason, resp.StatusSince = current.Status, current.EffectiveReason(), &current.Time
+	}
+	planned, err := h.store.ActivePlannedDowntime(ctx, key, to)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+	case err != nil:
+		return err
+	default:
+		resp.PlannedDowntime = &planned
+	}
+	return c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+)
+
+// LossesResponse is the body returned by GET /oee/losses.
+type LossesResponse struct {
+	Site                  string    `json:"site,omitempty"`
+	MachineID             int       `json:"machine_id"`
+	From                  time.Time `json:"from"`
+	To                    time.Time `json:"to"`
+	MicroStopThresholdSec float64   `json:"micro_stop_threshold_sec"`
+	oee.Losses
+}
+
+// GetOEELosses handles GET /oee/losses?machine_id=1&from=...&to=...
+//
+// It reports the minutes lost to availability, performance and quality over
+// the window, under the same conventions and micro_stop_threshold override
+// as GET /oee, each broken down by what it was lost to.
+func (h *Handler) GetOEELosses(c echo.Context) error {
+	policy, err := h.policyParams(c)
+	if err != nil {
+		return err
+	}
+	key, err := machineParam(c)
+	if err != nil {
+		return err
+	}
+	from, to, err := windowParams(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	machine, err := h.store.Machine(ctx, key)
+	if err != nil {
+		return storeError(err)
+	}
+	totals, err := h.store.ProductionTotals(ctx, key, from, to)
+	if err != nil {
+		return err
+	}
+	window := oee.Interval{Start: from, End: to}
+	in, err := h.input(ctx, machine, window, totals)
+	if err != nil {
+		return err
+	}
+	history, err := h.store.StatusHistory(ctx, &key, from, to)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, LossesResponse{
+		Site:                  key.Site,
+		MachineID:             key.ID,
+		From:                  from,
+		To:                    to,
+		MicroStopThresholdSec: policy.MicroStopThreshold.Seconds(),
+		Losses:                oee.AttributeLosses(in, policy, oee.Stops(history[key], window)),
+	})
+}
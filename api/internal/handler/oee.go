@@ -0,0 +1,273 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/store"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// OEEResponse is the body returned by GET /oee.
+type OEEResponse struct {
+	Site      string `json:"site,omitempty"`
+	MachineID int    `json:"machine_id"`
+	LotID     string `json:"lot_id,omitempty"`
+	// MicroStopThresholdSec is the cutoff the split was computed with.
+	MicroStopThresholdSec float64 `json:"micro_stop_threshold_sec"`
+	oee.Result
+	// HasProduction is false when no parts were made in the window, as on
+	// an availability-only machine. Performance and quality have nothing to
+	// be measured by then, so they and OEE are null, overriding the result's
+	// zeros, and availability stands alone.
+	HasProduction bool     `json:"has_production"`
+	Performance   *float64 `json:"performance"`
+	Quality       *float64 `json:"quality"`
+	OEE           *float64 `json:"oee"`
+	// Warnings are problems with the request that didn't stop the
+	// calculation, such as a planned_seconds shorter than the run time.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// newOEEResponse returns the GET /oee body for result.
+func newOEEResponse(machine machineid.Key, policy oee.Policy, result oee.Result) OEEResponse {
+	resp := OEEResponse{
+		Site:                  machine.Site,
+		MachineID:             machine.ID,
+		MicroStopThresholdSec: policy.MicroStopThreshold.Seconds(),
+		Result:                result,
+	}
+	if result.TotalCount > 0 {
+		resp.HasProduction = true
+		resp.Performance, resp.Quality, resp.OEE = &result.Performance, &result.Quality, &result.OEE
+	}
+	return resp
+}
+
+// GetOEE handles GET /oee?machine_id=1&from=...&to=... and the per-lot
+// variant GET /oee?lot_id=... Both accept micro_stop_threshold (seconds) to
+// override the policy's micro-stop cutoff for this request, and
+// planned_seconds to replace the planned production time.
+func (h *Handler) GetOEE(c echo.Context) error {
+	policy, err := h.policyParams(c)
+	if err != nil {
+		return err
+	}
+	planned, err := plannedSecondsParam(c)
+	if err != nil {
+		return err
+	}
+	if lotID := c.QueryParam("lot_id"); lotID != "" {
+		return h.getLotOEE(c, lotID, policy, planned)
+	}
+
+	machine, err := machineParam(c)
+	if err != nil {
+		return err
+	}
+	from, to, err := windowParams(c)
+	if err != nil {
+		return err
+	}
+	key := oeeCacheKey{machine: machine, from: c.QueryParam("from"), to: c.QueryParam("to"), microStopThreshold: policy.MicroStopThreshold, plannedTime: planned}
+	resp, err := h.machineOEE(c.Request().Context(), key, oee.Interval{Start: from, End: to}, policy)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// machineOEE returns the GET /oee body for the machine and window of key,
+// from the cache if it has a fresh one.
+func (h *Handler) machineOEE(ctx context.Context, key oeeCacheKey, window oee.Interval, policy oee.Policy) (OEEResponse, error) {
+	if resp, ok := h.cache.get(key); ok {
+		return resp, nil
+	}
+	machine, err := h.store.Machine(ctx, key.machine)
+	if err != nil {
+		return OEEResponse{}, storeError(err)
+	}
+	totals, err := h.store.ProductionTotals(ctx, key.machine, window.Start, window.End)
+	if err != nil {
+		return OEEResponse{}, err
+	}
+	resp, err := h.plannedOEE(ctx, machine, window, totals, policy, key.plannedTime)
+	if err != nil {
+		return OEEResponse{}, err
+	}
+	h.cache.put(key, resp)
+	return resp, nil
+}
+
+// plannedSecondsParam reads the optional planned_seconds query parameter,
+// a positive number of seconds, returning zero if it is absent.
+func plannedSecondsParam(c echo.Context) (time.Duration, error) {
+	raw := c.QueryParam("planned_seconds")
+	if raw == "" {
+		return 0, nil
+	}
+	sec, err := strconv.ParseFloat(raw, 64)
+	if err != nil || sec <= 0 || math.IsInf(sec, 0) || math.IsNaN(sec) {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "planned_seconds must be a positive number of seconds")
+	}
+	return time.Duration(sec * float64(time.Second)), nil
+}
+
+// plannedOEE computes the GET /oee body for machine over window, with the
+// planned production time replaced by planned if it is positive.
+func (h *Handler) plannedOEE(ctx context.Context, machine store.Machine, window oee.Interval, totals store.ProductionTotals, policy oee.Policy, planned time.Duration) (OEEResponse, error) {
+	in, err := h.input(ctx, machine, window, totals)
+	if err != nil {
+		return OEEResponse{}, err
+	}
+	in.PlannedTime = planned
+	resp := newOEEResponse(machine.Key(), policy, oee.Calculate(in, policy))
+	if planned > 0 && resp.RunSeconds > planned.Seconds() {
+		resp.Warnings = append(resp.Warnings, fmt.Sprintf(
+			"the machine ran for %gs, more than planned_seconds; availability is capped at 1", resp.RunSeconds))
+	}
+	return resp, nil
+}
+
+// policyParams returns the server's OEE policy with any per-request
+// overrides applied. micro_stop_threshold is a non-negative number of
+// seconds; stops shorter than it count as performance loss.
+func (h *Handler) policyParams(c echo.Context) (oee.Policy, error) {
+	policy := h.policy
+	if raw := c.QueryParam("micro_stop_threshold"); raw != "" {
+		sec, err := strconv.ParseFloat(raw, 64)
+		if err != nil || sec < 0 || math.IsInf(sec, 0) || math.IsNaN(sec) {
+			return policy, echo.NewHTTPError(http.StatusBadRequest, "micro_stop_threshold must be a non-negative number of seconds")
+		}
+		policy.MicroStopThreshold = time.Duration(sec * float64(time.Second))
+	}
+	return policy, nil
+}
+
+// getLotOEE reports OEE for a single lot. The window runs from the start of
+// the lot's first cycle to its last part, and only the lot's parts count.
+func (h *Handler) getLotOEE(c echo.Context, lotID string, policy oee.Policy, planned time.Duration) error {
+	var key *machineid.Key
+	if c.QueryParam("machine_id") != "" {
+		k, err := machineParam(c)
+		if err != nil {
+			return err
+		}
+		key = &k
+	}
+	ctx := c.Request().Context()
+
+	lot, err := h.store.FindLot(ctx, lotID, key)
+	if err != nil {
+		return storeError(err)
+	}
+	machine, err := h.store.Machine(ctx, lot.Machine)
+	if err != nil {
+		return storeError(err)
+	}
+	totals, err := h.store.LotTotals(ctx, lot.Machine, lotID)
+	if err != nil {
+		return err
+	}
+
+	// Production events are stamped when a part completes, so the lot
+	// started one ideal cycle before its first event.
+	var product string
+	for p := range totals.ByProduct {
+		product = p // a lot runs a single product
+	}
+	idealCycle := h.idealCycleTime(machine, product)
+	window := oee.Interval{Start: lot.First.Add(-idealCycle), End: lot.Last}
+	resp, err := h.plannedOEE(ctx, machine, window, totals, policy, planned)
+	if err != nil {
+		return err
+	}
+	resp.LotID = lotID
+	return c.JSON(http.StatusOK, resp)
+}
+
+// calculate loads the status history and planned downtime for machine over
+// window and computes OEE from them and the given part counts under policy.
+func (h *Handler) calculate(ctx context.Context, machine store.Machine, window oee.Interval, totals store.ProductionTotals, policy oee.Policy) (oee.Result, error) {
+	in, err := h.input(ctx, machine, window, totals)
+	if err != nil {
+		return oee.Result{}, err
+	}
+	return oee.Calculate(in, policy), nil
+}
+
+// input loads the status history and planned downtime for machine over
+// window, and combines them with the given part counts into the input of
+// an OEE calculation.
+func (h *Handler) input(ctx context.Context, machine store.Machine, window oee.Interval, totals store.ProductionTotals) (oee.Input, error) {
+	initial, changes, err := h.store.StatusChanges(ctx, machine.Key(), window.Start, window.End)
+	if err != nil {
+		return oee.Input{}, err
+	}
+	windows, err := h.store.ListPlannedDowntime(ctx, machine.Key(), window.Start, window.End)
+	if err != nil {
+		return oee.Input{}, err
+	}
+
+	planned := make([]oee.Interval, 0, len(windows))
+	for _, pd := range windows {
+		planned = append(planned, oee.Interval{Start: pd.StartTime, End: pd.EndTime})
+	}
+	// Stops an operator classified count as they said, whatever the windows
+	history := changes
+	if initial.Status != "" {
+		history = append([]oee.StatusChange{initial}, changes...)
+	}
+	confirmed, unplanned := oee.Classified(oee.Stops(history, window))
+	planned = oee.Subtract(append(planned, confirmed...), unplanned)
+
+	// Events from before products were tracked have no product and run at
+	// the machine's own ideal cycle time.
+	var products []oee.ProductRun
+	if _, untracked := totals.ByProduct[""]; len(totals.ByProduct) > 1 || !untracked {
+		for _, p := range slices.Sorted(maps.Keys(totals.ByProduct)) {
+			products = append(products, oee.ProductRun{
+				Product:        p,
+				IdealCycleTime: h.idealCycleTime(machine, p),
+				Count:          totals.ByProduct[p],
+			})
+		}
+	}
+
+	// Parts made at a planned slowdown were meant to take longer
+	var slowdown time.Duration
+	for p, cycles := range totals.SlowdownCycles {
+		slowdown += time.Duration(cycles * float64(h.idealCycleTime(machine, p)))
+	}
+
+	return oee.Input{
+		Window:           window,
+		Running:          oee.RunningIntervals(initial.Status, changes, window),
+		PlannedDowntime:  planned,
+		IdealCycleTime:   h.idealCycleTime(machine, ""),
+		Products:         products,
+		GoodCount:        totals.Good,
+		ReworkedCount:    totals.Reworked,
+		ScrapCount:       totals.Scrapped,
+		WarmupScrapCount: totals.WarmupScrapped,
+		PlannedSlowdown:  slowdown,
+	}, nil
+}
+
+// idealCycleTime returns the ideal cycle time of product on machine: its
+// CYCLE_TIMES entry if there is one, otherwise the machine's own.
+func (h *Handler) idealCycleTime(machine store.Machine, product string) time.Duration {
+	if d, ok := h.cycleTimes.Lookup(machine.ID, product); ok {
+		return d
+	}
+	return time.Duration(machine.IdealCycleTimeSec * float64(time.Second))
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/store"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+const (
+	defaultPartWindow = time.Hour
+	maxPartWindow     = 7 * 24 * time.Hour
+)
+
+// PartResponse is the body returned by GET /parts/:serial.
+type PartResponse struct {
+	store.PartSerial
+	// From and To bound the window the OEE was computed over, centred on
+	// the part and ending no later than now.
+	From time.Time  `json:"from"`
+	To   time.Time  `json:"to"`
+	OEE  oee.Result `json:"oee"`
+}
+
+// GetPart handles GET /parts/:serial?window=1h, tracing a serialized part
+// back to the machine, lot and product it was made in, and the machine's
+// OEE around the time it was made.
+func (h *Handler) GetPart(c echo.Context) error {
+	window := defaultPartWindow
+	if raw := c.QueryParam("window"); raw != "" {
+		var err error
+		if window, err = time.ParseDuration(raw); err != nil || window <= 0 || window > maxPartWindow {
+			return echo.NewHTTPError(http.StatusBadRequest, "window must be a duration such as 1h, up to 168h")
+		}
+	}
+	policy, err := h.policyParams(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	part, err := h.store.FindSerial(ctx, c.Param("serial"))
+	if err != nil {
+		return storeError(err)
+	}
+	machine, err := h.store.Machine(ctx, machineid.Key{Site: part.Site, ID: part.MachineID})
+	if err != nil {
+		return storeError(err)
+	}
+	// The window can't reach past now, where the machine's state isn't
+	// known yet
+	to := part.Time.Add(window / 2)
+	if now := time.Now().UTC(); to.After(now) {
+		to = now
+	}
+	from := to.Add(-window)
+	totals, err := h.store.ProductionTotals(ctx, machine.Key(), from, to)
+	if err != nil {
+		return err
+	}
+	result, err := h.calculate(ctx, machine, oee.Interval{Start: from, End: to}, totals, policy)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, PartResponse{PartSerial: part, From: from, To: to, OEE: result})
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/store"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// PlannedDowntimeResponse is the body returned by GET /planned-downtime.
+// Windows holds the stored rows; Merged collapses overlapping, nested and
+// adjacent windows into the intervals actually excluded from OEE.
+type PlannedDowntimeResponse struct {
+	Windows []store.PlannedDowntime `json:"windows"`
+	Merged  []oee.Interval          `json:"merged"`
+}
+
+// ListPlannedDowntime handles GET /planned-downtime?machine_id=1&from=...&to=...
+func (h *Handler) ListPlannedDowntime(c echo.Context) error {
+	key, err := machineParam(c)
+	if err != nil {
+		return err
+	}
+	from, to, err := windowParams(c)
+	if err != nil {
+		return err
+	}
+
+	windows, err := h.store.ListPlannedDowntime(c.Request().Context(), key, from, to)
+	if err != nil {
+		return err
+	}
+	intervals := make([]oee.Interval, 0, len(windows))
+	for _, pd := range windows {
+		intervals = append(intervals, oee.Interval{Start: pd.StartTime, End: pd.EndTime})
+	}
+	return c.JSON(http.StatusOK, PlannedDowntimeResponse{
+		Windows: windows,
+		Merged:  oee.Merge(intervals),
+	})
+}
+
+// createPlannedDowntimeRequest is the body accepted by POST /planned-downtime.
+type createPlannedDowntimeRequest struct {
+	Site      string    `json:"site"`
+	MachineID int       `json:"machine_id"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Reason    string    `json:"reason"`
+}
+
+// CreatePlannedDowntime handles POST /planned-downtime.
+func (h *Handler) CreatePlannedDowntime(c echo.Context) error {
+	var req createPlannedDowntimeRequest
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if req.MachineID == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "machine_id is required")
+	}
+	if req.StartTime.IsZero() || req.EndTime.IsZero() {
+		return echo.NewHTTPError(http.StatusBadRequest, "start_time and end_time are required")
+	}
+	if !req.EndTime.After(req.StartTime) {
+		return echo.NewHTTPError(http.StatusBadRequest, "end_time must be after start_time")
+	}
+
+	ctx := c.Request().Context()
+	if _, err := h.store.Machine(ctx, machineid.Key{Site: req.Site, ID: req.MachineID}); err != nil {
+		return storeError(err)
+	}
+	pd := store.PlannedDowntime{
+		Site:      req.Site,
+		MachineID: req.MachineID,
+		StartTime: req.StartTime.UTC(),
+		EndTime:   req.EndTime.UTC(),
+		Reason:    req.Reason,
+	}
+	if err := h.store.CreatePlannedDowntime(ctx, &pd); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, pd)
+}
+
+// DeletePlannedDowntime handles DELETE /planned-downtime/:id.
+func (h *Handler) DeletePlannedDowntime(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "id must be an integer")
+	}
+	if err := h.store.DeletePlannedDowntime(c.Request().Context(), id); err != nil {
+		return storeError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
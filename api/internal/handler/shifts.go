@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/store"
+)
+
+// ShiftOEE is one shift of GET /oee/by-shift.
+type ShiftOEE struct {
+	ShiftID int       `json:"shift_id"`
+	Name    string    `json:"name"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	// InProgress is set for the shift running now, whose OEE covers its
+	// start until now, and Upcoming for one that hasn't started yet, which
+	// has no OEE.
+	InProgress bool         `json:"in_progress,omitempty"`
+	Upcoming   bool         `json:"upcoming,omitempty"`
+	OEE        *OEEResponse `json:"oee"`
+}
+
+// ShiftReport is the body returned by GET /oee/by-shift.
+type ShiftReport struct {
+	Site      string     `json:"site,omitempty"`
+	MachineID int        `json:"machine_id"`
+	Date      string     `json:"date"`
+	Timezone  string     `json:"timezone"`
+	Shifts    []ShiftOEE `json:"shifts"`
+}
+
+// GetOEEByShift handles GET /oee/by-shift?machine_id=1&date=2025-11-05
+//
+// It reports OEE for each shift of the schedule in the shifts table that
+// starts on date, a calendar day in the server's TIMEZONE that defaults to
+// today. A shift belongs to the day it starts on, so one ending at or
+// before its start time runs into the next day. micro_stop_threshold
+// overrides the policy as for GET /oee.
+func (h *Handler) GetOEEByShift(c echo.Context) error {
+	policy, err := h.policyParams(c)
+	if err != nil {
+		return err
+	}
+	key, err := machineParam(c)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	day := now.In(h.location)
+	if raw := c.QueryParam("date"); raw != "" {
+		if day, err = time.ParseInLocation(time.DateOnly, raw, h.location); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "date must be a YYYY-MM-DD date")
+		}
+	}
+	ctx := c.Request().Context()
+
+	machine, err := h.store.Machine(ctx, key)
+	if err != nil {
+		return storeError(err)
+	}
+	shifts, err := h.store.Shifts(ctx)
+	if err != nil {
+		return err
+	}
+
+	report := ShiftReport{Site: key.Site, MachineID: key.ID, Date: day.Format(time.DateOnly), Timezone: h.location.String(), Shifts: []ShiftOEE{}}
+	for _, sh := range shifts {
+		start, end := shiftWindow(day, sh, h.location)
+		s := ShiftOEE{ShiftID: sh.ID, Name: sh.Name, Start: start.UTC(), End: end.UTC()}
+		if !start.Before(now) {
+			s.Upcoming = true
+			report.Shifts = append(report.Shifts, s)
+			continue
+		}
+		if end.After(now) {
+			s.InProgress = true
+			end = now
+		}
+		totals, err := h.store.ProductionTotals(ctx, key, start, end)
+		if err != nil {
+			return err
+		}
+		result, err := h.calculate(ctx, machine, oee.Interval{Start: start, End: end}, totals, policy)
+		if err != nil {
+			return err
+		}
+		resp := newOEEResponse(key, policy, result)
+		s.OEE = &resp
+		report.Shifts = append(report.Shifts, s)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// shiftWindow returns when sh starts and ends on the day of day, read in
+// loc. Both are built from the wall clock, so on the days daylight saving
+// time starts or ends a shift still starts and ends at its local times and
+// is an hour shorter or longer.
+func shiftWindow(day time.Time, sh store.Shift, loc *time.Location) (start, end time.Time) {
+	day = day.In(loc)
+	at := func(days int, offset time.Duration) time.Time {
+		hour, minute, sec := int(offset/time.Hour), int(offset%time.Hour/time.Minute), int(offset%time.Minute/time.Second)
+		return time.Date(day.Year(), day.Month(), day.Day()+days, hour, minute, sec, 0, loc)
+	}
+	start, end = at(0, sh.Start), at(0, sh.End)
+	if sh.End <= sh.Start {
+		end = at(1, sh.End)
+	}
+	return start, end
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // Europe/Berlin must resolve without a zoneinfo database
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/store"
+)
+
+func TestShiftWindowDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	early := store.Shift{Name: "early", Start: 6 * time.Hour, End: 14 * time.Hour}
+	night := store.Shift{Name: "night", Start: 22 * time.Hour, End: 6 * time.Hour}
+	graveyard := store.Shift{Name: "graveyard", Start: 0, End: 8 * time.Hour}
+	tests := []struct {
+		name       string
+		date       string
+		shift      store.Shift
+		start, end string
+		length     time.Duration
+	}{
+		{"day shift on an ordinary day", "2025-03-20", early, "2025-03-20T05:00:00Z", "2025-03-20T13:00:00Z", 8 * time.Hour},
+		{"day shift on spring-forward day", "2025-03-30", early, "2025-03-30T04:00:00Z", "2025-03-30T12:00:00Z", 8 * time.Hour},
+		{"day shift on fall-back day", "2025-10-26", early, "2025-10-26T05:00:00Z", "2025-10-26T13:00:00Z", 8 * time.Hour},
+		{"night shift on an ordinary day", "2025-03-20", night, "2025-03-20T21:00:00Z", "2025-03-21T05:00:00Z", 8 * time.Hour},
+		{"night shift into spring-forward day", "2025-03-29", night, "2025-03-29T21:00:00Z", "2025-03-30T04:00:00Z", 7 * time.Hour},
+		{"night shift out of spring-forward day", "2025-03-30", night, "2025-03-30T20:00:00Z", "2025-03-31T04:00:00Z", 8 * time.Hour},
+		{"night shift into fall-back day", "2025-10-25", night, "2025-10-25T20:00:00Z", "2025-10-26T05:00:00Z", 9 * time.Hour},
+		{"night shift out of fall-back day", "2025-10-26", night, "2025-10-26T21:00:00Z", "2025-10-27T05:00:00Z", 8 * time.Hour},
+		{"shift from midnight on spring-forward day", "2025-03-30", graveyard, "2025-03-29T23:00:00Z", "2025-03-30T06:00:00Z", 7 * time.Hour},
+		{"shift from midnight on fall-back day", "2025-10-26", graveyard, "2025-10-25T22:00:00Z", "2025-10-26T07:00:00Z", 9 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			day, err := time.ParseInLocation(time.DateOnly, tt.date, berlin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start, end := shiftWindow(day, tt.shift, berlin)
+			if !start.Equal(utc(tt.start)) || !end.Equal(utc(tt.end)) {
+				t.Fatalf("shiftWindow(%s, %s) = %s - %s, want %s - %s", tt.date, tt.shift.Name, start.UTC(), end.UTC(), tt.start, tt.end)
+			}
+			if got := end.Sub(start); got != tt.length {
+				t.Fatalf("shift lasts %v, want %v", got, tt.length)
+			}
+			// The wall clock times are the shift's, whatever the offset
+			if h := start.Hour(); time.Duration(h)*time.Hour != tt.shift.Start {
+				t.Fatalf("starts at %s local", start.Format(time.TimeOnly))
+			}
+			if h := end.Hour(); time.Duration(h)*time.Hour != tt.shift.End {
+				t.Fatalf("ends at %s local", end.Format(time.TimeOnly))
+			}
+		})
+	}
+}
+
+// A day given in another zone, such as the server's time now, is the
+// calendar day it falls on in loc.
+func TestShiftWindowDayInOtherZone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	early := store.Shift{Name: "early", Start: 6 * time.Hour, End: 14 * time.Hour}
+	// 23:30 UTC on the 29th is already the 30th in Berlin
+	start, _ := shiftWindow(time.Date(2025, 3, 29, 23, 30, 0, 0, time.UTC), early, berlin)
+	if want := time.Date(2025, 3, 30, 4, 0, 0, 0, time.UTC); !start.Equal(want) {
+		t.Fatalf("start = %s, want %s", start.UTC(), want)
+	}
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+)
+
+// TimelineState is one entry of GET /timeline. A stop carries the
+// DowntimeID to annotate it by.
+type TimelineState struct {
+	oee.State
+	DurationSeconds float64 `json:"duration_seconds"`
+	DowntimeID      string  `json:"downtime_id,omitempty"`
+}
+
+// TimelineResponse is the body returned by GET /timeline.
+type TimelineResponse struct {
+	Site      string          `json:"site,omitempty"`
+	MachineID int             `json:"machine_id"`
+	From      time.Time       `json:"from"`
+	To        time.Time       `json:"to"`
+	States    []TimelineState `json:"states"`
+}
+
+// GetTimeline handles GET /timeline?machine_id=1&from=...&to=...
+//
+// It returns the machine's states over the window, oldest first, for a
+// Gantt-style availability chart. The states are rebuilt from the status
+// events and cover the whole window: the first opens in the state the
+// machine was in at from, and the last runs to the end of the window
+// whether or not the machine has left it since.
+func (h *Handler) GetTimeline(c echo.Context) error {
+	key, err := machineParam(c)
+	if err != nil {
+		return err
+	}
+	from, to, err := windowParams(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	if _, err := h.store.Machine(ctx, key); err != nil {
+		return storeError(err)
+	}
+	history, err := h.store.StatusHistory(ctx, &key, from, to)
+	if err != nil {
+		return err
+	}
+	states := oee.Timeline(history[key], oee.Interval{Start: from, End: to})
+	resp := TimelineResponse{Site: key.Site, MachineID: key.ID, From: from, To: to, States: make([]TimelineState, 0, len(states))}
+	for _, st := range states {
+		ts := TimelineState{State: st, DurationSeconds: st.Duration().Seconds()}
+		if st.Status != oee.StatusRunning && st.Status != oee.StatusUnknown {
+			ts.DowntimeID = downtimeID(key, st.Began)
+		}
+		resp.States = append(resp.States, ts)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
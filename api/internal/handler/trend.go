@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/store"
+)
+
+const (
+	defaultTrendDays = 30
+	maxTrendDays     = 366
+	// flatTrendSlope is the slope, in OEE per day, below which a trend is
+	// reported as flat: 0.1 percentage points a day, or 3 a month.
+	flatTrendSlope = 0.001
+)
+
+// Trend directions.
+const (
+	trendUp           = "up"
+	trendDown         = "down"
+	trendFlat         = "flat"
+	trendInsufficient = "insufficient_data"
+)
+
+// TrendDay is one UTC day of GET /oee/trend. The ratios are null on a day
+// without production, which is left out of the regression rather than
+// counted as zero OEE.
+type TrendDay struct {
+	Date          string   `json:"date"`
+	HasProduction bool     `json:"has_production"`
+	TotalCount    int      `json:"total_count"`
+	Availability  *float64 `json:"availability"`
+	Performance   *float64 `json:"performance"`
+	Quality       *float64 `json:"quality"`
+	OEE           *float64 `json:"oee"`
+	// FirstPassYield and FinalYield are as in GET /oee.
+	FirstPassYield *float64 `json:"first_pass_yield"`
+	FinalYield     *float64 `json:"final_yield"`
+}
+
+// TrendResponse is the body returned by GET /oee/trend.
+type TrendResponse struct {
+	Site         string    `json:"site,omitempty"`
+	MachineID    int       `json:"machine_id"`
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	DaysWithData int       `json:"days_with_data"`
+	// Fit is the regression of daily OEE on the day number, counted from
+	// From; it is null with fewer than two days of data.
+	Fit       *oee.Fit   `json:"fit"`
+	Direction string     `json:"direction"`
+	Days      []TrendDay `json:"days"`
+}
+
+// GetOEETrend handles GET /oee/trend?machine_id=1&days=30.
+//
+// It reports daily OEE for the last days UTC days, today included, from the
+// oee_hourly rollups, and fits a line through the days with production to
+// say whether OEE is trending up, down or flat.
+func (h *Handler) GetOEETrend(c echo.Context) error {
+	key, err := machineParam(c)
+	if err != nil {
+		return err
+	}
+	days := defaultTrendDays
+	if raw := c.QueryParam("days"); raw != "" {
+		if days, err = strconv.Atoi(raw); err != nil || days < 1 || days > maxTrendDays {
+			return echo.NewHTTPError(http.StatusBadRequest, "days must be an integer from 1 to 366")
+		}
+	}
+	ctx := c.Request().Context()
+
+	if _, err := h.store.Machine(ctx, key); err != nil {
+		return storeError(err)
+	}
+	to := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	from := to.AddDate(0, 0, -days)
+	rollups, err := h.store.DailyRollups(ctx, key, from, to)
+	if err != nil {
+		return err
+	}
+	byDay := make(map[string]store.DailyRollup, len(rollups))
+	for _, r := range rollups {
+		byDay[r.Day.Format(time.DateOnly)] = r
+	}
+
+	resp := TrendResponse{Site: key.Site, MachineID: key.ID, From: from, To: to, Direction: trendInsufficient}
+	var xs, ys []float64
+	for i := range days {
+		day := from.AddDate(0, 0, i)
+		td := h.trendDay(day, byDay[day.Format(time.DateOnly)])
+		if td.HasProduction {
+			xs = append(xs, float64(i))
+			ys = append(ys, *td.OEE)
+		}
+		resp.Days = append(resp.Days, td)
+	}
+	resp.DaysWithData = len(xs)
+
+	if fit, ok := oee.LinearFit(xs, ys); ok {
+		resp.Fit = &fit
+		switch {
+		case fit.Slope >= flatTrendSlope:
+			resp.Direction = trendUp
+		case fit.Slope <= -flatTrendSlope:
+			resp.Direction = trendDown
+		default:
+			resp.Direction = trendFlat
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// trendDay computes the day's OEE from its summed rollups the way Calculate
+// would over the whole day, under the server's policy.
+func (h *Handler) trendDay(day time.Time, r store.DailyRollup) TrendDay {
+	total := r.TotalCount()
+	td := TrendDay{Date: day.Format(time.DateOnly), TotalCount: total}
+	if total == 0 {
+		return td
+	}
+
+	availability, performance, quality, o := rollupOEE(r.RollupTotals, h.policy)
+	firstPass, final := oee.Yields(r.GoodCount, r.ReworkedCount, total)
+
+	td.HasProduction = true
+	td.Availability, td.Performance, td.Quality, td.OEE = &availability, &performance, &quality, &o
+	td.FirstPassYield, td.FinalYield = &firstPass, &final
+	return td
+}
+
+// rollupOEE computes OEE from summed rollups the way Calculate would over
+// their whole span, under policy. Performance and quality are zero without
+// production.
+func rollupOEE(r store.RollupTotals, policy oee.Policy) (availability, performance, quality, o float64) {
+	if r.PlannedSeconds > 0 {
+		availability = r.RunSeconds / r.PlannedSeconds
+	}
+	if r.RunSeconds > 0 {
+		performance = r.IdealSeconds / r.RunSeconds
+		if policy.CapPerformance && performance > 1 {
+			performance = 1
+		}
+	}
+	if total := r.TotalCount(); total > 0 {
+		quality = (float64(r.GoodCount) + policy.ReworkCredit*float64(r.ReworkedCount)) / float64(total)
+	}
+	return availability, performance, quality, availability * performance * quality
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultWorstLimit = 10
+	maxWorstLimit     = 100
+	maxWorstWindow    = 366 * 24 * time.Hour
+)
+
+// Ratios GET /oee/worst can rank by.
+const (
+	metricOEE          = "oee"
+	metricAvailability = "availability"
+	metricPerformance  = "performance"
+	metricQuality      = "quality"
+)
+
+// WorstMachine is one entry of GET /oee/worst. Performance, quality and OEE
+// are null for a machine that made no parts in the window.
+type WorstMachine struct {
+	Rank          int      `json:"rank"`
+	Site          string   `json:"site,omitempty"`
+	MachineID     int      `json:"machine_id"`
+	Name          string   `json:"name"`
+	TotalCount    int      `json:"total_count"`
+	HasProduction bool     `json:"has_production"`
+	Availability  float64  `json:"availability"`
+	Performance   *float64 `json:"performance"`
+	Quality       *float64 `json:"quality"`
+	OEE           *float64 `json:"oee"`
+}
+
+// WorstResponse is the body returned by GET /oee/worst.
+type WorstResponse struct {
+	From     time.Time      `json:"from"`
+	To       time.Time      `json:"to"`
+	Metric   string         `json:"metric"`
+	Machines []WorstMachine `json:"machines"`
+}
+
+// value returns the ratio w is ranked by for metric, or false if it is
+// undefined.
+func (w WorstMachine) value(metric string) (float64, bool) {
+	var v *float64
+	switch metric {
+	case metricAvailability:
+		return w.Availability, true
+	case metricPerformance:
+		v = w.Performance
+	case metricQuality:
+		v = w.Quality
+	default:
+		v = w.OEE
+	}
+	if v == nil {
+		return 0, false
+	}
+	return *v, true
+}
+
+// GetWorstOEE handles GET /oee/worst?from=...&to=...&limit=10&metric=oee.
+//
+// It ranks the machines by metric over the window, lowest first, from the
+// oee_hourly rollups under the server's policy. The window is widened to
+// whole hours and may span at most 366 days. Machines for which the metric
+// is undefined, such as those without production when ranking by anything
+// but availability, are left out.
+func (h *Handler) GetWorstOEE(c echo.Context) error {
+	from, to, err := windowParams(c)
+	if err != nil {
+		return err
+	}
+	from = from.Truncate(rollupBucket)
+	if t := to.Truncate(rollupBucket); t.Before(to) {
+		to = t.Add(rollupBucket)
+	}
+	if to.Sub(from) > maxWorstWindow {
+		return echo.NewHTTPError(http.StatusBadRequest, "the window must not span more than 366 days")
+	}
+	limit := defaultWorstLimit
+	if raw := c.QueryParam("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > maxWorstLimit {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be an integer from 1 to 100")
+		}
+	}
+	metric := c.QueryParam("metric")
+	switch metric {
+	case "":
+		metric = metricOEE
+	case metricOEE, metricAvailability, metricPerformance, metricQuality:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "metric must be oee, availability, performance or quality")
+	}
+
+	rollups, err := h.store.MachineRollups(c.Request().Context(), from, to)
+	if err != nil {
+		return err
+	}
+	machines := []WorstMachine{}
+	for _, r := range rollups {
+		if r.PlannedSeconds == 0 {
+			continue // planned downtime throughout; nothing to measure
+		}
+		availability, performance, quality, o := rollupOEE(r.RollupTotals, h.policy)
+		w := WorstMachine{
+			Site:         r.Machine.Site,
+			MachineID:    r.Machine.ID,
+			Name:         r.Name,
+			TotalCount:   r.TotalCount(),
+			Availability: availability,
+		}
+		if w.TotalCount > 0 {
+			w.HasProduction = true
+			w.Performance, w.Quality, w.OEE = &performance, &quality, &o
+		}
+		if _, ok := w.value(metric); ok {
+			machines = append(machines, w)
+		}
+	}
+	sort.SliceStable(machines, func(a, b int) bool {
+		va, _ := machines[a].value(metric)
+		vb, _ := machines[b].value(metric)
+		return va < vb
+	})
+	if len(machines) > limit {
+		machines = machines[:limit]
+	}
+	for i := range machines {
+		machines[i].Rank = i + 1
+	}
+	return c.JSON(http.StatusOK, WorstResponse{From: from, To: to, Metric: metric, Machines: machines})
+}
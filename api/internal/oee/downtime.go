@@ -0,0 +1,150 @@
+package oee
+
+import (
+	"sort"
+	"time"
+)
+
+// ReasonUnspecified labels stops whose status event carried no reason.
+const ReasonUnspecified = "unspecified"
+
+// Stop is a period during which a machine was not running, with the reason
+// it stopped for, as confirmed by an operator or else as reported, and any
+// classification an operator gave it.
+type Stop struct {
+	Interval
+	Reason  string
+	Planned *bool
+}
+
+// Stops reconstructs the periods inside window during which the machine was
+// stopped. changes may include the last change before window.Start, which
+// sets the state the window opens in, and are normalized first. A machine
+// whose status is unknown at some point is not counted as stopped then.
+func Stops(changes []StatusChange, window Interval) []Stop {
+	var out []Stop
+	var cur StatusChange
+	changes = Normalize(changes)
+	for _, ch := range changes {
+		if cur.Status != "" && cur.Status != StatusRunning {
+			out = append(out, Stop{Interval: Interval{Start: cur.Time, End: ch.Time}, Reason: cur.EffectiveReason(), Planned: cur.Planned})
+		}
+		cur = ch
+	}
+	if cur.Status != "" && cur.Status != StatusRunning {
+		out = append(out, Stop{Interval: Interval{Start: cur.Time, End: window.End}, Reason: cur.EffectiveReason(), Planned: cur.Planned})
+	}
+
+	clipped := out[:0]
+	for _, st := range out {
+		if iv := Clip([]Interval{st.Interval}, window); len(iv) > 0 {
+			st.Interval = iv[0]
+			clipped = append(clipped, st)
+		}
+	}
+	return clipped
+}
+
+// SplitPlanned divides stops into the parts that fall inside the planned
+// windows and the parts outside them. A stop that straddles a window edge
+// contributes a piece to each side, unless an operator classified it: then
+// it goes to the side they chose whole.
+func SplitPlanned(stops []Stop, planned []Interval) (inside, outside []Stop) {
+	planned = Merge(planned)
+	for _, st := range stops {
+		if st.Planned != nil {
+			if *st.Planned {
+				inside = append(inside, st)
+			} else {
+				outside = append(outside, st)
+			}
+			continue
+		}
+		out := Subtract([]Interval{st.Interval}, planned)
+		for _, iv := range out {
+			outside = append(outside, Stop{Interval: iv, Reason: st.Reason})
+		}
+		for _, iv := range Subtract([]Interval{st.Interval}, out) {
+			inside = append(inside, Stop{Interval: iv, Reason: st.Reason})
+		}
+	}
+	return inside, outside
+}
+
+// DowntimeReason is one bar of a downtime Pareto chart. Share and
+// CumulativeShare are fractions of the total by whichever measure the
+// reasons are ranked by.
+type DowntimeReason struct {
+	Reason          string  `json:"reason"`
+	Seconds         float64 `json:"seconds"`
+	Count           int     `json:"count"`
+	Share           float64 `json:"share"`
+	CumulativeShare float64 `json:"cumulative_share"`
+}
+
+// Pareto groups stops by reason and ranks the reasons by total duration, or
+// by number of stops if byCount is set, largest first.
+func Pareto(stops []Stop, byCount bool) []DowntimeReason {
+	index := map[string]int{}
+	out := []DowntimeReason{}
+	durations := map[string]time.Duration{}
+	var total time.Duration
+	for _, st := range stops {
+		reason := st.Reason
+		if reason == "" {
+			reason = ReasonUnspecified
+		}
+		i, ok := index[reason]
+		if !ok {
+			i = len(out)
+			index[reason] = i
+			out = append(out, DowntimeReason{Reason: reason})
+		}
+		out[i].Count++
+		durations[reason] += st.Duration()
+		total += st.Duration()
+	}
+	for i := range out {
+		out[i].Seconds = durations[out[i].Reason].Seconds()
+	}
+
+	measure := func(r DowntimeReason) float64 { return r.Seconds }
+	sum := total.Seconds()
+	if byCount {
+		measure = func(r DowntimeReason) float64 { return float64(r.Count) }
+		sum = float64(len(stops))
+	}
+	sort.SliceStable(out, func(a, b int) bool {
+		if ma, mb := measure(out[a]), measure(out[b]); ma != mb {
+			return ma > mb
+		}
+		return out[a].Reason < out[b].Reason
+	})
+
+	var cumulative float64
+	for i := range out {
+		if sum > 0 {
+			out[i].Share = measure(out[i]) / sum
+		}
+		cumulative += out[i].Share
+		out[i].CumulativeShare = cumulative
+	}
+	return out
+}
+
+// Classified returns the stops an operator classified as planned and as
+// unplanned downtime, which replace the planned downtime windows where they
+// overlap: planned windows plus planned, less unplanned, are the time
+// excluded from OEE.
+func Classified(stops []Stop) (planned, unplanned []Interval) {
+	for _, st := range stops {
+		switch {
+		case st.Planned == nil:
+		case *st.Planned:
+			planned = append(planned, st.Interval)
+		default:
+			unplanned = append(unplanned, st.Interval)
+		}
+	}
+	return planned, unplanned
+}
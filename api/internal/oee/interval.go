@@ -0,0 +1,120 @@
+package oee
+
+import (
+	"sort"
+	"time"
+)
+
+// Interval is a half-open time range [Start, End).
+type Interval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Duration returns the length of the interval, or zero if it is empty.
+func (i Interval) Duration() time.Duration {
+	if !i.End.After(i.Start) {
+		return 0
+	}
+	return i.End.Sub(i.Start)
+}
+
+// Merge sorts intervals by start time and collapses any that overlap, nest
+// or touch end-to-start into a single interval. Empty intervals are dropped.
+func Merge(in []Interval) []Interval {
+	sorted := make([]Interval, 0, len(in))
+	for _, iv := range in {
+		if iv.Duration() > 0 {
+			sorted = append(sorted, iv)
+		}
+	}
+	sort.Slice(sorted, func(a, b int) bool {
+		return sorted[a].Start.Before(sorted[b].Start)
+	})
+
+	merged := make([]Interval, 0, len(sorted))
+	for _, iv := range sorted {
+		if n := len(merged); n > 0 && !iv.Start.After(merged[n-1].End) {
+			// Overlapping, nested or adjacent: extend the previous interval.
+			if iv.End.After(merged[n-1].End) {
+				merged[n-1].End = iv.End
+			}
+			continue
+		}
+		merged = append(merged, iv)
+	}
+	return merged
+}
+
+// Clip trims every interval to the given window, dropping those that fall
+// entirely outside it.
+func Clip(in []Interval, window Interval) []Interval {
+	out := make([]Interval, 0, len(in))
+	for _, iv := range in {
+		if iv.Start.Before(window.Start) {
+			iv.Start = window.Start
+		}
+		if iv.End.After(window.End) {
+			iv.End = window.End
+		}
+		if iv.Duration() > 0 {
+			out = append(out, iv)
+		}
+	}
+	return out
+}
+
+// Subtract returns the parts of a that are not covered by b. Both inputs are
+// merged first, so callers may pass unsorted or overlapping intervals.
+func Subtract(a, b []Interval) []Interval {
+	a, b = Merge(a), Merge(b)
+	out := make([]Interval, 0, len(a))
+	j := 0
+	for _, iv := range a {
+		cur := iv
+		// Skip holes that end before this interval starts.
+		for j < len(b) && !b[j].End.After(cur.Start) {
+			j++
+		}
+		for k := j; k < len(b) && b[k].Start.Before(cur.End); k++ {
+			if b[k].Start.After(cur.Start) {
+				out = append(out, Interval{Start: cur.Start, End: b[k].Start})
+			}
+			if b[k].End.After(cur.Start) {
+				cur.Start = b[k].End
+			}
+		}
+		if cur.Duration() > 0 {
+			out = append(out, cur)
+		}
+	}
+	return out
+}
+
+// FillGaps merges the intervals and then closes every gap between two of
+// them that is shorter than maxGap. A non-positive maxGap fills nothing.
+func FillGaps(in []Interval, maxGap time.Duration) []Interval {
+	merged := Merge(in)
+	if maxGap <= 0 || len(merged) == 0 {
+		return merged
+	}
+	out := merged[:1]
+	for _, iv := range merged[1:] {
+		if last := &out[len(out)-1]; iv.Start.Sub(last.End) < maxGap {
+			last.End = iv.End
+			continue
+		}
+		out = append(out, iv)
+	}
+	return out
+}
+
+// Total sums the durations of the intervals. Callers should merge first if
+// the intervals may overlap.
+func Total(in []Interval) time.Duration {
+	var total time.Duration
+	for _, iv := range in {
+		total += iv.Duration()
+	}
+	return total
+}
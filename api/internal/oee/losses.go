@@ -0,0 +1,143 @@
+package oee
+
+import (
+	"sort"
+	"time"
+)
+
+// Reasons losses are attributed to besides the stop reasons machines report.
+const (
+	// ReasonNoStatus is availability loss while the machine's status was
+	// unknown, e.g. before its first status event.
+	ReasonNoStatus = "no_status"
+	// ReasonMicroStops, ReasonPlannedSlowdown and ReasonSlowCycles split
+	// performance loss into stops shorter than the micro-stop threshold,
+	// deliberate derating, and the unexpected slowdowns left.
+	ReasonMicroStops      = "micro_stops"
+	ReasonPlannedSlowdown = "planned_slowdown"
+	ReasonSlowCycles      = "slow_cycles"
+	// ReasonScrap, ReasonStartupRejects and ReasonRework split quality loss
+	// by the parts behind it: scrapped in steady state, scrapped while
+	// warming up after a restart or changeover, and reworked.
+	ReasonScrap          = "scrap"
+	ReasonStartupRejects = "startup_rejects"
+	ReasonRework         = "rework"
+)
+
+// Losses is the planned production time of a window split into the time
+// that was fully productive, making good parts at the ideal rate, and the
+// time lost to each OEE factor. The three losses add up to PlannedMinutes
+// less ProductiveMinutes.
+type Losses struct {
+	PlannedMinutes    float64 `json:"planned_minutes"`
+	ProductiveMinutes float64 `json:"productive_minutes"`
+	LostMinutes       float64 `json:"lost_minutes"`
+	Availability      Loss    `json:"availability"`
+	Performance       Loss    `json:"performance"`
+	Quality           Loss    `json:"quality"`
+}
+
+// Loss is the time lost to one OEE factor and what it was lost to, largest
+// first.
+type Loss struct {
+	Minutes float64      `json:"minutes"`
+	Reasons []LossReason `json:"reasons"`
+}
+
+// LossReason is the time lost to one reason.
+type LossReason struct {
+	Reason  string  `json:"reason"`
+	Minutes float64 `json:"minutes"`
+}
+
+// AttributeLosses computes OEE for in under p, as Calculate does, and
+// converts each factor into time: availability loses planned × (1 − A),
+// performance run × (1 − P) and quality run × P × (1 − Q). stops, from
+// Stops over the same window, attribute the availability loss to stop
+// reasons. Performance loss is negative if the machine ran faster than its
+// ideal cycle time and performance isn't capped.
+func AttributeLosses(in Input, p Policy, stops []Stop) Losses {
+	r := Calculate(in, p)
+	run := r.PlannedSeconds * r.Availability
+	availability := r.PlannedSeconds - run
+	performance := run * (1 - r.Performance)
+	quality := run * r.Performance * (1 - r.Quality)
+
+	l := Losses{
+		PlannedMinutes:    r.PlannedSeconds / 60,
+		ProductiveMinutes: r.PlannedSeconds * r.OEE / 60,
+		Availability:      Loss{Minutes: availability / 60, Reasons: []LossReason{}},
+		Performance:       Loss{Minutes: performance / 60, Reasons: []LossReason{}},
+		Quality:           Loss{Minutes: quality / 60, Reasons: []LossReason{}},
+	}
+	l.LostMinutes = l.PlannedMinutes - l.ProductiveMinutes
+
+	// Availability is lost whenever planned time isn't run time; where a
+	// stop covers it, to the stop's reason
+	t := splitWindow(in, p)
+	lost := Subtract(t.productive, t.running)
+	byReason := map[string]time.Duration{}
+	var attributed time.Duration
+	for _, st := range stops {
+		span := []Interval{st.Interval}
+		d := Total(Subtract(span, Subtract(span, lost)))
+		reason := st.Reason
+		if reason == "" {
+			reason = ReasonUnspecified
+		}
+		byReason[reason] += d
+		attributed += d
+	}
+	if rest := Total(lost) - attributed; rest > 0 {
+		byReason[ReasonNoStatus] += rest
+	}
+	for reason, d := range byReason {
+		if d > 0 {
+			l.Availability.Reasons = append(l.Availability.Reasons, LossReason{reason, d.Minutes()})
+		}
+	}
+
+	// Micro stops are performance loss only as far as there is any
+	micro := min(max(r.MicroStopSeconds, 0), max(performance, 0))
+	if micro > 0 {
+		l.Performance.Reasons = append(l.Performance.Reasons, LossReason{ReasonMicroStops, micro / 60})
+	}
+	// and so is a planned slowdown, after them
+	planned := min(max(in.PlannedSlowdown.Seconds(), 0), max(performance-micro, 0))
+	if planned > 0 {
+		l.Performance.Reasons = append(l.Performance.Reasons, LossReason{ReasonPlannedSlowdown, planned / 60})
+	}
+	if slow := performance - micro - planned; slow != 0 {
+		l.Performance.Reasons = append(l.Performance.Reasons, LossReason{ReasonSlowCycles, slow / 60})
+	}
+
+	// Quality loss is shared by scrap, startup rejects and the uncredited
+	// part of rework
+	startup := float64(r.WarmupScrapCount)
+	scrap := float64(r.ScrapCount) - startup
+	if p.ExcludeWarmupScrap {
+		startup = 0
+	}
+	rework := (1 - p.ReworkCredit) * float64(r.ReworkedCount)
+	if bad := scrap + startup + rework; bad > 0 && quality > 0 {
+		if scrap > 0 {
+			l.Quality.Reasons = append(l.Quality.Reasons, LossReason{ReasonScrap, quality * scrap / bad / 60})
+		}
+		if startup > 0 {
+			l.Quality.Reasons = append(l.Quality.Reasons, LossReason{ReasonStartupRejects, quality * startup / bad / 60})
+		}
+		if rework > 0 {
+			l.Quality.Reasons = append(l.Quality.Reasons, LossReason{ReasonRework, quality * rework / bad / 60})
+		}
+	}
+
+	for _, loss := range []Loss{l.Availability, l.Performance, l.Quality} {
+		sort.Slice(loss.Reasons, func(a, b int) bool {
+			if loss.Reasons[a].Minutes != loss.Reasons[b].Minutes {
+				return loss.Reasons[a].Minutes > loss.Reasons[b].Minutes
+			}
+			return loss.Reasons[a].Reason < loss.Reasons[b].Reason
+		})
+	}
+	return l
+}
@@ -0,0 +1,293 @@
+// Package oee implements the Overall Equipment Effectiveness calculation on
+// top of raw status and production figures. It has no database dependency so
+// the same logic can back every endpoint that reports OEE.
+package oee
+
+import (
+	"sort"
+	"time"
+)
+
+// StatusRunning is the status string machines report while producing.
+const StatusRunning = "running"
+
+// StatusChange is a single status transition reported by a machine.
+type StatusChange struct {
+	Time   time.Time
+	Status string
+	Reason string
+	// ConfirmedReason and Planned are what an operator recorded about the
+	// stop the change starts, if anything: the reason they confirmed,
+	// which replaces Reason, and whether it was planned downtime, which
+	// overrides the planned downtime windows.
+	ConfirmedReason string
+	Planned         *bool
+}
+
+// EffectiveReason returns the reason an operator confirmed for the change,
+// or else the one the machine reported.
+func (c StatusChange) EffectiveReason() string {
+	if c.ConfirmedReason != "" {
+		return c.ConfirmedReason
+	}
+	return c.Reason
+}
+
+// annotated reports whether an operator recorded anything about c.
+func (c StatusChange) annotated() bool {
+	return c.ConfirmedReason != "" || c.Planned != nil
+}
+
+// Normalize returns changes ordered by time with the noise of redelivery and
+// flapping removed: a change repeating the status and reason already in
+// effect is dropped, as the machine never left that state, though an
+// annotation of it applies to the state if the change that started it has
+// none; and of several
+// changes at the same instant only the last one received is kept. Changes
+// that arrive out of order are sorted into place. changes itself is not
+// modified.
+func Normalize(changes []StatusChange) []StatusChange {
+	sorted := make([]StatusChange, len(changes))
+	copy(sorted, changes)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return sorted[a].Time.Before(sorted[b].Time)
+	})
+
+	out := make([]StatusChange, 0, len(sorted))
+	for _, ch := range sorted {
+		if n := len(out); n > 0 && out[n-1].Time.Equal(ch.Time) {
+			out = out[:n-1]
+		}
+		if n := len(out); n > 0 && out[n-1].Status == ch.Status && out[n-1].Reason == ch.Reason {
+			if !out[n-1].annotated() {
+				out[n-1].ConfirmedReason, out[n-1].Planned = ch.ConfirmedReason, ch.Planned
+			}
+			continue
+		}
+		out = append(out, ch)
+	}
+	return out
+}
+
+// RunningIntervals reconstructs the intervals during which a machine was
+// running inside window. initial is the status in effect at window.Start
+// (empty if unknown). changes may be unordered, duplicated or flapping; they
+// are normalized first, and the intervals returned are merged, so running
+// time summed over them is never counted twice.
+func RunningIntervals(initial string, changes []StatusChange, window Interval) []Interval {
+	var out []Interval
+	changes = Normalize(changes)
+	status := initial
+	start := window.Start
+	for _, ch := range changes {
+		if ch.Time.Before(window.Start) {
+			status = ch.Status
+			continue
+		}
+		if !ch.Time.Before(window.End) {
+			break
+		}
+		if status == StatusRunning {
+			out = append(out, Interval{Start: start, End: ch.Time})
+		}
+		status = ch.Status
+		start = ch.Time
+	}
+	if status == StatusRunning {
+		out = append(out, Interval{Start: start, End: window.End})
+	}
+	return Merge(out)
+}
+
+// ProductRun is how many parts of one product a machine made, whatever their
+// quality, and the machine's ideal cycle time for that product.
+type ProductRun struct {
+	Product        string
+	IdealCycleTime time.Duration
+	Count          int
+}
+
+// Input holds the raw figures for one machine over one window.
+type Input struct {
+	Window Interval
+	// Running may be unordered and overlap; Calculate merges it.
+	Running         []Interval
+	PlannedDowntime []Interval
+	// PlannedTime, when positive, is the planned production time as the
+	// caller knows it, from an MES for instance. It replaces the time
+	// derived from Window and PlannedDowntime, which is then ignored.
+	PlannedTime    time.Duration
+	IdealCycleTime time.Duration
+	// Products splits the parts by product. When set, performance uses
+	// each product's own ideal cycle time and IdealCycleTime is ignored.
+	Products      []ProductRun
+	GoodCount     int
+	ReworkedCount int
+	ScrapCount    int
+	// WarmupScrapCount is the part of ScrapCount scrapped while the
+	// machine warmed up after a restart or changeover.
+	WarmupScrapCount int
+	// PlannedSlowdown is the time beyond the ideal cycle time the machine
+	// was meant to take for parts made at a planned slowdown. It is
+	// performance loss like any other and only changes how AttributeLosses
+	// breaks that down.
+	PlannedSlowdown time.Duration
+}
+
+// TotalCount is every part the machine made, whatever its quality outcome.
+func (in Input) TotalCount() int {
+	return in.GoodCount + in.ReworkedCount + in.ScrapCount
+}
+
+// Result is the OEE breakdown for one machine over one window.
+type Result struct {
+	From                   time.Time `json:"from"`
+	To                     time.Time `json:"to"`
+	PlannedSeconds         float64   `json:"planned_seconds"`
+	PlannedDowntimeSeconds float64   `json:"planned_downtime_seconds"`
+	RunSeconds             float64   `json:"run_seconds"`
+	MicroStopSeconds       float64   `json:"micro_stop_seconds"`
+	IdealCycleTimeSec      float64   `json:"ideal_cycle_time_sec"`
+	GoodCount              int       `json:"good_count"`
+	ReworkedCount          int       `json:"reworked_count"`
+	ScrapCount             int       `json:"scrap_count"`
+	WarmupScrapCount       int       `json:"warmup_scrap_count"`
+	TotalCount             int       `json:"total_count"`
+	Availability           float64   `json:"availability"`
+	Performance            float64   `json:"performance"`
+	Quality                float64   `json:"quality"`
+	OEE                    float64   `json:"oee"`
+	// FirstPassYield is the share of parts good at first inspection.
+	// FinalYield also counts the parts that failed it but were reworked
+	// successfully, in full whatever the rework credit. Quality lies between
+	// the two.
+	FirstPassYield float64 `json:"first_pass_yield"`
+	FinalYield     float64 `json:"final_yield"`
+	// Products is the per-product breakdown behind IdealCycleTimeSec, which
+	// is then the count-weighted average.
+	Products []ProductResult `json:"products,omitempty"`
+}
+
+// ProductResult is one product's share of the parts in a Result.
+type ProductResult struct {
+	Product           string  `json:"product"`
+	Count             int     `json:"count"`
+	IdealCycleTimeSec float64 `json:"ideal_cycle_time_sec"`
+}
+
+// Calculate computes availability, performance and quality for in under the
+// conventions in p.
+//
+// When p.ExcludePlannedDowntime is set, planned downtime windows are removed
+// from the planned production time regardless of what the machine reported
+// during them, and any running time that overlaps a planned window is not
+// counted either.
+//
+// When in.PlannedTime is set, availability is the run time over it, capped
+// at 1 if the machine ran for longer.
+func Calculate(in Input, p Policy) Result {
+	if in.PlannedTime > 0 {
+		in.PlannedDowntime = nil
+	}
+	t := splitWindow(in, p)
+	planned, productive, reported, running := t.planned, t.productive, t.reported, t.running
+
+	plannedTime := Total(productive)
+	if in.PlannedTime > 0 {
+		plannedTime = in.PlannedTime
+	}
+	runTime := Total(running)
+	microStops := runTime - Total(Subtract(reported, planned))
+	total := in.TotalCount()
+
+	r := Result{
+		From:                   in.Window.Start,
+		To:                     in.Window.End,
+		PlannedSeconds:         plannedTime.Seconds(),
+		PlannedDowntimeSeconds: Total(planned).Seconds(),
+		RunSeconds:             runTime.Seconds(),
+		MicroStopSeconds:       microStops.Seconds(),
+		IdealCycleTimeSec:      in.IdealCycleTime.Seconds(),
+		GoodCount:              in.GoodCount,
+		ReworkedCount:          in.ReworkedCount,
+		ScrapCount:             in.ScrapCount,
+		WarmupScrapCount:       in.WarmupScrapCount,
+		TotalCount:             total,
+	}
+	if plannedTime > 0 {
+		r.Availability = min(runTime.Seconds()/plannedTime.Seconds(), 1)
+	}
+
+	// Ideal time to make what was made; each product at its own speed
+	idealSeconds := in.IdealCycleTime.Seconds() * float64(total)
+	if len(in.Products) > 0 {
+		idealSeconds = 0
+		for _, p := range in.Products {
+			idealSeconds += p.IdealCycleTime.Seconds() * float64(p.Count)
+			r.Products = append(r.Products, ProductResult{
+				Product:           p.Product,
+				Count:             p.Count,
+				IdealCycleTimeSec: p.IdealCycleTime.Seconds(),
+			})
+		}
+		r.IdealCycleTimeSec = 0
+		if total > 0 {
+			r.IdealCycleTimeSec = idealSeconds / float64(total)
+		}
+	}
+
+	if runTime > 0 {
+		r.Performance = idealSeconds / runTime.Seconds()
+		if p.CapPerformance && r.Performance > 1 {
+			r.Performance = 1
+		}
+	}
+	if total > 0 {
+		graded := total
+		if p.ExcludeWarmupScrap {
+			graded -= in.WarmupScrapCount
+		}
+		if graded > 0 {
+			good := float64(in.GoodCount) + p.ReworkCredit*float64(in.ReworkedCount)
+			r.Quality = good / float64(graded)
+		}
+		r.FirstPassYield, r.FinalYield = Yields(in.GoodCount, in.ReworkedCount, total)
+	}
+	r.OEE = r.Availability * r.Performance * r.Quality
+	return r
+}
+
+// windowSplit is how Calculate divides an input's window.
+type windowSplit struct {
+	// planned is the planned downtime excluded from the window, if any.
+	planned []Interval
+	// productive is the planned production time: the window less planned.
+	productive []Interval
+	// reported is when the machine reported running, and running that
+	// with micro stops filled in, less planned.
+	reported []Interval
+	running  []Interval
+}
+
+func splitWindow(in Input, p Policy) windowSplit {
+	var t windowSplit
+	if p.ExcludePlannedDowntime {
+		t.planned = Merge(Clip(in.PlannedDowntime, in.Window))
+	}
+	t.productive = Subtract([]Interval{in.Window}, t.planned)
+	t.reported = Merge(Clip(in.Running, in.Window))
+	// Stops shorter than the threshold are speed losses, not availability
+	// losses, so they count as run time.
+	t.running = Subtract(FillGaps(t.reported, p.MicroStopThreshold), t.planned)
+	return t
+}
+
+// Yields returns the first-pass and final yield of total parts, good of
+// which passed first inspection and reworked of which passed after rework.
+// Both are zero when no parts were made.
+func Yields(good, reworked, total int) (firstPass, final float64) {
+	if total == 0 {
+		return 0, 0
+	}
+	return float64(good) / float64(total), float64(good+reworked) / float64(total)
+}
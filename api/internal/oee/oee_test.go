@@ -0,0 +1,181 @@
+package oee
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2025, 11, 5, 8, 0, 0, 0, time.UTC)
+
+// at returns the time m minutes after t0.
+func at(m int) time.Time {
+	return t0.Add(time.Duration(m) * time.Minute)
+}
+
+func change(m int, status, reason string) StatusChange {
+	return StatusChange{Time: at(m), Status: status, Reason: reason}
+}
+
+func TestNormalize(t *testing.T) {
+	planned := true
+	tests := []struct {
+		name    string
+		changes []StatusChange
+		want    []StatusChange
+	}{
+		{"empty", nil, []StatusChange{}},
+		{
+			"unsorted",
+			[]StatusChange{change(20, "running", ""), change(0, "running", ""), change(10, "stopped", "jam")},
+			[]StatusChange{change(0, "running", ""), change(10, "stopped", "jam"), change(20, "running", "")},
+		},
+		{
+			"redelivered",
+			[]StatusChange{change(0, "running", ""), change(10, "stopped", "jam"), change(10, "stopped", "jam"), change(20, "running", "")},
+			[]StatusChange{change(0, "running", ""), change(10, "stopped", "jam"), change(20, "running", "")},
+		},
+		{
+			"redelivered out of order",
+			[]StatusChange{change(0, "running", ""), change(10, "stopped", "jam"), change(20, "running", ""), change(10, "stopped", "jam")},
+			[]StatusChange{change(0, "running", ""), change(10, "stopped", "jam"), change(20, "running", "")},
+		},
+		{
+			"repeated status",
+			[]StatusChange{change(0, "running", ""), change(5, "running", ""), change(10, "stopped", "jam"), change(15, "stopped", "jam")},
+			[]StatusChange{change(0, "running", ""), change(10, "stopped", "jam")},
+		},
+		{
+			"same status with a new reason",
+			[]StatusChange{change(0, "stopped", "jam"), change(5, "stopped", "material")},
+			[]StatusChange{change(0, "stopped", "jam"), change(5, "stopped", "material")},
+		},
+		{
+			"same instant keeps the last received",
+			[]StatusChange{change(0, "running", ""), change(10, "stopped", "jam"), change(10, "running", ""), change(20, "stopped", "material")},
+			[]StatusChange{change(0, "running", ""), change(20, "stopped", "material")},
+		},
+		{
+			"same instant as the first",
+			[]StatusChange{change(0, "stopped", "jam"), change(0, "running", "")},
+			[]StatusChange{change(0, "running", "")},
+		},
+		{
+			"flapping",
+			[]StatusChange{
+				change(0, "running", ""), change(1, "stopped", "jam"), change(1, "running", ""),
+				change(2, "running", ""), change(3, "stopped", "jam"), change(3, "stopped", "jam"),
+				change(4, "running", ""),
+			},
+			[]StatusChange{change(0, "running", ""), change(3, "stopped", "jam"), change(4, "running", "")},
+		},
+		{
+			"annotation of a repeat moves to the stop it repeats",
+			[]StatusChange{
+				change(0, "stopped", "jam"),
+				{Time: at(5), Status: "stopped", Reason: "jam", ConfirmedReason: "setup", Planned: &planned},
+			},
+			[]StatusChange{{Time: at(0), Status: "stopped", Reason: "jam", ConfirmedReason: "setup", Planned: &planned}},
+		},
+		{
+			"annotation of the stop wins over its repeat",
+			[]StatusChange{
+				{Time: at(0), Status: "stopped", Reason: "jam", ConfirmedReason: "material"},
+				{Time: at(5), Status: "stopped", Reason: "jam", ConfirmedReason: "setup"},
+			},
+			[]StatusChange{{Time: at(0), Status: "stopped", Reason: "jam", ConfirmedReason: "material"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := append([]StatusChange(nil), tt.changes...)
+			got := Normalize(tt.changes)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Normalize() =\n%v\nwant\n%v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.changes, in) {
+				t.Fatalf("Normalize modified its input: %v, was %v", tt.changes, in)
+			}
+		})
+	}
+}
+
+func TestRunningIntervals(t *testing.T) {
+	window := Interval{at(0), at(60)}
+	tests := []struct {
+		name    string
+		initial string
+		changes []StatusChange
+		want    []Interval
+	}{
+		{"no changes, running", StatusRunning, nil, []Interval{window}},
+		{"no changes, unknown", "", nil, nil},
+		{
+			"unsorted and redelivered",
+			"",
+			[]StatusChange{change(30, "stopped", "jam"), change(10, "running", ""), change(30, "stopped", "jam"), change(10, "running", "")},
+			[]Interval{{at(10), at(30)}},
+		},
+		{
+			"flapping within a run",
+			"",
+			[]StatusChange{change(10, "running", ""), change(20, "running", ""), change(30, "stopped", "jam"), change(30, "running", ""), change(40, "stopped", "jam")},
+			[]Interval{{at(10), at(40)}},
+		},
+		{
+			"changes before the window set the initial status",
+			"",
+			[]StatusChange{change(-30, "running", ""), change(-10, "stopped", "jam"), change(-5, "running", ""), change(20, "stopped", "jam")},
+			[]Interval{{at(0), at(20)}},
+		},
+		{
+			"changes after the window are ignored",
+			StatusRunning,
+			[]StatusChange{change(50, "stopped", "jam"), change(60, "running", ""), change(70, "stopped", "jam")},
+			[]Interval{{at(0), at(50)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RunningIntervals(tt.initial, tt.changes, window)
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("RunningIntervals() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Running intervals that overlap, such as those of a machine reported
+// twice, or nest or touch, count their shared time once.
+func TestCalculateOverlappingRunning(t *testing.T) {
+	window := Interval{at(0), at(60)}
+	tests := []struct {
+		name    string
+		running []Interval
+		want    time.Duration
+	}{
+		{"overlapping", []Interval{{at(0), at(30)}, {at(20), at(40)}}, 40 * time.Minute},
+		{"nested", []Interval{{at(0), at(40)}, {at(10), at(20)}}, 40 * time.Minute},
+		{"duplicated", []Interval{{at(10), at(30)}, {at(10), at(30)}}, 20 * time.Minute},
+		{"touching", []Interval{{at(0), at(20)}, {at(20), at(30)}}, 30 * time.Minute},
+		{"unsorted", []Interval{{at(40), at(50)}, {at(0), at(10)}, {at(5), at(15)}}, 25 * time.Minute},
+		{"beyond the window", []Interval{{at(-30), at(10)}, {at(50), at(90)}, {at(0), at(70)}}, 60 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Calculate(Input{Window: window, Running: tt.running, IdealCycleTime: time.Minute, GoodCount: 10}, Policy{})
+			if r.RunSeconds != tt.want.Seconds() {
+				t.Fatalf("RunSeconds = %v, want %v", r.RunSeconds, tt.want.Seconds())
+			}
+			if want := tt.want.Seconds() / window.Duration().Seconds(); r.Availability != want {
+				t.Fatalf("Availability = %v, want %v", r.Availability, want)
+			}
+			if want := 600 / tt.want.Seconds(); r.Performance != want {
+				t.Fatalf("Performance = %v, want %v", r.Performance, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package oee
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Policy holds the accounting conventions applied by Calculate. Plants
+// classify the same losses differently, so every convention that changes the
+// reported numbers is a knob here rather than hard-coded.
+type Policy struct {
+	// MicroStopThreshold is the length below which a stop is treated as a
+	// minor stoppage: the time counts as run time, so the loss shows up in
+	// performance instead of availability. Zero counts every stop against
+	// availability.
+	MicroStopThreshold time.Duration
+
+	// ReworkCredit is the fraction of a reworked part counted as good in the
+	// quality factor, between 0 (treated like scrap) and 1 (treated like a
+	// good part). Reworked parts always count in full towards performance,
+	// since they consumed machine time.
+	ReworkCredit float64
+
+	// CapPerformance clamps performance to 100%. Without it, an ideal cycle
+	// time set too high shows up as performance above 100%.
+	CapPerformance bool
+
+	// ExcludePlannedDowntime removes planned downtime windows from the
+	// availability denominator. Without it, planned stops count as
+	// availability losses like any other stop.
+	ExcludePlannedDowntime bool
+
+	// ExcludeWarmupScrap leaves the parts scrapped while a machine warmed
+	// up out of the quality factor, as parts that were never meant to be
+	// sold. Without it, they are scrap like any other. They still count
+	// towards performance, since they consumed machine time.
+	ExcludeWarmupScrap bool
+}
+
+// Policies are the named presets selectable with OEE_POLICY.
+var Policies = map[string]Policy{
+	// classic counts every stop against availability and gives reworked
+	// parts half credit.
+	"classic": {
+		ReworkCredit:           0.5,
+		ExcludePlannedDowntime: true,
+	},
+	// six-big-losses follows the Nakajima loss categories: stops under five
+	// minutes are "idling and minor stoppages" (a performance loss),
+	// rework is a quality defect, warmup scrap is "startup rejects" (a
+	// quality loss), and performance can't exceed 100%.
+	"six-big-losses": {
+		MicroStopThreshold:     5 * time.Minute,
+		ReworkCredit:           0,
+		CapPerformance:         true,
+		ExcludePlannedDowntime: true,
+	},
+}
+
+// DefaultPolicy is used when no other conventions are configured.
+var DefaultPolicy = Policies["classic"]
+
+// LoadPolicy builds a Policy from the OEE_POLICY preset (default "classic")
+// and then applies any individual overrides found through getenv.
+func LoadPolicy(getenv func(string) string) (Policy, error) {
+	name := getenv("OEE_POLICY")
+	if name == "" {
+		name = "classic"
+	}
+	p, ok := Policies[name]
+	if !ok {
+		return p, fmt.Errorf("unknown OEE_POLICY %q", name)
+	}
+
+	if raw := getenv("OEE_MICRO_STOP_THRESHOLD"); raw != "" {
+		sec, err := strconv.ParseFloat(raw, 64)
+		if err != nil || sec < 0 {
+			return p, fmt.Errorf("invalid OEE_MICRO_STOP_THRESHOLD %q: must be a non-negative number of seconds", raw)
+		}
+		p.MicroStopThreshold = time.Duration(sec * float64(time.Second))
+	}
+	if raw := getenv("REWORK_QUALITY_CREDIT"); raw != "" {
+		credit, err := strconv.ParseFloat(raw, 64)
+		if err != nil || credit < 0 || credit > 1 {
+			return p, fmt.Errorf("invalid REWORK_QUALITY_CREDIT %q: must be between 0 and 1", raw)
+		}
+		p.ReworkCredit = credit
+	}
+	if raw := getenv("OEE_CAP_PERFORMANCE"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return p, fmt.Errorf("invalid OEE_CAP_PERFORMANCE %q: %w", raw, err)
+		}
+		p.CapPerformance = v
+	}
+	if raw := getenv("OEE_EXCLUDE_PLANNED_DOWNTIME"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return p, fmt.Errorf("invalid OEE_EXCLUDE_PLANNED_DOWNTIME %q: %w", raw, err)
+		}
+		p.ExcludePlannedDowntime = v
+	}
+	if raw := getenv("OEE_EXCLUDE_WARMUP_SCRAP"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return p, fmt.Errorf("invalid OEE_EXCLUDE_WARMUP_SCRAP %q: %w", raw, err)
+		}
+		p.ExcludeWarmupScrap = v
+	}
+	return p, nil
+}
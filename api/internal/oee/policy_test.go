@@ -0,0 +1,191 @@
+package oee
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// env returns a getenv for LoadPolicy reading vars.
+func env(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+// policyInput is an hour in which the machine stops for 3 minutes at the
+// start, then for 2, then for 5, and makes 80 good parts, 10 reworked and
+// 10 scrapped, 4 of them during warmup, at an ideal 36 seconds each.
+var policyInput = Input{
+	Window:           Interval{at(0), at(60)},
+	Running:          []Interval{{at(3), at(20)}, {at(22), at(40)}, {at(45), at(60)}},
+	IdealCycleTime:   36 * time.Second,
+	GoodCount:        80,
+	ReworkedCount:    10,
+	ScrapCount:       10,
+	WarmupScrapCount: 4,
+}
+
+func TestCalculateUnderPresets(t *testing.T) {
+	tests := []struct {
+		preset string
+		// run and microStops are in minutes
+		run, microStops                    float64
+		availability, performance, quality float64
+	}{
+		// Every stop is an availability loss, rework earns half credit,
+		// warmup scrap is scrap and performance may exceed 100%
+		{"classic", 50, 0, 50.0 / 60, 3600.0 / 3000, 0.85},
+		// The 2-minute stop is a minor stoppage counted as run time; the 5
+		// minute one, at the threshold, and the one before the first run
+		// are not. Rework earns nothing, warmup scrap is a startup reject and
+		// performance is capped
+		{"six-big-losses", 52, 2, 52.0 / 60, 1, 0.8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			p, err := LoadPolicy(env(map[string]string{"OEE_POLICY": tt.preset}))
+			if err != nil {
+				t.Fatalf("LoadPolicy: %v", err)
+			}
+			r := Calculate(policyInput, p)
+			checks := []struct {
+				name      string
+				got, want float64
+			}{
+				{"RunSeconds", r.RunSeconds, tt.run * 60},
+				{"MicroStopSeconds", r.MicroStopSeconds, tt.microStops * 60},
+				{"Availability", r.Availability, tt.availability},
+				{"Performance", r.Performance, tt.performance},
+				{"Quality", r.Quality, tt.quality},
+				{"OEE", r.OEE, tt.availability * tt.performance * tt.quality},
+				// Yields don't depend on the rework credit
+				{"FirstPassYield", r.FirstPassYield, 0.8},
+				{"FinalYield", r.FinalYield, 0.9},
+			}
+			for _, c := range checks {
+				if math.Abs(c.got-c.want) > 1e-9 {
+					t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+				}
+			}
+			if r.ReworkedCount != 10 || r.WarmupScrapCount != 4 || r.TotalCount != 100 {
+				t.Errorf("counts = %d reworked and %d warmup scrap of %d, want 10 and 4 of 100", r.ReworkedCount, r.WarmupScrapCount, r.TotalCount)
+			}
+		})
+	}
+}
+
+// Planned downtime is excluded under both presets, and a minor stoppage
+// inside it isn't run time.
+func TestCalculatePresetsExcludePlannedDowntime(t *testing.T) {
+	in := policyInput
+	in.PlannedDowntime = []Interval{{at(15), at(25)}}
+	for _, preset := range []string{"classic", "six-big-losses"} {
+		p, err := LoadPolicy(env(map[string]string{"OEE_POLICY": preset}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := Calculate(in, p)
+		if r.PlannedSeconds != 50*60 || r.PlannedDowntimeSeconds != 10*60 {
+			t.Errorf("%s: planned %vs with %vs planned downtime, want 3000s and 600s", preset, r.PlannedSeconds, r.PlannedDowntimeSeconds)
+		}
+		if r.RunSeconds != 42*60 {
+			t.Errorf("%s: RunSeconds = %v, want 2520", preset, r.RunSeconds)
+		}
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]string
+		want Policy
+	}{
+		{"default", nil, Policies["classic"]},
+		{"classic", map[string]string{"OEE_POLICY": "classic"}, Policy{ReworkCredit: 0.5, ExcludePlannedDowntime: true}},
+		{"six-big-losses", map[string]string{"OEE_POLICY": "six-big-losses"}, Policy{MicroStopThreshold: 5 * time.Minute, CapPerformance: true, ExcludePlannedDowntime: true}},
+		{
+			"overrides",
+			map[string]string{"OEE_MICRO_STOP_THRESHOLD": "90.5", "REWORK_QUALITY_CREDIT": "1", "OEE_CAP_PERFORMANCE": "true", "OEE_EXCLUDE_PLANNED_DOWNTIME": "false", "OEE_EXCLUDE_WARMUP_SCRAP": "true"},
+			Policy{MicroStopThreshold: 90500 * time.Millisecond, ReworkCredit: 1, CapPerformance: true, ExcludeWarmupScrap: true},
+		},
+		{
+			"overrides of a preset",
+			map[string]string{"OEE_POLICY": "six-big-losses", "OEE_MICRO_STOP_THRESHOLD": "0", "REWORK_QUALITY_CREDIT": "0.25"},
+			Policy{ReworkCredit: 0.25, CapPerformance: true, ExcludePlannedDowntime: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadPolicy(env(tt.vars))
+			if err != nil {
+				t.Fatalf("LoadPolicy: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("LoadPolicy = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// An override changes how Calculate classifies the same hour.
+func TestCalculateWithOverrides(t *testing.T) {
+	p, err := LoadPolicy(env(map[string]string{"OEE_MICRO_STOP_THRESHOLD": "301", "REWORK_QUALITY_CREDIT": "1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Calculate(policyInput, p)
+	// Now the 5-minute stop is a minor stoppage too, and rework is good
+	if r.RunSeconds != 57*60 || r.MicroStopSeconds != 7*60 {
+		t.Errorf("run %vs with %vs micro stops, want 3420s and 420s", r.RunSeconds, r.MicroStopSeconds)
+	}
+	if r.Quality != 0.9 {
+		t.Errorf("Quality = %v, want 0.9", r.Quality)
+	}
+}
+
+// Excluding warmup scrap takes it out of quality under either preset, and
+// out of the startup rejects, but not out of performance or the yields.
+func TestCalculatePresetsExcludeWarmupScrap(t *testing.T) {
+	tests := []struct {
+		preset      string
+		performance float64
+		quality     float64
+	}{
+		{"classic", 3600.0 / 3000, 85.0 / 96},
+		{"six-big-losses", 1, 80.0 / 96},
+	}
+	for _, tt := range tests {
+		p, err := LoadPolicy(env(map[string]string{"OEE_POLICY": tt.preset, "OEE_EXCLUDE_WARMUP_SCRAP": "true"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := Calculate(policyInput, p)
+		if math.Abs(r.Quality-tt.quality) > 1e-9 || math.Abs(r.Performance-tt.performance) > 1e-9 {
+			t.Errorf("%s: quality %v, performance %v, want %v and %v", tt.preset, r.Quality, r.Performance, tt.quality, tt.performance)
+		}
+		if r.FirstPassYield != 0.8 || r.FinalYield != 0.9 {
+			t.Errorf("%s: yields %v and %v, want 0.8 and 0.9", tt.preset, r.FirstPassYield, r.FinalYield)
+		}
+		for _, reason := range AttributeLosses(policyInput, p, nil).Quality.Reasons {
+			if reason.Reason == ReasonStartupRejects {
+				t.Errorf("%s: startup rejects of %v minutes with warmup scrap excluded", tt.preset, reason.Minutes)
+			}
+		}
+	}
+}
+
+func TestLoadPolicyErrors(t *testing.T) {
+	for _, vars := range []map[string]string{
+		{"OEE_POLICY": "tpm"},
+		{"OEE_MICRO_STOP_THRESHOLD": "-1"},
+		{"OEE_MICRO_STOP_THRESHOLD": "5m"},
+		{"REWORK_QUALITY_CREDIT": "1.5"},
+		{"REWORK_QUALITY_CREDIT": "half"},
+		{"OEE_CAP_PERFORMANCE": "sometimes"},
+		{"OEE_EXCLUDE_PLANNED_DOWNTIME": "maybe"},
+		{"OEE_EXCLUDE_WARMUP_SCRAP": "sometimes"},
+	} {
+		if p, err := LoadPolicy(env(vars)); err == nil {
+			t.Errorf("LoadPolicy(%v) = %+v, want error", vars, p)
+		}
+	}
+}
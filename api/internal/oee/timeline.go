@@ -0,0 +1,62 @@
+package oee
+
+import "time"
+
+// StatusUnknown labels the part of a timeline before a machine first
+// reported a status.
+const StatusUnknown = "unknown"
+
+// State is a period during which a machine stayed in one status.
+type State struct {
+	Interval
+	Status string `json:"status"`
+	// Reason is the reason an operator confirmed, if they did, and
+	// ReportedReason then the one the machine reported.
+	Reason         string `json:"reason,omitempty"`
+	ReportedReason string `json:"reported_reason,omitempty"`
+	Planned        *bool  `json:"planned,omitempty"`
+	// Began is when the machine entered the state, which for the first
+	// state may be before the window; zero for StatusUnknown.
+	Began time.Time `json:"-"`
+}
+
+// Timeline reconstructs the machine's states across window, in order and
+// covering all of it: each state runs from its status change to the next
+// one, the first is clipped to window.Start and the last runs to
+// window.End. changes may include the last change before window.Start,
+// which sets the state the window opens in, and are normalized first. A
+// window with no changes in it is a single state, StatusUnknown if the
+// machine had never reported one.
+func Timeline(changes []StatusChange, window Interval) []State {
+	cur := StatusChange{Time: window.Start, Status: StatusUnknown}
+	var began time.Time
+	var out []State
+	for _, ch := range Normalize(changes) {
+		if !ch.Time.Before(window.End) {
+			break
+		}
+		if ch.Time.After(window.Start) && ch.Time.After(cur.Time) {
+			out = append(out, state(cur, ch.Time, began))
+		}
+		cur, began = ch, ch.Time
+		if cur.Time.Before(window.Start) {
+			cur.Time = window.Start
+		}
+	}
+	return append(out, state(cur, window.End, began))
+}
+
+// state returns the state cur starts, lasting until end, entered at began.
+func state(cur StatusChange, end, began time.Time) State {
+	s := State{
+		Interval: Interval{Start: cur.Time, End: end},
+		Status:   cur.Status,
+		Reason:   cur.EffectiveReason(),
+		Planned:  cur.Planned,
+		Began:    began,
+	}
+	if cur.ConfirmedReason != "" {
+		s.ReportedReason = cur.Reason
+	}
+	return s
+}
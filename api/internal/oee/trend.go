@@ -0,0 +1,44 @@
+package oee
+
+import "math"
+
+// Fit is a least-squares line y = Intercept + Slope·x.
+type Fit struct {
+	Slope     float64 `json:"slope"`
+	Intercept float64 `json:"intercept"`
+	// RSquared is the share of the variance in y the line explains, from 0
+	// to 1. It is 0 when y doesn't vary.
+	RSquared float64 `json:"r_squared"`
+}
+
+// LinearFit fits a line through the points (xs[i], ys[i]). ok is false when
+// there are fewer than two points or all xs are equal, so no slope exists.
+func LinearFit(xs, ys []float64) (fit Fit, ok bool) {
+	n := float64(len(xs))
+	if len(xs) < 2 || len(xs) != len(ys) {
+		return Fit{}, false
+	}
+	var sumX, sumY float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+
+	var sxx, sxy, syy float64
+	for i := range xs {
+		dx, dy := xs[i]-meanX, ys[i]-meanY
+		sxx += dx * dx
+		sxy += dx * dy
+		syy += dy * dy
+	}
+	if sxx == 0 {
+		return Fit{}, false
+	}
+	fit.Slope = sxy / sxx
+	fit.Intercept = meanY - fit.Slope*meanX
+	if syy > 0 {
+		fit.RSquared = math.Min(1, sxy*sxy/(sxx*syy))
+	}
+	return fit, true
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// DowntimeAnnotation is what an operator recorded about a stop, identified
+// by its machine and the time of the status event that started it.
+type DowntimeAnnotation struct {
+	Site      string    `json:"site,omitempty"`
+	MachineID int       `json:"machine_id"`
+	StartTime time.Time `json:"start_time"`
+	// Reason is the confirmed reason, replacing the one the machine
+	// reported; empty keeps the reported one.
+	Reason  string `json:"reason"`
+	Comment string `json:"comment"`
+	// Planned classifies the stop as planned or unplanned downtime,
+	// overriding the planned downtime windows; nil leaves them to decide.
+	Planned     *bool     `json:"planned"`
+	AnnotatedAt time.Time `json:"annotated_at"`
+}
+
+// StatusAt returns the status machine reported at exactly at, or
+// ErrNotFound if no status event has that time.
+func (s *Store) StatusAt(ctx context.Context, machine machineid.Key, at time.Time) (string, error) {
+	var status string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT status FROM status_events WHERE site = $1 AND machine_id = $2 AND time = $3 LIMIT 1`,
+		machine.Site, machine.ID, at,
+	).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("query status at %s: %w", at.Format(time.RFC3339Nano), err)
+	}
+	return status, nil
+}
+
+// AnnotateDowntime stores a, replacing any earlier annotation of the same
+// stop, and fills in its AnnotatedAt.
+func (s *Store) AnnotateDowntime(ctx context.Context, a *DowntimeAnnotation) error {
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO downtime_annotations (site, machine_id, start_time, reason, comment, planned)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (site, machine_id, start_time) DO UPDATE SET
+			reason = EXCLUDED.reason,
+			comment = EXCLUDED.comment,
+			planned = EXCLUDED.planned,
+			annotated_at = now()
+		RETURNING annotated_at`,
+		a.Site, a.MachineID, a.StartTime, a.Reason, a.Comment, a.Planned,
+	).Scan(&a.AnnotatedAt)
+	if err != nil {
+		return fmt.Errorf("insert downtime annotation: %w", err)
+	}
+	return nil
+}
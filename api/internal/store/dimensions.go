@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+)
+
+// Dimensions are the distinct values the stored events can be filtered by.
+type Dimensions struct {
+	MachineIDs []int    `json:"machine_ids"`
+	Sites      []string `json:"sites"`
+	Products   []string `json:"products"`
+	// Unavailable lists the dimensions the schema has no column for yet,
+	// whose lists are then empty.
+	Unavailable []string `json:"unavailable,omitempty"`
+}
+
+// dimensionColumns are the columns behind the dimensions that later
+// migrations added, by dimension.
+var dimensionColumns = []struct{ dimension, table, column string }{
+	{"products", "production_events", "product"},
+}
+
+// Dimensions returns the machines, sites and products seen in window, or
+// ever if window is nil: every registered machine, the sites they were
+// registered at and every product made. In a window a machine is seen if
+// it reported any status or production event in it. Machine IDs are listed
+// once however many sites use them.
+func (s *Store) Dimensions(ctx context.Context, window *oee.Interval) (Dimensions, error) {
+	d := Dimensions{MachineIDs: []int{}, Sites: []string{}, Products: []string{}}
+	has := map[string]bool{}
+	for _, c := range dimensionColumns {
+		var exists bool
+		if err := s.db.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2)`,
+			c.table, c.column,
+		).Scan(&exists); err != nil {
+			return d, fmt.Errorf("check column %s.%s: %w", c.table, c.column, err)
+		}
+		has[c.dimension] = exists
+		if !exists {
+			d.Unavailable = append(d.Unavailable, c.dimension)
+		}
+	}
+
+	// Machines seen in the window; with none, the machines registry
+	seen := `SELECT site, id FROM machines`
+	var args []any
+	if window != nil {
+		seen = `SELECT site, machine_id FROM status_events WHERE time >= $1 AND time < $2
+			UNION SELECT site, machine_id FROM production_events WHERE time >= $1 AND time < $2`
+		args = []any{window.Start, window.End}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT id FROM (`+seen+`) seen(site, id) ORDER BY id`, args...)
+	if err != nil {
+		return d, fmt.Errorf("query machine ids: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return d, fmt.Errorf("scan machine id: %w", err)
+		}
+		d.MachineIDs = append(d.MachineIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return d, fmt.Errorf("query machine ids: %w", err)
+	}
+
+	if d.Sites, err = s.distinct(ctx,
+		`SELECT DISTINCT site FROM (`+seen+`) seen(site, id) WHERE site <> '' ORDER BY site`,
+		args...); err != nil {
+		return d, fmt.Errorf("query sites: %w", err)
+	}
+	if has["products"] {
+		query := `SELECT DISTINCT product FROM production_events WHERE product <> '' ORDER BY product`
+		if window != nil {
+			query = `SELECT DISTINCT product FROM production_events WHERE product <> '' AND time >= $1 AND time < $2 ORDER BY product`
+		}
+		if d.Products, err = s.distinct(ctx, query, args...); err != nil {
+			return d, fmt.Errorf("query products: %w", err)
+		}
+	}
+	return d, nil
+}
+
+// distinct returns the strings query selects, in order.
+func (s *Store) distinct(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []string{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// Cursor is a keyset position in the (time, site, machine_id) ordering of
+// an events table. The zero Cursor starts from the beginning.
+type Cursor struct {
+	Time    time.Time
+	Machine machineid.Key
+}
+
+// StatusEvent is a row from the status_events table.
+type StatusEvent struct {
+	Time      time.Time `json:"time"`
+	Site      string    `json:"site,omitempty"`
+	MachineID int       `json:"machine_id"`
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason,omitempty"`
+	// Suspect marks a status the ingestion service flagged as an
+	// impossible transition from the machine's previous status.
+	Suspect bool `json:"suspect"`
+}
+
+// ProductionEvent is a row from the production_events table.
+type ProductionEvent struct {
+	Time          time.Time `json:"time"`
+	Site          string    `json:"site,omitempty"`
+	MachineID     int       `json:"machine_id"`
+	PartsProduced int       `json:"parts_produced"`
+	PartsScrapped int       `json:"parts_scrapped"`
+	PartsReworked int       `json:"parts_reworked"`
+	// SampleWeight is how many events this row stands for when the
+	// ingestion service samples production events.
+	SampleWeight int `json:"sample_weight"`
+	// Cycle is the machine's cycle index for the part, if its producer
+	// counts cycles.
+	Cycle *int64 `json:"cycle,omitempty"`
+	// Warmup marks a part made while the machine was warming up after a
+	// restart or changeover.
+	Warmup bool `json:"warmup"`
+}
+
+// machineFilter turns an optional machine into query arguments for its
+// site and ID; a nil machine leaves the ID NULL to match every machine.
+func machineFilter(machine *machineid.Key) (string, sql.NullInt64) {
+	if machine == nil {
+		return "", sql.NullInt64{}
+	}
+	return machine.Site, sql.NullInt64{Int64: int64(machine.ID), Valid: true}
+}
+
+// ListStatusEvents returns up to limit status events strictly after the
+// cursor, ordered by (time, site, machine_id).
+func (s *Store) ListStatusEvents(ctx context.Context, machine *machineid.Key, after Cursor, limit int) ([]StatusEvent, error) {
+	site, id := machineFilter(machine)
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT time, site, machine_id, status, reason, suspect FROM status_events
+		WHERE ($2::int IS NULL OR (site, machine_id) = ($1, $2)) AND (time, site, machine_id) > ($3, $4, $5)
+		ORDER BY time, site, machine_id
+		LIMIT $6`,
+		site, id, after.Time, after.Machine.Site, after.Machine.ID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query status events: %w", err)
+	}
+	defer rows.Close()
+
+	out := []StatusEvent{}
+	for rows.Next() {
+		var e StatusEvent
+		if err := rows.Scan(&e.Time, &e.Site, &e.MachineID, &e.Status, &e.Reason, &e.Suspect); err != nil {
+			return nil, fmt.Errorf("scan status event: %w", err)
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// ListProductionEvents returns up to limit production events strictly after
+// the cursor, ordered by (time, site, machine_id).
+func (s *Store) ListProductionEvents(ctx context.Context, machine *machineid.Key, after Cursor, limit int) ([]ProductionEvent, error) {
+	site, id := machineFilter(machine)
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT time, site, machine_id, parts_produced, parts_scrapped, parts_reworked, sample_weight, cycle, warmup FROM production_events
+		WHERE ($2::int IS NULL OR (site, machine_id) = ($1, $2)) AND (time, site, machine_id) > ($3, $4, $5)
+		ORDER BY time, site, machine_id
+		LIMIT $6`,
+		site, id, after.Time, after.Machine.Site, after.Machine.ID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query production events: %w", err)
+	}
+	defer rows.Close()
+
+	out := []ProductionEvent{}
+	for rows.Next() {
+		var e ProductionEvent
+		if err := rows.Scan(&e.Time, &e.Site, &e.MachineID, &e.PartsProduced, &e.PartsScrapped, &e.PartsReworked, &e.SampleWeight, &e.Cycle, &e.Warmup); err != nil {
+			return nil, fmt.Errorf("scan production event: %w", err)
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
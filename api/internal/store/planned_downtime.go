@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// PlannedDowntime is a scheduled maintenance window for one machine.
+type PlannedDowntime struct {
+	ID        int       `json:"id"`
+	Site      string    `json:"site,omitempty"`
+	MachineID int       `json:"machine_id"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListPlannedDowntime returns the windows for machine that overlap
+// [from, to), ordered by start time.
+func (s *Store) ListPlannedDowntime(ctx context.Context, machine machineid.Key, from, to time.Time) ([]PlannedDowntime, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, site, machine_id, start_time, end_time, reason, created_at
+		FROM planned_downtime
+		WHERE site = $1 AND machine_id = $2 AND start_time < $4 AND end_time > $3
+		ORDER BY start_time`,
+		machine.Site, machine.ID, from, to,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query planned downtime: %w", err)
+	}
+	defer rows.Close()
+
+	out := []PlannedDowntime{}
+	for rows.Next() {
+		var pd PlannedDowntime
+		if err := rows.Scan(&pd.ID, &pd.Site, &pd.MachineID, &pd.StartTime, &pd.EndTime, &pd.Reason, &pd.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan planned downtime: %w", err)
+		}
+		out = append(out, pd)
+	}
+	return out, rows.Err()
+}
+
+// ActivePlannedDowntime returns the window machine is in at at, or
+// ErrNotFound if there is none. Of overlapping windows it returns the one
+// that ends last.
+func (s *Store) ActivePlannedDowntime(ctx context.Context, machine machineid.Key, at time.Time) (PlannedDowntime, error) {
+	var pd PlannedDowntime
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, site, machine_id, start_time, end_time, reason, created_at
+		FROM planned_downtime
+		WHERE site = $1 AND machine_id = $2 AND start_time <= $3 AND end_time > $3
+		ORDER BY end_time DESC LIMIT 1`,
+		machine.Site, machine.ID, at,
+	).Scan(&pd.ID, &pd.Site, &pd.MachineID, &pd.StartTime, &pd.EndTime, &pd.Reason, &pd.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return pd, ErrNotFound
+	}
+	if err != nil {
+		return pd, fmt.Errorf("query active planned downtime: %w", err)
+	}
+	return pd, nil
+}
+
+// CreatePlannedDowntime inserts pd and fills in its generated fields.
+func (s *Store) CreatePlannedDowntime(ctx context.Context, pd *PlannedDowntime) error {
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO planned_downtime (site, machine_id, start_time, end_time, reason)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		pd.Site, pd.MachineID, pd.StartTime, pd.EndTime, pd.Reason,
+	).Scan(&pd.ID, &pd.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert planned downtime: %w", err)
+	}
+	return nil
+}
+
+// DeletePlannedDowntime removes the window with the given id.
+func (s *Store) DeletePlannedDowntime(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM planned_downtime WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("delete planned downtime %d: %w", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
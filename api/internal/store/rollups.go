@@ -0,0 +1,154 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// Machines returns the key of every registered machine, ordered by site
+// and then ID.
+func (s *Store) Machines(ctx context.Context) ([]machineid.Key, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT site, id FROM machines ORDER BY site, id`)
+	if err != nil {
+		return nil, fmt.Errorf("query machines: %w", err)
+	}
+	defer rows.Close()
+
+	var out []machineid.Key
+	for rows.Next() {
+		var k machineid.Key
+		if err := rows.Scan(&k.Site, &k.ID); err != nil {
+			return nil, fmt.Errorf("scan machine: %w", err)
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+// UpsertHourly stores r as machine's OEE for the hour starting at bucket,
+// replacing any row already there so rebuilds can overlap live writers.
+func (s *Store) UpsertHourly(ctx context.Context, machine machineid.Key, bucket time.Time, r oee.Result) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO oee_hourly (site, machine_id, bucket, planned_seconds, run_seconds, micro_stop_seconds,
+			ideal_cycle_time_sec, good_count, reworked_count, scrap_count,
+			availability, performance, quality, oee, computed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, now())
+		ON CONFLICT (site, machine_id, bucket) DO UPDATE SET
+			planned_seconds = EXCLUDED.planned_seconds,
+			run_seconds = EXCLUDED.run_seconds,
+			micro_stop_seconds = EXCLUDED.micro_stop_seconds,
+			ideal_cycle_time_sec = EXCLUDED.ideal_cycle_time_sec,
+			good_count = EXCLUDED.good_count,
+			reworked_count = EXCLUDED.reworked_count,
+			scrap_count = EXCLUDED.scrap_count,
+			availability = EXCLUDED.availability,
+			performance = EXCLUDED.performance,
+			quality = EXCLUDED.quality,
+			oee = EXCLUDED.oee,
+			computed_at = EXCLUDED.computed_at`,
+		machine.Site, machine.ID, bucket, r.PlannedSeconds, r.RunSeconds, r.MicroStopSeconds,
+		r.IdealCycleTimeSec, r.GoodCount, r.ReworkedCount, r.ScrapCount,
+		r.Availability, r.Performance, r.Quality, r.OEE,
+	)
+	if err != nil {
+		return fmt.Errorf("upsert hourly oee for machine %s at %s: %w", machine, bucket.Format(time.RFC3339), err)
+	}
+	return nil
+}
+
+// RollupTotals is a sum of oee_hourly rows.
+type RollupTotals struct {
+	PlannedSeconds float64
+	RunSeconds     float64
+	// IdealSeconds is the time the parts would have taken at the ideal
+	// cycle time, summed from each hour's performance × run time.
+	IdealSeconds  float64
+	GoodCount     int
+	ReworkedCount int
+	ScrapCount    int
+}
+
+// TotalCount is every part counted in the rows, whatever its quality.
+func (t RollupTotals) TotalCount() int {
+	return t.GoodCount + t.ReworkedCount + t.ScrapCount
+}
+
+// DailyRollup is a machine's oee_hourly rows for one UTC day, summed.
+type DailyRollup struct {
+	Day time.Time
+	RollupTotals
+}
+
+// DailyRollups sums machine's hourly rollups per UTC day over
+// [from, to), in day order. Days without any rollup rows are omitted.
+func (s *Store) DailyRollups(ctx context.Context, machine machineid.Key, from, to time.Time) ([]DailyRollup, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT (bucket AT TIME ZONE 'UTC')::date AS day,
+			SUM(planned_seconds), SUM(run_seconds), SUM(performance * run_seconds),
+			SUM(good_count), SUM(reworked_count), SUM(scrap_count)
+		FROM oee_hourly
+		WHERE site = $1 AND machine_id = $2 AND bucket >= $3 AND bucket < $4
+		GROUP BY day
+		ORDER BY day`,
+		machine.Site, machine.ID, from, to,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query daily rollups: %w", err)
+	}
+	defer rows.Close()
+
+	var out []DailyRollup
+	for rows.Next() {
+		var d DailyRollup
+		if err := rows.Scan(&d.Day, &d.PlannedSeconds, &d.RunSeconds, &d.IdealSeconds,
+			&d.GoodCount, &d.ReworkedCount, &d.ScrapCount); err != nil {
+			return nil, fmt.Errorf("scan daily rollup: %w", err)
+		}
+		d.Day = d.Day.UTC()
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// MachineRollup is one machine's oee_hourly rows over a window, summed.
+type MachineRollup struct {
+	Machine machineid.Key
+	Name    string
+	RollupTotals
+}
+
+// MachineRollups sums every machine's hourly rollups with buckets in
+// [from, to), in machine order. Machines without rollup rows in the window
+// are omitted.
+func (s *Store) MachineRollups(ctx context.Context, from, to time.Time) ([]MachineRollup, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT m.site, m.id, m.name,
+			SUM(r.planned_seconds), SUM(r.run_seconds), SUM(r.performance * r.run_seconds),
+			SUM(r.good_count), SUM(r.reworked_count), SUM(r.scrap_count)
+		FROM oee_hourly r
+		JOIN machines m ON m.site = r.site AND m.id = r.machine_id
+		WHERE r.bucket >= $1 AND r.bucket < $2
+		GROUP BY m.site, m.id, m.name
+		ORDER BY m.site, m.id`,
+		from, to,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query machine rollups: %w", err)
+	}
+	defer rows.Close()
+
+	var out []MachineRollup
+	for rows.Next() {
+		var m MachineRollup
+		if err := rows.Scan(&m.Machine.Site, &m.Machine.ID, &m.Name, &m.PlannedSeconds, &m.RunSeconds, &m.IdealSeconds,
+			&m.GoodCount, &m.ReworkedCount, &m.ScrapCount); err != nil {
+			return nil, fmt.Errorf("scan machine rollup: %w", err)
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,35 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PartSerial is a row from the part_serials table: a part that shipped and
+// the production event it was made in.
+type PartSerial struct {
+	Serial    string    `json:"serial"`
+	Site      string    `json:"site,omitempty"`
+	MachineID int       `json:"machine_id"`
+	Time      time.Time `json:"time"`
+	LotID     string    `json:"lot_id,omitempty"`
+	Product   string    `json:"product,omitempty"`
+}
+
+// FindSerial returns the part with the given serial.
+func (s *Store) FindSerial(ctx context.Context, serial string) (PartSerial, error) {
+	p := PartSerial{Serial: serial}
+	err := s.db.QueryRowContext(ctx,
+		`SELECT site, machine_id, time, lot_id, product FROM part_serials WHERE serial = $1`, serial,
+	).Scan(&p.Site, &p.MachineID, &p.Time, &p.LotID, &p.Product)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, ErrNotFound
+	}
+	if err != nil {
+		return p, fmt.Errorf("query serial %q: %w", serial, err)
+	}
+	return p, nil
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Shift is one shift of the plant's schedule, from the shifts table. Start
+// and End are wall-clock times, as offsets from midnight; a shift whose End
+// is not after its Start runs past midnight into the next day.
+type Shift struct {
+	ID    int
+	Name  string
+	Start time.Duration
+	End   time.Duration
+}
+
+// Shifts returns the shift schedule, ordered by start time.
+func (s *Store) Shifts(ctx context.Context) ([]Shift, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, name, EXTRACT(EPOCH FROM start_time)::int, EXTRACT(EPOCH FROM end_time)::int
+		FROM shifts
+		ORDER BY start_time, id`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query shifts: %w", err)
+	}
+	defer rows.Close()
+
+	var out []Shift
+	for rows.Next() {
+		var sh Shift
+		var start, end int
+		if err := rows.Scan(&sh.ID, &sh.Name, &start, &end); err != nil {
+			return nil, fmt.Errorf("scan shift: %w", err)
+		}
+		sh.Start, sh.End = time.Duration(start)*time.Second, time.Duration(end)*time.Second
+		out = append(out, sh)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,299 @@
+// Package store wraps the TimescaleDB queries used by the API.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/api/internal/oee"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// ErrNotFound is returned when a requested row does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrAmbiguous is returned when a lookup matches more than one row but the
+// caller needs exactly one.
+var ErrAmbiguous = errors.New("ambiguous")
+
+// Store runs queries against the OEE database.
+type Store struct {
+	db *sql.DB
+	// inspections grades parts by their latest inspection, if they have one.
+	inspections bool
+}
+
+// New returns a Store backed by db.
+func New(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// GradeByInspection makes the production totals take the quality of each
+// inspected part from its latest inspection rather than from the machine:
+// a pass counts a scrapped part as good, a fail counts any part as scrap.
+// Parts not inspected yet keep the machine's grading.
+func (s *Store) GradeByInspection() {
+	s.inspections = true
+}
+
+// Machine is a row from the machines table.
+type Machine struct {
+	ID                int     `json:"id"`
+	Site              string  `json:"site,omitempty"`
+	Name              string  `json:"name"`
+	IdealCycleTimeSec float64 `json:"ideal_cycle_time_sec"`
+}
+
+// Key returns the key the machine is registered under.
+func (m Machine) Key() machineid.Key {
+	return machineid.Key{Site: m.Site, ID: m.ID}
+}
+
+// Machine returns the machine with the given key.
+func (s *Store) Machine(ctx context.Context, key machineid.Key) (Machine, error) {
+	var m Machine
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, site, name, ideal_cycle_time_sec FROM machines WHERE site = $1 AND id = $2`, key.Site, key.ID,
+	).Scan(&m.ID, &m.Site, &m.Name, &m.IdealCycleTimeSec)
+	if errors.Is(err, sql.ErrNoRows) {
+		return m, ErrNotFound
+	}
+	if err != nil {
+		return m, fmt.Errorf("query machine %s: %w", key, err)
+	}
+	return m, nil
+}
+
+// The time, status and reason of status events e, with the reason and
+// classification an operator recorded for the stop an event starts.
+const (
+	annotatedColumns = `e.time, e.status, e.reason, COALESCE(a.reason, '') AS confirmed_reason, a.planned`
+	annotatedEvents  = `status_events e
+		LEFT JOIN downtime_annotations a ON a.site = e.site AND a.machine_id = e.machine_id AND a.start_time = e.time`
+)
+
+// StatusChanges returns the status change in effect at from (with an
+// empty status if the machine never reported one) followed by every status
+// change in [from, to).
+func (s *Store) StatusChanges(ctx context.Context, machine machineid.Key, from, to time.Time) (oee.StatusChange, []oee.StatusChange, error) {
+	var initial oee.StatusChange
+	err := s.db.QueryRowContext(ctx,
+		`SELECT `+annotatedColumns+` FROM `+annotatedEvents+`
+		WHERE e.site = $1 AND e.machine_id = $2 AND e.time < $3 ORDER BY e.time DESC LIMIT 1`,
+		machine.Site, machine.ID, from,
+	).Scan(&initial.Time, &initial.Status, &initial.Reason, &initial.ConfirmedReason, &initial.Planned)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return initial, nil, fmt.Errorf("query initial status: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+annotatedColumns+` FROM `+annotatedEvents+`
+		WHERE e.site = $1 AND e.machine_id = $2 AND e.time >= $3 AND e.time < $4 ORDER BY e.time`,
+		machine.Site, machine.ID, from, to,
+	)
+	if err != nil {
+		return initial, nil, fmt.Errorf("query status events: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []oee.StatusChange
+	for rows.Next() {
+		var ch oee.StatusChange
+		if err := rows.Scan(&ch.Time, &ch.Status, &ch.Reason, &ch.ConfirmedReason, &ch.Planned); err != nil {
+			return initial, nil, fmt.Errorf("scan status event: %w", err)
+		}
+		changes = append(changes, ch)
+	}
+	return initial, changes, rows.Err()
+}
+
+// CurrentStatus returns the latest status change at or before at, with the
+// time the machine entered it, or ErrNotFound if it never reported one.
+func (s *Store) CurrentStatus(ctx context.Context, machine machineid.Key, at time.Time) (oee.StatusChange, error) {
+	var ch oee.StatusChange
+	err := s.db.QueryRowContext(ctx,
+		`SELECT `+annotatedColumns+` FROM `+annotatedEvents+`
+		WHERE e.site = $1 AND e.machine_id = $2 AND e.time <= $3 ORDER BY e.time DESC LIMIT 1`,
+		machine.Site, machine.ID, at,
+	).Scan(&ch.Time, &ch.Status, &ch.Reason, &ch.ConfirmedReason, &ch.Planned)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ch, ErrNotFound
+	}
+	if err != nil {
+		return ch, fmt.Errorf("query current status: %w", err)
+	}
+	return ch, nil
+}
+
+// StatusHistory returns, per machine, the status changes in [from, to)
+// preceded by the last change before from, so callers know the state each
+// machine was in when the window opened. A nil machine covers every
+// machine.
+func (s *Store) StatusHistory(ctx context.Context, machine *machineid.Key, from, to time.Time) (map[machineid.Key][]oee.StatusChange, error) {
+	site, id := machineFilter(machine)
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT * FROM (
+			SELECT DISTINCT ON (e.site, e.machine_id) e.site, e.machine_id, `+annotatedColumns+` FROM `+annotatedEvents+`
+			WHERE ($2::int IS NULL OR (e.site, e.machine_id) = ($1, $2)) AND e.time < $3
+			ORDER BY e.site, e.machine_id, e.time DESC
+		) opening
+		UNION ALL
+		SELECT e.site, e.machine_id, `+annotatedColumns+` FROM `+annotatedEvents+`
+		WHERE ($2::int IS NULL OR (e.site, e.machine_id) = ($1, $2)) AND e.time >= $3 AND e.time < $4
+		ORDER BY site, machine_id, time`,
+		site, id, from, to,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query status history: %w", err)
+	}
+	defer rows.Close()
+
+	history := map[machineid.Key][]oee.StatusChange{}
+	for rows.Next() {
+		var m machineid.Key
+		var ch oee.StatusChange
+		if err := rows.Scan(&m.Site, &m.ID, &ch.Time, &ch.Status, &ch.Reason, &ch.ConfirmedReason, &ch.Planned); err != nil {
+			return nil, fmt.Errorf("scan status event: %w", err)
+		}
+		history[m] = append(history[m], ch)
+	}
+	return history, rows.Err()
+}
+
+// ProductionTotals are the summed part counts for a window. Sampled events
+// count sample_weight times, so the totals estimate the unsampled counts.
+type ProductionTotals struct {
+	Good     int
+	Reworked int
+	Scrapped int
+	// WarmupScrapped is the part of Scrapped made while the machine was
+	// warming up after a restart or changeover: its startup rejects.
+	WarmupScrapped int
+	// ByProduct is the number of parts of any quality per product. Events
+	// without a product are counted under "".
+	ByProduct map[string]int
+	// SlowdownCycles is, per product, how many ideal cycles beyond one per
+	// part the parts made at a planned slowdown were meant to take.
+	SlowdownCycles map[string]float64
+}
+
+// ProductionTotals returns the part counts in [from, to).
+func (s *Store) ProductionTotals(ctx context.Context, machine machineid.Key, from, to time.Time) (ProductionTotals, error) {
+	t, err := s.productionTotals(ctx, `site = $1 AND machine_id = $2 AND time >= $3 AND time < $4`, machine.Site, machine.ID, from, to)
+	if err != nil {
+		return t, fmt.Errorf("query production totals: %w", err)
+	}
+	return t, nil
+}
+
+// LotTotals returns the part counts for one lot on one machine.
+func (s *Store) LotTotals(ctx context.Context, machine machineid.Key, lotID string) (ProductionTotals, error) {
+	t, err := s.productionTotals(ctx, `site = $1 AND machine_id = $2 AND lot_id = $3`, machine.Site, machine.ID, lotID)
+	if err != nil {
+		return t, fmt.Errorf("query lot totals: %w", err)
+	}
+	return t, nil
+}
+
+// Part counts of a production event, and with GradeByInspection those of
+// its part graded by inspection i.
+const (
+	machineGood     = `parts_produced`
+	machineReworked = `parts_reworked`
+	machineScrapped = `parts_scrapped`
+
+	inspectedGood     = `CASE i.result WHEN 'pass' THEN parts_produced + parts_scrapped WHEN 'fail' THEN 0 ELSE parts_produced END`
+	inspectedReworked = `CASE i.result WHEN 'fail' THEN 0 ELSE parts_reworked END`
+	inspectedScrapped = `CASE i.result WHEN 'pass' THEN 0 WHEN 'fail' THEN parts_produced + parts_reworked + parts_scrapped ELSE parts_scrapped END`
+)
+
+// productionTotals sums the part counts of the production events matching
+// where, by product.
+func (s *Store) productionTotals(ctx context.Context, where string, args ...any) (ProductionTotals, error) {
+	good, reworked, scrapped, from := machineGood, machineReworked, machineScrapped, `production_events`
+	if s.inspections {
+		good, reworked, scrapped = inspectedGood, inspectedReworked, inspectedScrapped
+		from = `production_events p LEFT JOIN LATERAL (
+			SELECT result FROM inspection_events
+			WHERE inspection_events.site = p.site AND inspection_events.machine_id = p.machine_id AND inspection_events.produced_at = p.time
+			ORDER BY inspection_events.time DESC LIMIT 1
+		) i ON true`
+	}
+	query := fmt.Sprintf(`SELECT product, SUM((%[1]s) * sample_weight), SUM((%[2]s) * sample_weight), SUM((%[3]s) * sample_weight),
+			SUM(CASE WHEN warmup THEN (%[3]s) * sample_weight ELSE 0 END),
+			SUM(CASE WHEN planned_slowdown > 0 THEN (parts_produced + parts_reworked + parts_scrapped) * sample_weight * (1 / planned_slowdown - 1) ELSE 0 END)
+		FROM %[4]s WHERE %[5]s
+		GROUP BY product`, good, reworked, scrapped, from, where)
+
+	t := ProductionTotals{ByProduct: map[string]int{}, SlowdownCycles: map[string]float64{}}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return t, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var product string
+		var good, reworked, scrapped, warmupScrapped int
+		var slowdownCycles float64
+		if err := rows.Scan(&product, &good, &reworked, &scrapped, &warmupScrapped, &slowdownCycles); err != nil {
+			return t, err
+		}
+		t.Good += good
+		t.Reworked += reworked
+		t.Scrapped += scrapped
+		t.WarmupScrapped += warmupScrapped
+		t.ByProduct[product] += good + reworked + scrapped
+		if slowdownCycles > 0 {
+			t.SlowdownCycles[product] += slowdownCycles
+		}
+	}
+	return t, rows.Err()
+}
+
+// Lot is the span of production events recorded for one lot.
+type Lot struct {
+	ID      string
+	Machine machineid.Key
+	First   time.Time
+	Last    time.Time
+}
+
+// FindLot returns the machine and time span of a lot. If machine is nil
+// the lot must have been produced by exactly one machine.
+func (s *Store) FindLot(ctx context.Context, lotID string, machine *machineid.Key) (Lot, error) {
+	site, id := machineFilter(machine)
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT site, machine_id, MIN(time), MAX(time) FROM production_events
+		WHERE lot_id = $1 AND ($3::int IS NULL OR (site, machine_id) = ($2, $3))
+		GROUP BY site, machine_id`,
+		lotID, site, id,
+	)
+	if err != nil {
+		return Lot{}, fmt.Errorf("query lot %s: %w", lotID, err)
+	}
+	defer rows.Close()
+
+	var lots []Lot
+	for rows.Next() {
+		l := Lot{ID: lotID}
+		if err := rows.Scan(&l.Machine.Site, &l.Machine.ID, &l.First, &l.Last); err != nil {
+			return Lot{}, fmt.Errorf("scan lot: %w", err)
+		}
+		lots = append(lots, l)
+	}
+	if err := rows.Err(); err != nil {
+		return Lot{}, err
+	}
+	switch len(lots) {
+	case 0:
+		return Lot{}, ErrNotFound
+	case 1:
+		return lots[0], nil
+	default:
+		return Lot{}, ErrAmbiguous
+	}
+}
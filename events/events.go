@@ -0,0 +1,234 @@
+// Package events defines the MQTT messages exchanged by the simulator, the
+// ingestion service and any third-party producer or consumer: the JSON
+// payloads and the topics they are published on.
+//
+// Every message is published on <prefix>/machine/<id>/<kind>, where prefix
+// is "factory" for a single site or "factory/<site>" for several, and kind
+// is one of the Kind constants.
+//
+// A machine numbers its status, production and lifecycle events in one
+// sequence, in the seq field: 1 for its birth, then one more for each event
+// it publishes, so a consumer can tell a lost or reordered message from a
+// quiet machine. The sequence restarts at the next birth. Zero means the
+// producer doesn't number its events.
+package events
+
+import "time"
+
+// Message kinds, the last segment of a topic.
+const (
+	KindStatus     = "status"
+	KindProduction = "production"
+	KindLifecycle  = "lifecycle"
+	KindOperator   = "operator"
+	KindInspection = "inspection"
+	KindCommand    = "command"
+)
+
+// Statuses a machine reports in a StatusEvent.
+const (
+	StatusRunning = "running"
+	StatusStopped = "stopped"
+)
+
+// Lifecycle states of a LifecycleEvent.
+const (
+	LifecycleBirth = "birth"
+	LifecycleDeath = "death"
+)
+
+// Results of an InspectionEvent.
+const (
+	InspectionPass = "pass"
+	InspectionFail = "fail"
+)
+
+// Commands accepted in a Command.
+const (
+	// CommandQualityIntervention makes a simulated machine's scrap rate drop
+	// and then recover.
+	CommandQualityIntervention = "quality_intervention"
+	// CommandInjectAnomaly makes a simulated machine show the anomaly in
+	// Type for DurationSec.
+	CommandInjectAnomaly = "inject_anomaly"
+	// CommandStopLine stops every machine of the simulator's group Line,
+	// by default the one group of the machine it is sent to, for
+	// DurationSec, then restarts them StaggerSec apart.
+	CommandStopLine = "stop_line"
+)
+
+// Anomalies that can be injected into a simulated machine. Events emitted
+// while one is active carry its name in their Anomaly field.
+const (
+	// AnomalyScrapSpike makes about half of all parts scrap.
+	AnomalyScrapSpike = "scrap_spike"
+	// AnomalySlowdown doubles the cycle time.
+	AnomalySlowdown = "slowdown"
+	// AnomalyChatter follows every part with a 1-3 s stop, reason "chatter".
+	AnomalyChatter = "chatter"
+)
+
+// StatusEvent represents a machine changing its operational state.
+type StatusEvent struct {
+	MachineID int    `json:"machine_id"`
+	Site      string `json:"site,omitempty"`
+	Status    string `json:"status"`           // StatusRunning or StatusStopped
+	Reason    string `json:"reason,omitempty"` // why a machine stopped, e.g. "breakdown"
+	// PlannedUntil marks a planned stop and when it is scheduled to end.
+	PlannedUntil *time.Time `json:"planned_until,omitempty"`
+	Anomaly      string     `json:"anomaly,omitempty"` // injected anomaly in effect, if any
+	// Totals, on the status a simulated machine sends as it finishes its
+	// run, are the parts it made in that run.
+	Totals *PartTotals `json:"totals,omitempty"`
+	// Summary, if the producer adds one, is what the machine did since its
+	// previous status event, so a consumer of status events alone can
+	// estimate OEE.
+	Summary     *StatusSummary `json:"summary,omitempty"`
+	Seq         uint64         `json:"seq,omitempty"` // see the package doc
+	TraceID     string         `json:"trace_id,omitempty"`
+	SpanID      string         `json:"span_id,omitempty"`      // publish span, set when tracing is enabled
+	PublishedAt *time.Time     `json:"published_at,omitempty"` // see ProductionEvent
+	Timestamp   time.Time      `json:"timestamp"`
+}
+
+// PartTotals counts the parts a machine made, by outcome, as in
+// ProductionEvent.
+type PartTotals struct {
+	PartsProduced int `json:"parts_produced"`
+	PartsScrapped int `json:"parts_scrapped"`
+	PartsReworked int `json:"parts_reworked"`
+}
+
+// StatusSummary is what a machine did between two of its status events:
+// the parts it made, by outcome, the time between the events and how much
+// of it the machine was running.
+type StatusSummary struct {
+	PartTotals
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	UptimeSeconds  float64 `json:"uptime_seconds"`
+}
+
+// ProductionEvent represents a machine producing parts.
+type ProductionEvent struct {
+	MachineID     int    `json:"machine_id"`
+	Site          string `json:"site,omitempty"`
+	PartsProduced int    `json:"parts_produced"`
+	PartsScrapped int    `json:"parts_scrapped"`
+	PartsReworked int    `json:"parts_reworked"` // failed first inspection but reworked successfully
+	LotID         string `json:"lot_id,omitempty"`
+	Product       string `json:"product,omitempty"`
+	Anomaly       string `json:"anomaly,omitempty"` // injected anomaly in effect, if any
+	// Warmup marks the first parts after a restart or changeover, made
+	// before the process has settled; their scrap is startup rejects
+	// rather than steady-state defects.
+	Warmup bool `json:"warmup,omitempty"`
+	// PlannedSlowdown marks a part made while the machine was deliberately
+	// run below its ideal rate, as during a trial, and gives the rate it
+	// was run at as a fraction of ideal, e.g. 0.7. Its extra cycle time is
+	// planned rather than unexpected performance loss. Zero for a part made
+	// at full speed.
+	PlannedSlowdown float64 `json:"planned_slowdown,omitempty"`
+	// Serial is the number a serialization station gave the part, for
+	// traceability. Only parts that ship, good or reworked, have one.
+	Serial string `json:"serial,omitempty"`
+	// Measurement is a dimension measured on the part, if it was measured.
+	Measurement *Measurement `json:"measurement,omitempty"`
+	// Cycle counts the machine's cycles: 1 for the first part it makes
+	// after its birth, then one more for each part. Unlike timestamps it
+	// is immune to clock jitter and skew. Zero means the producer doesn't
+	// count cycles.
+	Cycle uint64 `json:"cycle,omitempty"`
+	// PartsTotal is the machine's good parts counter, for producers that
+	// read a PLC totalizer rather than count parts per event. It counts up
+	// from 0, wrapping or resetting to 0 now and then, and the consumer
+	// takes parts produced from its increase instead of PartsProduced. Nil
+	// when the producer doesn't report it.
+	PartsTotal *uint64 `json:"parts_total,omitempty"`
+	Seq        uint64  `json:"seq,omitempty"` // see the package doc
+	TraceID    string  `json:"trace_id,omitempty"`
+	SpanID     string  `json:"span_id,omitempty"`
+	// PublishedAt is when the producer published the event by its host's
+	// clock, to the nanosecond, for measuring the latency until it is
+	// stored. Unlike Timestamp, it is never offset by simulated clock
+	// drift.
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+	Timestamp   time.Time  `json:"timestamp"`
+}
+
+// Measurement is one characteristic measured on a part, with the limits it
+// must stay within, for Statistical Process Control charts.
+type Measurement struct {
+	Characteristic string  `json:"characteristic"` // e.g. "diameter"
+	Unit           string  `json:"unit,omitempty"`
+	Value          float64 `json:"value"`
+	LowerSpec      float64 `json:"lower_spec"`
+	UpperSpec      float64 `json:"upper_spec"`
+}
+
+// OutOfSpec reports whether the value is outside the specification limits.
+func (m Measurement) OutOfSpec() bool {
+	return m.Value < m.LowerSpec || m.Value > m.UpperSpec
+}
+
+// LifecycleEvent announces a machine coming online (LifecycleBirth) or
+// going offline (LifecycleDeath). Both carry the machine's metadata, so a
+// consumer that connects later can describe the fleet from the retained
+// messages alone.
+type LifecycleEvent struct {
+	MachineID         int       `json:"machine_id"`
+	Site              string    `json:"site,omitempty"`
+	State             string    `json:"state"`
+	IdealCycleTimeSec float64   `json:"ideal_cycle_time_sec"`
+	Products          []string  `json:"products,omitempty"`
+	StartedAt         time.Time `json:"started_at"`
+	Seq               uint64    `json:"seq,omitempty"` // see the package doc
+	TraceID           string    `json:"trace_id,omitempty"`
+	SpanID            string    `json:"span_id,omitempty"`
+	Timestamp         time.Time `json:"timestamp"`
+}
+
+// OperatorEvent records who runs a machine: it is published when a shift
+// starts, and when the machine comes online mid-shift, and holds until the
+// next one.
+type OperatorEvent struct {
+	MachineID  int    `json:"machine_id"`
+	Site       string `json:"site,omitempty"`
+	OperatorID string `json:"operator_id"`
+	// Shift is the local start time of the shift, e.g. "07:00", as in the
+	// shifts table.
+	Shift     string    `json:"shift"`
+	TraceID   string    `json:"trace_id,omitempty"`
+	SpanID    string    `json:"span_id,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// InspectionEvent is the verdict of an inspection station downstream of a
+// machine on one part the machine made, published on the machine's
+// KindInspection topic some time after the part's ProductionEvent. The
+// part is the one whose production event has timestamp ProducedAt, and
+// Serial too if it has one. A part may be inspected again; its latest
+// inspection counts.
+type InspectionEvent struct {
+	MachineID  int       `json:"machine_id"`
+	Site       string    `json:"site,omitempty"`
+	ProducedAt time.Time `json:"produced_at"`
+	Serial     string    `json:"serial,omitempty"`
+	Result     string    `json:"result"` // InspectionPass or InspectionFail
+	// DefectCode says what was wrong with a failed part, e.g. "out_of_spec".
+	DefectCode string    `json:"defect_code,omitempty"`
+	TraceID    string    `json:"trace_id,omitempty"`
+	SpanID     string    `json:"span_id,omitempty"`
+	Timestamp  time.Time `json:"timestamp"` // when the part was inspected
+}
+
+// Command is a message sent to a machine on its KindCommand topic.
+type Command struct {
+	Command string `json:"command"`
+	// Type and DurationSec parameterise CommandInjectAnomaly.
+	Type        string  `json:"type,omitempty"`
+	DurationSec float64 `json:"duration_sec,omitempty"`
+	// Line and StaggerSec parameterise CommandStopLine, with DurationSec.
+	// A nil StaggerSec uses the simulator's default.
+	Line       string   `json:"line,omitempty"`
+	StaggerSec *float64 `json:"stagger_sec,omitempty"`
+}
@@ -0,0 +1,19 @@
+package events
+
+import "embed"
+
+// SchemaVersion is the version of the JSON Schemas in schema/, one per
+// event kind, that describe the payloads above. Changing a payload means
+// changing its schema with it; a change older consumers would reject, such
+// as a new required field, starts a new version next to the old one.
+const SchemaVersion = "v1"
+
+//go:embed schema
+var schemas embed.FS
+
+// Schema returns the JSON Schema, at SchemaVersion, of the payload of
+// events of kind: KindStatus, KindProduction, KindLifecycle, KindOperator
+// or KindInspection.
+func Schema(kind string) ([]byte, error) {
+	return schemas.ReadFile("schema/" + SchemaVersion + "/" + kind + ".json")
+}
@@ -0,0 +1,67 @@
+package events
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Topic is a parsed <prefix>/machine/<id>/<kind> topic.
+type Topic struct {
+	Prefix    string
+	MachineID int
+	Kind      string
+}
+
+// String returns the topic name, as MarshalTopic does.
+func (t Topic) String() string {
+	return MarshalTopic(t.Prefix, t.MachineID, t.Kind)
+}
+
+// MarshalTopic returns the topic machineID publishes kind messages on, e.g.
+// "factory/machine/1/status".
+func MarshalTopic(prefix string, machineID int, kind string) string {
+	return join(prefix, fmt.Sprintf("machine/%d/%s", machineID, kind))
+}
+
+// Wildcard returns the subscription matching kind messages from every
+// machine under prefix, e.g. "factory/machine/+/status".
+func Wildcard(prefix, kind string) string {
+	return join(prefix, "machine/+/"+kind)
+}
+
+// join prepends prefix to rest, if there is one.
+func join(prefix, rest string) string {
+	if prefix == "" {
+		return rest
+	}
+	return prefix + "/" + rest
+}
+
+// ParseTopic splits a topic built by MarshalTopic back into its parts. The
+// prefix may itself contain slashes, as in "factory/plant-a/machine/1/status".
+func ParseTopic(topic string) (Topic, error) {
+	parts := strings.Split(topic, "/")
+	n := len(parts)
+	if n < 3 || parts[n-3] != "machine" || parts[n-1] == "" {
+		return Topic{}, fmt.Errorf("unknown topic format: %s", topic)
+	}
+	id, err := strconv.Atoi(parts[n-2])
+	if err != nil {
+		return Topic{}, fmt.Errorf("invalid machine ID in topic %s: %w", topic, err)
+	}
+	return Topic{
+		Prefix:    strings.Join(parts[:n-3], "/"),
+		MachineID: id,
+		Kind:      parts[n-1],
+	}, nil
+}
+
+// MachineID returns the machine ID in topic.
+func MachineID(topic string) (int, error) {
+	t, err := ParseTopic(topic)
+	if err != nil {
+		return 0, err
+	}
+	return t.MachineID, nil
+}
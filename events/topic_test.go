@@ -0,0 +1,97 @@
+package events
+
+import "testing"
+
+func TestTopicRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		id     int
+		kind   string
+		topic  string
+	}{
+		{"no prefix", "", 1, KindStatus, "machine/1/status"},
+		{"single-level prefix", "factory", 7, KindProduction, "factory/machine/7/production"},
+		{"multi-level prefix", "factory/plant-a", 42, KindLifecycle, "factory/plant-a/machine/42/lifecycle"},
+		{"site prefix", "factory/plant-b/line-2", 1001, KindInspection, "factory/plant-b/line-2/machine/1001/inspection"},
+		{"prefix containing machine", "machine/hall", 3, KindOperator, "machine/hall/machine/3/operator"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topic := MarshalTopic(tt.prefix, tt.id, tt.kind)
+			if topic != tt.topic {
+				t.Fatalf("MarshalTopic(%q, %d, %q) = %q, want %q", tt.prefix, tt.id, tt.kind, topic, tt.topic)
+			}
+			got, err := ParseTopic(topic)
+			if err != nil {
+				t.Fatalf("ParseTopic(%q): %v", topic, err)
+			}
+			want := Topic{Prefix: tt.prefix, MachineID: tt.id, Kind: tt.kind}
+			if got != want {
+				t.Fatalf("ParseTopic(%q) = %+v, want %+v", topic, got, want)
+			}
+			if got.String() != topic {
+				t.Fatalf("String() = %q, want %q", got.String(), topic)
+			}
+			id, err := MachineID(topic)
+			if err != nil || id != tt.id {
+				t.Fatalf("MachineID(%q) = %d, %v, want %d", topic, id, err, tt.id)
+			}
+		})
+	}
+}
+
+func TestParseTopicErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		topic string
+	}{
+		{"empty", ""},
+		{"kind only", "status"},
+		{"missing machine segment", "factory/1/status"},
+		{"missing kind", "factory/machine/1"},
+		{"empty kind", "factory/machine/1/"},
+		{"missing machine ID", "factory/machine//status"},
+		{"non-numeric machine ID", "factory/machine/press-1/status"},
+		{"wildcard machine ID", "factory/machine/+/status"},
+		{"fractional machine ID", "factory/machine/1.5/status"},
+		{"overflowing machine ID", "factory/machine/99999999999999999999/status"},
+		{"kind before machine", "factory/status/machine/1"},
+		{"extra trailing segment", "factory/machine/1/status/extra"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := ParseTopic(tt.topic); err == nil {
+				t.Fatalf("ParseTopic(%q) = %+v, want error", tt.topic, got)
+			}
+			if _, err := MachineID(tt.topic); err == nil {
+				t.Fatalf("MachineID(%q) succeeded, want error", tt.topic)
+			}
+		})
+	}
+}
+
+// ParseTopic doesn't know which kinds exist: it returns an unknown kind as
+// is and leaves rejecting it to the caller, so that new kinds only need
+// handling where they are consumed.
+func TestParseTopicUnknownKind(t *testing.T) {
+	for _, kind := range []string{"telemetry", "Status", "machine"} {
+		topic := "factory/plant-a/machine/5/" + kind
+		got, err := ParseTopic(topic)
+		if err != nil {
+			t.Fatalf("ParseTopic(%q): %v", topic, err)
+		}
+		if got.Kind != kind || got.MachineID != 5 || got.Prefix != "factory/plant-a" {
+			t.Fatalf("ParseTopic(%q) = %+v", topic, got)
+		}
+	}
+}
+
+func TestWildcard(t *testing.T) {
+	if got := Wildcard("", KindStatus); got != "machine/+/status" {
+		t.Fatalf("Wildcard without prefix = %q", got)
+	}
+	if got := Wildcard("factory/plant-a", KindProduction); got != "factory/plant-a/machine/+/production" {
+		t.Fatalf("Wildcard with prefix = %q", got)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// ackOrder releases the acknowledgements of one connection's messages in
+// the order they were received. MQTT 3.1.1 §4.6 requires a client to send
+// PUBACKs in the order the QoS 1 PUBLISH packets arrived, but with several
+// workers a message can be stored before one received ahead of it on
+// another worker. Each message takes a ticket on receipt, and acking a
+// ticket only sends its PUBACK once every earlier ticket has been acked,
+// so the workers still store in parallel.
+//
+// Sequencing is per connection: on a new connection the broker redelivers
+// whatever was left unacked, so tickets of the old one are released among
+// themselves and never hold up the new one.
+type ackOrder struct {
+	mu     sync.Mutex
+	issued uint64                  // next ticket to hand out
+	next   uint64                  // oldest ticket not yet acked
+	done   map[uint64]mqtt.Message // acked tickets waiting for an earlier one
+}
+
+func newAckOrder() *ackOrder {
+	return &ackOrder{done: map[uint64]mqtt.Message{}}
+}
+
+// ticket returns the place in the order of the message just received. It
+// must be called in the order messages arrive, as the MQTT client's
+// callback does.
+func (o *ackOrder) ticket() uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	t := o.issued
+	o.issued++
+	return t
+}
+
+// ack acknowledges ticket t's message m, then sends the PUBACKs of every
+// message from the oldest unacked one up to the first still being handled.
+func (o *ackOrder) ack(t uint64, m mqtt.Message) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.done[t] = m
+	for {
+		m, ok := o.done[o.next]
+		if !ok {
+			return
+		}
+		delete(o.done, o.next)
+		o.next++
+		m.Ack()
+	}
+}
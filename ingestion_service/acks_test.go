@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// fakeMessage is an mqtt.Message that records its acknowledgement.
+type fakeMessage struct {
+	topic    string
+	payload  []byte
+	retained bool
+	id       uint16
+	onAck    func(id uint16)
+}
+
+func (m *fakeMessage) Duplicate() bool   { return false }
+func (m *fakeMessage) Qos() byte         { return 1 }
+func (m *fakeMessage) Retained() bool    { return m.retained }
+func (m *fakeMessage) Topic() string     { return m.topic }
+func (m *fakeMessage) MessageID() uint16 { return m.id }
+func (m *fakeMessage) Payload() []byte   { return m.payload }
+func (m *fakeMessage) Ack() {
+	if m.onAck != nil {
+		m.onAck(m.id)
+	}
+}
+
+func TestAckOrderReleasesInReceiveOrder(t *testing.T) {
+	var mu sync.Mutex
+	var acked []uint16
+	record := func(id uint16) {
+		mu.Lock()
+		defer mu.Unlock()
+		acked = append(acked, id)
+	}
+
+	o := newAckOrder()
+	const n = 200
+	ins := make([]inbound, n)
+	for i := range ins {
+		ins[i] = inbound{msg: &fakeMessage{id: uint16(i), onAck: record}, acks: o, ticket: o.ticket()}
+	}
+
+	// Workers finish in any order
+	rand.Shuffle(n, func(i, j int) { ins[i], ins[j] = ins[j], ins[i] })
+	var wg sync.WaitGroup
+	for _, in := range ins {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			in.ack()
+		}()
+	}
+	wg.Wait()
+
+	want := make([]uint16, n)
+	for i := range want {
+		want[i] = uint16(i)
+	}
+	if !slices.Equal(acked, want) {
+		t.Fatalf("acked in order %v, want %v", acked, want)
+	}
+}
+
+func TestAckOrderHoldsBackLaterAcks(t *testing.T) {
+	var acked []uint16
+	record := func(id uint16) { acked = append(acked, id) }
+	o := newAckOrder()
+	first, second, third := o.ticket(), o.ticket(), o.ticket()
+
+	o.ack(third, &fakeMessage{id: 3, onAck: record})
+	o.ack(second, &fakeMessage{id: 2, onAck: record})
+	if len(acked) != 0 {
+		t.Fatalf("acked %v before the first message was handled", acked)
+	}
+	o.ack(first, &fakeMessage{id: 1, onAck: record})
+	if !slices.Equal(acked, []uint16{1, 2, 3}) {
+		t.Fatalf("acked %v, want [1 2 3]", acked)
+	}
+}
+
+func TestInboundWithoutTicketAcksAtOnce(t *testing.T) {
+	acked := false
+	inbound{msg: &fakeMessage{onAck: func(uint16) { acked = true }}}.ack()
+	if !acked {
+		t.Fatal("message without a ticket wasn't acked")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/events"
+)
+
+// errBufferFull is recorded on the span of a message dropped because the
+// buffer was full.
+var errBufferFull = errors.New("inbound buffer full")
+
+// inbound is a received message waiting for a worker, with the span that
+// covers it from receipt, so time spent in the buffer shows in the trace.
+// With acks set its acknowledgement waits for those received before it.
+type inbound struct {
+	ctx    context.Context
+	span   trace.Span
+	msg    mqtt.Message
+	acks   *ackOrder
+	ticket uint64
+}
+
+// ack acknowledges the message, in receive order if it has a ticket.
+func (in inbound) ack() {
+	if in.acks == nil {
+		in.msg.Ack()
+		return
+	}
+	in.acks.ack(in.ticket, in.msg)
+}
+
+// receipt is how a message arrived, carried in the context it is handled
+// with.
+type receipt struct {
+	at       time.Time
+	retained bool // replayed by the broker on subscribe rather than just published
+}
+
+type receiptKey struct{}
+
+// withReceipt records in ctx how the message being handled arrived.
+func withReceipt(ctx context.Context, r receipt) context.Context {
+	return context.WithValue(ctx, receiptKey{}, r)
+}
+
+// receiptFrom returns the receipt recorded in ctx, if any.
+func receiptFrom(ctx context.Context) (receipt, bool) {
+	r, ok := ctx.Value(receiptKey{}).(receipt)
+	return r, ok
+}
+
+// inbox is the bounded buffer between the MQTT client, which delivers
+// messages on its own goroutine, and the workers that store them. It absorbs
+// bursts such as the retained messages replayed at subscribe, and shows in
+// its depth when ingestion can't keep up.
+//
+// Each worker has its own queue and a machine's messages always go to the
+// same one, so they are stored in the order they arrived whatever the
+// number of workers.
+type inbox struct {
+	queues []chan inbound
+}
+
+// newInbox returns an inbox for workers workers holding size messages in
+// all, split evenly between them.
+func newInbox(size, workers int) *inbox {
+	b := &inbox{queues: make([]chan inbound, workers)}
+	for i := range b.queues {
+		b.queues[i] = make(chan inbound, (size+workers-1)/workers)
+	}
+	return b
+}
+
+// start runs one worker per queue, each calling handle for its messages in
+// turn.
+func (b *inbox) start(handle func(inbound)) {
+	for _, q := range b.queues {
+		go func() {
+			for in := range q {
+				handle(in)
+			}
+		}()
+	}
+}
+
+// put hands in to the worker for its machine. When the queue is full it
+// waits for room if wait is set, holding up the MQTT client and with it the
+// broker; otherwise it drops the message and returns false.
+func (b *inbox) put(in inbound, wait bool) bool {
+	q := b.queues[b.shard(in.msg.Topic())]
+	if wait {
+		q <- in
+		return true
+	}
+	select {
+	case q <- in:
+		return true
+	default:
+		return false
+	}
+}
+
+// shard picks the queue for a topic by its prefix and machine ID. Topics
+// that don't parse go to the first queue; they fail in the worker anyway.
+func (b *inbox) shard(topic string) int {
+	if len(b.queues) == 1 {
+		return 0
+	}
+	t, err := events.ParseTopic(topic)
+	if err != nil {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(t.Prefix + "/" + strconv.Itoa(t.MachineID)))
+	return int(h.Sum32() % uint32(len(b.queues)))
+}
+
+// depth is the number of messages waiting across all queues.
+func (b *inbox) depth() int {
+	n := 0
+	for _, q := range b.queues {
+		n += len(q)
+	}
+	return n
+}
+
+// capacity is the number of messages the inbox can hold.
+func (b *inbox) capacity() int {
+	n := 0
+	for _, q := range b.queues {
+		n += cap(q)
+	}
+	return n
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// compactor skips repeated status events; nil when STATUS_COMPACT_WINDOW
+// is zero.
+var compactor *statusCompactor
+
+// storedStatus is the last status event stored for a machine.
+type storedStatus struct {
+	status, reason string
+	time           time.Time
+}
+
+// statusCompactor drops a status event with the same status and reason as
+// the last one stored for its machine, such as a heartbeat restating an
+// unchanged state, when it comes no more than window after it. The stored
+// event already starts the interval the repeat would only split, so the
+// timeline keeps one interval per state. A repeat later than window is
+// stored, so a long interval still gets a row every window.
+type statusCompactor struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	last map[machineid.Key]storedStatus
+}
+
+func newStatusCompactor(window time.Duration) *statusCompactor {
+	return &statusCompactor{window: window, last: map[machineid.Key]storedStatus{}}
+}
+
+// repeat reports whether the status event s of machine repeats the last
+// one stored within the window. The first event seen for a machine is
+// compared with the latest one in the database, if any. An event older
+// than the last stored one is never a repeat.
+func (c *statusCompactor) repeat(db *sql.DB, machine machineid.Key, s storedStatus) bool {
+	c.mu.Lock()
+	prev, ok := c.last[machine]
+	c.mu.Unlock()
+	if !ok {
+		// The lookup runs without the lock, so other machines' events don't
+		// wait on the database
+		loaded, err := c.load(db, machine)
+		if err != nil {
+			log.Printf("failed to load last status for machine %s: %v", machine, err)
+			return false
+		}
+		c.mu.Lock()
+		// Another status of the machine may have been recorded meanwhile
+		if prev, ok = c.last[machine]; !ok {
+			prev = loaded
+			c.last[machine] = prev
+		}
+		c.mu.Unlock()
+	}
+	if prev.status == "" || prev.status != s.status || prev.reason != s.reason {
+		return false
+	}
+	return !s.time.Before(prev.time) && s.time.Sub(prev.time) <= c.window
+}
+
+// load returns the latest status event stored for machine, or the zero
+// storedStatus if there is none.
+func (c *statusCompactor) load(db *sql.DB, machine machineid.Key) (storedStatus, error) {
+	// Status events may be written elsewhere with INGEST_MAP_STATUS_*, or
+	// without their status or reason
+	table, columns := mapped("status_events", "status", "reason", "site", "machine_id", "time")
+	if columns[0] == "" {
+		return storedStatus{}, nil
+	}
+	reason := columns[1]
+	if reason == "" {
+		reason = "''"
+	}
+	var s storedStatus
+	query := fmt.Sprintf(`SELECT %s, %s, %s FROM %s WHERE %s = $1 AND %s = $2 ORDER BY %s DESC LIMIT 1`,
+		columns[0], reason, columns[4], table, columns[2], columns[3], columns[4])
+	err := db.QueryRow(query, machine.Site, machine.ID).Scan(&s.status, &s.reason, &s.time)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storedStatus{}, nil
+	}
+	return s, err
+}
+
+// record notes s as the last status event stored for machine.
+func (c *statusCompactor) record(machine machineid.Key, s storedStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if prev, ok := c.last[machine]; !ok || !s.time.Before(prev.time) {
+		c.last[machine] = s
+	}
+}
+
+// touchLastSeen moves the machine's last_seen_at forward to t, so status
+// events compacted away still show the machine is alive. It never moves it
+// back, so late or redelivered events are harmless.
+func touchLastSeen(ctx context.Context, machine machineid.Key, t time.Time) error {
+	r := record{
+		table:   "machines",
+		columns: []string{"site", "id", "last_seen_at"},
+		values:  []any{machine.Site, machine.ID, t},
+		query:   `UPDATE machines SET last_seen_at = $3 WHERE site = $1 AND id = $2 AND (last_seen_at IS NULL OR last_seen_at < $3)`,
+	}
+	if err := storeEvent(ctx, machine, r); err != nil {
+		return &stageError{stageInsert, fmt.Errorf("failed to update last seen: %w", err)}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// A machine's last status is looked up in the database without holding up
+// the events of machines already known.
+func TestStatusCompactorLooksUpWithoutLock(t *testing.T) {
+	db := stallingDB(t)
+	c := newStatusCompactor(time.Minute)
+	known, unknown := machineid.Key{ID: 1}, machineid.Key{ID: 2}
+	c.record(known, storedStatus{status: "running", time: testTime})
+
+	first := make(chan bool)
+	go func() { first <- c.repeat(db, unknown, storedStatus{status: "running", time: testTime}) }()
+	<-stalling.started
+	within(t, "repeat", func() {
+		if !c.repeat(db, known, storedStatus{status: "running", time: testTime.Add(time.Second)}) {
+			t.Error("repeated status within the window was not compacted")
+		}
+	})
+	// The machine's first status is stored while its lookup is under way
+	c.record(unknown, storedStatus{status: "running", time: testTime})
+	close(stalling.release)
+	if !<-first {
+		t.Error("status recorded during the lookup was not compacted")
+	}
+}
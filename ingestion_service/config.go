@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/chaos"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/configfile"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/connstr"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/envfile"
+)
+
+// Config holds the ingestion service settings, loaded from environment
+// variables.
+type Config struct {
+	// ConfigFile is the CONFIG_FILE the settings were loaded from, if any;
+	// environment variables override it.
+	ConfigFile    string
+	MQTTBrokerURL string `secret:"url"`
+	MQTTClientID  string `instance:"true"`
+	// MQTTUsername and MQTTPassword authenticate with the broker; each can
+	// also be read from the file named by its _FILE variable.
+	MQTTUsername string
+	MQTTPassword string `secret:"true"`
+	// TopicPrefixes are the topic trees to ingest; each subscribes to
+	// <prefix>/machine/+/status and <prefix>/machine/+/production. A + in
+	// a prefix matches the site of events that don't carry one.
+	TopicPrefixes []string
+	// SharedGroup, when set, subscribes through the shared subscription
+	// $share/<SharedGroup>/..., so replicas in the same group split the
+	// messages between them instead of each receiving all of them.
+	SharedGroup string
+	// DBDriver is "postgres" (TimescaleDB) or "sqlite", which stores events
+	// in the file at SQLitePath for local runs without Postgres.
+	DBDriver   string
+	SQLitePath string
+	PGHost     string
+	PGPort     string
+	PGUser     string
+	PGPassword string `secret:"true"`
+	PGDB       string
+	// Sinks are where events are written: "db" for the database above,
+	// "stdout", or the URL of another database. The ingestor's own state
+	// stays in "db", which must be listed.
+	Sinks []string `secret:"url"`
+	// TableMap redirects event tables to other tables and columns, keyed
+	// by the table it replaces.
+	TableMap map[string]tableMapping
+	// ErrorsTopic is where failed messages are republished; empty disables it.
+	ErrorsTopic string
+	// LogEvents logs every stored event with its trace ID.
+	LogEvents bool
+	// LatencyReportInterval is how often the publish-to-store latency of
+	// events carrying published_at is logged and exposed per machine; zero
+	// doesn't measure it.
+	LatencyReportInterval time.Duration
+	MetricsAddr           string
+	Delivery              Delivery
+	// ZeroTimestamp is what happens to events without a timestamp: they
+	// are stamped "now" or at "received_at", or rejected.
+	ZeroTimestamp string
+	// StateValidation enables flagging of status transitions not listed in
+	// StateTransitions.
+	StateValidation  bool
+	StateTransitions map[string]map[string]bool
+	// StatusCompactWindow drops a status event repeating the machine's
+	// last stored status and reason no more than this after it, updating
+	// only the machine's last_seen_at; zero stores every status event.
+	StatusCompactWindow time.Duration
+	// SequenceSaveInterval is how often the machines' last sequence
+	// numbers are saved to machines.last_seq.
+	SequenceSaveInterval time.Duration
+	// Staging writes event rows to staging tables instead of the event
+	// tables, and moves them over in the background every StagingInterval,
+	// up to StagingBatch rows per table and transaction.
+	Staging         bool
+	StagingInterval time.Duration
+	StagingBatch    int
+	// RehydrateState loads every known machine's last sequence number and
+	// status at startup instead of on each machine's first event.
+	RehydrateState bool
+	// AutoMigrate adds columns missing from an older schema at startup.
+	AutoMigrate bool
+	// ValidateSchema checks every payload against its event's JSON Schema
+	// before storing it.
+	ValidateSchema bool
+	// StrictParsing rejects events with fields they don't have or data
+	// after them, instead of ignoring what can't be read.
+	StrictParsing bool
+	// StoreRawPayload keeps each event's payload, as received, in the
+	// raw_payload column of its row.
+	StoreRawPayload bool
+	// ProductionSampleRate stores one production event in every N per
+	// machine, weighted by N; 1 stores them all.
+	ProductionSampleRate int
+	// TotalizerMax is the value after which parts_total counters wrap to
+	// 0; zero means they never wrap, so every decrease is a reset.
+	TotalizerMax uint64
+	// DBHealthInterval is how often the database is pinged; zero disables
+	// the check. After DBUnhealthyAfter failed pings in a row the ingestor
+	// reports not ready and, delivering at least once, holds its messages.
+	DBHealthInterval time.Duration
+	DBUnhealthyAfter int
+	// Decimation rolls part measurements older than a recent window up
+	// into per-bucket summaries.
+	Decimation Decimation
+	// SkipRetainedOnStart is how long after each connect retained messages
+	// are ignored; zero ingests them.
+	SkipRetainedOnStart time.Duration
+	// InsertChaos delays every insert and fails some of them, for chaos
+	// testing.
+	InsertChaos chaos.Faults
+	// SelfTest checks the path from the broker to the database once and
+	// exits instead of ingesting, failing if it takes over SelfTestTimeout.
+	SelfTest        bool
+	SelfTestTimeout time.Duration
+	// BufferSize is how many received messages can wait for the Workers
+	// that store them.
+	BufferSize int
+	Workers    int
+	// MetricsRequired exits when MetricsAddr can't be served, instead of
+	// ingesting without metrics.
+	MetricsRequired bool
+	// DebugEndpoints exposes /debug/config next to /metrics.
+	DebugEndpoints bool
+	// APIToken is the bearer token required on /metrics and /debug/config;
+	// empty leaves them open.
+	APIToken string `secret:"true"`
+}
+
+// Global config instance
+var config Config
+
+// loadConfig loads configuration from environment variables
+func loadConfig() (Config, error) {
+	// Settings from CONFIG_FILE fill in what the environment leaves unset
+	path, err := configfile.Load()
+	if err != nil {
+		return Config{}, err
+	}
+
+	cfg := Config{
+		ConfigFile:    path,
+		MQTTBrokerURL: mustEnv("MQTT_BROKER_URL", "tcp://emqx:1883"),
+		MQTTClientID:  mustEnv("MQTT_INGEST_CLIENT_ID", "oee-ingestor"),
+		DBDriver:      mustEnv("DB_DRIVER", driverPostgres),
+		SQLitePath:    mustEnv("SQLITE_PATH", "oee.db"),
+		PGHost:        mustEnv("PG_HOST", "timescaledb"),
+		PGPort:        mustEnv("PG_PORT", "5432"),
+		PGUser:        mustEnv("PG_USER", "postgres"),
+		PGDB:          mustEnv("PG_DB", "oee"),
+		ErrorsTopic:   mustEnv("INGEST_ERRORS_TOPIC", ""),
+		MetricsAddr:   mustEnv("INGEST_METRICS_ADDR", ":8081"),
+		APIToken:      mustEnv("API_TOKEN", ""),
+	}
+
+	if cfg.MQTTUsername, err = envfile.Get("MQTT_USERNAME", ""); err != nil {
+		return cfg, err
+	}
+	if cfg.MQTTPassword, err = envfile.Get("MQTT_PASSWORD", ""); err != nil {
+		return cfg, err
+	}
+	if cfg.PGPassword, err = envfile.Get("PG_PASSWORD", "postgres"); err != nil {
+		return cfg, err
+	}
+	if cfg.MQTTBrokerURL, err = connstr.BrokerURL(cfg.MQTTBrokerURL); err != nil {
+		return cfg, fmt.Errorf("invalid MQTT_BROKER_URL: %w", err)
+	}
+
+	if cfg.DBDriver != driverPostgres && cfg.DBDriver != driverSQLite {
+		return cfg, fmt.Errorf("invalid DB_DRIVER %q: must be %s or %s", cfg.DBDriver, driverPostgres, driverSQLite)
+	}
+
+	hasDB := false
+	for _, entry := range strings.Split(mustEnv("INGEST_SINKS", sinkDB), ",") {
+		entry = strings.TrimSpace(entry)
+		if !validSink(entry) {
+			return cfg, fmt.Errorf("invalid INGEST_SINKS entry %q: must be %s, %s, a postgres:// URL or sqlite:<path>", entry, sinkDB, sinkStdout)
+		}
+		if slices.Contains(cfg.Sinks, entry) {
+			return cfg, fmt.Errorf("invalid INGEST_SINKS: %q is listed twice", entry)
+		}
+		hasDB = hasDB || entry == sinkDB
+		cfg.Sinks = append(cfg.Sinks, entry)
+	}
+	if !hasDB {
+		return cfg, fmt.Errorf("invalid INGEST_SINKS: must include %s, which holds the ingestor's own state", sinkDB)
+	}
+
+	if cfg.TableMap, err = parseTableMap(); err != nil {
+		return cfg, err
+	}
+
+	for _, prefix := range strings.Split(mustEnv("MQTT_TOPIC_PREFIXES", "factory,factory/+"), ",") {
+		prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+		if prefix != "" {
+			cfg.TopicPrefixes = append(cfg.TopicPrefixes, prefix)
+		}
+	}
+
+	cfg.SharedGroup = mustEnv("MQTT_SHARED_GROUP", "")
+	if strings.ContainsAny(cfg.SharedGroup, "/+#") {
+		return cfg, fmt.Errorf("invalid MQTT_SHARED_GROUP %q: must not contain /, + or #", cfg.SharedGroup)
+	}
+
+	cfg.Delivery = Delivery{
+		Mode:       mustEnv("INGEST_DELIVERY", deliveryAtLeastOnce),
+		Duplicates: mustEnv("INGEST_DUPLICATES", duplicatesIgnore),
+	}
+	if cfg.Delivery.Mode != deliveryAtLeastOnce && cfg.Delivery.Mode != deliveryAtMostOnce {
+		return cfg, fmt.Errorf("invalid INGEST_DELIVERY %q: must be %s or %s", cfg.Delivery.Mode, deliveryAtLeastOnce, deliveryAtMostOnce)
+	}
+	switch cfg.Delivery.Duplicates {
+	case duplicatesReject, duplicatesIgnore, duplicatesUpsert:
+	default:
+		return cfg, fmt.Errorf("invalid INGEST_DUPLICATES %q: must be %s, %s or %s", cfg.Delivery.Duplicates, duplicatesReject, duplicatesIgnore, duplicatesUpsert)
+	}
+	if cfg.Delivery.RetryMax, err = strconv.Atoi(mustEnv("INGEST_RETRY_MAX", "3")); err != nil || cfg.Delivery.RetryMax < 0 {
+		return cfg, fmt.Errorf("invalid INGEST_RETRY_MAX: must be a non-negative integer")
+	}
+	backoffMs, err := strconv.Atoi(mustEnv("INGEST_RETRY_BACKOFF_MS", "200"))
+	if err != nil || backoffMs < 0 {
+		return cfg, fmt.Errorf("invalid INGEST_RETRY_BACKOFF_MS: must be a non-negative integer")
+	}
+	cfg.Delivery.RetryBackoff = time.Duration(backoffMs) * time.Millisecond
+	cfg.ZeroTimestamp = mustEnv("ZERO_TIMESTAMP", zeroTimestampNow)
+	switch cfg.ZeroTimestamp {
+	case zeroTimestampNow, zeroTimestampReject, zeroTimestampReceivedAt:
+	default:
+		return cfg, fmt.Errorf("invalid ZERO_TIMESTAMP %q: must be %s, %s or %s", cfg.ZeroTimestamp, zeroTimestampNow, zeroTimestampReject, zeroTimestampReceivedAt)
+	}
+
+	if cfg.StateValidation, err = strconv.ParseBool(mustEnv("STATE_VALIDATION", "false")); err != nil {
+		return cfg, fmt.Errorf("invalid STATE_VALIDATION: %w", err)
+	}
+	if cfg.StateTransitions, err = parseTransitions(mustEnv("STATE_TRANSITIONS", "running>stopped,stopped>running")); err != nil {
+		return cfg, fmt.Errorf("invalid STATE_TRANSITIONS: %w", err)
+	}
+	compactSec, err := strconv.Atoi(mustEnv("STATUS_COMPACT_WINDOW", "0"))
+	if err != nil || compactSec < 0 {
+		return cfg, fmt.Errorf("invalid STATUS_COMPACT_WINDOW: must be a non-negative number of seconds")
+	}
+	cfg.StatusCompactWindow = time.Duration(compactSec) * time.Second
+	seqSaveSec, err := strconv.Atoi(mustEnv("SEQUENCE_SAVE_INTERVAL", "5"))
+	if err != nil || seqSaveSec < 1 {
+		return cfg, fmt.Errorf("invalid SEQUENCE_SAVE_INTERVAL: must be a positive number of seconds")
+	}
+	cfg.SequenceSaveInterval = time.Duration(seqSaveSec) * time.Second
+	if cfg.Staging, err = strconv.ParseBool(mustEnv("INGEST_STAGING", "false")); err != nil {
+		return cfg, fmt.Errorf("invalid INGEST_STAGING: %w", err)
+	}
+	if cfg.Staging && cfg.Delivery.Duplicates == duplicatesReject {
+		// A duplicate is only found once its event has been acknowledged
+		return cfg, fmt.Errorf("invalid INGEST_STAGING: needs INGEST_DUPLICATES %s or %s, as duplicates are found only when promoted", duplicatesIgnore, duplicatesUpsert)
+	}
+	stagingSec, err := strconv.Atoi(mustEnv("INGEST_STAGING_INTERVAL", "1"))
+	if err != nil || stagingSec < 1 {
+		return cfg, fmt.Errorf("invalid INGEST_STAGING_INTERVAL: must be a positive number of seconds")
+	}
+	cfg.StagingInterval = time.Duration(stagingSec) * time.Second
+	// Each batch is promoted with a parameter per row
+	if cfg.StagingBatch, err = strconv.Atoi(mustEnv("INGEST_STAGING_BATCH", "1000")); err != nil || cfg.StagingBatch < 1 || cfg.StagingBatch > 10000 {
+		return cfg, fmt.Errorf("invalid INGEST_STAGING_BATCH: must be between 1 and 10000")
+	}
+	if cfg.RehydrateState, err = strconv.ParseBool(mustEnv("REHYDRATE_STATE", "false")); err != nil {
+		return cfg, fmt.Errorf("invalid REHYDRATE_STATE: %w", err)
+	}
+	if cfg.AutoMigrate, err = strconv.ParseBool(mustEnv("AUTO_MIGRATE", "false")); err != nil {
+		return cfg, fmt.Errorf("invalid AUTO_MIGRATE: %w", err)
+	}
+	if cfg.ValidateSchema, err = strconv.ParseBool(mustEnv("VALIDATE_SCHEMA", "false")); err != nil {
+		return cfg, fmt.Errorf("invalid VALIDATE_SCHEMA: %w", err)
+	}
+	if cfg.StrictParsing, err = strconv.ParseBool(mustEnv("INGEST_STRICT_PARSING", "false")); err != nil {
+		return cfg, fmt.Errorf("invalid INGEST_STRICT_PARSING: %w", err)
+	}
+	if cfg.StoreRawPayload, err = strconv.ParseBool(mustEnv("STORE_RAW_PAYLOAD", "false")); err != nil {
+		return cfg, fmt.Errorf("invalid STORE_RAW_PAYLOAD: %w", err)
+	}
+	if cfg.ProductionSampleRate, err = strconv.Atoi(mustEnv("PRODUCTION_SAMPLE_RATE", "1")); err != nil || cfg.ProductionSampleRate < 1 {
+		return cfg, fmt.Errorf("invalid PRODUCTION_SAMPLE_RATE: must be a positive integer")
+	}
+	if cfg.TotalizerMax, err = strconv.ParseUint(mustEnv("TOTALIZER_MAX", "0"), 10, 64); err != nil {
+		return cfg, fmt.Errorf("invalid TOTALIZER_MAX: must be a non-negative integer")
+	}
+	healthSec, err := strconv.Atoi(mustEnv("DB_HEALTH_INTERVAL", "10"))
+	if err != nil || healthSec < 0 {
+		return cfg, fmt.Errorf("invalid DB_HEALTH_INTERVAL: must be a non-negative number of seconds")
+	}
+	cfg.DBHealthInterval = time.Duration(healthSec) * time.Second
+	if cfg.DBUnhealthyAfter, err = strconv.Atoi(mustEnv("DB_UNHEALTHY_AFTER", "3")); err != nil || cfg.DBUnhealthyAfter < 1 {
+		return cfg, fmt.Errorf("invalid DB_UNHEALTHY_AFTER: must be a positive integer")
+	}
+	if cfg.Decimation, err = loadDecimation(); err != nil {
+		return cfg, err
+	}
+	skipSec, err := strconv.Atoi(mustEnv("SKIP_RETAINED_ON_START", "0"))
+	if err != nil || skipSec < 0 {
+		return cfg, fmt.Errorf("invalid SKIP_RETAINED_ON_START: must be a non-negative number of seconds")
+	}
+	cfg.SkipRetainedOnStart = time.Duration(skipSec) * time.Second
+	chaosLatencyMs, err := strconv.Atoi(mustEnv("CHAOS_INSERT_LATENCY_MS", "0"))
+	if err != nil || chaosLatencyMs < 0 {
+		return cfg, fmt.Errorf("invalid CHAOS_INSERT_LATENCY_MS: must be a non-negative integer")
+	}
+	cfg.InsertChaos.Latency = time.Duration(chaosLatencyMs) * time.Millisecond
+	if cfg.InsertChaos.ErrorRate, err = strconv.ParseFloat(mustEnv("CHAOS_INSERT_ERROR_RATE", "0"), 64); err != nil || cfg.InsertChaos.ErrorRate < 0 || cfg.InsertChaos.ErrorRate > 1 {
+		return cfg, fmt.Errorf("invalid CHAOS_INSERT_ERROR_RATE: must be between 0 and 1")
+	}
+	if cfg.SelfTest, err = strconv.ParseBool(mustEnv("SELFTEST", "false")); err != nil {
+		return cfg, fmt.Errorf("invalid SELFTEST: %w", err)
+	}
+	selfTestSec, err := strconv.Atoi(mustEnv("SELFTEST_TIMEOUT", "30"))
+	if err != nil || selfTestSec < 1 {
+		return cfg, fmt.Errorf("invalid SELFTEST_TIMEOUT: must be a positive number of seconds")
+	}
+	cfg.SelfTestTimeout = time.Duration(selfTestSec) * time.Second
+	if cfg.BufferSize, err = strconv.Atoi(mustEnv("INGEST_BUFFER_SIZE", "1000")); err != nil || cfg.BufferSize < 1 {
+		return cfg, fmt.Errorf("invalid INGEST_BUFFER_SIZE: must be a positive integer")
+	}
+	if cfg.Workers, err = strconv.Atoi(mustEnv("INGEST_WORKERS", "1")); err != nil || cfg.Workers < 1 {
+		return cfg, fmt.Errorf("invalid INGEST_WORKERS: must be a positive integer")
+	}
+	if cfg.MetricsRequired, err = strconv.ParseBool(mustEnv("METRICS_REQUIRED", "false")); err != nil {
+		return cfg, fmt.Errorf("invalid METRICS_REQUIRED: %w", err)
+	}
+	if cfg.LogEvents, err = strconv.ParseBool(mustEnv("INGEST_LOG_EVENTS", "false")); err != nil {
+		return cfg, fmt.Errorf("invalid INGEST_LOG_EVENTS: %w", err)
+	}
+	latencySec, err := strconv.Atoi(mustEnv("LATENCY_REPORT_INTERVAL", "0"))
+	if err != nil || latencySec < 0 {
+		return cfg, fmt.Errorf("invalid LATENCY_REPORT_INTERVAL: must be a non-negative number of seconds")
+	}
+	cfg.LatencyReportInterval = time.Duration(latencySec) * time.Second
+	if cfg.DebugEndpoints, err = strconv.ParseBool(mustEnv("DEBUG_ENDPOINTS", "false")); err != nil {
+		return cfg, fmt.Errorf("invalid DEBUG_ENDPOINTS: %w", err)
+	}
+
+	return cfg, nil
+}
+
+func mustEnv(key, def string) string {
+	if envDefaults != nil {
+		envDefaults[key] = def
+	}
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
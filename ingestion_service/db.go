@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/connstr"
+)
+
+// Supported DB_DRIVER values.
+const (
+	driverPostgres = "postgres"
+	driverSQLite   = "sqlite"
+)
+
+// sqliteSchema creates the event tables when running against SQLite.
+//
+//go:embed schema_sqlite.sql
+var sqliteSchema string
+
+// openDB opens and pings the database selected by DB_DRIVER.
+//
+// TimescaleDB is the production store and its schema is owned by the goose
+// migrations. SQLite is for local runs and tests without Postgres: its
+// schema is created here, and the Timescale-only parts (hypertables,
+// retention) simply don't exist. The queries the service runs, including
+// the ON CONFLICT clauses and $n placeholders, are valid in both.
+func openDB(cfg Config) (*sql.DB, error) {
+	switch cfg.DBDriver {
+	case driverPostgres:
+		return openDSN(driverPostgres, connstr.Postgres(cfg.PGHost, cfg.PGPort, cfg.PGUser, cfg.PGPassword, cfg.PGDB))
+	case driverSQLite:
+		return openDSN(driverSQLite, cfg.SQLitePath)
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q", cfg.DBDriver)
+	}
+}
+
+// openDSN opens and pings a database of driver at dsn, creating the schema
+// if it is SQLite.
+func openDSN(driver, dsn string) (*sql.DB, error) {
+	switch driver {
+	case driverPostgres:
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.Ping(); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return db, nil
+	case driverSQLite:
+		db, err := sql.Open("sqlite", dsn)
+		if err != nil {
+			return nil, err
+		}
+		// SQLite allows one writer at a time, and every connection to
+		// ":memory:" would otherwise get its own empty database.
+		db.SetMaxOpenConns(1)
+		if _, err := db.Exec(sqliteSchema); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("create sqlite schema: %w", err)
+		}
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q", driver)
+	}
+}
+
+// isPermanent reports whether retrying err is pointless: constraint
+// violations and bad data. In Postgres those are classes 23 and 22.
+func isPermanent(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		class := pqErr.Code.Class()
+		return class == "22" || class == "23"
+	}
+	var liteErr *sqlite.Error
+	if errors.As(err, &liteErr) {
+		switch liteErr.Code() & 0xff {
+		case sqlite3.SQLITE_CONSTRAINT, sqlite3.SQLITE_MISMATCH, sqlite3.SQLITE_TOOBIG:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultMaxIdleConns is database/sql's default, which a recovery restores
+// after dropping the idle connections.
+const defaultMaxIdleConns = 2
+
+// dbHealth tracks whether the database answers the background pings. After
+// DBUnhealthyAfter failed pings in a row the outage is sustained: /readyz
+// fails, so an orchestrator stops routing to or restarts the ingestor, and
+// in at-least-once mode the workers hold their messages until a ping
+// succeeds again rather than burning their retries on inserts that can't
+// work, leaving the backlog with the broker.
+type dbHealth struct {
+	mu       sync.Mutex
+	failures int
+	// since is when the failing pings started, and open whether they have
+	// gone on long enough to be a sustained outage.
+	since   time.Time
+	open    bool
+	lastErr error
+	// up is closed while the database is up, and replaced by an open
+	// channel for the length of a sustained outage.
+	up chan struct{}
+}
+
+// dbState is the health of the ingestor's database, checked by watchDB.
+var dbState = newDBHealth()
+
+func newDBHealth() *dbHealth {
+	up := make(chan struct{})
+	close(up)
+	return &dbHealth{up: up}
+}
+
+// watchDB pings db every interval until ctx is done, recording the result
+// in h.
+func watchDB(ctx context.Context, db *sql.DB, h *dbHealth, interval time.Duration) {
+	dbUp.Set(1)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := db.PingContext(pingCtx)
+		cancel()
+		if err != nil {
+			h.failed(err)
+		} else {
+			h.succeeded(db)
+		}
+	}
+}
+
+// failed records a failed ping, opening the circuit once the outage is
+// sustained.
+func (h *dbHealth) failed(err error) {
+	dbUp.Set(0)
+	dbPingFailures.Inc()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures++
+	h.lastErr = err
+	if h.failures == 1 {
+		h.since = time.Now()
+		log.Printf("database ping failed: %v", err)
+	}
+	if h.failures == config.DBUnhealthyAfter {
+		h.open = true
+		h.up = make(chan struct{})
+		dbCircuitOpen.Set(1)
+		log.Printf("database unreachable for %d pings in a row, not ready until it answers: %v", h.failures, err)
+	}
+}
+
+// succeeded records a successful ping. After a sustained outage it drops
+// the pool's idle connections, which may be to the database as it was
+// before the outage, and closes the circuit.
+func (h *dbHealth) succeeded(db *sql.DB) {
+	dbUp.Set(1)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failures == 0 {
+		return
+	}
+	if !h.open {
+		log.Printf("database answering again after %d failed ping(s)", h.failures)
+	} else {
+		db.SetMaxIdleConns(0)
+		db.SetMaxIdleConns(defaultMaxIdleConns)
+		close(h.up)
+		dbCircuitOpen.Set(0)
+		log.Printf("database answering again after %v down, resuming", time.Since(h.since).Round(time.Second))
+	}
+	h.failures, h.open, h.lastErr = 0, false, nil
+}
+
+// wait blocks while the outage is sustained.
+func (h *dbHealth) wait() {
+	h.mu.Lock()
+	up := h.up
+	h.mu.Unlock()
+	<-up
+}
+
+// check returns an error describing a sustained outage, or nil.
+func (h *dbHealth) check() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.open {
+		return nil
+	}
+	return fmt.Errorf("database unreachable since %s: %v", h.since.UTC().Format(time.RFC3339), h.lastErr)
+}
+
+// readyz is the unauthenticated readiness handler served on /readyz: 200
+// unless the database outage is sustained.
+func readyz(w http.ResponseWriter, _ *http.Request) {
+	if err := dbState.check(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyDriver is a database/sql driver whose connections answer pings
+// only while its database is up.
+type flakyDriver struct{ down atomic.Bool }
+
+func (d *flakyDriver) Open(string) (driver.Conn, error) {
+	if d.down.Load() {
+		return nil, errors.New("connection refused")
+	}
+	return flakyConn{d}, nil
+}
+
+type flakyConn struct{ d *flakyDriver }
+
+func (c flakyConn) Ping(context.Context) error {
+	if c.d.down.Load() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func (flakyConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (flakyConn) Close() error                        { return nil }
+func (flakyConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+var flaky = &flakyDriver{}
+
+func init() {
+	sql.Register("flaky", flaky)
+}
+
+// ready returns the status /readyz answers with.
+func ready() int {
+	rec := httptest.NewRecorder()
+	readyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	return rec.Code
+}
+
+func TestDBHealth(t *testing.T) {
+	setupTest(t, map[string]string{"DB_UNHEALTHY_AFTER": "3"})
+	h := newDBHealth()
+	saved := dbState
+	dbState = h
+	t.Cleanup(func() { dbState = saved })
+	db, err := sql.Open("flaky", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	boom := errors.New("boom")
+	// A failing ping or two is not yet an outage
+	h.failed(boom)
+	h.failed(boom)
+	if code := ready(); code != http.StatusOK {
+		t.Fatalf("after 2 failed pings /readyz = %d, want %d", code, http.StatusOK)
+	}
+	h.succeeded(db)
+	h.failed(boom)
+	h.failed(boom)
+	if code := ready(); code != http.StatusOK {
+		t.Fatalf("after a success the failures restarted, but /readyz = %d", code)
+	}
+
+	h.failed(boom)
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Fatalf("after 3 failed pings /readyz = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	waited := make(chan struct{})
+	go func() {
+		h.wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("wait returned during the outage")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	h.succeeded(db)
+	if code := ready(); code != http.StatusOK {
+		t.Fatalf("after recovering /readyz = %d, want %d", code, http.StatusOK)
+	}
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("wait still blocked after recovering")
+	}
+}
+
+// watchDB notices a database that stops answering, and one that answers
+// again.
+func TestWatchDB(t *testing.T) {
+	setupTest(t, map[string]string{"DB_UNHEALTHY_AFTER": "2"})
+	h := newDBHealth()
+	saved := dbState
+	dbState = h
+	t.Cleanup(func() {
+		dbState = saved
+		flaky.down.Store(false)
+	})
+	db, err := sql.Open("flaky", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		watchDB(ctx, db, h, 5*time.Millisecond)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	await := func(want int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for ready() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("/readyz = %d, want %d", ready(), want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	await(http.StatusOK)
+	flaky.down.Store(true)
+	await(http.StatusServiceUnavailable)
+	flaky.down.Store(false)
+	await(http.StatusOK)
+}
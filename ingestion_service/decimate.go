@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Decimation keeps part measurements at full resolution for a recent
+// window and rolls older ones up into a mean, min and max per bucket in
+// part_measurements_rollup, which outlives them. It needs TimescaleDB's
+// time_bucket, so it only runs against Postgres.
+type Decimation struct {
+	// After is how long measurements are kept raw; zero keeps them all and
+	// turns decimation off.
+	After time.Duration
+	// Bucket is the width of the summaries, and Interval how often the job
+	// rolls up the measurements that have aged past After.
+	Bucket   time.Duration
+	Interval time.Duration
+	// Retention is how long the summaries are kept; zero keeps them.
+	Retention time.Duration
+}
+
+// loadDecimation reads the MEASUREMENT_DECIMATE_* and
+// MEASUREMENT_ROLLUP_RETENTION_DAYS settings.
+func loadDecimation() (Decimation, error) {
+	var d Decimation
+	afterHours, err := strconv.Atoi(mustEnv("MEASUREMENT_DECIMATE_AFTER_HOURS", "168"))
+	if err != nil || afterHours < 0 {
+		return d, fmt.Errorf("invalid MEASUREMENT_DECIMATE_AFTER_HOURS: must be a non-negative number of hours")
+	}
+	d.After = time.Duration(afterHours) * time.Hour
+	bucketSec, err := strconv.Atoi(mustEnv("MEASUREMENT_DECIMATE_BUCKET_SEC", "3600"))
+	if err != nil || bucketSec < 1 {
+		return d, fmt.Errorf("invalid MEASUREMENT_DECIMATE_BUCKET_SEC: must be a positive number of seconds")
+	}
+	d.Bucket = time.Duration(bucketSec) * time.Second
+	intervalSec, err := strconv.Atoi(mustEnv("MEASUREMENT_DECIMATE_INTERVAL_SEC", "3600"))
+	if err != nil || intervalSec < 1 {
+		return d, fmt.Errorf("invalid MEASUREMENT_DECIMATE_INTERVAL_SEC: must be a positive number of seconds")
+	}
+	d.Interval = time.Duration(intervalSec) * time.Second
+	retentionDays, err := strconv.Atoi(mustEnv("MEASUREMENT_ROLLUP_RETENTION_DAYS", "730"))
+	if err != nil || retentionDays < 0 {
+		return d, fmt.Errorf("invalid MEASUREMENT_ROLLUP_RETENTION_DAYS: must be a non-negative number of days")
+	}
+	d.Retention = time.Duration(retentionDays) * 24 * time.Hour
+	if d.Retention > 0 && d.Retention <= d.After {
+		return d, fmt.Errorf("invalid MEASUREMENT_ROLLUP_RETENTION_DAYS: must be longer than MEASUREMENT_DECIMATE_AFTER_HOURS, or the summaries are dropped as they are made")
+	}
+	return d, nil
+}
+
+// decimateLockID is the advisory lock a decimation holds, so replicas
+// sharing the database never roll up the same measurements twice.
+const decimateLockID = 0x6f65652d64656369
+
+// rollupSQL summarizes the measurements before $2 into buckets of width $1.
+// A bucket already summarized, because a measurement arrived after its
+// bucket was rolled up, absorbs the new summary.
+const rollupSQL = `INSERT INTO part_measurements_rollup AS r
+	(bucket, bucket_width, site, machine_id, characteristic, unit, mean_value, min_value, max_value, samples, out_of_spec_samples)
+SELECT time_bucket($1::interval, time), $1::interval, site, machine_id, characteristic, unit,
+	avg(value), min(value), max(value), count(*), count(*) FILTER (WHERE out_of_spec)
+FROM part_measurements
+WHERE time < $2
+GROUP BY 1, site, machine_id, characteristic, unit
+ON CONFLICT (site, machine_id, characteristic, unit, bucket_width, bucket) DO UPDATE SET
+	mean_value = (r.mean_value * r.samples + EXCLUDED.mean_value * EXCLUDED.samples) / (r.samples + EXCLUDED.samples),
+	min_value = LEAST(r.min_value, EXCLUDED.min_value),
+	max_value = GREATEST(r.max_value, EXCLUDED.max_value),
+	samples = r.samples + EXCLUDED.samples,
+	out_of_spec_samples = r.out_of_spec_samples + EXCLUDED.out_of_spec_samples`
+
+// runDecimation decimates db now and then every d.Interval until ctx is
+// done. A failed run is logged and retried at the next interval.
+func runDecimation(ctx context.Context, db *sql.DB, d Decimation) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		n, err := decimate(ctx, db, d, time.Now())
+		switch {
+		case err != nil:
+			decimationFailures.Inc()
+			log.Printf("ERROR: decimating part measurements: %v", err)
+		case n > 0:
+			measurementsDecimated.Add(float64(n))
+			log.Printf("Rolled up %d part measurements older than %v into %v buckets", n, d.After, d.Bucket)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// decimate rolls up the measurements older than d.After, in whole buckets,
+// deletes them and drops the summaries older than d.Retention, all in one
+// transaction. It returns how many measurements it rolled up, none when
+// another replica holds the lock.
+func decimate(ctx context.Context, db *sql.DB, d Decimation, now time.Time) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, int64(decimateLockID)).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	// Only buckets that have aged past d.After entirely are rolled up, so
+	// none is summarized from part of its measurements
+	width := fmt.Sprintf("%d seconds", int64(d.Bucket.Seconds()))
+	var cutoff time.Time
+	if err := tx.QueryRowContext(ctx, `SELECT time_bucket($1::interval, $2::timestamptz)`, width, now.Add(-d.After)).Scan(&cutoff); err != nil {
+		return 0, fmt.Errorf("bucket cutoff: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, rollupSQL, width, cutoff); err != nil {
+		return 0, fmt.Errorf("roll up: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM part_measurements WHERE time < $1`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("delete rolled up measurements: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if d.Retention > 0 {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM part_measurements_rollup WHERE bucket < $1`, now.Add(-d.Retention)); err != nil {
+			return 0, fmt.Errorf("drop expired summaries: %w", err)
+		}
+	}
+	return n, tx.Commit()
+}
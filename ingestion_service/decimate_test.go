@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decimationDriver is a database/sql driver standing in for TimescaleDB in
+// a decimation: it grants the advisory lock if locked is set, answers the
+// cutoff query with cutoff, fails the statements containing failOn, and
+// records the statements run and how the transaction ended.
+type decimationDriver struct {
+	locked    bool
+	cutoff    time.Time
+	failOn    string
+	execs     []string
+	args      [][]driver.NamedValue
+	committed bool
+}
+
+func (d *decimationDriver) Open(string) (driver.Conn, error) { return decimationConn{d}, nil }
+
+type decimationConn struct{ d *decimationDriver }
+
+func (c decimationConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if strings.Contains(query, "pg_try_advisory_xact_lock") {
+		return &oneRow{col: "locked", v: c.d.locked}, nil
+	}
+	return &oneRow{col: "time_bucket", v: c.d.cutoff}, nil
+}
+
+func (c decimationConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.d.failOn != "" && strings.Contains(query, c.d.failOn) {
+		return nil, errors.New("relation does not exist")
+	}
+	c.d.execs = append(c.d.execs, query)
+	c.d.args = append(c.d.args, args)
+	return driver.RowsAffected(42), nil
+}
+
+func (c decimationConn) Begin() (driver.Tx, error)         { return decimationTx{c.d}, nil }
+func (decimationConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (decimationConn) Close() error                        { return nil }
+
+type decimationTx struct{ d *decimationDriver }
+
+func (t decimationTx) Commit() error { t.d.committed = true; return nil }
+func (decimationTx) Rollback() error { return nil }
+
+// oneRow is a result of a single row with a single column.
+type oneRow struct {
+	col  string
+	v    driver.Value
+	done bool
+}
+
+func (r *oneRow) Columns() []string { return []string{r.col} }
+func (r *oneRow) Close() error      { return nil }
+func (r *oneRow) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.v
+	return nil
+}
+
+var decimation = &decimationDriver{}
+
+func init() {
+	sql.Register("decimation", decimation)
+}
+
+func TestDecimate(t *testing.T) {
+	now := time.Date(2025, 11, 12, 10, 30, 0, 0, time.UTC)
+	cutoff := time.Date(2025, 11, 5, 10, 0, 0, 0, time.UTC)
+	d := Decimation{After: 7 * 24 * time.Hour, Bucket: time.Hour, Interval: time.Hour, Retention: 730 * 24 * time.Hour}
+	tests := []struct {
+		name      string
+		locked    bool
+		failOn    string
+		retention time.Duration
+		want      int64
+		// wantExecs are the statements run, by the table they start with
+		wantExecs []string
+		wantErr   bool
+	}{
+		{"rolls up, deletes and expires", true, "", d.Retention, 42,
+			[]string{"INSERT INTO part_measurements_rollup", "DELETE FROM part_measurements ", "DELETE FROM part_measurements_rollup"}, false},
+		{"summaries kept forever", true, "", 0, 42,
+			[]string{"INSERT INTO part_measurements_rollup", "DELETE FROM part_measurements "}, false},
+		{"another replica holds the lock", false, "", d.Retention, 0, nil, false},
+		{"failed rollup deletes nothing", true, "INSERT", d.Retention, 0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := sql.Open("decimation", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			*decimation = decimationDriver{locked: tt.locked, cutoff: cutoff, failOn: tt.failOn}
+
+			dd := d
+			dd.Retention = tt.retention
+			n, err := decimate(context.Background(), db, dd, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decimate = %d, %v, want error %v", n, err, tt.wantErr)
+			}
+			if n != tt.want {
+				t.Fatalf("decimate rolled up %d measurements, want %d", n, tt.want)
+			}
+			if want := tt.locked && !tt.wantErr; decimation.committed != want {
+				t.Fatalf("committed = %v, want %v", decimation.committed, want)
+			}
+			if len(decimation.execs) != len(tt.wantExecs) {
+				t.Fatalf("ran %q, want %q", decimation.execs, tt.wantExecs)
+			}
+			for i, prefix := range tt.wantExecs {
+				if !strings.HasPrefix(decimation.execs[i], prefix) {
+					t.Fatalf("statement %d is %q, want %s...", i, decimation.execs[i], prefix)
+				}
+			}
+			if len(tt.wantExecs) == 0 {
+				return
+			}
+			// Measurements are rolled up and deleted in whole buckets
+			if got := decimation.args[0][0].Value; got != "3600 seconds" {
+				t.Errorf("bucket width %v, want 3600 seconds", got)
+			}
+			for _, i := range []int{0, 1} {
+				if got := decimation.args[i][len(decimation.args[i])-1].Value; got != cutoff {
+					t.Errorf("statement %d cut off at %v, want %v", i, got, cutoff)
+				}
+			}
+			if len(tt.wantExecs) == 3 {
+				if got, want := decimation.args[2][0].Value, now.Add(-tt.retention); got != want {
+					t.Errorf("summaries expired before %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigDecimation(t *testing.T) {
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	want := Decimation{After: 168 * time.Hour, Bucket: time.Hour, Interval: time.Hour, Retention: 730 * 24 * time.Hour}
+	if cfg.Decimation != want {
+		t.Fatalf("Decimation = %+v, want %+v by default", cfg.Decimation, want)
+	}
+
+	for _, env := range []map[string]string{
+		{"MEASUREMENT_DECIMATE_AFTER_HOURS": "-1"},
+		{"MEASUREMENT_DECIMATE_BUCKET_SEC": "0"},
+		{"MEASUREMENT_DECIMATE_INTERVAL_SEC": "hourly"},
+		{"MEASUREMENT_ROLLUP_RETENTION_DAYS": "-1"},
+		{"MEASUREMENT_DECIMATE_AFTER_HOURS": "720", "MEASUREMENT_ROLLUP_RETENTION_DAYS": "30"},
+	} {
+		for k, v := range env {
+			t.Setenv(k, v)
+		}
+		if _, err := loadConfig(); err == nil {
+			t.Errorf("loadConfig accepted %v", env)
+		}
+		for k := range env {
+			t.Setenv(k, "")
+		}
+	}
+}
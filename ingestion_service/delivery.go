@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+// Delivery modes (INGEST_DELIVERY).
+const (
+	// deliveryAtLeastOnce subscribes at QoS 1 with a persistent session and
+	// retries failed inserts, so no event is lost but some may arrive twice.
+	deliveryAtLeastOnce = "at-least-once"
+	// deliveryAtMostOnce subscribes at QoS 0 and tries each insert once, so
+	// no event is stored twice but some may be lost.
+	deliveryAtMostOnce = "at-most-once"
+)
+
+// Duplicate handling (INGEST_DUPLICATES). An event is a duplicate of a
+// stored one when both have the same site, machine_id and timestamp.
+const (
+	// duplicatesReject fails the insert; the event goes to ingest_errors.
+	duplicatesReject = "reject"
+	// duplicatesIgnore keeps the stored row and drops the new one.
+	duplicatesIgnore = "ignore"
+	// duplicatesUpsert overwrites the stored row with the new one.
+	duplicatesUpsert = "upsert"
+)
+
+// Delivery is the ingestion contract: how messages are received from the
+// broker, how failed inserts are retried and what happens to duplicates.
+// Events that still fail are always recorded in ingest_errors (the DLQ).
+type Delivery struct {
+	Mode         string
+	Duplicates   string
+	RetryMax     int
+	RetryBackoff time.Duration
+}
+
+// qos is the subscription QoS matching the delivery mode.
+func (d Delivery) qos() byte {
+	if d.Mode == deliveryAtMostOnce {
+		return 0
+	}
+	return 1
+}
+
+// onConflict returns the clause appended to an event INSERT to apply the
+// duplicate policy; key names the table's site, machine_id and time
+// columns, and columns are the non-key columns an upsert overwrites.
+func (d Delivery) onConflict(key string, columns ...string) string {
+	switch d.Duplicates {
+	case duplicatesIgnore:
+		return " ON CONFLICT (" + key + ") DO NOTHING"
+	case duplicatesUpsert:
+		set := make([]string, len(columns))
+		for i, c := range columns {
+			set[i] = c + " = EXCLUDED." + c
+		}
+		return " ON CONFLICT (" + key + ") DO UPDATE SET " + strings.Join(set, ", ")
+	default:
+		return ""
+	}
+}
+
+// exec runs an insert, retrying transient failures with exponential backoff
+// in at-least-once mode. Errors the database will keep returning, such as a
+// rejected duplicate, are not retried.
+func (d Delivery) exec(db *sql.DB, query string, args ...any) error {
+	attempts := 1
+	if d.Mode == deliveryAtLeastOnce {
+		attempts += d.RetryMax
+	}
+	backoff := d.RetryBackoff
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			log.Printf("retrying insert (attempt %d/%d) after error: %v", i+1, attempts, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = injectInsertFault(); err != nil {
+			continue
+		}
+		if _, err = db.Exec(query, args...); err == nil || isPermanent(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// injectInsertFault degrades one insert attempt as CHAOS_INSERT_LATENCY_MS
+// and CHAOS_INSERT_ERROR_RATE say. An injected failure is transient, so it
+// is retried like a lost connection.
+func injectInsertFault() error {
+	f := config.InsertChaos
+	if !f.Enabled() {
+		return nil
+	}
+	if f.Latency > 0 {
+		chaosFaults.WithLabelValues("latency").Inc()
+	}
+	err := f.Inject()
+	if err != nil {
+		chaosFaults.WithLabelValues("error").Inc()
+	}
+	return err
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOnConflict(t *testing.T) {
+	const key = "site, machine_id, time"
+	tests := []struct {
+		duplicates string
+		columns    []string
+		want       string
+	}{
+		{duplicatesReject, []string{"status", "reason"}, ""},
+		{"", []string{"status"}, ""},
+		{duplicatesIgnore, []string{"status", "reason"}, " ON CONFLICT (site, machine_id, time) DO NOTHING"},
+		{duplicatesUpsert, []string{"status"}, " ON CONFLICT (site, machine_id, time) DO UPDATE SET status = EXCLUDED.status"},
+		{duplicatesUpsert, []string{"status", "reason", "suspect"}, " ON CONFLICT (site, machine_id, time) DO UPDATE SET status = EXCLUDED.status, reason = EXCLUDED.reason, suspect = EXCLUDED.suspect"},
+	}
+	for _, tt := range tests {
+		d := Delivery{Duplicates: tt.duplicates}
+		if got := d.onConflict(key, tt.columns...); got != tt.want {
+			t.Errorf("onConflict with %q = %q, want %q", tt.duplicates, got, tt.want)
+		}
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	db := setupTest(t, nil)
+	if _, err := db.Exec(`CREATE TABLE rowids (id INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatal(err)
+	}
+	insert := `INSERT INTO status_events (time, machine_id, status) VALUES ($1, 1, 'running')`
+	if _, err := db.Exec(insert, testTime); err != nil {
+		t.Fatal(err)
+	}
+	sqliteErr := func(query string, args ...any) error {
+		_, err := db.Exec(query, args...)
+		if err == nil {
+			t.Fatalf("%s succeeded", query)
+		}
+		return err
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"postgres unique violation", &pq.Error{Code: "23505"}, true},
+		{"postgres not null violation", &pq.Error{Code: "23502"}, true},
+		{"postgres invalid text", &pq.Error{Code: "22P02"}, true},
+		{"postgres value out of range", &pq.Error{Code: "22003"}, true},
+		{"wrapped postgres unique violation", fmt.Errorf("insert: %w", &pq.Error{Code: "23505"}), true},
+		{"postgres connection failure", &pq.Error{Code: "08006"}, false},
+		{"postgres serialization failure", &pq.Error{Code: "40001"}, false},
+		{"postgres shutting down", &pq.Error{Code: "57P01"}, false},
+		{"postgres missing table", &pq.Error{Code: "42P01"}, false},
+		{"sqlite unique violation", sqliteErr(insert, testTime), true},
+		{"sqlite not null violation", sqliteErr(`INSERT INTO status_events (time, machine_id) VALUES ($1, 1)`, testTime), true},
+		{"sqlite datatype mismatch", sqliteErr(`INSERT INTO rowids (id) VALUES ('x')`), true},
+		{"sqlite missing table", sqliteErr(`INSERT INTO missing (id) VALUES (1)`), false},
+		{"bad connection", driver.ErrBadConn, false},
+		{"timeout", context.DeadlineExceeded, false},
+		{"other", errors.New("connection reset by peer"), false},
+	}
+	for _, tt := range tests {
+		if got := isPermanent(tt.err); got != tt.want {
+			t.Errorf("%s: isPermanent(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+// scriptedDriver is a database/sql driver whose Exec fails with the
+// errors in fail, one per call, and then succeeds, counting the calls.
+type scriptedDriver struct {
+	fail  []error
+	calls int
+}
+
+func (d *scriptedDriver) Open(string) (driver.Conn, error) { return scriptedConn{d}, nil }
+
+type scriptedConn struct{ d *scriptedDriver }
+
+func (c scriptedConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	c.d.calls++
+	if len(c.d.fail) > 0 {
+		err := c.d.fail[0]
+		c.d.fail = c.d.fail[1:]
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (scriptedConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (scriptedConn) Close() error                        { return nil }
+func (scriptedConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+var scripted = &scriptedDriver{}
+
+func init() {
+	sql.Register("scripted", scripted)
+}
+
+func TestDeliveryExecRetries(t *testing.T) {
+	transient := errors.New("connection reset by peer")
+	duplicate := &pq.Error{Code: "23505"}
+	tests := []struct {
+		name      string
+		mode      string
+		fail      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{"at-least-once success", deliveryAtLeastOnce, nil, 1, nil},
+		{"at-least-once recovers", deliveryAtLeastOnce, []error{transient, transient}, 3, nil},
+		{"at-least-once gives up", deliveryAtLeastOnce, []error{transient, transient, transient, transient, transient}, 4, transient},
+		{"at-least-once permanent", deliveryAtLeastOnce, []error{duplicate}, 1, duplicate},
+		{"at-least-once permanent after transient", deliveryAtLeastOnce, []error{transient, duplicate}, 2, duplicate},
+		{"at-most-once success", deliveryAtMostOnce, nil, 1, nil},
+		{"at-most-once transient", deliveryAtMostOnce, []error{transient, transient}, 1, transient},
+		{"at-most-once permanent", deliveryAtMostOnce, []error{duplicate}, 1, duplicate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := sql.Open("scripted", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			scripted.fail, scripted.calls = tt.fail, 0
+
+			d := Delivery{Mode: tt.mode, RetryMax: 3, RetryBackoff: time.Millisecond}
+			err = d.exec(db, `INSERT`)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("exec = %v, want %v", err, tt.wantErr)
+			}
+			if scripted.calls != tt.wantCalls {
+				t.Fatalf("exec tried %d time(s), want %d", scripted.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+// Injected insert failures are transient, so at-least-once retries them.
+func TestDeliveryExecInjectedFaults(t *testing.T) {
+	for _, tt := range []struct {
+		mode string
+		want int
+	}{
+		{deliveryAtLeastOnce, 4},
+		{deliveryAtMostOnce, 1},
+	} {
+		t.Run(tt.mode, func(t *testing.T) {
+			db := setupTest(t, map[string]string{"CHAOS_INSERT_ERROR_RATE": "1"})
+			injected := chaosFaults.WithLabelValues("error")
+			before := testutil.ToFloat64(injected)
+			d := Delivery{Mode: tt.mode, RetryMax: 3, RetryBackoff: time.Millisecond}
+			if err := d.exec(db, `SELECT 1`); err == nil {
+				t.Fatal("exec succeeded with every attempt failing")
+			}
+			if n := testutil.ToFloat64(injected) - before; n != float64(tt.want) {
+				t.Fatalf("exec tried %v time(s), want %d", n, tt.want)
+			}
+		})
+	}
+}
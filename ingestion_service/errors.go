@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Stages at which a message can fail to be ingested.
+const (
+	stageTopic  = "topic"
+	stageParse  = "parse"
+	stageSchema = "schema"
+	stageInsert = "insert"
+	// stagePromote is a staged row the event table rejected; see
+	// INGEST_STAGING.
+	stagePromote = "promote"
+)
+
+// stageError tags an ingestion error with the stage it happened in.
+type stageError struct {
+	stage string
+	err   error
+}
+
+func (e *stageError) Error() string { return e.stage + ": " + e.err.Error() }
+func (e *stageError) Unwrap() error { return e.err }
+
+// IngestError is a structured record of a message that could not be ingested.
+type IngestError struct {
+	Time    time.Time `json:"time"`
+	Topic   string    `json:"topic"`
+	Payload string    `json:"payload"`
+	Stage   string    `json:"stage"`
+	Error   string    `json:"error"`
+	TraceID string    `json:"trace_id,omitempty"`
+}
+
+// reportIngestError logs a failed message, stores it in the ingest_errors
+// table and, if errorsTopic is set, republishes it there for live consumers.
+func reportIngestError(db *sql.DB, client mqtt.Client, errorsTopic, topic string, payload []byte, err error) {
+	stage := "unknown"
+	var se *stageError
+	if errors.As(err, &se) {
+		stage = se.stage
+	}
+	rec := IngestError{
+		Time:    time.Now().UTC(),
+		Topic:   topic,
+		Payload: string(payload),
+		Stage:   stage,
+		Error:   err.Error(),
+		TraceID: payloadTraceID(payload),
+	}
+	if rec.TraceID != "" {
+		log.Printf("[trace %s] failed to ingest message on %s: %v", rec.TraceID, topic, err)
+	} else {
+		log.Printf("failed to ingest message on %s: %v", topic, err)
+	}
+
+	if _, dbErr := db.Exec(`INSERT INTO ingest_errors (time, topic, payload, stage, error) VALUES ($1,$2,$3,$4,$5)`,
+		rec.Time, rec.Topic, payload, rec.Stage, rec.Error); dbErr != nil {
+		log.Printf("failed to insert ingest error: %v", dbErr)
+	}
+
+	if errorsTopic == "" || client == nil {
+		return
+	}
+	body, _ := json.Marshal(rec)
+	token := client.Publish(errorsTopic, 1, false, body)
+	// Don't block the message handler on the broker ack; paho delivers
+	// messages on the same goroutine that processes acks.
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			log.Printf("failed to publish ingest error to %s: %v", errorsTopic, token.Error())
+		}
+	}()
+}
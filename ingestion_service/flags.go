@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/cliflags"
+)
+
+// options are the settings the ingestor takes as flags, one per
+// environment variable, in the order of .env.example.
+var options = []cliflags.Option{
+	{Env: "CONFIG_FILE", Usage: "YAML or TOML file of settings keyed by variable name"},
+	{Env: "MQTT_BROKER_URL", Usage: "MQTT broker to subscribe to"},
+	{Env: "MQTT_USERNAME", Usage: "broker user name"},
+	{Env: "MQTT_USERNAME_FILE", Usage: "file holding the broker user name"},
+	{Env: "MQTT_PASSWORD", Usage: "broker password; visible to other users, prefer -mqtt-password-file"},
+	{Env: "MQTT_PASSWORD_FILE", Usage: "file holding the broker password"},
+	{Env: "DB_DRIVER", Usage: `database driver: "postgres" or "sqlite"`},
+	{Env: "SQLITE_PATH", Usage: `SQLite database file (":memory:" allowed)`},
+	{Env: "PG_HOST", Usage: "PostgreSQL host"},
+	{Env: "PG_PORT", Usage: "PostgreSQL port"},
+	{Env: "PG_USER", Usage: "PostgreSQL user"},
+	{Env: "PG_PASSWORD", Usage: "PostgreSQL password; visible to other users, prefer -pg-password-file"},
+	{Env: "PG_PASSWORD_FILE", Usage: "file holding the PostgreSQL password"},
+	{Env: "PG_DB", Usage: "PostgreSQL database"},
+	{Env: "INGEST_SINKS", Usage: `comma-separated places to write events to: "db", "stdout", postgres:// URLs or sqlite:<path>`},
+	{Env: "MQTT_INGEST_CLIENT_ID", Usage: "MQTT client ID"},
+	{Env: "INGEST_ERRORS_TOPIC", Usage: "topic failed messages are republished on (empty = none)"},
+	{Env: "MQTT_TOPIC_PREFIXES", Usage: "comma-separated topic prefixes to ingest"},
+	{Env: "MQTT_SHARED_GROUP", Usage: "shared subscription group for several replicas (empty = none)"},
+	{Env: "INGEST_DELIVERY", Usage: `delivery contract: "at-least-once" or "at-most-once"`},
+	{Env: "INGEST_DUPLICATES", Usage: `events already stored: "reject", "ignore" or "upsert"`},
+	{Env: "ZERO_TIMESTAMP", Usage: `events without a timestamp: "now", "received_at" or "reject"`},
+	{Env: "INGEST_RETRY_MAX", Usage: "retries of transient insert failures in at-least-once mode"},
+	{Env: "INGEST_RETRY_BACKOFF_MS", Usage: "initial backoff between retries, in milliseconds"},
+	{Env: "SKIP_RETAINED_ON_START", Usage: "seconds after each connect during which retained messages are ignored (0 = ingest them)"},
+	{Env: "CHAOS_INSERT_LATENCY_MS", Usage: "delay every insert attempt by this many milliseconds (0 = off)"},
+	{Env: "CHAOS_INSERT_ERROR_RATE", Usage: "fraction of insert attempts failed (0 = off)"},
+	{Env: "SELFTEST", Usage: "check once that an event published to the broker is stored, then exit", Bool: true},
+	{Env: "SELFTEST_TIMEOUT", Usage: "seconds the self-test may take"},
+	{Env: "INGEST_BUFFER_SIZE", Usage: "messages received and waiting to be stored"},
+	{Env: "INGEST_WORKERS", Usage: "workers storing buffered messages"},
+	{Env: "DB_HEALTH_INTERVAL", Usage: "seconds between database pings (0 = no check)"},
+	{Env: "DB_UNHEALTHY_AFTER", Usage: "failed pings in a row after which the database is unhealthy"},
+	{Env: "STATE_VALIDATION", Usage: "flag status events whose transition is not allowed", Bool: true},
+	{Env: "STATE_TRANSITIONS", Usage: "allowed transitions as comma-separated from>to pairs"},
+	{Env: "STATUS_COMPACT_WINDOW", Usage: "seconds within which a status event repeating the last stored one is dropped (0 = off)"},
+	{Env: "SEQUENCE_SAVE_INTERVAL", Usage: "seconds between saves of the machines' last sequence numbers"},
+	{Env: "INGEST_STAGING", Usage: "write events to staging tables and promote them to the event tables in the background", Bool: true},
+	{Env: "INGEST_STAGING_INTERVAL", Usage: "seconds between promotions of staged events"},
+	{Env: "INGEST_STAGING_BATCH", Usage: "staged rows promoted per table and transaction"},
+	{Env: "REHYDRATE_STATE", Usage: "load every machine's last sequence number, status and parts_total at startup", Bool: true},
+	{Env: "INGEST_LOG_EVENTS", Usage: "log every stored event with its trace_id", Bool: true},
+	{Env: "LATENCY_REPORT_INTERVAL", Usage: "seconds between reports of the publish-to-store latency per machine (0 = off)"},
+	{Env: "AUTO_MIGRATE", Usage: "add event columns missing from an older schema at startup", Bool: true},
+	{Env: "INGEST_STRICT_PARSING", Usage: "route events with unknown fields to ingest_errors", Bool: true},
+	{Env: "VALIDATE_SCHEMA", Usage: "check every payload against its event's JSON Schema", Bool: true},
+	{Env: "STORE_RAW_PAYLOAD", Usage: "keep each event's payload as received in raw_payload", Bool: true},
+	{Env: "PRODUCTION_SAMPLE_RATE", Usage: "store 1 in N production events per machine (1 = all)"},
+	{Env: "TOTALIZER_MAX", Usage: "value after which parts_total counters wrap to 0 (0 = they never wrap)"},
+	{Env: "INGEST_METRICS_ADDR", Usage: "address of the /metrics endpoint (empty = disabled)"},
+	{Env: "METRICS_REQUIRED", Usage: "exit if the metrics address can't be bound", Bool: true},
+	{Env: "API_TOKEN", Usage: "bearer token required on /metrics and /debug/config"},
+	{Env: "DEBUG_ENDPOINTS", Usage: "serve the effective configuration on /debug/config", Bool: true},
+	{Env: "OTEL_EXPORTER_OTLP_ENDPOINT", Usage: "OTLP/HTTP endpoint to export spans to (unset = no tracing)"},
+}
+
+// optionsFooter lists the settings taken from the environment only.
+const optionsFooter = `The INGEST_MAP_<TYPE>_TABLE and INGEST_MAP_<TYPE>_COLUMNS mappings and the
+other OTEL_EXPORTER_OTLP_* variables are read from the environment only.`
+
+// parseFlags applies the command-line flags to the environment, before
+// loadConfig reads it.
+func parseFlags() {
+	cliflags.Parse("oee-ingestor", os.Args[1:], options, optionsFooter, flagDefaults)
+}
+
+// envDefaults, when not nil, collects the defaults the settings are read
+// with, for -h.
+var envDefaults map[string]string
+
+// flagDefaults returns the defaults of the settings. It loads the
+// configuration from an emptied environment, so it is only for -h, which
+// exits right after.
+func flagDefaults() map[string]string {
+	envDefaults = map[string]string{}
+	os.Clearenv()
+	loadConfig()
+	return envDefaults
+}
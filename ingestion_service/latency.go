@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"log"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// maxLatencySamples bounds the samples kept per machine between reports;
+// beyond it a uniform sample of the events is kept.
+const maxLatencySamples = 10000
+
+// latencyQuantiles are the quantiles reported.
+var latencyQuantiles = []float64{0.5, 0.95, 0.99}
+
+// e2eLatency holds the quantiles of the last report.
+var e2eLatency = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "oee_ingest_e2e_latency_seconds",
+	Help: "Quantiles of the time from publish to store of the events each machine published with published_at, over the last LATENCY_REPORT_INTERVAL.",
+}, []string{"site", "machine_id", "quantile"})
+
+// latencySamples are the publish-to-store latencies of one machine's events
+// since the last report, in seconds.
+type latencySamples struct {
+	values []float64
+	seen   int
+}
+
+// latencyTracker collects the latencies of the events stored between
+// reports, by machine.
+type latencyTracker struct {
+	mu       sync.Mutex
+	machines map[machineid.Key]*latencySamples
+	// reported are the machines whose gauges the last report set, only
+	// used by report.
+	reported []machineid.Key
+}
+
+// latencies are the latencies measured with LATENCY_REPORT_INTERVAL.
+var latencies = &latencyTracker{machines: map[machineid.Key]*latencySamples{}}
+
+// observeLatency records the latency of an event of machine published at
+// publishedAt and stored just now. Events without published_at, and
+// retained ones, which the broker replays from the past, are skipped.
+func observeLatency(ctx context.Context, machine machineid.Key, publishedAt *time.Time) {
+	if config.LatencyReportInterval <= 0 || publishedAt == nil {
+		return
+	}
+	if r, ok := receiptFrom(ctx); ok && r.retained {
+		return
+	}
+	latency := time.Since(*publishedAt).Seconds()
+
+	latencies.mu.Lock()
+	defer latencies.mu.Unlock()
+	s := latencies.machines[machine]
+	if s == nil {
+		s = &latencySamples{}
+		latencies.machines[machine] = s
+	}
+	s.seen++
+	if len(s.values) < maxLatencySamples {
+		s.values = append(s.values, latency)
+	} else if i := rand.IntN(s.seen); i < maxLatencySamples {
+		s.values[i] = latency
+	}
+}
+
+// reportLatencies logs and exposes the latency quantiles of each machine
+// every interval until ctx is done, starting afresh each time.
+func reportLatencies(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		latencies.report()
+	}
+}
+
+// report logs the quantiles of the latencies collected since the last
+// report, sets the gauges of the machines that had events, drops those of
+// the machines that didn't, and starts a new collection.
+func (t *latencyTracker) report() {
+	t.mu.Lock()
+	machines := t.machines
+	t.machines = map[machineid.Key]*latencySamples{}
+	t.mu.Unlock()
+
+	for _, m := range t.reported {
+		if _, ok := machines[m]; !ok {
+			e2eLatency.DeletePartialMatch(prometheus.Labels{"site": m.Site, "machine_id": strconv.Itoa(m.ID)})
+		}
+	}
+	keys := slices.SortedFunc(maps.Keys(machines), machineid.Compare)
+	for _, m := range keys {
+		s := machines[m]
+		slices.Sort(s.values)
+		var qs []time.Duration
+		for _, q := range latencyQuantiles {
+			v := quantile(s.values, q)
+			e2eLatency.WithLabelValues(m.Site, strconv.Itoa(m.ID), strconv.FormatFloat(q, 'g', -1, 64)).Set(v)
+			qs = append(qs, time.Duration(v*float64(time.Second)))
+		}
+		log.Printf("Publish-to-store latency of machine %s over %d events: p50 %v, p95 %v, p99 %v",
+			m, s.seen, qs[0].Round(time.Microsecond), qs[1].Round(time.Microsecond), qs[2].Round(time.Microsecond))
+		// Latencies can't be negative, so the producer's clock must be ahead
+		if s.values[0] < 0 {
+			log.Printf("Machine %s published events after this host stored them, by up to %v: its clock is ahead and the latencies are off by the skew",
+				m, time.Duration(-s.values[0]*float64(time.Second)).Round(time.Microsecond))
+		}
+	}
+	t.reported = keys
+}
+
+// quantile returns the q quantile of sorted by the nearest rank.
+func quantile(sorted []float64, q float64) float64 {
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
@@ -1,83 +1,249 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"log"
-	"os"
-	"strings"
+	"slices"
+	"sync/atomic"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
-	_ "github.com/lib/pq"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/events"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/buildinfo"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/mqttmetrics"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/telemetry"
 )
 
-// StatusEvent represents a machine status message
-type StatusEvent struct {
-	MachineID int       `json:"machine_id"`
-	Status    string    `json:"status"`
-	Timestamp time.Time `json:"timestamp"`
-}
+func main() {
+	parseFlags()
+	var err error
+	config, err = loadConfig()
+	if err != nil {
+		log.Fatalf("failed to load configuration: %v", err)
+	}
+	log.Printf("Starting %s", buildinfo.Get("oee-ingestor", config))
+	if config.ConfigFile != "" {
+		log.Printf("Loaded settings from %s (environment variables take precedence)", config.ConfigFile)
+	}
+	mqttURL := config.MQTTBrokerURL
 
-// ProductionEvent represents a production message
-type ProductionEvent struct {
-	MachineID     int       `json:"machine_id"`
-	PartsProduced int       `json:"parts_produced"`
-	PartsScrapped int       `json:"parts_scrapped"`
-	Timestamp     time.Time `json:"timestamp"`
-}
+	db, err := openDB(config)
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	log.Printf("Connected to database (%s)", config.DBDriver)
+	if config.DBHealthInterval > 0 {
+		go watchDB(context.Background(), db, dbState, config.DBHealthInterval)
+	}
+	if config.LatencyReportInterval > 0 {
+		go reportLatencies(context.Background(), config.LatencyReportInterval)
+		log.Printf("Reporting publish-to-store latency every %v", config.LatencyReportInterval)
+	}
+	tables := expectedSchema
+	if config.Staging {
+		tables = append(slices.Clone(tables), stagedSchema()...)
+	}
+	if err := checkSchema(db, config.DBDriver, config.AutoMigrate, tables); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := checkTableMap(db); err != nil {
+		log.Fatalf("%v", err)
+	}
+	for table, m := range config.TableMap {
+		log.Printf("Writing %s to %s with columns renamed %v", table, cmp.Or(m.Table, table), m.Columns)
+	}
+	if d := config.Decimation; d.After > 0 {
+		_, mapped := config.TableMap["part_measurements"]
+		switch {
+		case config.DBDriver != driverPostgres:
+			log.Printf("Keeping every part measurement: decimation needs TimescaleDB, not %s", config.DBDriver)
+		case mapped:
+			log.Printf("Keeping every part measurement: decimation doesn't follow INGEST_MAP_MEASUREMENT_*")
+		default:
+			log.Printf("Rolling part measurements older than %v up into %v buckets every %v", d.After, d.Bucket, d.Interval)
+			go runDecimation(context.Background(), db, d)
+		}
+	}
 
-func mustEnv(key, def string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
+	// Events are written to every sink in INGEST_SINKS, while the
+	// ingestor's own state stays in db
+	opened, closeSinks, err := openSinks(config, db)
+	if err != nil {
+		log.Fatalf("failed to open sinks: %v", err)
 	}
-	return def
-}
+	defer closeSinks()
+	sinks = opened
+	log.Printf("Writing events to: %s", sinks.Name())
 
-func main() {
-	mqttURL := mustEnv("MQTT_BROKER_URL", "tcp://emqx:1883")
-	mqttClientID := mustEnv("MQTT_INGEST_CLIENT_ID", "oee-ingestor")
-	pgHost := mustEnv("PG_HOST", "timescaledb")
-	pgPort := mustEnv("PG_PORT", "5432")
-	pgUser := mustEnv("PG_USER", "postgres")
-	pgPass := mustEnv("PG_PASSWORD", "postgres")
-	pgDB := mustEnv("PG_DB", "oee")
-
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		pgHost, pgPort, pgUser, pgPass, pgDB)
-
-	db, err := sql.Open("postgres", dsn)
+	serveHTTP(config.MetricsAddr)
+
+	shutdownTracing, err := telemetry.Setup(context.Background(), "oee-ingestor")
 	if err != nil {
-		log.Fatalf("failed to open db: %v", err)
+		log.Fatalf("failed to set up OpenTelemetry: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	log.Printf("Delivery: %s, duplicates: %s", config.Delivery.Mode, config.Delivery.Duplicates)
+	if config.InsertChaos.Enabled() {
+		log.Printf("WARNING: chaos testing, inserts get %s", config.InsertChaos)
+	}
+
+	sampler = newProductionSampler(config.ProductionSampleRate)
+	if sampler.rate > 1 {
+		log.Printf("Storing 1 in %d production events per machine", sampler.rate)
 	}
-	defer db.Close()
 
-	// Test database connection
-	if err := db.Ping(); err != nil {
-		log.Fatalf("failed to ping database: %v", err)
+	// Each replica in a shared group sees only some of a machine's events,
+	// so their sequence numbers would all look like gaps
+	if config.SharedGroup == "" {
+		sequences = newSequenceTracker()
+	}
+
+	if config.ValidateSchema {
+		if schemas, err = loadSchemas(); err != nil {
+			log.Fatalf("failed to load event schemas: %v", err)
+		}
+		log.Printf("Validating payloads against the %s event schemas", events.SchemaVersion)
+	}
+
+	if config.StateValidation {
+		validator = newTransitionValidator(config.StateTransitions)
+		log.Printf("Validating status transitions: %v", config.StateTransitions)
+	}
+
+	// A replica in a shared group doesn't see the statuses the others
+	// store, so it could drop a change back to a status it saw last
+	switch {
+	case config.StatusCompactWindow > 0 && config.SharedGroup != "":
+		log.Printf("Not compacting status events: STATUS_COMPACT_WINDOW is ignored with MQTT_SHARED_GROUP")
+	case config.StatusCompactWindow > 0:
+		compactor = newStatusCompactor(config.StatusCompactWindow)
+		log.Printf("Compacting repeated status events within %v", config.StatusCompactWindow)
+	}
+
+	// Rows an earlier run left staged are promoted before anything is read
+	// back from the event tables
+	if config.Staging {
+		stager = newPromoter(db, config.DBDriver, config.StagingBatch)
+		if err := stager.drain(context.Background()); err != nil {
+			log.Fatalf("failed to promote staged events: %v", err)
+		}
+		log.Printf("Staging events, promoting them every %v in batches of up to %d", config.StagingInterval, config.StagingBatch)
+	}
+
+	if config.RehydrateState && !config.SelfTest {
+		if err := rehydrateState(db); err != nil {
+			log.Fatalf("failed to rehydrate state: %v", err)
+		}
+	}
+
+	if config.SelfTest {
+		if err := runSelfTest(db, config.SelfTestTimeout); err != nil {
+			log.Fatalf("self-test failed: %v", err)
+		}
+		log.Printf("self-test passed")
+		return
+	}
+	if stager != nil {
+		go stager.run(context.Background(), config.StagingInterval)
+	}
+	if sequences != nil {
+		go sequences.run(context.Background(), config.SequenceSaveInterval)
 	}
-	log.Printf("Connected to TimescaleDB")
 
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(mqttURL)
-	opts.SetClientID(mqttClientID)
+	opts.SetClientID(config.MQTTClientID)
+	opts.SetUsername(config.MQTTUsername)
+	opts.SetPassword(config.MQTTPassword)
 	opts.SetAutoReconnect(true)
 	opts.SetConnectRetry(true)
 	opts.SetMaxReconnectInterval(10 * time.Second)
 	opts.SetKeepAlive(60 * time.Second)
 	opts.SetPingTimeout(10 * time.Second)
+	// At-least-once needs a persistent session so the broker redelivers
+	// QoS 1 messages that arrived while we were disconnected.
+	opts.SetCleanSession(config.Delivery.Mode != deliveryAtLeastOnce)
+	// Messages are acked once a worker has stored them, not when they enter
+	// the buffer, so a crash can't lose a message the broker thinks is done.
+	// Workers finish out of order, so the acks go through an ackOrder that
+	// sends them in receive order, as MQTT 3.1.1 §4.6 requires; it is
+	// replaced on each connection, whose unacked messages are redelivered.
+	opts.SetAutoAckDisabled(config.Delivery.Mode == deliveryAtLeastOnce)
+	var acks atomic.Pointer[ackOrder]
+	acks.Store(newAckOrder())
 
-	// Define topics to subscribe to
-	topics := []string{"factory/machine/+/status", "factory/machine/+/production"}
+	// Define topics to subscribe to. Each prefix covers one topic tree; the
+	// default matches single-site simulators ("factory/machine/...") and
+	// multi-site ones ("factory/<site>/machine/...").
+	var topics []string
+	for _, prefix := range config.TopicPrefixes {
+		for _, kind := range []string{events.KindStatus, events.KindProduction, events.KindLifecycle, events.KindOperator, events.KindInspection} {
+			topics = append(topics, sharedTopic(events.Wildcard(prefix, kind)))
+		}
+	}
+	if config.SharedGroup != "" {
+		log.Printf("Sharing subscriptions with group %q", config.SharedGroup)
+	}
+
+	// Received messages wait in a bounded buffer for the workers that
+	// store them. At-least-once delivery waits for room when it is full, so
+	// the broker holds on to the backlog; at-most-once drops the message.
+	buffer := newInbox(config.BufferSize, config.Workers)
+	registerBufferMetrics(buffer)
+	var client mqtt.Client
+	buffer.start(func(in inbound) {
+		// While the database is down the message waits, unacked, rather
+		// than failing its inserts
+		if config.Delivery.Mode == deliveryAtLeastOnce {
+			dbState.wait()
+		}
+		m := in.msg
+		err := handleMessage(in.ctx, db, m.Topic(), m.Payload())
+		endSpan(in.span, err)
+		if err != nil {
+			reportIngestError(db, client, config.ErrorsTopic, m.Topic(), m.Payload(), err)
+		}
+		in.ack()
+	})
+	log.Printf("Buffering up to %d messages for %d worker(s)", buffer.capacity(), config.Workers)
+	retained := &retainedGate{grace: config.SkipRetainedOnStart}
+	receive := func(_ mqtt.Client, m mqtt.Message) {
+		in := inbound{msg: m}
+		if config.Delivery.Mode == deliveryAtLeastOnce {
+			in.acks = acks.Load()
+			in.ticket = in.acks.ticket()
+		}
+		if retained.skip(m) {
+			retainedSkipped.Inc()
+			in.ack()
+			return
+		}
+		ctx, span := startReceiveSpan(m.Topic(), m.Payload())
+		in.ctx, in.span = withReceipt(ctx, receipt{at: time.Now(), retained: m.Retained()}), span
+		if !buffer.put(in, config.Delivery.Mode == deliveryAtLeastOnce) {
+			bufferDropped.Inc()
+			endSpan(in.span, errBufferFull)
+		}
+	}
 
 	// On connect callback - resubscribe to topics
 	opts.OnConnect = func(c mqtt.Client) {
+		mqttmetrics.Connect()
 		log.Printf("Connected to MQTT broker at %s", mqttURL)
+		acks.Store(newAckOrder())
+		retained.connect()
+		if retained.grace > 0 {
+			log.Printf("Ignoring retained messages for %v", retained.grace)
+		}
 		for _, t := range topics {
-			if token := c.Subscribe(t, 1, func(client mqtt.Client, m mqtt.Message) {
-				handleMessage(db, m.Topic(), m.Payload())
-			}); token.Wait() && token.Error() != nil {
+			if token := c.Subscribe(t, config.Delivery.qos(), receive); token.Wait() && token.Error() != nil {
 				log.Printf("ERROR: failed to subscribe to %s: %v", t, token.Error())
 			} else {
 				log.Printf("Subscribed to topic: %s", t)
@@ -86,10 +252,11 @@ func main() {
 	}
 
 	opts.OnConnectionLost = func(c mqtt.Client, err error) {
+		mqttmetrics.ConnectionLost()
 		log.Printf("MQTT connection lost: %v", err)
 	}
 
-	client := mqtt.NewClient(opts)
+	client = mqtt.NewClient(opts)
 	if token := client.Connect(); token.Wait() && token.Error() != nil {
 		log.Fatalf("failed to connect to mqtt: %v", token.Error())
 	}
@@ -99,43 +266,228 @@ func main() {
 	select {}
 }
 
-func handleMessage(db *sql.DB, topic string, payload []byte) {
-	// topic examples: factory/machine/1/status
-	parts := strings.Split(topic, "/")
-	if len(parts) < 4 {
-		log.Printf("unknown topic format: %s", topic)
-		return
+// sharedTopic returns the filter to subscribe to for topic: topic itself,
+// or its shared subscription with MQTT_SHARED_GROUP set. Shared
+// subscriptions are an MQTT 5 feature, but the paho client speaks 3.1.1;
+// brokers such as Mosquitto, EMQX and HiveMQ accept $share from 3.1.1
+// clients too, and paho strips the $share/<group>/ part when routing
+// messages to the handler, so this works without a v5 client.
+func sharedTopic(topic string) string {
+	if config.SharedGroup == "" {
+		return topic
+	}
+	return "$share/" + config.SharedGroup + "/" + topic
+}
+
+// validator flags suspect status transitions; nil when STATE_VALIDATION is off.
+var validator *transitionValidator
+
+// recordPlannedStop turns a planned stop into a planned downtime window, so
+// the API excludes it from availability like a scheduled one. Redelivered
+// events find the window already there.
+func recordPlannedStop(ctx context.Context, machine machineid.Key, e events.StatusEvent) error {
+	if e.PlannedUntil == nil {
+		return nil
+	}
+	if !e.PlannedUntil.After(e.Timestamp) {
+		return &stageError{stageParse, fmt.Errorf("planned_until %s is not after timestamp %s",
+			e.PlannedUntil.Format(time.RFC3339), e.Timestamp.Format(time.RFC3339))}
+	}
+	r := record{
+		table:   "planned_downtime",
+		columns: []string{"site", "machine_id", "start_time", "end_time", "reason"},
+		values:  []any{machine.Site, machine.ID, e.Timestamp, *e.PlannedUntil, e.Reason},
+		query: `INSERT INTO planned_downtime (site, machine_id, start_time, end_time, reason)
+			SELECT $1, $2, $3, $4, $5
+			WHERE NOT EXISTS (SELECT 1 FROM planned_downtime
+				WHERE site = $1 AND machine_id = $2 AND start_time = $3 AND end_time = $4 AND reason = $5)`,
+	}
+	if err := storeEvent(ctx, machine, r); err != nil {
+		return &stageError{stageInsert, fmt.Errorf("failed to insert planned downtime: %w", err)}
+	}
+	return nil
+}
+
+// handleMessage parses a message and inserts it into the matching table.
+// Errors are wrapped in a stageError so they can be reported by stage.
+func handleMessage(ctx context.Context, db *sql.DB, topic string, payload []byte) error {
+	// topic examples: factory/machine/1/status, factory/plant-a/machine/1/status
+	t, err := events.ParseTopic(topic)
+	if err != nil {
+		return &stageError{stageTopic, err}
+	}
+	typ := t.Kind
+	if err := validateSchema(ctx, typ, payload); err != nil {
+		return &stageError{stageSchema, err}
 	}
-	machineID := 0
-	fmt.Sscanf(parts[2], "%d", &machineID)
-	typ := parts[3]
 
 	switch typ {
-	case "status":
-		var e StatusEvent
-		if err := json.Unmarshal(payload, &e); err != nil {
-			log.Printf("failed to unmarshal status: %v", err)
-			return
+	case events.KindStatus:
+		var e events.StatusEvent
+		if err := parseEvent(ctx, typ, payload, &e); err != nil {
+			return &stageError{stageParse, fmt.Errorf("failed to unmarshal status: %w", err)}
 		}
-		if e.Timestamp.IsZero() {
-			e.Timestamp = time.Now().UTC()
+		machine := machineKey(t, e.Site, e.MachineID)
+		sequences.observe(ctx, db, machine, e.Seq, false)
+		if e.Timestamp, err = eventTime(ctx, e.Timestamp); err != nil {
+			return err
 		}
-		if _, err := db.Exec(`INSERT INTO status_events (time, machine_id, status) VALUES ($1,$2,$3)`, e.Timestamp, e.MachineID, e.Status); err != nil {
-			log.Printf("failed to insert status event: %v", err)
+		if compactor != nil {
+			if err := touchLastSeen(ctx, machine, e.Timestamp); err != nil {
+				return err
+			}
+			if compactor.repeat(db, machine, storedStatus{e.Status, e.Reason, e.Timestamp}) {
+				statusCompacted.Inc()
+				return nil
+			}
 		}
-	case "production":
-		var e ProductionEvent
-		if err := json.Unmarshal(payload, &e); err != nil {
-			log.Printf("failed to unmarshal production: %v", err)
-			return
+		suspect := false
+		if validator != nil {
+			suspect = validator.check(db, machine, e.Status)
+		}
+		r := record{
+			table:   "status_events",
+			columns: []string{"time", "site", "machine_id", "status", "reason", "suspect"},
+			values:  []any{e.Timestamp, machine.Site, machine.ID, e.Status, e.Reason, suspect},
+		}
+		if err := storeEvent(ctx, machine, r.withRawPayload(payload)); err != nil {
+			return &stageError{stageInsert, fmt.Errorf("failed to insert status event: %w", err)}
+		}
+		if compactor != nil {
+			compactor.record(machine, storedStatus{e.Status, e.Reason, e.Timestamp})
+		}
+		if err := recordPlannedStop(ctx, machine, e); err != nil {
+			return err
+		}
+		observeLatency(ctx, machine, e.PublishedAt)
+		logStored(typ, machine, e.TraceID)
+	case events.KindProduction:
+		var e events.ProductionEvent
+		if err := parseEvent(ctx, typ, payload, &e); err != nil {
+			return &stageError{stageParse, fmt.Errorf("failed to unmarshal production: %w", err)}
+		}
+		machine := machineKey(t, e.Site, e.MachineID)
+		sequences.observe(ctx, db, machine, e.Seq, false)
+		if e.Timestamp, err = eventTime(ctx, e.Timestamp); err != nil {
+			return err
+		}
+		// A counter's reading stands for every part since the last one, so
+		// events carrying one are never sampled out
+		var reading *counterReading
+		if e.PartsTotal != nil {
+			reading = &counterReading{*e.PartsTotal, e.Timestamp}
+			if e.PartsProduced, err = totalizer.increment(db, machine, *reading); err != nil {
+				return err
+			}
+		} else if !sampler.keep(machine) {
+			productionSampledOut.Inc()
+			return nil
+		}
+		// A producer that doesn't count cycles leaves the cycle NULL, a
+		// part made at full speed the planned slowdown, and one without a
+		// counter the parts total
+		var cycle, plannedSlowdown, partsTotal any
+		if e.Cycle > 0 {
+			cycle = int64(e.Cycle)
+		}
+		if e.PlannedSlowdown > 0 {
+			plannedSlowdown = e.PlannedSlowdown
+		}
+		weight := sampler.rate
+		if reading != nil {
+			partsTotal, weight = int64(reading.value), 1
+		}
+		r := record{
+			table:   "production_events",
+			columns: []string{"time", "site", "machine_id", "parts_produced", "parts_scrapped", "parts_reworked", "lot_id", "product", "sample_weight", "cycle", "warmup", "planned_slowdown", "parts_total"},
+			values:  []any{e.Timestamp, machine.Site, machine.ID, e.PartsProduced, e.PartsScrapped, e.PartsReworked, e.LotID, e.Product, weight, cycle, e.Warmup, plannedSlowdown, partsTotal},
+		}
+		if err := storeEvent(ctx, machine, r.withRawPayload(payload)); err != nil {
+			return &stageError{stageInsert, fmt.Errorf("failed to insert production event: %w", err)}
+		}
+		if reading != nil {
+			totalizer.record(machine, *reading)
+		}
+		if m := e.Measurement; m != nil {
+			r := record{
+				table:   "part_measurements",
+				columns: []string{"time", "site", "machine_id", "characteristic", "unit", "value", "lower_spec", "upper_spec", "out_of_spec"},
+				values:  []any{e.Timestamp, machine.Site, machine.ID, m.Characteristic, m.Unit, m.Value, m.LowerSpec, m.UpperSpec, m.OutOfSpec()},
+			}
+			if err := storeEvent(ctx, machine, r); err != nil {
+				return &stageError{stageInsert, fmt.Errorf("failed to insert part measurement: %w", err)}
+			}
+		}
+		if e.Serial != "" {
+			r := record{
+				table:   "part_serials",
+				columns: []string{"time", "site", "machine_id", "serial", "lot_id", "product"},
+				values:  []any{e.Timestamp, machine.Site, machine.ID, e.Serial, e.LotID, e.Product},
+			}
+			if err := storeEvent(ctx, machine, r); err != nil {
+				return &stageError{stageInsert, fmt.Errorf("failed to insert part serial: %w", err)}
+			}
+		}
+		observeLatency(ctx, machine, e.PublishedAt)
+		logStored(typ, machine, e.TraceID)
+	case events.KindLifecycle:
+		var e events.LifecycleEvent
+		if err := parseEvent(ctx, typ, payload, &e); err != nil {
+			return &stageError{stageParse, fmt.Errorf("failed to unmarshal lifecycle: %w", err)}
+		}
+		machine := machineKey(t, e.Site, e.MachineID)
+		sequences.observe(ctx, db, machine, e.Seq, e.State == events.LifecycleBirth)
+		if err := registerLifecycle(ctx, machine, e); err != nil {
+			return err
+		}
+		logStored(typ, machine, e.TraceID)
+	case events.KindOperator:
+		var e events.OperatorEvent
+		if err := parseEvent(ctx, typ, payload, &e); err != nil {
+			return &stageError{stageParse, fmt.Errorf("failed to unmarshal operator: %w", err)}
+		}
+		machine := machineKey(t, e.Site, e.MachineID)
+		if e.OperatorID == "" {
+			return &stageError{stageParse, fmt.Errorf("operator event has no operator_id")}
+		}
+		if e.Timestamp, err = eventTime(ctx, e.Timestamp); err != nil {
+			return err
+		}
+		r := record{
+			table:   "operator_events",
+			columns: []string{"time", "site", "machine_id", "operator_id", "shift"},
+			values:  []any{e.Timestamp, machine.Site, machine.ID, e.OperatorID, e.Shift},
+		}
+		if err := storeEvent(ctx, machine, r.withRawPayload(payload)); err != nil {
+			return &stageError{stageInsert, fmt.Errorf("failed to insert operator event: %w", err)}
+		}
+		logStored(typ, machine, e.TraceID)
+	case events.KindInspection:
+		var e events.InspectionEvent
+		if err := parseEvent(ctx, typ, payload, &e); err != nil {
+			return &stageError{stageParse, fmt.Errorf("failed to unmarshal inspection: %w", err)}
+		}
+		machine := machineKey(t, e.Site, e.MachineID)
+		if e.Result != events.InspectionPass && e.Result != events.InspectionFail {
+			return &stageError{stageParse, fmt.Errorf("inspection result %q is not %s or %s", e.Result, events.InspectionPass, events.InspectionFail)}
+		}
+		if e.ProducedAt.IsZero() {
+			return &stageError{stageParse, fmt.Errorf("inspection has no produced_at")}
+		}
+		if e.Timestamp, err = eventTime(ctx, e.Timestamp); err != nil {
+			return err
 		}
-		if e.Timestamp.IsZero() {
-			e.Timestamp = time.Now().UTC()
+		r := record{
+			table:   "inspection_events",
+			columns: []string{"time", "site", "machine_id", "produced_at", "serial", "result", "defect_code"},
+			values:  []any{e.Timestamp, machine.Site, machine.ID, e.ProducedAt, e.Serial, e.Result, e.DefectCode},
 		}
-		if _, err := db.Exec(`INSERT INTO production_events (time, machine_id, parts_produced, parts_scrapped) VALUES ($1,$2,$3,$4)`, e.Timestamp, e.MachineID, e.PartsProduced, e.PartsScrapped); err != nil {
-			log.Printf("failed to insert production event: %v", err)
+		if err := storeEvent(ctx, machine, r.withRawPayload(payload)); err != nil {
+			return &stageError{stageInsert, fmt.Errorf("failed to insert inspection event: %w", err)}
 		}
+		logStored(typ, machine, e.TraceID)
 	default:
-		log.Printf("unhandled topic type: %s", typ)
+		return &stageError{stageTopic, fmt.Errorf("unhandled topic type: %s", typ)}
 	}
+	return nil
 }
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// setupTest points the service at a fresh in-memory SQLite database, with
+// the configuration loadConfig reads from the defaults and env, and resets
+// the per-machine state handleMessage keeps. Everything is restored when
+// the test ends.
+func setupTest(t *testing.T, env map[string]string) *sql.DB {
+	t.Helper()
+	t.Setenv("DB_DRIVER", driverSQLite)
+	t.Setenv("SQLITE_PATH", ":memory:")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	db, err := openDB(cfg)
+	if err != nil {
+		t.Fatalf("openDB: %v", err)
+	}
+
+	savedConfig, savedSinks, savedSampler, savedTotalizer := config, sinks, sampler, totalizer
+	savedSequences, savedCompactor, savedValidator, savedStager := sequences, compactor, validator, stager
+	t.Cleanup(func() {
+		db.Close()
+		config, sinks, sampler, totalizer = savedConfig, savedSinks, savedSampler, savedTotalizer
+		sequences, compactor, validator, stager = savedSequences, savedCompactor, savedValidator, savedStager
+	})
+	config = cfg
+	sinks = MultiSink{dbSink{name: sinkDB, db: db}}
+	sampler = newProductionSampler(cfg.ProductionSampleRate)
+	totalizer = newPartsTotalizer()
+	sequences, compactor, validator, stager = nil, nil, nil, nil
+	return db
+}
+
+// stageOf returns the stage err failed at, or "" if it has none.
+func stageOf(err error) string {
+	var se *stageError
+	if errors.As(err, &se) {
+		return se.stage
+	}
+	return ""
+}
+
+// count returns the rows query counts.
+func count(t *testing.T, db *sql.DB, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
+
+var testTime = time.Date(2025, 11, 5, 10, 0, 0, 0, time.UTC)
+
+func TestHandleMessageStatus(t *testing.T) {
+	db := setupTest(t, nil)
+	payload := `{"machine_id": 1, "status": "stopped", "reason": "jam", "timestamp": "2025-11-05T10:00:00Z"}`
+	if err := handleMessage(context.Background(), db, "factory/machine/1/status", []byte(payload)); err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+
+	var site, status, reason string
+	var id int
+	var at time.Time
+	var suspect bool
+	err := db.QueryRow(`SELECT time, site, machine_id, status, reason, suspect FROM status_events`).
+		Scan(&at, &site, &id, &status, &reason, &suspect)
+	if err != nil {
+		t.Fatalf("read status event: %v", err)
+	}
+	if !at.Equal(testTime) || site != "" || id != 1 || status != "stopped" || reason != "jam" || suspect {
+		t.Fatalf("stored %v %q %d %q %q %v", at, site, id, status, reason, suspect)
+	}
+}
+
+func TestHandleMessagePlannedStop(t *testing.T) {
+	// Ignoring the duplicate status lets a redelivery reach its window,
+	// which mustn't be added twice
+	db := setupTest(t, map[string]string{"INGEST_DUPLICATES": duplicatesIgnore})
+	payload := `{"machine_id": 2, "status": "stopped", "reason": "maintenance", "planned_until": "2025-11-05T11:00:00Z", "timestamp": "2025-11-05T10:00:00Z"}`
+	for range 2 {
+		if err := handleMessage(context.Background(), db, "factory/machine/2/status", []byte(payload)); err != nil {
+			t.Fatalf("handleMessage: %v", err)
+		}
+	}
+	if n := count(t, db, `SELECT COUNT(*) FROM planned_downtime WHERE machine_id = 2 AND reason = 'maintenance'`); n != 1 {
+		t.Fatalf("%d planned downtime windows, want 1", n)
+	}
+
+	// The stop is stored even if its window is invalid
+	backwards := `{"machine_id": 3, "status": "stopped", "planned_until": "2025-11-05T09:00:00Z", "timestamp": "2025-11-05T10:00:00Z"}`
+	if err := handleMessage(context.Background(), db, "factory/machine/3/status", []byte(backwards)); stageOf(err) != stageParse {
+		t.Fatalf("planned_until before timestamp: %v, want a parse error", err)
+	}
+	if n := count(t, db, `SELECT COUNT(*) FROM planned_downtime WHERE machine_id = 3`); n != 0 {
+		t.Fatalf("%d planned downtime windows for an invalid one", n)
+	}
+}
+
+func TestHandleMessageProduction(t *testing.T) {
+	db := setupTest(t, nil)
+	payload := `{"machine_id": 3, "parts_produced": 1, "parts_scrapped": 0, "parts_reworked": 1,
+		"lot_id": "L1", "product": "widget-a", "cycle": 42, "serial": "SN-1",
+		"measurement": {"characteristic": "diameter", "unit": "mm", "value": 10.2, "lower_spec": 9.9, "upper_spec": 10.1},
+		"timestamp": "2025-11-05T10:00:00Z"}`
+	if err := handleMessage(context.Background(), db, "factory/machine/3/production", []byte(payload)); err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+
+	var produced, scrapped, reworked, weight int
+	var lot, product string
+	var cycle sql.NullInt64
+	err := db.QueryRow(`SELECT parts_produced, parts_scrapped, parts_reworked, lot_id, product, sample_weight, cycle
+		FROM production_events WHERE machine_id = 3`).
+		Scan(&produced, &scrapped, &reworked, &lot, &product, &weight, &cycle)
+	if err != nil {
+		t.Fatalf("read production event: %v", err)
+	}
+	if produced != 1 || scrapped != 0 || reworked != 1 || lot != "L1" || product != "widget-a" || weight != 1 || cycle.Int64 != 42 {
+		t.Fatalf("stored %d %d %d %q %q %d %v", produced, scrapped, reworked, lot, product, weight, cycle)
+	}
+
+	var value float64
+	var outOfSpec bool
+	if err := db.QueryRow(`SELECT value, out_of_spec FROM part_measurements WHERE machine_id = 3 AND characteristic = 'diameter'`).
+		Scan(&value, &outOfSpec); err != nil {
+		t.Fatalf("read measurement: %v", err)
+	}
+	if value != 10.2 || !outOfSpec {
+		t.Fatalf("measurement %v out of spec %v, want 10.2 out of spec", value, outOfSpec)
+	}
+	if n := count(t, db, `SELECT COUNT(*) FROM part_serials WHERE serial = 'SN-1' AND machine_id = 3`); n != 1 {
+		t.Fatalf("%d serials stored, want 1", n)
+	}
+}
+
+func TestHandleMessageLifecycle(t *testing.T) {
+	db := setupTest(t, nil)
+	ctx := context.Background()
+	birth := `{"machine_id": 4, "state": "birth", "ideal_cycle_time_sec": 2.5, "started_at": "2025-11-05T09:00:00Z", "timestamp": "2025-11-05T09:00:00Z"}`
+	if err := handleMessage(ctx, db, "factory/machine/4/lifecycle", []byte(birth)); err != nil {
+		t.Fatalf("birth: %v", err)
+	}
+	var name string
+	var ideal float64
+	var online bool
+	if err := db.QueryRow(`SELECT name, ideal_cycle_time_sec, online FROM machines WHERE site = '' AND id = 4`).
+		Scan(&name, &ideal, &online); err != nil {
+		t.Fatalf("read machine: %v", err)
+	}
+	if name != "Machine 4" || ideal != 2.5 || !online {
+		t.Fatalf("registered %q, %v, online %v", name, ideal, online)
+	}
+
+	death := `{"machine_id": 4, "state": "death", "started_at": "2025-11-05T09:00:00Z", "timestamp": "2025-11-05T12:00:00Z"}`
+	if err := handleMessage(ctx, db, "factory/machine/4/lifecycle", []byte(death)); err != nil {
+		t.Fatalf("death: %v", err)
+	}
+	if err := db.QueryRow(`SELECT online FROM machines WHERE site = '' AND id = 4`).Scan(&online); err != nil {
+		t.Fatalf("read machine: %v", err)
+	}
+	if online {
+		t.Fatal("machine still online after its death")
+	}
+}
+
+func TestHandleMessageOperator(t *testing.T) {
+	db := setupTest(t, nil)
+	payload := `{"machine_id": 5, "operator_id": "op-17", "shift": "A", "timestamp": "2025-11-05T10:00:00Z"}`
+	if err := handleMessage(context.Background(), db, "factory/machine/5/operator", []byte(payload)); err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	if n := count(t, db, `SELECT COUNT(*) FROM operator_events WHERE machine_id = 5 AND operator_id = 'op-17' AND shift = 'A'`); n != 1 {
+		t.Fatalf("%d operator events stored, want 1", n)
+	}
+}
+
+func TestHandleMessageInspection(t *testing.T) {
+	db := setupTest(t, nil)
+	payload := `{"machine_id": 6, "produced_at": "2025-11-05T09:59:00Z", "serial": "SN-9", "result": "fail", "defect_code": "D7", "timestamp": "2025-11-05T10:00:00Z"}`
+	if err := handleMessage(context.Background(), db, "factory/machine/6/inspection", []byte(payload)); err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	var result, defect string
+	var producedAt time.Time
+	if err := db.QueryRow(`SELECT produced_at, result, defect_code FROM inspection_events WHERE machine_id = 6 AND serial = 'SN-9'`).
+		Scan(&producedAt, &result, &defect); err != nil {
+		t.Fatalf("read inspection: %v", err)
+	}
+	if !producedAt.Equal(testTime.Add(-time.Minute)) || result != "fail" || defect != "D7" {
+		t.Fatalf("stored %v %q %q", producedAt, result, defect)
+	}
+}
+
+// Sites number their machines independently, so the same ID under two
+// site prefixes is two machines, stored under the ID they report.
+func TestHandleMessageSites(t *testing.T) {
+	db := setupTest(t, nil)
+	ctx := context.Background()
+	for _, topic := range []string{"factory/plant-a/machine/1/status", "factory/plant-b/machine/1/status", "factory/machine/1/status"} {
+		payload := `{"machine_id": 1, "status": "running", "timestamp": "2025-11-05T10:00:00Z"}`
+		if err := handleMessage(ctx, db, topic, []byte(payload)); err != nil {
+			t.Fatalf("%s: %v", topic, err)
+		}
+	}
+	// The payload's site wins over the topic
+	payload := `{"machine_id": 1, "site": "plant-c", "status": "running", "timestamp": "2025-11-05T10:00:00Z"}`
+	if err := handleMessage(ctx, db, "factory/plant-a/machine/1/status", []byte(payload)); err != nil {
+		t.Fatalf("site in payload: %v", err)
+	}
+
+	rows, err := db.Query(`SELECT site, machine_id FROM status_events ORDER BY site`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []machineid.Key
+	for rows.Next() {
+		var k machineid.Key
+		if err := rows.Scan(&k.Site, &k.ID); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, k)
+	}
+	want := []machineid.Key{{Site: "", ID: 1}, {Site: "plant-a", ID: 1}, {Site: "plant-b", ID: 1}, {Site: "plant-c", ID: 1}}
+	if len(got) != len(want) {
+		t.Fatalf("stored %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("stored %v, want %v", got, want)
+		}
+	}
+}
+
+func TestHandleMessageDuplicates(t *testing.T) {
+	first := `{"machine_id": 1, "status": "stopped", "reason": "jam", "timestamp": "2025-11-05T10:00:00Z"}`
+	again := `{"machine_id": 1, "status": "stopped", "reason": "breakdown", "timestamp": "2025-11-05T10:00:00Z"}`
+	tests := []struct {
+		duplicates string
+		wantStage  string
+		wantReason string
+	}{
+		{duplicatesReject, stageInsert, "jam"},
+		{duplicatesIgnore, "", "jam"},
+		{duplicatesUpsert, "", "breakdown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.duplicates, func(t *testing.T) {
+			db := setupTest(t, map[string]string{"INGEST_DUPLICATES": tt.duplicates})
+			ctx := context.Background()
+			if err := handleMessage(ctx, db, "factory/machine/1/status", []byte(first)); err != nil {
+				t.Fatalf("first: %v", err)
+			}
+			err := handleMessage(ctx, db, "factory/machine/1/status", []byte(again))
+			if stageOf(err) != tt.wantStage || (tt.wantStage == "") != (err == nil) {
+				t.Fatalf("duplicate: %v, want stage %q", err, tt.wantStage)
+			}
+			if n := count(t, db, `SELECT COUNT(*) FROM status_events`); n != 1 {
+				t.Fatalf("%d rows stored, want 1", n)
+			}
+			var reason string
+			if err := db.QueryRow(`SELECT reason FROM status_events`).Scan(&reason); err != nil {
+				t.Fatal(err)
+			}
+			if reason != tt.wantReason {
+				t.Fatalf("reason %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestHandleMessageErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		topic     string
+		payload   string
+		wantStage string
+	}{
+		{"malformed topic", "factory/status", `{}`, stageTopic},
+		{"unknown kind", "factory/machine/1/telemetry", `{}`, stageTopic},
+		{"invalid JSON", "factory/machine/1/status", `{"machine_id": 1,`, stageParse},
+		{"wrong type", "factory/machine/1/production", `{"machine_id": 1, "parts_produced": "one", "timestamp": "2025-11-05T10:00:00Z"}`, stageParse},
+		{"operator without ID", "factory/machine/1/operator", `{"machine_id": 1, "shift": "A", "timestamp": "2025-11-05T10:00:00Z"}`, stageParse},
+		{"inspection result", "factory/machine/1/inspection", `{"machine_id": 1, "produced_at": "2025-11-05T09:59:00Z", "result": "maybe", "timestamp": "2025-11-05T10:00:00Z"}`, stageParse},
+		{"inspection without produced_at", "factory/machine/1/inspection", `{"machine_id": 1, "result": "pass", "timestamp": "2025-11-05T10:00:00Z"}`, stageParse},
+		{"birth without cycle time", "factory/machine/1/lifecycle", `{"machine_id": 1, "state": "birth", "started_at": "2025-11-05T09:00:00Z"}`, stageParse},
+		{"unknown lifecycle state", "factory/machine/1/lifecycle", `{"machine_id": 1, "state": "zombie"}`, stageParse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTest(t, nil)
+			err := handleMessage(context.Background(), db, tt.topic, []byte(tt.payload))
+			if stageOf(err) != tt.wantStage {
+				t.Fatalf("error %v, want stage %q", err, tt.wantStage)
+			}
+			if n := count(t, db, `SELECT COUNT(*) FROM status_events`) + count(t, db, `SELECT COUNT(*) FROM production_events`); n != 0 {
+				t.Fatalf("%d events stored for a failed message", n)
+			}
+		})
+	}
+}
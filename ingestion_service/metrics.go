@@ -0,0 +1,182 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/buildinfo"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/configdump"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/httpauth"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/httpserve"
+)
+
+// Database health, from the background pings.
+var (
+	dbUp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "oee_ingest_db_up",
+		Help: "1 if the last ping of the database succeeded, 0 otherwise.",
+	})
+	dbPingFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "oee_ingest_db_ping_failures_total",
+		Help: "Background pings of the database that failed.",
+	})
+	dbCircuitOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "oee_ingest_db_circuit_open",
+		Help: "1 while the database has failed DB_UNHEALTHY_AFTER pings in a row and the ingestor reports not ready.",
+	})
+)
+
+// retainedSkipped counts messages ignored by SKIP_RETAINED_ON_START.
+var retainedSkipped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "oee_ingest_retained_skipped_total",
+	Help: "Retained messages ignored because they arrived within SKIP_RETAINED_ON_START of connecting.",
+})
+
+// chaosFaults counts the faults CHAOS_INSERT_* injected into inserts.
+var chaosFaults = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "oee_ingest_chaos_faults_total",
+	Help: "Faults injected into inserts for chaos testing, by fault (latency or error).",
+}, []string{"fault"})
+
+// suspectTransitions counts status events flagged by the transition validator.
+var suspectTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "oee_ingest_suspect_transitions_total",
+	Help: "Status events whose transition from the previous status is not allowed.",
+}, []string{"from", "to"})
+
+// unmarshalErrors counts payloads that failed to parse into their event.
+var unmarshalErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "oee_ingest_unmarshal_errors_total",
+	Help: "Payloads that could not be parsed into an event, by event type and reason (syntax, type or unknown_field).",
+}, []string{"type", "reason"})
+
+// schemaViolations counts payloads rejected by VALIDATE_SCHEMA.
+var schemaViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "oee_ingest_schema_violations_total",
+	Help: "Payloads that parse but violate their event's JSON Schema, by event type.",
+}, []string{"type"})
+
+// productionSampledOut counts production events dropped by PRODUCTION_SAMPLE_RATE.
+var productionSampledOut = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "oee_ingest_production_sampled_out_total",
+	Help: "Production events not stored because of PRODUCTION_SAMPLE_RATE.",
+})
+
+// Decimation of part measurements into part_measurements_rollup.
+var (
+	measurementsDecimated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "oee_ingest_measurements_decimated_total",
+		Help: "Part measurements rolled up into part_measurements_rollup and deleted.",
+	})
+	decimationFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "oee_ingest_decimation_failures_total",
+		Help: "Decimation runs that failed and were rolled back.",
+	})
+)
+
+// statusCompacted counts status events dropped by STATUS_COMPACT_WINDOW.
+var statusCompacted = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "oee_ingest_status_compacted_total",
+	Help: "Status events not stored because they repeat the machine's last one within STATUS_COMPACT_WINDOW.",
+})
+
+// Promotion of staged rows, by event table, with INGEST_STAGING.
+var (
+	stagingPromoted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oee_ingest_staging_promoted_total",
+		Help: "Staged rows moved into their event table, or to ingest_errors if it rejected them.",
+	}, []string{"table"})
+	stagingBacklog = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "oee_ingest_staging_backlog",
+		Help: "Rows waiting in a staging table after the last promotion pass.",
+	}, []string{"table"})
+	stagingPromoteErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "oee_ingest_staging_promote_errors_total",
+		Help: "Promotion passes that failed and were left to the next one.",
+	})
+)
+
+// Sequence number checks, by machine.
+var (
+	sequenceMissing = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oee_ingest_sequence_missing_total",
+		Help: "Events never received, going by the gaps in each machine's sequence numbers.",
+	}, []string{"site", "machine_id"})
+	sequenceLate = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oee_ingest_sequence_late_total",
+		Help: "Events that arrived after a later one from the same machine, or again.",
+	}, []string{"site", "machine_id"})
+)
+
+// Parts counters going down, by machine.
+var (
+	totalizerWraps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oee_ingest_totalizer_wraps_total",
+		Help: "Times a machine's parts_total counter wrapped after TOTALIZER_MAX.",
+	}, []string{"site", "machine_id"})
+	totalizerResets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oee_ingest_totalizer_resets_total",
+		Help: "Times a machine's parts_total counter went down other than by wrapping, as when its PLC restarts.",
+	}, []string{"site", "machine_id"})
+)
+
+// Writes to each sink in INGEST_SINKS, by sink name.
+var (
+	sinkWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oee_ingest_sink_writes_total",
+		Help: "Records written to each sink, including failed writes.",
+	}, []string{"sink"})
+	sinkErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oee_ingest_sink_errors_total",
+		Help: "Records each sink failed to write, after any retries.",
+	}, []string{"sink"})
+)
+
+// bufferDropped counts messages dropped because the inbound buffer was full.
+var bufferDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "oee_ingest_buffer_dropped_total",
+	Help: "Messages dropped because the inbound buffer was full (at-most-once delivery only).",
+})
+
+// registerBufferMetrics exports the depth and capacity of the inbound buffer.
+func registerBufferMetrics(b *inbox) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "oee_ingest_buffer_depth",
+		Help: "Messages received and waiting for a worker.",
+	}, func() float64 { return float64(b.depth()) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "oee_ingest_buffer_capacity",
+		Help: "Messages the inbound buffer can hold (INGEST_BUFFER_SIZE).",
+	}, func() float64 { return float64(b.capacity()) })
+}
+
+// serveHTTP exposes /metrics and /version on addr, plus /debug/config when
+// DEBUG_ENDPOINTS is set, all behind API_TOKEN, and an open /healthz and
+// /readyz. An empty addr disables the server.
+func serveHTTP(addr string) {
+	if addr == "" {
+		return
+	}
+	auth := httpauth.Require(config.APIToken)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", httpauth.Healthz)
+	mux.HandleFunc("/readyz", readyz)
+	mux.Handle("/metrics", auth(promhttp.Handler()))
+	mux.Handle("/version", auth(buildinfo.Handler("oee-ingestor", func() any { return config })))
+	if config.DebugEndpoints {
+		mux.Handle("/debug/config", auth(configdump.Handler(func() any { return config })))
+		log.Printf("Serving effective configuration on %s/debug/config", addr)
+	}
+	if err := httpserve.Start(addr, mux); err != nil {
+		if config.MetricsRequired {
+			log.Fatalf("failed to serve metrics on %s: %v", addr, err)
+		}
+		log.Printf("WARNING: not serving metrics, carrying on without them (set METRICS_REQUIRED=true to exit instead): %v", err)
+		return
+	}
+	log.Printf("Serving metrics on %s/metrics", addr)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Kinds of unmarshal failure, the reason label of
+// oee_ingest_unmarshal_errors_total.
+const (
+	// unmarshalSyntax is a payload that isn't a single JSON value, e.g. a
+	// truncated one.
+	unmarshalSyntax = "syntax"
+	// unmarshalType is a value of the wrong JSON type for its field, such
+	// as a string where a number belongs.
+	unmarshalType = "type"
+	// unmarshalUnknownField is a field the event doesn't have, an error
+	// only under INGEST_STRICT_PARSING.
+	unmarshalUnknownField = "unknown_field"
+)
+
+// errTrailingData is returned in strict mode for data after the event,
+// which json.Unmarshal rejects but a json.Decoder leaves unread.
+var errTrailingData = errors.New("unexpected data after the event")
+
+// decode unmarshals payload into v. Under INGEST_STRICT_PARSING fields v
+// doesn't have are errors too, so events from a newer or misconfigured
+// publisher are routed to ingest_errors rather than stored without what
+// this version can't read.
+func decode(payload []byte, v any) error {
+	if !config.StrictParsing {
+		return json.Unmarshal(payload, v)
+	}
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		return err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return errTrailingData
+	}
+	return nil
+}
+
+// unmarshalReason classifies an error from decode.
+func unmarshalReason(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr):
+		return unmarshalType
+	case strings.HasPrefix(err.Error(), "json: unknown field"):
+		// encoding/json has no error type for it
+		return unmarshalUnknownField
+	}
+	return unmarshalSyntax
+}
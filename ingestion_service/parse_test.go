@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/events"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// parseCases are, for each event type, a valid payload and one with a
+// field of the wrong JSON type.
+var parseCases = []struct {
+	kind, valid, mistyped string
+}{
+	{
+		events.KindStatus,
+		`{"machine_id": 1, "status": "running", "timestamp": "2025-11-05T10:00:00Z"}`,
+		`{"machine_id": 1, "status": 1, "timestamp": "2025-11-05T10:00:00Z"}`,
+	},
+	{
+		events.KindProduction,
+		`{"machine_id": 1, "parts_produced": 1, "parts_scrapped": 0, "timestamp": "2025-11-05T10:00:00Z"}`,
+		`{"machine_id": 1, "parts_produced": "1", "parts_scrapped": 0, "timestamp": "2025-11-05T10:00:00Z"}`,
+	},
+	{
+		events.KindLifecycle,
+		`{"machine_id": 1, "state": "birth", "ideal_cycle_time_sec": 2.5, "started_at": "2025-11-05T09:00:00Z", "timestamp": "2025-11-05T09:00:00Z"}`,
+		`{"machine_id": 1, "state": "birth", "ideal_cycle_time_sec": [2.5], "started_at": "2025-11-05T09:00:00Z", "timestamp": "2025-11-05T09:00:00Z"}`,
+	},
+	{
+		events.KindOperator,
+		`{"machine_id": 1, "operator_id": "op-17", "shift": "A", "timestamp": "2025-11-05T10:00:00Z"}`,
+		`{"machine_id": "1", "operator_id": "op-17", "shift": "A", "timestamp": "2025-11-05T10:00:00Z"}`,
+	},
+	{
+		events.KindInspection,
+		`{"machine_id": 1, "produced_at": "2025-11-05T09:59:00Z", "result": "pass", "timestamp": "2025-11-05T10:00:00Z"}`,
+		`{"machine_id": 1, "produced_at": "2025-11-05T09:59:00Z", "result": true, "timestamp": "2025-11-05T10:00:00Z"}`,
+	},
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		mode := "lenient"
+		if strict {
+			mode = "strict"
+		}
+		for _, pc := range parseCases {
+			// A field the event doesn't have is only an error when strict
+			withField := strings.TrimSuffix(pc.valid, "}") + `, "firmware": "2.1"}`
+			unknownField := ""
+			if strict {
+				unknownField = unmarshalUnknownField
+			}
+			tests := []struct {
+				name    string
+				payload string
+				// reason is the unmarshal error counted, or "" if the
+				// event is stored
+				reason string
+			}{
+				{"valid", pc.valid, ""},
+				{"truncated", pc.valid[:len(pc.valid)/2], unmarshalSyntax},
+				{"empty", "", unmarshalSyntax},
+				{"not an object", `[1, 2]`, unmarshalType},
+				{"mistyped field", pc.mistyped, unmarshalType},
+				{"unknown field", withField, unknownField},
+				{"trailing data", pc.valid + ` {}`, unmarshalSyntax},
+			}
+			for _, tt := range tests {
+				t.Run(mode+"/"+pc.kind+"/"+tt.name, func(t *testing.T) {
+					db := setupTest(t, map[string]string{"INGEST_STRICT_PARSING": strconv.FormatBool(strict)})
+					counters := map[string]float64{}
+					for _, reason := range []string{unmarshalSyntax, unmarshalType, unmarshalUnknownField} {
+						counters[reason] = testutil.ToFloat64(unmarshalErrors.WithLabelValues(pc.kind, reason))
+					}
+
+					err := handleMessage(context.Background(), db, "factory/machine/1/"+pc.kind, []byte(tt.payload))
+					if tt.reason == "" && err != nil {
+						t.Fatalf("handleMessage: %v", err)
+					}
+					if tt.reason != "" && stageOf(err) != stageParse {
+						t.Fatalf("handleMessage = %v, want a %s error", err, stageParse)
+					}
+					for reason, before := range counters {
+						want := 0.0
+						if reason == tt.reason {
+							want = 1
+						}
+						if got := testutil.ToFloat64(unmarshalErrors.WithLabelValues(pc.kind, reason)) - before; got != want {
+							t.Errorf("%s %s errors counted %v, want %v", pc.kind, reason, got, want)
+						}
+					}
+				})
+			}
+		}
+	}
+}
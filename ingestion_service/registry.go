@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/events"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// registerLifecycle keeps the machines table in step with the lifecycle
+// messages of machine. A birth adds the machine if it is new, named
+// "Machine <id>", and otherwise refreshes its ideal cycle time and start
+// time without touching a name set by hand. A death marks the machine
+// offline unless a newer birth has already been recorded, so a stale
+// retained death from an earlier run can't take a live machine offline.
+func registerLifecycle(ctx context.Context, machine machineid.Key, e events.LifecycleEvent) error {
+	switch e.State {
+	case events.LifecycleBirth:
+		if e.IdealCycleTimeSec <= 0 || e.StartedAt.IsZero() {
+			return &stageError{stageParse, fmt.Errorf("birth for machine %s needs ideal_cycle_time_sec and started_at", machine)}
+		}
+		r := record{
+			table:   "machines",
+			columns: []string{"site", "id", "name", "ideal_cycle_time_sec", "started_at", "online"},
+			values:  []any{machine.Site, machine.ID, fmt.Sprintf("Machine %d", machine.ID), e.IdealCycleTimeSec, e.StartedAt, true},
+			query: `INSERT INTO machines (site, id, name, ideal_cycle_time_sec, started_at, online)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				ON CONFLICT (site, id) DO UPDATE SET
+					ideal_cycle_time_sec = EXCLUDED.ideal_cycle_time_sec,
+					started_at = EXCLUDED.started_at,
+					online = EXCLUDED.online`,
+		}
+		if err := storeEvent(ctx, machine, r); err != nil {
+			return &stageError{stageInsert, fmt.Errorf("failed to register machine: %w", err)}
+		}
+	case events.LifecycleDeath:
+		r := record{
+			table:   "machines",
+			columns: []string{"site", "id", "started_at", "online"},
+			values:  []any{machine.Site, machine.ID, e.StartedAt, false},
+			query:   `UPDATE machines SET online = $4 WHERE site = $1 AND id = $2 AND (started_at IS NULL OR started_at <= $3)`,
+		}
+		if err := storeEvent(ctx, machine, r); err != nil {
+			return &stageError{stageInsert, fmt.Errorf("failed to mark machine offline: %w", err)}
+		}
+	default:
+		return &stageError{stageParse, fmt.Errorf("unknown lifecycle state %q", e.State)}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// rehydrateState seeds the per-machine state the ingestor keeps in memory,
+// the last sequence number, the last status and the last parts_total
+// reading, from the database at startup. Without it each machine's state is loaded on its first event,
+// one query at a time while the broker's backlog floods in. Machines
+// registered later, such as by another ingestor, are still loaded on first
+// sight. The production sampler's count isn't rebuilt, since the events it
+// skipped aren't stored: each machine's first event after a restart is kept.
+func rehydrateState(db *sql.DB) error {
+	if sequences == nil && validator == nil && compactor == nil && totalizer == nil {
+		return nil
+	}
+	start := time.Now()
+	rows, err := db.Query(`SELECT site, id, last_seq FROM machines`)
+	if err != nil {
+		return fmt.Errorf("load machines: %w", err)
+	}
+	defer rows.Close()
+	last := map[machineid.Key]uint64{}
+	for rows.Next() {
+		var m machineid.Key
+		var seq uint64
+		if err := rows.Scan(&m.Site, &m.ID, &seq); err != nil {
+			return fmt.Errorf("load machines: %w", err)
+		}
+		last[m] = seq
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("load machines: %w", err)
+	}
+
+	if sequences != nil {
+		sequences.mu.Lock()
+		for m, seq := range last {
+			sequences.last[m] = seq
+		}
+		sequences.mu.Unlock()
+	}
+	if validator != nil {
+		validator.mu.Lock()
+		defer validator.mu.Unlock()
+		for m := range last {
+			status, err := validator.stored(db, m)
+			if err != nil {
+				return fmt.Errorf("load last status of machine %s: %w", m, err)
+			}
+			validator.last[m] = status
+		}
+	}
+	if compactor != nil {
+		compactor.mu.Lock()
+		defer compactor.mu.Unlock()
+		for m := range last {
+			s, err := compactor.load(db, m)
+			if err != nil {
+				return fmt.Errorf("load last status event of machine %s: %w", m, err)
+			}
+			compactor.last[m] = s
+		}
+	}
+	if totalizer != nil {
+		totalizer.mu.Lock()
+		defer totalizer.mu.Unlock()
+		for m := range last {
+			r, err := totalizer.load(db, m)
+			if err != nil {
+				return fmt.Errorf("load last parts_total of machine %s: %w", m, err)
+			}
+			totalizer.last[m] = r
+		}
+	}
+	log.Printf("Rehydrated the state of %d machine(s) from the database in %v", len(last), time.Since(start).Round(time.Millisecond))
+	return nil
+}
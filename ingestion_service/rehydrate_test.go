@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// seedMachines registers three machines, two sharing an ID across sites,
+// with a saved sequence number, status events and production events, the
+// last of them out of insertion order. Only plant-a reports a parts
+// counter, and machine 3 has never reported a status.
+func seedMachines(t *testing.T, db *sql.DB) {
+	t.Helper()
+	stmts := []struct {
+		query string
+		args  []any
+	}{
+		{`INSERT INTO machines (site, id, name, ideal_cycle_time_sec, last_seq) VALUES ('plant-a', 1, 'Press', 2, 41)`, nil},
+		{`INSERT INTO machines (site, id, name, ideal_cycle_time_sec, last_seq) VALUES ('plant-b', 1, 'Lathe', 3, 7)`, nil},
+		{`INSERT INTO machines (site, id, name, ideal_cycle_time_sec) VALUES ('', 3, 'Mill', 4)`, nil},
+		{`INSERT INTO status_events (time, site, machine_id, status, reason) VALUES ($1, 'plant-a', 1, 'stopped', 'jam')`, []any{testTime.Add(time.Minute)}},
+		{`INSERT INTO status_events (time, site, machine_id, status, reason) VALUES ($1, 'plant-a', 1, 'running', '')`, []any{testTime}},
+		{`INSERT INTO status_events (time, site, machine_id, status, reason) VALUES ($1, 'plant-b', 1, 'running', '')`, []any{testTime}},
+		{`INSERT INTO production_events (time, site, machine_id, parts_produced, parts_scrapped, parts_total) VALUES ($1, 'plant-a', 1, 3, 0, 120)`, []any{testTime.Add(time.Minute)}},
+		{`INSERT INTO production_events (time, site, machine_id, parts_produced, parts_scrapped, parts_total) VALUES ($1, 'plant-a', 1, 0, 0, 117)`, []any{testTime}},
+		{`INSERT INTO production_events (time, site, machine_id, parts_produced, parts_scrapped) VALUES ($1, 'plant-b', 1, 1, 0)`, []any{testTime}},
+	}
+	for _, s := range stmts {
+		if _, err := db.Exec(s.query, s.args...); err != nil {
+			t.Fatalf("%s: %v", s.query, err)
+		}
+	}
+}
+
+func TestRehydrateState(t *testing.T) {
+	db := setupTest(t, nil)
+	seedMachines(t, db)
+	sequences = newSequenceTracker()
+	validator = newTransitionValidator(map[string]map[string]bool{"running": {"stopped": true}, "stopped": {"running": true}})
+	compactor = newStatusCompactor(time.Minute)
+
+	if err := rehydrateState(db); err != nil {
+		t.Fatalf("rehydrateState: %v", err)
+	}
+
+	plantA, plantB, mill := machineid.Key{Site: "plant-a", ID: 1}, machineid.Key{Site: "plant-b", ID: 1}, machineid.Key{ID: 3}
+	wantSeq := map[machineid.Key]uint64{plantA: 41, plantB: 7, mill: 0}
+	wantStatus := map[machineid.Key]string{plantA: "stopped", plantB: "running", mill: ""}
+	wantLast := map[machineid.Key]storedStatus{
+		plantA: {"stopped", "jam", testTime.Add(time.Minute)},
+		plantB: {"running", "", testTime},
+		mill:   {},
+	}
+	wantTotal := map[machineid.Key]counterReading{plantA: {120, testTime.Add(time.Minute)}, plantB: {}, mill: {}}
+	if len(sequences.last) != len(wantSeq) {
+		t.Fatalf("sequences = %v, want %v", sequences.last, wantSeq)
+	}
+	for m, seq := range wantSeq {
+		if got, ok := sequences.last[m]; !ok || got != seq {
+			t.Errorf("last seq of %s = %d (loaded %v), want %d", m, got, ok, seq)
+		}
+		if got, ok := validator.last[m]; !ok || got != wantStatus[m] {
+			t.Errorf("last status of %s = %q (loaded %v), want %q", m, got, ok, wantStatus[m])
+		}
+		got, ok := compactor.last[m]
+		if !ok || got.status != wantLast[m].status || got.reason != wantLast[m].reason || !got.time.Equal(wantLast[m].time) {
+			t.Errorf("last status event of %s = %+v (loaded %v), want %+v", m, got, ok, wantLast[m])
+		}
+		if got, ok := totalizer.last[m]; !ok || got.value != wantTotal[m].value || !got.time.Equal(wantTotal[m].time) {
+			t.Errorf("last parts_total of %s = %+v (loaded %v), want %+v", m, got, ok, wantTotal[m])
+		}
+	}
+
+	// The rehydrated state answers without the database
+	db.Close()
+	if !validator.check(db, plantA, "stopped") {
+		t.Error("stopped after the rehydrated stopped was not suspect")
+	}
+	if validator.check(db, plantB, "stopped") {
+		t.Error("stopped after the rehydrated running was suspect")
+	}
+	if !compactor.repeat(db, plantA, storedStatus{"stopped", "jam", testTime.Add(90 * time.Second)}) {
+		t.Error("repeat of the rehydrated status event was not compacted")
+	}
+	if n, err := totalizer.increment(db, plantA, counterReading{125, testTime.Add(2 * time.Minute)}); err != nil || n != 5 {
+		t.Errorf("parts_total 125 after the rehydrated 120 counted %d, %v, want 5", n, err)
+	}
+	if !sequences.advance(db, plantA, 43, false) || sequences.last[plantA] != 43 {
+		t.Errorf("seq 43 after the rehydrated 41 was not taken, last = %d", sequences.last[plantA])
+	}
+	if sequences.advance(db, plantB, 7, false) {
+		t.Error("seq 7 at the rehydrated 7 was not late")
+	}
+}
+
+// With no per-machine state to seed the database isn't queried at all.
+func TestRehydrateStateNothingToSeed(t *testing.T) {
+	db := setupTest(t, nil)
+	totalizer = nil
+	db.Close()
+	if err := rehydrateState(db); err != nil {
+		t.Fatalf("rehydrateState: %v", err)
+	}
+}
+
+// A restarted ingestor counts the gap between the sequence number saved
+// before it stopped and the first one it receives.
+func TestRehydrateStateThenHandleMessage(t *testing.T) {
+	db := setupTest(t, nil)
+	seedMachines(t, db)
+	sequences = newSequenceTracker()
+	if err := rehydrateState(db); err != nil {
+		t.Fatalf("rehydrateState: %v", err)
+	}
+
+	missing := sequenceMissing.WithLabelValues("plant-a", "1")
+	before := testutil.ToFloat64(missing)
+	payload := `{"machine_id": 1, "site": "plant-a", "status": "running", "seq": 45, "timestamp": "2025-11-05T10:05:00Z"}`
+	if err := handleMessage(context.Background(), db, "factory/plant-a/machine/1/status", []byte(payload)); err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	if n := testutil.ToFloat64(missing) - before; n != 3 {
+		t.Fatalf("counted %v missing events, want 3", n)
+	}
+	if n := count(t, db, `SELECT last_seq FROM machines WHERE site = 'plant-a' AND id = 1`); n != 41 {
+		t.Fatalf("last_seq = %d before the save, want 41", n)
+	}
+	if err := sequences.save(context.Background()); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if n := count(t, db, `SELECT last_seq FROM machines WHERE site = 'plant-a' AND id = 1`); n != 45 {
+		t.Fatalf("saved last_seq = %d, want 45", n)
+	}
+	if got := sequences.last[machineid.Key{Site: "plant-a", ID: 1}]; got != 45 {
+		t.Fatalf("last seq = %d, want 45", got)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// retainedGate ignores the retained messages the broker replays when the
+// ingestor subscribes, for SKIP_RETAINED_ON_START after each connect. The
+// replay is the last message of every topic at once, which can be old and
+// already stored; only messages published from then on are ingested.
+type retainedGate struct {
+	grace time.Duration
+	// connected is when the client last connected, in Unix nanoseconds.
+	connected atomic.Int64
+}
+
+// connect starts the grace period.
+func (g *retainedGate) connect() {
+	g.connected.Store(time.Now().UnixNano())
+}
+
+// skip reports whether m is a retained message within the grace period.
+func (g *retainedGate) skip(m mqtt.Message) bool {
+	if g.grace <= 0 || !m.Retained() {
+		return false
+	}
+	return time.Since(time.Unix(0, g.connected.Load())) < g.grace
+}
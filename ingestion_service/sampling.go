@@ -0,0 +1,37 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// sampler thins out production events when PRODUCTION_SAMPLE_RATE > 1.
+var sampler = newProductionSampler(1)
+
+// productionSampler keeps one production event in every rate per machine.
+// Each kept event is stored with sample_weight = rate, so summing
+// parts * sample_weight estimates the true counts.
+type productionSampler struct {
+	rate int
+
+	mu   sync.Mutex
+	seen map[machineid.Key]int
+}
+
+func newProductionSampler(rate int) *productionSampler {
+	return &productionSampler{rate: rate, seen: make(map[machineid.Key]int)}
+}
+
+// keep reports whether the next production event from machine should be
+// stored. The first event of each machine is kept, then every rate-th.
+func (s *productionSampler) keep(machine machineid.Key) bool {
+	if s.rate <= 1 {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.seen[machine]
+	s.seen[machine] = (n + 1) % s.rate
+	return n == 0
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+func TestSamplerKeep(t *testing.T) {
+	for _, rate := range []int{0, 1, 2, 5} {
+		s := newProductionSampler(rate)
+		a, b := machineid.Key{Site: "plant-a", ID: 1}, machineid.Key{Site: "plant-b", ID: 1}
+		var keptA, keptB []int
+		for i := range 10 {
+			if s.keep(a) {
+				keptA = append(keptA, i)
+			}
+			// Machine b sends half as often, and is counted on its own
+			if i%2 == 0 && s.keep(b) {
+				keptB = append(keptB, i/2)
+			}
+		}
+		step := max(rate, 1)
+		for j, i := range keptA {
+			if i != j*step {
+				t.Fatalf("rate %d: machine a kept events %v, want every %d from the first", rate, keptA, step)
+			}
+		}
+		if len(keptA) != (10+step-1)/step {
+			t.Fatalf("rate %d: machine a kept events %v", rate, keptA)
+		}
+		for j, i := range keptB {
+			if i != j*step {
+				t.Fatalf("rate %d: machine b kept events %v, want every %d from the first", rate, keptB, step)
+			}
+		}
+	}
+}
+
+// Summing parts * sample_weight over the events kept gives what storing
+// every event would, for machines sending a whole number of sampling
+// periods of equal events.
+func TestSampledTotalsMatchUnsampled(t *testing.T) {
+	machines := []struct {
+		topic  string
+		id     int
+		events int
+	}{
+		{"factory/plant-a/machine/1/production", 1, 60},
+		{"factory/plant-b/machine/1/production", 1, 60},
+		{"factory/machine/2/production", 2, 120},
+	}
+	totals := func(t *testing.T, rate int) map[string][3]int {
+		db := setupTest(t, map[string]string{"PRODUCTION_SAMPLE_RATE": strconv.Itoa(rate)})
+		// The machines' events interleave, as they arrive
+		for i := range 120 {
+			for _, m := range machines {
+				if i >= m.events {
+					continue
+				}
+				payload := fmt.Sprintf(`{"machine_id": %d, "parts_produced": 2, "parts_scrapped": 1, "parts_reworked": 1, "timestamp": %q}`,
+					m.id, testTime.Add(time.Duration(i)*time.Second).Format(time.RFC3339))
+				if err := handleMessage(context.Background(), db, m.topic, []byte(payload)); err != nil {
+					t.Fatalf("event %d of %s: %v", i, m.topic, err)
+				}
+			}
+		}
+		rows, err := db.Query(`SELECT site, machine_id, SUM(parts_produced * sample_weight), SUM(parts_scrapped * sample_weight), SUM(parts_reworked * sample_weight)
+			FROM production_events GROUP BY site, machine_id`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		out := map[string][3]int{}
+		for rows.Next() {
+			var m machineid.Key
+			var sums [3]int
+			if err := rows.Scan(&m.Site, &m.ID, &sums[0], &sums[1], &sums[2]); err != nil {
+				t.Fatal(err)
+			}
+			out[m.String()] = sums
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	want := totals(t, 1)
+	if len(want) != len(machines) {
+		t.Fatalf("unsampled totals = %v, want %d machines", want, len(machines))
+	}
+	for _, rate := range []int{2, 3, 10, 60} {
+		t.Run("rate "+strconv.Itoa(rate), func(t *testing.T) {
+			got := totals(t, rate)
+			for m, sums := range want {
+				if got[m] != sums {
+					t.Errorf("machine %s: weighted totals %v, unsampled %v", m, got[m], sums)
+				}
+			}
+		})
+	}
+}
+
+// Events carrying a parts counter are never sampled out and weigh 1, since
+// each stands for every part since the last reading. They don't shift the
+// sampling of the machine's other events.
+func TestSamplingKeepsCounterEvents(t *testing.T) {
+	db := setupTest(t, map[string]string{"PRODUCTION_SAMPLE_RATE": "4"})
+	send := func(i int, payload string) {
+		t.Helper()
+		at := testTime.Add(time.Duration(i) * time.Second).Format(time.RFC3339)
+		if err := handleMessage(context.Background(), db, "factory/machine/1/production", []byte(fmt.Sprintf(payload, at))); err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+	}
+	for i := range 8 {
+		send(i, `{"machine_id": 1, "parts_produced": 1, "parts_scrapped": 0, "timestamp": %q}`)
+	}
+	for i := range 3 {
+		send(8+i, `{"machine_id": 1, "parts_produced": 0, "parts_scrapped": 0, "parts_total": `+strconv.Itoa(10*i)+`, "timestamp": %q}`)
+	}
+	for i := range 4 {
+		send(11+i, `{"machine_id": 1, "parts_produced": 1, "parts_scrapped": 0, "timestamp": %q}`)
+	}
+
+	if n := count(t, db, `SELECT COUNT(*) FROM production_events WHERE parts_total IS NULL`); n != 3 {
+		t.Errorf("stored %d of 12 sampled events, want 3", n)
+	}
+	if n := count(t, db, `SELECT COUNT(*) FROM production_events WHERE parts_total IS NOT NULL AND sample_weight = 1`); n != 3 {
+		t.Errorf("stored %d of 3 counter events with weight 1, want 3", n)
+	}
+	if n := count(t, db, `SELECT SUM(parts_produced * sample_weight) FROM production_events`); n != 12+20 {
+		t.Errorf("weighted parts = %d, want 32", n)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// schemaColumn is a column the service reads or writes.
+type schemaColumn struct {
+	name string
+	// pgType is the data_type information_schema reports for the column
+	// the goose migrations create; sqliteType is its declared type in
+	// schema_sqlite.sql.
+	pgType     string
+	sqliteType string
+	// addable holds the constraints AUTO_MIGRATE adds a missing column
+	// with. It is empty for columns every version of the table has, which
+	// a partial migration can't have lost, and for key columns, which need
+	// the unique indexes the migrations build too.
+	addable string
+}
+
+// schemaTable is a table and the columns the service depends on.
+type schemaTable struct {
+	name    string
+	columns []schemaColumn
+}
+
+// expectedSchema lists what the service needs from the database. Keep it in
+// step with the migrations and schema_sqlite.sql when columns are added.
+var expectedSchema = []schemaTable{
+	{"status_events", []schemaColumn{
+		{"time", "timestamp with time zone", "timestamp", ""},
+		{"site", "text", "text", ""},
+		{"machine_id", "integer", "integer", ""},
+		{"status", "text", "text", ""},
+		{"reason", "text", "text", "NOT NULL DEFAULT ''"},
+		{"suspect", "boolean", "boolean", "NOT NULL DEFAULT false"},
+		{"raw_payload", "jsonb", "text", "NULL"},
+	}},
+	{"production_events", []schemaColumn{
+		{"time", "timestamp with time zone", "timestamp", ""},
+		{"site", "text", "text", ""},
+		{"machine_id", "integer", "integer", ""},
+		{"parts_produced", "integer", "integer", ""},
+		{"parts_scrapped", "integer", "integer", ""},
+		{"parts_reworked", "integer", "integer", "NOT NULL DEFAULT 0"},
+		{"lot_id", "text", "text", "NOT NULL DEFAULT ''"},
+		{"product", "text", "text", "NOT NULL DEFAULT ''"},
+		{"sample_weight", "integer", "integer", "NOT NULL DEFAULT 1"},
+		{"cycle", "bigint", "integer", "NULL"},
+		{"warmup", "boolean", "boolean", "NOT NULL DEFAULT false"},
+		{"planned_slowdown", "double precision", "real", "NULL"},
+		{"parts_total", "bigint", "integer", "NULL"},
+		{"raw_payload", "jsonb", "text", "NULL"},
+	}},
+	{"part_measurements", []schemaColumn{
+		{"time", "timestamp with time zone", "timestamp", ""},
+		{"site", "text", "text", ""},
+		{"machine_id", "integer", "integer", ""},
+		{"characteristic", "text", "text", ""},
+		{"unit", "text", "text", ""},
+		{"value", "double precision", "real", ""},
+		{"lower_spec", "double precision", "real", ""},
+		{"upper_spec", "double precision", "real", ""},
+		{"out_of_spec", "boolean", "boolean", ""},
+	}},
+	{"part_serials", []schemaColumn{
+		{"time", "timestamp with time zone", "timestamp", ""},
+		{"site", "text", "text", ""},
+		{"machine_id", "integer", "integer", ""},
+		{"serial", "text", "text", ""},
+		{"lot_id", "text", "text", ""},
+		{"product", "text", "text", ""},
+	}},
+	{"operator_events", []schemaColumn{
+		{"time", "timestamp with time zone", "timestamp", ""},
+		{"site", "text", "text", ""},
+		{"machine_id", "integer", "integer", ""},
+		{"operator_id", "text", "text", ""},
+		{"shift", "text", "text", ""},
+		{"raw_payload", "jsonb", "text", "NULL"},
+	}},
+	{"inspection_events", []schemaColumn{
+		{"time", "timestamp with time zone", "timestamp", ""},
+		{"site", "text", "text", ""},
+		{"machine_id", "integer", "integer", ""},
+		{"produced_at", "timestamp with time zone", "timestamp", ""},
+		{"serial", "text", "text", ""},
+		{"result", "text", "text", ""},
+		{"defect_code", "text", "text", ""},
+		{"raw_payload", "jsonb", "text", "NULL"},
+	}},
+	{"machines", []schemaColumn{
+		{"id", "integer", "integer", ""},
+		{"name", "character varying", "text", ""},
+		{"ideal_cycle_time_sec", "double precision", "real", ""},
+		{"site", "text", "text", ""},
+		{"started_at", "timestamp with time zone", "timestamp", "NULL"},
+		{"online", "boolean", "boolean", "NOT NULL DEFAULT false"},
+		{"last_seq", "bigint", "integer", "NOT NULL DEFAULT 0"},
+		{"last_seen_at", "timestamp with time zone", "timestamp", "NULL"},
+	}},
+	{"planned_downtime", []schemaColumn{
+		{"site", "text", "text", ""},
+		{"machine_id", "integer", "integer", ""},
+		{"start_time", "timestamp with time zone", "timestamp", ""},
+		{"end_time", "timestamp with time zone", "timestamp", ""},
+		{"reason", "text", "text", ""},
+	}},
+	{"ingest_errors", []schemaColumn{
+		{"time", "timestamp with time zone", "timestamp", ""},
+		{"topic", "text", "text", ""},
+		{"payload", "bytea", "blob", ""},
+		{"stage", "text", "text", ""},
+		{"error", "text", "text", ""},
+	}},
+}
+
+// checkSchema compares the database against tables, expectedSchema and
+// any staging tables, so a partial
+// migration fails at startup, naming every missing or mistyped column,
+// rather than on the first insert. With autoMigrate, columns added by later
+// migrations are added in place; missing tables and type mismatches still
+// need the migrations to be run. Tables INGEST_MAP_* redirects are checked
+// by checkTableMap instead.
+func checkSchema(db *sql.DB, driver string, autoMigrate bool, tables []schemaTable) error {
+	var problems []string
+	fixable := false
+	for _, table := range tables {
+		if _, mapped := config.TableMap[table.name]; mapped {
+			continue
+		}
+		actual, err := tableColumns(db, driver, table.name)
+		if err != nil {
+			return err
+		}
+		if len(actual) == 0 {
+			problems = append(problems, fmt.Sprintf("table %s is missing", table.name))
+			continue
+		}
+		for _, col := range table.columns {
+			want := col.pgType
+			if driver == driverSQLite {
+				want = col.sqliteType
+			}
+			got, ok := actual[col.name]
+			switch {
+			case !ok && col.addable != "" && autoMigrate:
+				query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s %s", table.name, col.name, want, col.addable)
+				if _, err := db.Exec(query); err != nil {
+					return fmt.Errorf("auto-migrate %s.%s: %w", table.name, col.name, err)
+				}
+				log.Printf("AUTO_MIGRATE: added column %s.%s", table.name, col.name)
+			case !ok:
+				problems = append(problems, fmt.Sprintf("column %s.%s is missing", table.name, col.name))
+				fixable = fixable || col.addable != ""
+			case !strings.EqualFold(got, want):
+				problems = append(problems, fmt.Sprintf("column %s.%s is %s, want %s", table.name, col.name, got, want))
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+
+	hint := "run the goose migrations in timescaledb/migrations"
+	if driver == driverSQLite {
+		hint = "delete the SQLite file to recreate it"
+	}
+	if fixable {
+		hint += ", or set AUTO_MIGRATE=true to add the missing columns"
+	}
+	return fmt.Errorf("database schema does not match this version (%s):\n  - %s", hint, strings.Join(problems, "\n  - "))
+}
+
+// tableColumns returns the type of each column of table, keyed by name. A
+// missing table has no columns.
+func tableColumns(db *sql.DB, driver, table string) (map[string]string, error) {
+	query := `SELECT column_name, data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1`
+	if driver == driverSQLite {
+		query = `SELECT name, type FROM pragma_table_info($1)`
+	}
+	rows, err := db.Query(query, table)
+	if err != nil {
+		return nil, fmt.Errorf("read columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]string)
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, fmt.Errorf("read columns of %s: %w", table, err)
+		}
+		columns[name] = typ
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,165 @@
+-- Minimal SQLite equivalent of the TimescaleDB schema built by the goose
+-- migrations, covering only what the ingestion service writes and reads.
+-- Keep in step with timescaledb/migrations and expectedSchema in schema.go
+-- when event columns change.
+CREATE TABLE IF NOT EXISTS machines (
+  id integer NOT NULL,
+  name text NOT NULL,
+  ideal_cycle_time_sec real NOT NULL,
+  site text NOT NULL DEFAULT '',
+  started_at timestamp,
+  online boolean NOT NULL DEFAULT false,
+  last_seq integer NOT NULL DEFAULT 0,
+  last_seen_at timestamp,
+  PRIMARY KEY (site, id)
+);
+
+CREATE TABLE IF NOT EXISTS status_events (
+  time timestamp NOT NULL,
+  site text NOT NULL DEFAULT '',
+  machine_id integer NOT NULL,
+  status text NOT NULL,
+  reason text NOT NULL DEFAULT '',
+  suspect boolean NOT NULL DEFAULT false,
+  raw_payload text,
+  UNIQUE (site, machine_id, time)
+);
+
+CREATE TABLE IF NOT EXISTS production_events (
+  time timestamp NOT NULL,
+  site text NOT NULL DEFAULT '',
+  machine_id integer NOT NULL,
+  parts_produced integer NOT NULL,
+  parts_scrapped integer NOT NULL,
+  parts_reworked integer NOT NULL DEFAULT 0,
+  lot_id text NOT NULL DEFAULT '',
+  product text NOT NULL DEFAULT '',
+  sample_weight integer NOT NULL DEFAULT 1,
+  cycle integer,
+  warmup boolean NOT NULL DEFAULT false,
+  planned_slowdown real,
+  parts_total integer,
+  raw_payload text,
+  UNIQUE (site, machine_id, time)
+);
+
+CREATE TABLE IF NOT EXISTS part_measurements (
+  time timestamp NOT NULL,
+  site text NOT NULL DEFAULT '',
+  machine_id integer NOT NULL,
+  characteristic text NOT NULL,
+  unit text NOT NULL DEFAULT '',
+  value real NOT NULL,
+  lower_spec real NOT NULL,
+  upper_spec real NOT NULL,
+  out_of_spec boolean NOT NULL,
+  UNIQUE (site, machine_id, time)
+);
+
+CREATE TABLE IF NOT EXISTS part_serials (
+  time timestamp NOT NULL,
+  site text NOT NULL DEFAULT '',
+  machine_id integer NOT NULL,
+  serial text NOT NULL UNIQUE,
+  lot_id text NOT NULL DEFAULT '',
+  product text NOT NULL DEFAULT '',
+  UNIQUE (site, machine_id, time)
+);
+
+CREATE TABLE IF NOT EXISTS operator_events (
+  time timestamp NOT NULL,
+  site text NOT NULL DEFAULT '',
+  machine_id integer NOT NULL,
+  operator_id text NOT NULL,
+  shift text NOT NULL DEFAULT '',
+  raw_payload text,
+  UNIQUE (site, machine_id, time)
+);
+
+CREATE TABLE IF NOT EXISTS inspection_events (
+  time timestamp NOT NULL,
+  site text NOT NULL DEFAULT '',
+  machine_id integer NOT NULL,
+  produced_at timestamp NOT NULL,
+  serial text NOT NULL DEFAULT '',
+  result text NOT NULL,
+  defect_code text NOT NULL DEFAULT '',
+  raw_payload text,
+  UNIQUE (site, machine_id, time)
+);
+
+CREATE INDEX IF NOT EXISTS inspection_events_part ON inspection_events (site, machine_id, produced_at);
+
+-- Staging tables for INGEST_STAGING: rows wait here, numbered by staged_id,
+-- until they are promoted to the event table of the same name without the
+-- prefix. No unique key, so duplicates are handled on promotion.
+CREATE TABLE IF NOT EXISTS staged_status_events (
+  staged_id integer PRIMARY KEY,
+  time timestamp NOT NULL,
+  site text NOT NULL DEFAULT '',
+  machine_id integer NOT NULL,
+  status text NOT NULL,
+  reason text NOT NULL DEFAULT '',
+  suspect boolean NOT NULL DEFAULT false,
+  raw_payload text
+);
+
+CREATE TABLE IF NOT EXISTS staged_production_events (
+  staged_id integer PRIMARY KEY,
+  time timestamp NOT NULL,
+  site text NOT NULL DEFAULT '',
+  machine_id integer NOT NULL,
+  parts_produced integer NOT NULL,
+  parts_scrapped integer NOT NULL,
+  parts_reworked integer NOT NULL DEFAULT 0,
+  lot_id text NOT NULL DEFAULT '',
+  product text NOT NULL DEFAULT '',
+  sample_weight integer NOT NULL DEFAULT 1,
+  cycle integer,
+  warmup boolean NOT NULL DEFAULT false,
+  planned_slowdown real,
+  parts_total integer,
+  raw_payload text
+);
+
+CREATE TABLE IF NOT EXISTS staged_part_measurements (
+  staged_id integer PRIMARY KEY,
+  time timestamp NOT NULL,
+  site text NOT NULL DEFAULT '',
+  machine_id integer NOT NULL,
+  characteristic text NOT NULL,
+  unit text NOT NULL DEFAULT '',
+  value real NOT NULL,
+  lower_spec real NOT NULL,
+  upper_spec real NOT NULL,
+  out_of_spec boolean NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS staged_operator_events (
+  staged_id integer PRIMARY KEY,
+  time timestamp NOT NULL,
+  site text NOT NULL DEFAULT '',
+  machine_id integer NOT NULL,
+  operator_id text NOT NULL,
+  shift text NOT NULL DEFAULT '',
+  raw_payload text
+);
+
+CREATE TABLE IF NOT EXISTS planned_downtime (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  site text NOT NULL DEFAULT '',
+  machine_id integer NOT NULL,
+  start_time timestamp NOT NULL,
+  end_time timestamp NOT NULL,
+  reason text NOT NULL DEFAULT '',
+  created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK (end_time > start_time)
+);
+
+CREATE TABLE IF NOT EXISTS ingest_errors (
+  time timestamp NOT NULL,
+  topic text NOT NULL,
+  payload blob NOT NULL,
+  stage text NOT NULL,
+  error text NOT NULL
+);
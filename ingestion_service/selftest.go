@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/events"
+)
+
+// selfTestMachineID is the machine the self-test event comes from, the
+// largest ID a machine_id column holds, so that it is never a real one.
+const selfTestMachineID = 1<<31 - 1
+
+// selfTestPrefix is the topic tree the self-test publishes on, outside the
+// MQTT_TOPIC_PREFIXES trees so running ingestors don't store its event. It
+// is also the site of the self-test machine.
+const selfTestPrefix = "oee-selftest"
+
+// runSelfTest checks the path an event takes through the ingestor end to
+// end: it publishes a status event on a topic of its own, receives it back
+// through a subscription, stores it like any other event and reads it back
+// from every database sink, then deletes it. It returns the first step that
+// failed, or nil.
+func runSelfTest(db *sql.DB, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	dbs := selfTestDatabases(db)
+	defer func() {
+		for name, d := range dbs {
+			if err := deleteSelfTestRows(d); err != nil {
+				log.Printf("self-test: failed to clean up in %s: %v", name, err)
+			}
+		}
+	}()
+	// Rows left by an earlier self-test that didn't get to clean up would
+	// pass the read back below
+	for name, d := range dbs {
+		if err := deleteSelfTestRows(d); err != nil {
+			return fmt.Errorf("database %s: %w", name, err)
+		}
+	}
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(config.MQTTBrokerURL)
+	// A client ID of its own, so a running ingestor isn't disconnected
+	opts.SetClientID(config.MQTTClientID + "-selftest")
+	opts.SetUsername(config.MQTTUsername)
+	opts.SetPassword(config.MQTTPassword)
+	opts.SetCleanSession(true)
+	opts.SetConnectTimeout(timeout)
+	client := mqtt.NewClient(opts)
+	if err := waitToken(client.Connect(), deadline); err != nil {
+		return fmt.Errorf("connect to %s: %w", config.MQTTBrokerURL, err)
+	}
+	defer client.Disconnect(250)
+	log.Printf("self-test: connected to %s", config.MQTTBrokerURL)
+
+	topic := events.MarshalTopic(fmt.Sprintf("%s/%d", selfTestPrefix, time.Now().UnixNano()), selfTestMachineID, events.KindStatus)
+	stored := make(chan error, 1)
+	received := func(_ mqtt.Client, m mqtt.Message) {
+		select {
+		case stored <- handleMessage(ctx, db, m.Topic(), m.Payload()):
+		default: // a redelivery
+		}
+	}
+	if err := waitToken(client.Subscribe(topic, 1, received), deadline); err != nil {
+		return fmt.Errorf("subscribe to %s: %w", topic, err)
+	}
+	log.Printf("self-test: subscribed to %s", topic)
+
+	payload, err := json.Marshal(events.StatusEvent{
+		MachineID: selfTestMachineID,
+		Site:      selfTestPrefix,
+		Status:    events.StatusStopped,
+		Reason:    "selftest",
+		Timestamp: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := waitToken(client.Publish(topic, 1, false, payload), deadline); err != nil {
+		return fmt.Errorf("publish to %s: %w", topic, err)
+	}
+	log.Printf("self-test: published a status event for machine %d", selfTestMachineID)
+
+	select {
+	case err := <-stored:
+		if err != nil {
+			return fmt.Errorf("store the event: %w", err)
+		}
+	case <-ctx.Done():
+		return fmt.Errorf("the event didn't come back from the broker within %v", timeout)
+	}
+	log.Printf("self-test: received and stored the event in %s", sinks.Name())
+	if stager != nil {
+		if err := stager.drain(ctx); err != nil {
+			return fmt.Errorf("promote the staged event: %w", err)
+		}
+		log.Printf("self-test: promoted the staged event")
+	}
+
+	table, columns := mapped("status_events", "site", "machine_id")
+	for name, d := range dbs {
+		var n int
+		query := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s = $1 AND %s = $2`, table, columns[0], columns[1])
+		if err := d.QueryRowContext(ctx, query, selfTestPrefix, selfTestMachineID).Scan(&n); err != nil {
+			return fmt.Errorf("read the event back from %s: %w", name, err)
+		}
+		if n == 0 {
+			return fmt.Errorf("the event is missing from %s", name)
+		}
+		log.Printf("self-test: read the event back from %s", name)
+	}
+	return nil
+}
+
+// selfTestDatabases returns the databases among the sinks, by name.
+func selfTestDatabases(db *sql.DB) map[string]*sql.DB {
+	dbs := map[string]*sql.DB{sinkDB: db}
+	if m, ok := sinks.(MultiSink); ok {
+		for _, s := range m {
+			if d, ok := s.(dbSink); ok {
+				dbs[d.Name()] = d.db
+			}
+		}
+	}
+	return dbs
+}
+
+// deleteSelfTestRows deletes the self-test machine's status events from db,
+// and from db's staging table if it has one.
+func deleteSelfTestRows(db *sql.DB) error {
+	table, columns := mapped("status_events", "site", "machine_id")
+	if _, err := db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE %s = $1 AND %s = $2`, table, columns[0], columns[1]), selfTestPrefix, selfTestMachineID); err != nil {
+		return err
+	}
+	if stager == nil || db != stager.db {
+		return nil
+	}
+	_, err := db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE site = $1 AND machine_id = $2`, stagingTable("status_events")), selfTestPrefix, selfTestMachineID)
+	return err
+}
+
+// waitToken waits for t until deadline and returns its error.
+func waitToken(t mqtt.Token, deadline time.Time) error {
+	if !t.WaitTimeout(time.Until(deadline)) {
+		return errors.New("timed out")
+	}
+	return t.Error()
+}
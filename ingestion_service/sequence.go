@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// sequenceTracker follows the seq numbers each machine puts on its events
+// to detect lost messages and messages that arrive out of order, which
+// timestamps alone can't tell apart from a machine that was simply idle.
+type sequenceTracker struct {
+	mu   sync.Mutex
+	last map[machineid.Key]uint64
+	// unsaved holds the last seqs not yet saved to the database
+	unsaved map[machineid.Key]uint64
+}
+
+// sequences tracks event sequence numbers; nil when they can't be followed
+// because MQTT_SHARED_GROUP splits each machine's events across replicas.
+var sequences *sequenceTracker
+
+func newSequenceTracker() *sequenceTracker {
+	return &sequenceTracker{last: map[machineid.Key]uint64{}, unsaved: map[machineid.Key]uint64{}}
+}
+
+// observe checks seq, from an event of machine, against the last one
+// seen. A gap counts the numbers in between as missing, and a number at or
+// below the last counts as late. A birth restarts the count, since a
+// restarted machine numbers its events from 1 again. Events without a seq,
+// and retained messages replayed by the broker, are ignored. The first seq
+// seen for a machine is compared with the one saved in the database, if
+// any, and save writes the new last seqs there, so gaps across an ingestor
+// restart are caught too.
+func (t *sequenceTracker) observe(ctx context.Context, db *sql.DB, machine machineid.Key, seq uint64, birth bool) {
+	if t == nil || seq == 0 {
+		return
+	}
+	if r, ok := receiptFrom(ctx); ok && r.retained {
+		return
+	}
+	t.advance(db, machine, seq, birth)
+}
+
+// advance compares seq with the last one seen for machine, counting any
+// gap or late arrival, and reports whether seq is the new last.
+func (t *sequenceTracker) advance(db *sql.DB, machine machineid.Key, seq uint64, birth bool) bool {
+	t.mu.Lock()
+	_, ok := t.last[machine]
+	t.mu.Unlock()
+	// The lookup runs without the lock, so other machines' events don't
+	// wait on the database
+	var saved uint64
+	if !ok {
+		err := db.QueryRow(`SELECT last_seq FROM machines WHERE site = $1 AND id = $2`, machine.Site, machine.ID).Scan(&saved)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("failed to load last sequence number for machine %s: %v", machine, err)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Another event of the machine may have been checked meanwhile
+	prev, ok := t.last[machine]
+	if !ok {
+		prev = saved
+		t.last[machine] = prev
+	}
+
+	id := strconv.Itoa(machine.ID)
+	switch {
+	case birth || prev == 0 || seq == prev+1:
+	case seq > prev+1:
+		sequenceMissing.WithLabelValues(machine.Site, id).Add(float64(seq - prev - 1))
+		if seq == prev+2 {
+			log.Printf("machine %s: missing event %d", machine, prev+1)
+		} else {
+			log.Printf("machine %s: missing events %d-%d", machine, prev+1, seq-1)
+		}
+	default:
+		sequenceLate.WithLabelValues(machine.Site, id).Inc()
+		log.Printf("machine %s: event %d arrived after %d", machine, seq, prev)
+		return false
+	}
+	t.last[machine] = seq
+	t.unsaved[machine] = seq
+	return true
+}
+
+// save writes the last seq of every machine whose seq moved since the
+// previous save to machines.last_seq. A machine not registered yet has no
+// row to save to; its seq is tracked in memory until it has one. Seqs that
+// fail to save are kept for the next save, unless a newer one replaced
+// them meanwhile.
+func (t *sequenceTracker) save(ctx context.Context) error {
+	t.mu.Lock()
+	unsaved := t.unsaved
+	t.unsaved = map[machineid.Key]uint64{}
+	t.mu.Unlock()
+
+	var failed error
+	for machine, seq := range unsaved {
+		r := record{
+			table:   "machines",
+			columns: []string{"site", "id", "last_seq"},
+			values:  []any{machine.Site, machine.ID, seq},
+			query:   `UPDATE machines SET last_seq = $3 WHERE site = $1 AND id = $2`,
+		}
+		if err := storeEvent(ctx, machine, r); err != nil {
+			failed = fmt.Errorf("failed to save last sequence number for machine %s: %w", machine, err)
+			t.mu.Lock()
+			if _, ok := t.unsaved[machine]; !ok {
+				t.unsaved[machine] = seq
+			}
+			t.mu.Unlock()
+		}
+	}
+	return failed
+}
+
+// run saves the last seqs every interval until ctx is done.
+func (t *sequenceTracker) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if dbState.check() != nil {
+			continue
+		}
+		if err := t.save(ctx); err != nil {
+			log.Print(err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// Seqs that fail to save are saved with the next save, unless a newer one
+// came in meanwhile.
+func TestSequenceTrackerSaveKeepsFailedSeqs(t *testing.T) {
+	db := setupTest(t, map[string]string{"INGEST_RETRY_MAX": "0"})
+	seedMachines(t, db)
+	sequences = newSequenceTracker()
+	plantA, plantB := machineid.Key{Site: "plant-a", ID: 1}, machineid.Key{Site: "plant-b", ID: 1}
+	sequences.advance(db, plantA, 42, false)
+	sequences.advance(db, plantB, 8, false)
+
+	closed, err := openDB(config)
+	if err != nil {
+		t.Fatalf("openDB: %v", err)
+	}
+	closed.Close()
+	working := sinks
+	sinks = MultiSink{dbSink{name: sinkDB, db: closed}}
+	if err := sequences.save(context.Background()); err == nil {
+		t.Fatal("save to a closed database succeeded")
+	}
+	sequences.advance(db, plantA, 43, false)
+
+	sinks = working
+	if err := sequences.save(context.Background()); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if n := count(t, db, `SELECT last_seq FROM machines WHERE site = 'plant-a' AND id = 1`); n != 43 {
+		t.Errorf("plant-a last_seq = %d, want 43", n)
+	}
+	if n := count(t, db, `SELECT last_seq FROM machines WHERE site = 'plant-b' AND id = 1`); n != 8 {
+		t.Errorf("plant-b last_seq = %d, want 8", n)
+	}
+	if len(sequences.unsaved) != 0 {
+		t.Errorf("unsaved = %v after a successful save", sequences.unsaved)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Kinds of INGEST_SINKS entry besides database URLs.
+const (
+	// sinkDB is the database selected by DB_DRIVER, which also holds the
+	// ingestor's own state.
+	sinkDB = "db"
+	// sinkStdout writes each record to stdout as a JSON line.
+	sinkStdout = "stdout"
+)
+
+// record is one row written for an event, such as a status event or the
+// machine registered by a birth.
+type record struct {
+	table   string
+	columns []string
+	values  []any
+	// query writes the row to a database, with values as its arguments.
+	// Empty means a plain insert into table, keyed on (site, machine_id,
+	// time) and following INGEST_DUPLICATES; columns must then start with
+	// time, site and machine_id.
+	query string
+}
+
+// eventKey splits the columns of a plain insert into the unique key of its
+// table, as ON CONFLICT names it, and the columns after the key.
+func eventKey(columns []string) (key string, rest []string) {
+	return columns[1] + ", " + columns[2] + ", " + columns[0], columns[3:]
+}
+
+// withRawPayload returns r with payload, the message r was parsed from, in
+// its raw_payload column when STORE_RAW_PAYLOAD is set. The payload is
+// passed as a string, which Postgres casts to jsonb; the parsed columns
+// remain what queries use.
+func (r record) withRawPayload(payload []byte) record {
+	if !config.StoreRawPayload {
+		return r
+	}
+	r.columns = append(r.columns, "raw_payload")
+	r.values = append(r.values, string(payload))
+	return r
+}
+
+// insert returns the statement that writes r to a database, and its
+// arguments. A plain insert goes to the table and columns INGEST_MAP_*
+// maps it to, if any.
+func (r record) insert() (string, []any) {
+	if r.query != "" {
+		return r.query, r.values
+	}
+	table, columns, values := r.table, r.columns, r.values
+	if m, ok := config.TableMap[r.table]; ok {
+		table, columns, values = m.apply(table, columns, values)
+	}
+	params := make([]string, len(columns))
+	for i := range params {
+		params[i] = fmt.Sprintf("$%d", i+1)
+	}
+	key, rest := eventKey(columns)
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), strings.Join(params, ",")) +
+		config.Delivery.onConflict(key, rest...), values
+}
+
+// Sink is somewhere records are written.
+type Sink interface {
+	// Name identifies the sink in logs, errors and metrics.
+	Name() string
+	Write(ctx context.Context, r record) error
+}
+
+// sinks receives every record; set up from INGEST_SINKS at startup.
+var sinks Sink
+
+// dbSink writes records to a database, retrying as INGEST_DELIVERY says.
+// With staging set, event rows go to the staging tables; see promoter.
+type dbSink struct {
+	name    string
+	db      *sql.DB
+	staging bool
+}
+
+func (s dbSink) Name() string { return s.name }
+
+func (s dbSink) Write(_ context.Context, r record) error {
+	query, args := r.insert()
+	if s.staging && r.query == "" && staged(r.table) {
+		query, args = r.stage()
+	}
+	return config.Delivery.exec(s.db, query, args...)
+}
+
+// stdoutSink writes each record as a JSON object of its columns, plus
+// "table", on a line of its own, under this service's table and column
+// names whatever INGEST_MAP_* says. Logs go to stderr, so stdout carries
+// only records.
+type stdoutSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newStdoutSink() *stdoutSink {
+	return &stdoutSink{enc: json.NewEncoder(os.Stdout)}
+}
+
+func (s *stdoutSink) Name() string { return sinkStdout }
+
+func (s *stdoutSink) Write(_ context.Context, r record) error {
+	line := make(map[string]any, len(r.columns)+1)
+	line["table"] = r.table
+	for i, c := range r.columns {
+		line[c] = r.values[i]
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(line)
+}
+
+// MultiSink writes each record to all of its sinks at once, so a slow or
+// failing sink doesn't hold up the others. Write returns the errors of all
+// sinks that failed, each prefixed with the sink's name when there is more
+// than one. Writes and failures are counted per sink.
+type MultiSink []Sink
+
+func (m MultiSink) Name() string {
+	names := make([]string, len(m))
+	for i, s := range m {
+		names[i] = s.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (m MultiSink) Write(ctx context.Context, r record) error {
+	if len(m) == 1 {
+		return writeCounted(ctx, m[0], r)
+	}
+	errs := make([]error, len(m))
+	var wg sync.WaitGroup
+	for i, s := range m {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := writeCounted(ctx, s, r); err != nil {
+				errs[i] = fmt.Errorf("sink %s: %w", s.Name(), err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// writeCounted writes r to s and updates its metrics.
+func writeCounted(ctx context.Context, s Sink, r record) error {
+	err := s.Write(ctx, r)
+	sinkWrites.WithLabelValues(s.Name()).Inc()
+	if err != nil {
+		sinkErrors.WithLabelValues(s.Name()).Inc()
+	}
+	return err
+}
+
+// openSinks opens the sinks listed in INGEST_SINKS; db is the one opened
+// from DB_DRIVER. Extra databases are checked against the schema like db.
+// The returned function closes the databases it opened.
+func openSinks(cfg Config, db *sql.DB) (MultiSink, func(), error) {
+	var out MultiSink
+	var opened []*sql.DB
+	closeAll := func() {
+		for _, d := range opened {
+			d.Close()
+		}
+	}
+	for _, entry := range cfg.Sinks {
+		switch entry {
+		case sinkDB:
+			out = append(out, dbSink{name: sinkDB, db: db, staging: cfg.Staging})
+		case sinkStdout:
+			out = append(out, newStdoutSink())
+		default:
+			driver, dsn, name := parseSinkURL(entry)
+			extra, err := openDSN(driver, dsn)
+			if err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("sink %s: %w", name, err)
+			}
+			opened = append(opened, extra)
+			if err := checkSchema(extra, driver, cfg.AutoMigrate, expectedSchema); err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("sink %s: %w", name, err)
+			}
+			if err := checkTableMap(extra); err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("sink %s: %w", name, err)
+			}
+			out = append(out, dbSink{name: name, db: extra})
+		}
+	}
+	return out, closeAll, nil
+}
+
+// parseSinkURL splits a database entry of INGEST_SINKS, a postgres:// URL
+// or sqlite:<path>, into its driver and data source, and names the sink
+// after it without the password.
+func parseSinkURL(entry string) (driver, dsn, name string) {
+	if path, ok := strings.CutPrefix(entry, driverSQLite+":"); ok {
+		return driverSQLite, path, entry
+	}
+	name = entry
+	if u, err := url.Parse(entry); err == nil {
+		name = u.Redacted()
+	}
+	return driverPostgres, entry, name
+}
+
+// validSink reports whether entry is a kind of sink INGEST_SINKS accepts.
+func validSink(entry string) bool {
+	switch {
+	case entry == sinkDB, entry == sinkStdout:
+		return true
+	case strings.HasPrefix(entry, driverSQLite+":"):
+		return len(entry) > len(driverSQLite)+1
+	default:
+		u, err := url.Parse(entry)
+		return err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") && u.Host != ""
+	}
+}
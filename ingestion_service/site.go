@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/events"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// machineKey returns the key an event of machine id is stored under. The
+// site is the event's own, or else the one its topic names, so the
+// machines of several sites sharing the database don't collide.
+func machineKey(t events.Topic, site string, id int) machineid.Key {
+	if site == "" {
+		site = topicSite(t.Prefix)
+	}
+	return machineid.Key{Site: site, ID: id}
+}
+
+// topicSite returns the site a topic prefix names: the level matched by the
+// last + of the MQTT_TOPIC_PREFIXES entry it falls under, as the default
+// "factory/+" takes plant-a from "factory/plant-a". A prefix matched
+// without a +, such as "factory", names no site.
+func topicSite(prefix string) string {
+	levels := strings.Split(prefix, "/")
+	for _, p := range config.TopicPrefixes {
+		filter := strings.Split(p, "/")
+		if len(filter) != len(levels) {
+			continue
+		}
+		site, matched := "", true
+		for i, f := range filter {
+			if f == "+" {
+				site = levels[i]
+			} else if f != levels[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return site
+		}
+	}
+	return ""
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// stager promotes staged event rows; nil unless INGEST_STAGING is set.
+var stager *promoter
+
+// stagingTable returns the table rows of table are staged in.
+func stagingTable(table string) string {
+	return "staged_" + table
+}
+
+// staged reports whether INGEST_STAGING stages table: the event tables
+// INGEST_MAP_* can redirect, which take a row per event and are read back
+// only when a machine is first seen.
+func staged(table string) bool {
+	for _, t := range mappableTables {
+		if t.table == table {
+			return true
+		}
+	}
+	return false
+}
+
+// stagedSchema returns the staging tables checkSchema expects with
+// INGEST_STAGING: each staged table's columns after a staged_id numbering
+// its rows in the order they were staged.
+func stagedSchema() []schemaTable {
+	var tables []schemaTable
+	for _, t := range expectedSchema {
+		if staged(t.name) {
+			columns := append([]schemaColumn{{"staged_id", "bigint", "integer", ""}}, t.columns...)
+			tables = append(tables, schemaTable{stagingTable(t.name), columns})
+		}
+	}
+	return tables
+}
+
+// stage returns the statement that writes r, a plain insert into a staged
+// table, to its staging table instead. Staging tables have no unique key
+// and aren't mapped, so the duplicate policy and INGEST_MAP_* apply when
+// the row is promoted.
+func (r record) stage() (string, []any) {
+	params := make([]string, len(r.columns))
+	for i := range params {
+		params[i] = fmt.Sprintf("$%d", i+1)
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", stagingTable(r.table), strings.Join(r.columns, ", "), strings.Join(params, ",")), r.values
+}
+
+// promotion moves the rows of one staging table into its event table.
+type promotion struct {
+	table, staging string
+	// columns are the columns copied, under this service's names.
+	columns []string
+	// insert copies the rows whose staged_ids fill in its %s from the
+	// staging table into the event table, as INGEST_MAP_* and
+	// INGEST_DUPLICATES say.
+	insert string
+}
+
+func newPromotion(table string, columns []string) promotion {
+	p := promotion{table: table, staging: stagingTable(table), columns: columns}
+	source := make([]any, len(columns))
+	for i, c := range columns {
+		source[i] = "s." + c
+	}
+	target, targetColumns := table, columns
+	if m, ok := config.TableMap[table]; ok {
+		target, targetColumns, source = m.apply(table, columns, source)
+	}
+	sourceColumns := make([]string, len(source))
+	for i, s := range source {
+		sourceColumns[i] = s.(string)
+	}
+	where := "s.staged_id IN (%s)"
+	if config.Delivery.Duplicates == duplicatesUpsert {
+		// Postgres can't update a row twice in one statement, so of the
+		// rows staged for one key only the last is promoted; it would
+		// have overwritten the others anyway. A later one outside the
+		// batch is promoted with its own batch.
+		where += fmt.Sprintf(" AND NOT EXISTS (SELECT 1 FROM %s l WHERE l.site = s.site AND l.machine_id = s.machine_id AND l.time = s.time AND l.staged_id > s.staged_id)", p.staging)
+	}
+	key, rest := eventKey(targetColumns)
+	p.insert = fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s s WHERE %s ORDER BY s.staged_id",
+		target, strings.Join(targetColumns, ", "), strings.Join(sourceColumns, ", "), p.staging, where) +
+		config.Delivery.onConflict(key, rest...)
+	return p
+}
+
+// promoter moves staged rows into the event tables in the background, so
+// that events are acknowledged once they are in the staging tables, which
+// have no unique index, hypertable chunks or compression to maintain, and
+// queries against the event tables don't hold up ingestion.
+//
+// Each batch is copied and deleted from its staging table in one
+// transaction, so a crash either promotes the whole batch or leaves it all
+// staged for the next run; no row is lost or promoted twice. On Postgres
+// the batch is locked with SKIP LOCKED, so replicas in a shared group can
+// promote at the same time.
+type promoter struct {
+	db         *sql.DB
+	driver     string
+	batch      int
+	promotions []promotion
+}
+
+func newPromoter(db *sql.DB, driver string, batch int) *promoter {
+	p := &promoter{db: db, driver: driver, batch: batch}
+	for _, t := range expectedSchema {
+		if !staged(t.name) {
+			continue
+		}
+		columns := make([]string, len(t.columns))
+		for i, c := range t.columns {
+			columns[i] = c.name
+		}
+		p.promotions = append(p.promotions, newPromotion(t.name, columns))
+	}
+	return p
+}
+
+// run promotes the staged rows every interval until ctx is done. Passes are
+// skipped during a sustained database outage.
+func (p *promoter) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if dbState.check() != nil {
+			continue
+		}
+		if err := p.drain(ctx); err != nil {
+			stagingPromoteErrors.Inc()
+			log.Printf("failed to promote staged events: %v", err)
+		}
+	}
+}
+
+// drain promotes batches until the staging tables are empty, or the rows
+// left are locked by another replica.
+func (p *promoter) drain(ctx context.Context) error {
+	for _, pr := range p.promotions {
+		for {
+			n, err := p.promote(ctx, pr)
+			if err != nil {
+				return fmt.Errorf("%s: %w", pr.table, err)
+			}
+			if n < p.batch {
+				break
+			}
+		}
+		var backlog int
+		if err := p.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+pr.staging).Scan(&backlog); err != nil {
+			return fmt.Errorf("%s: %w", pr.staging, err)
+		}
+		stagingBacklog.WithLabelValues(pr.table).Set(float64(backlog))
+	}
+	return nil
+}
+
+// promote promotes the oldest batch of pr's staged rows and returns how
+// many rows it took from the staging table. If the batch fails for good,
+// say on a value the event table rejects, its rows are promoted one at a
+// time and those that still fail go to ingest_errors, so one bad row can't
+// hold up the rest.
+func (p *promoter) promote(ctx context.Context, pr promotion) (int, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	query := fmt.Sprintf("SELECT staged_id FROM %s ORDER BY staged_id LIMIT $1", pr.staging)
+	if p.driver == driverPostgres {
+		query += " FOR UPDATE SKIP LOCKED"
+	}
+	ids, err := stagedIDs(ctx, tx, query, p.batch)
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	err = p.move(ctx, tx, pr, ids)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err == nil {
+		stagingPromoted.WithLabelValues(pr.table).Add(float64(len(ids)))
+		return len(ids), nil
+	}
+	if !isPermanent(err) {
+		return 0, err
+	}
+	tx.Rollback()
+	for _, id := range ids {
+		if err := p.promoteOne(ctx, pr, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// promoteOne promotes the staged row id on its own, sending it to
+// ingest_errors if the event table rejects it.
+func (p *promoter) promoteOne(ctx context.Context, pr promotion, id int64) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = p.move(ctx, tx, pr, []int64{id})
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err == nil {
+		stagingPromoted.WithLabelValues(pr.table).Inc()
+		return nil
+	}
+	if !isPermanent(err) {
+		return err
+	}
+	tx.Rollback()
+	return p.reject(ctx, pr, id, err)
+}
+
+// move copies the staged rows ids into the event table and deletes them
+// from the staging table, within tx.
+func (p *promoter) move(ctx context.Context, tx *sql.Tx, pr promotion, ids []int64) error {
+	params := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		params[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = id
+	}
+	in := strings.Join(params, ",")
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(pr.insert, in), args...); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE staged_id IN (%s)", pr.staging, in), args...)
+	return err
+}
+
+// reject records the staged row id, which the event table rejected with
+// cause, in ingest_errors as a JSON object of its columns, and deletes it
+// from the staging table.
+func (p *promoter) reject(ctx context.Context, pr promotion, id int64, cause error) error {
+	values := make([]any, len(pr.columns))
+	dest := make([]any, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE staged_id = $1", strings.Join(pr.columns, ", "), pr.staging)
+	if err := p.db.QueryRowContext(ctx, query, id).Scan(dest...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil // promoted or rejected by another replica
+		}
+		return err
+	}
+	row := make(map[string]any, len(values))
+	for i, c := range pr.columns {
+		if b, ok := values[i].([]byte); ok {
+			values[i] = string(b)
+		}
+		row[c] = values[i]
+	}
+	payload, _ := json.Marshal(row)
+	reportIngestError(p.db, nil, "", pr.staging, payload, &stageError{stagePromote, fmt.Errorf("failed to promote to %s: %w", pr.table, cause)})
+	_, err := p.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE staged_id = $1", pr.staging), id)
+	return err
+}
+
+// stagedIDs runs query, which selects staged_ids, with args.
+func stagedIDs(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
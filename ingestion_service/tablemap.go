@@ -0,0 +1,153 @@
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// mappableTables are the event tables INGEST_MAP_<TYPE>_TABLE and
+// INGEST_MAP_<TYPE>_COLUMNS can redirect, by TYPE. The machines registry,
+// planned downtime, part serials and inspections are read back by the
+// ingestor or the API, so they keep their own tables.
+var mappableTables = []struct{ kind, table string }{
+	{"STATUS", "status_events"},
+	{"PRODUCTION", "production_events"},
+	{"MEASUREMENT", "part_measurements"},
+	{"OPERATOR", "operator_events"},
+}
+
+// identifier matches the table and column names a mapping may use: plain SQL
+// identifiers, with tables optionally qualified by a schema. They are
+// written into the INSERT as they are, so nothing that needs quoting is let
+// through.
+var (
+	identifier          = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	qualifiedIdentifier = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*\.)?[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// tableMapping writes an event table's rows into another table, such as one
+// in an existing warehouse schema.
+type tableMapping struct {
+	// Table replaces the table name; empty keeps it.
+	Table string
+	// Columns renames columns, keyed by their name in this service's
+	// schema. A column mapped to "" is not written.
+	Columns map[string]string
+}
+
+// apply returns the table, columns and values a row of columns and values
+// is written as.
+func (m tableMapping) apply(table string, columns []string, values []any) (string, []string, []any) {
+	if m.Table != "" {
+		table = m.Table
+	}
+	var mappedColumns []string
+	var mappedValues []any
+	for i, c := range columns {
+		to, ok := m.Columns[c]
+		if !ok {
+			to = c
+		}
+		if to == "" {
+			continue
+		}
+		mappedColumns = append(mappedColumns, to)
+		mappedValues = append(mappedValues, values[i])
+	}
+	return table, mappedColumns, mappedValues
+}
+
+// mapped returns the names table and its columns are written under; a
+// column that isn't written is "".
+func mapped(table string, columns ...string) (string, []string) {
+	m := config.TableMap[table]
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		var ok bool
+		if names[i], ok = m.Columns[c]; !ok {
+			names[i] = c
+		}
+	}
+	return cmp.Or(m.Table, table), names
+}
+
+// parseTableMap reads the INGEST_MAP_* variables into mappings keyed by the
+// table they replace. Columns are given as "from=to" pairs, e.g.
+// "time=ts,machine_id=asset_id,suspect=" to rename two columns and leave
+// suspect out.
+func parseTableMap() (map[string]tableMapping, error) {
+	mappings := map[string]tableMapping{}
+	for _, t := range mappableTables {
+		tableVar := "INGEST_MAP_" + t.kind + "_TABLE"
+		columnsVar := "INGEST_MAP_" + t.kind + "_COLUMNS"
+		m := tableMapping{Table: strings.TrimSpace(mustEnv(tableVar, "")), Columns: map[string]string{}}
+		if m.Table != "" && !qualifiedIdentifier.MatchString(m.Table) {
+			return nil, fmt.Errorf("invalid %s %q: must be a table name, optionally schema-qualified", tableVar, m.Table)
+		}
+		known := schemaColumns(t.table)
+		for _, pair := range strings.Split(mustEnv(columnsVar, ""), ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			from, to, ok := strings.Cut(pair, "=")
+			from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+			switch {
+			case !ok:
+				return nil, fmt.Errorf("invalid %s entry %q: must be column=new_name", columnsVar, pair)
+			case !known[from]:
+				return nil, fmt.Errorf("invalid %s: %s has no column %q", columnsVar, t.table, from)
+			case to == "" && (from == "time" || from == "site" || from == "machine_id"):
+				return nil, fmt.Errorf("invalid %s: %s can't be left out, it identifies the event", columnsVar, from)
+			case to != "" && !identifier.MatchString(to):
+				return nil, fmt.Errorf("invalid %s entry %q: %q is not a column name", columnsVar, pair, to)
+			}
+			if _, dup := m.Columns[from]; dup {
+				return nil, fmt.Errorf("invalid %s: %s is mapped twice", columnsVar, from)
+			}
+			m.Columns[from] = to
+		}
+		if m.Table != "" || len(m.Columns) > 0 {
+			mappings[t.table] = m
+		}
+	}
+	return mappings, nil
+}
+
+// schemaColumns returns the columns expectedSchema lists for table.
+func schemaColumns(table string) map[string]bool {
+	columns := map[string]bool{}
+	for _, t := range expectedSchema {
+		if t.name == table {
+			for _, c := range t.columns {
+				columns[c.name] = true
+			}
+		}
+	}
+	return columns
+}
+
+// checkTableMap prepares the INSERT of every mapped table against db, so a
+// mapping to a table or column that doesn't exist, or one without the
+// unique key INGEST_DUPLICATES needs, fails at startup.
+func checkTableMap(db *sql.DB) error {
+	for _, t := range expectedSchema {
+		if _, ok := config.TableMap[t.name]; !ok {
+			continue
+		}
+		r := record{table: t.name}
+		for _, c := range t.columns {
+			r.columns = append(r.columns, c.name)
+			r.values = append(r.values, nil)
+		}
+		query, _ := r.insert()
+		stmt, err := db.Prepare(query)
+		if err != nil {
+			return fmt.Errorf("invalid mapping of %s: %s: %w", t.name, query, err)
+		}
+		stmt.Close()
+	}
+	return nil
+}
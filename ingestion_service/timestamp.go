@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// What to do with an event that has no timestamp (ZERO_TIMESTAMP).
+const (
+	// zeroTimestampNow stamps it with the time it is stored.
+	zeroTimestampNow = "now"
+	// zeroTimestampReject sends it to ingest_errors.
+	zeroTimestampReject = "reject"
+	// zeroTimestampReceivedAt stamps it with the time the message arrived,
+	// before it waited in the buffer.
+	zeroTimestampReceivedAt = "received_at"
+)
+
+// errMissingTimestamp is the parse error of an event rejected for having no
+// timestamp.
+var errMissingTimestamp = errors.New("event has no timestamp")
+
+// eventTime returns the time to store an event under: ts, or if it is zero,
+// the time ZERO_TIMESTAMP picks.
+func eventTime(ctx context.Context, ts time.Time) (time.Time, error) {
+	if !ts.IsZero() {
+		return ts, nil
+	}
+	switch config.ZeroTimestamp {
+	case zeroTimestampReject:
+		return ts, &stageError{stageParse, errMissingTimestamp}
+	case zeroTimestampReceivedAt:
+		if r, ok := receiptFrom(ctx); ok {
+			return r.at.UTC(), nil
+		}
+	}
+	return time.Now().UTC(), nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEventTime(t *testing.T) {
+	received := time.Date(2025, 11, 5, 9, 59, 30, 0, time.FixedZone("CET", 3600))
+	arrived := withReceipt(context.Background(), receipt{at: received})
+	tests := []struct {
+		policy string
+		ctx    context.Context
+		ts     time.Time
+		// want is the time stored; zero means the time of the call
+		want    time.Time
+		wantErr bool
+	}{
+		{zeroTimestampNow, arrived, testTime, testTime, false},
+		{zeroTimestampNow, arrived, time.Time{}, time.Time{}, false},
+		{zeroTimestampReject, arrived, testTime, testTime, false},
+		{zeroTimestampReject, arrived, time.Time{}, time.Time{}, true},
+		{zeroTimestampReceivedAt, arrived, testTime, testTime, false},
+		{zeroTimestampReceivedAt, arrived, time.Time{}, received.UTC(), false},
+		// Without a receipt, as for a message handled outside the inbox,
+		// there is no arrival time to use
+		{zeroTimestampReceivedAt, context.Background(), time.Time{}, time.Time{}, false},
+	}
+	setupTest(t, nil)
+	for _, tt := range tests {
+		config.ZeroTimestamp = tt.policy
+		before := time.Now()
+		got, err := eventTime(tt.ctx, tt.ts)
+		after := time.Now()
+		switch {
+		case tt.wantErr:
+			if stageOf(err) != stageParse || !errors.Is(err, errMissingTimestamp) {
+				t.Errorf("%s: eventTime(%v) = %v, %v, want a missing timestamp parse error", tt.policy, tt.ts, got, err)
+			}
+		case err != nil:
+			t.Errorf("%s: eventTime(%v): %v", tt.policy, tt.ts, err)
+		case tt.want.IsZero():
+			if got.Before(before) || got.After(after) || got.Location() != time.UTC {
+				t.Errorf("%s: eventTime(%v) = %v, want the UTC time now", tt.policy, tt.ts, got)
+			}
+		case !got.Equal(tt.want) || got.Location() != tt.want.Location():
+			t.Errorf("%s: eventTime(%v) = %v, want %v", tt.policy, tt.ts, got, tt.want)
+		}
+	}
+}
+
+// An event without a timestamp is stored under the time the policy picks,
+// or not at all.
+func TestHandleMessageZeroTimestamp(t *testing.T) {
+	received := testTime.Add(-time.Minute)
+	ctx := withReceipt(context.Background(), receipt{at: received})
+	payloads := map[string]string{
+		"status":     `{"machine_id": 1, "status": "running"}`,
+		"production": `{"machine_id": 1, "parts_produced": 1, "parts_scrapped": 0, "timestamp": "0001-01-01T00:00:00Z"}`,
+		"operator":   `{"machine_id": 1, "operator_id": "op-17", "shift": "A"}`,
+	}
+	tables := map[string]string{"status": "status_events", "production": "production_events", "operator": "operator_events"}
+	for _, policy := range []string{zeroTimestampNow, zeroTimestampReject, zeroTimestampReceivedAt} {
+		for kind, payload := range payloads {
+			t.Run(policy+"/"+kind, func(t *testing.T) {
+				db := setupTest(t, map[string]string{"ZERO_TIMESTAMP": policy})
+				before := time.Now()
+				err := handleMessage(ctx, db, "factory/machine/1/"+kind, []byte(payload))
+				if policy == zeroTimestampReject {
+					if stageOf(err) != stageParse {
+						t.Fatalf("handleMessage = %v, want a %s error", err, stageParse)
+					}
+					if n := count(t, db, `SELECT COUNT(*) FROM `+tables[kind]); n != 0 {
+						t.Fatalf("stored %d rows of a rejected event", n)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("handleMessage: %v", err)
+				}
+				var at time.Time
+				if err := db.QueryRow(`SELECT time FROM ` + tables[kind]).Scan(&at); err != nil {
+					t.Fatal(err)
+				}
+				if policy == zeroTimestampReceivedAt {
+					if !at.Equal(received) {
+						t.Fatalf("stored at %v, want the arrival time %v", at, received)
+					}
+					return
+				}
+				if at.Before(before.Truncate(time.Microsecond)) || at.After(time.Now()) {
+					t.Fatalf("stored at %v, want the time it was handled", at)
+				}
+			})
+		}
+	}
+}
+
+func TestLoadConfigZeroTimestamp(t *testing.T) {
+	t.Setenv("ZERO_TIMESTAMP", "epoch")
+	if _, err := loadConfig(); err == nil {
+		t.Fatal("loadConfig accepted ZERO_TIMESTAMP=epoch")
+	}
+}
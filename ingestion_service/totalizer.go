@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// totalizer turns the parts counters of production events into parts
+// produced.
+var totalizer = newPartsTotalizer()
+
+// counterReading is a parts counter value and the time it was read.
+type counterReading struct {
+	value uint64
+	time  time.Time
+}
+
+// partsTotalizer follows the parts_total counter each machine may report
+// instead of counting parts per event, as a PLC totalizer does. The parts
+// an event stands for are the counter's increase since the machine's last
+// reading. The counter can also go down: it wraps to 0 after
+// TOTALIZER_MAX, or resets to 0 when the PLC restarts. Either way the
+// increase is counted across the boundary rather than as a negative or
+// huge delta.
+type partsTotalizer struct {
+	mu   sync.Mutex
+	last map[machineid.Key]counterReading
+}
+
+func newPartsTotalizer() *partsTotalizer {
+	return &partsTotalizer{last: map[machineid.Key]counterReading{}}
+}
+
+// increment returns the parts machine made up to the reading r, without
+// recording r; call record once the event is stored. The first reading
+// for a machine is compared with the latest one in the database, if any,
+// and a machine with none counts nothing until its next reading. A reading
+// no later than the last one, redelivered or out of order, counts nothing
+// either, since a later reading has already counted its parts.
+func (t *partsTotalizer) increment(db *sql.DB, machine machineid.Key, r counterReading) (int, error) {
+	if limit := config.TotalizerMax; limit > 0 && r.value > limit {
+		return 0, &stageError{stageParse, fmt.Errorf("parts_total %d is above TOTALIZER_MAX %d", r.value, limit)}
+	}
+	t.mu.Lock()
+	prev, ok := t.last[machine]
+	t.mu.Unlock()
+	if !ok {
+		// The lookup runs without the lock, so other machines' readings
+		// don't wait on the database
+		loaded, err := t.load(db, machine)
+		if err != nil {
+			return 0, &stageError{stageInsert, fmt.Errorf("failed to load last parts_total: %w", err)}
+		}
+		t.mu.Lock()
+		// Another reading of the machine may have been recorded meanwhile
+		if prev, ok = t.last[machine]; !ok {
+			prev = loaded
+			t.last[machine] = prev
+		}
+		t.mu.Unlock()
+	}
+	if prev.time.IsZero() || !r.time.After(prev.time) {
+		return 0, nil
+	}
+	if r.value >= prev.value {
+		return int(r.value - prev.value), nil
+	}
+
+	id := strconv.Itoa(machine.ID)
+	if n, ok := wrapped(prev.value, r.value, config.TotalizerMax); ok {
+		totalizerWraps.WithLabelValues(machine.Site, id).Inc()
+		log.Printf("machine %s: parts counter wrapped from %d to %d, counting %d part(s)", machine, prev.value, r.value, n)
+		return int(n), nil
+	}
+	totalizerResets.WithLabelValues(machine.Site, id).Inc()
+	log.Printf("machine %s: parts counter reset from %d to %d, counting %d part(s)", machine, prev.value, r.value, r.value)
+	return int(r.value), nil
+}
+
+// wrapped returns the parts counted by a counter that went from prev down
+// to cur by wrapping after limit, and whether that is a likelier
+// explanation than a reset to 0. A wrap must count at most 1% of the
+// counter's range, since the parts between two events are few, so a reset
+// is only mistaken for a wrap if it comes within that of limit. A prev
+// above limit, stored before TOTALIZER_MAX was lowered, can't have wrapped
+// at it.
+func wrapped(prev, cur, limit uint64) (uint64, bool) {
+	if limit == 0 || prev > limit {
+		return 0, false
+	}
+	n := limit - prev + 1 + cur
+	return n, n <= max(1, (limit+1)/100)
+}
+
+// record notes r as the last reading stored for machine.
+func (t *partsTotalizer) record(machine machineid.Key, r counterReading) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if prev, ok := t.last[machine]; !ok || r.time.After(prev.time) {
+		t.last[machine] = r
+	}
+}
+
+// load returns the latest reading stored for machine, or the zero reading
+// if there is none.
+func (t *partsTotalizer) load(db *sql.DB, machine machineid.Key) (counterReading, error) {
+	// Production events may be written elsewhere with
+	// INGEST_MAP_PRODUCTION_*, or without their counter
+	table, columns := mapped("production_events", "parts_total", "site", "machine_id", "time")
+	if columns[0] == "" {
+		return counterReading{}, nil
+	}
+	var r counterReading
+	query := fmt.Sprintf(`SELECT %s, %s FROM %s WHERE %s = $1 AND %s = $2 AND %s IS NOT NULL ORDER BY %s DESC LIMIT 1`,
+		columns[0], columns[3], table, columns[1], columns[2], columns[0], columns[3])
+	err := db.QueryRow(query, machine.Site, machine.ID).Scan(&r.value, &r.time)
+	if errors.Is(err, sql.ErrNoRows) {
+		return counterReading{}, nil
+	}
+	return r, err
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+func TestWrapped(t *testing.T) {
+	tests := []struct {
+		name           string
+		prev, cur, max uint64
+		want           uint64
+		wantWrapped    bool
+	}{
+		{"no limit", 90, 5, 0, 0, false},
+		{"wrap at the limit", 9998, 2, 9999, 4, true},
+		{"wrap from the limit to 0", 9999, 0, 9999, 1, true},
+		{"wrap of 1% of the range", 9950, 50, 9999, 100, true},
+		{"too far for a wrap", 9950, 51, 9999, 0, false},
+		{"reset from mid-range", 5000, 3, 9999, 0, false},
+		{"small limit", 3, 0, 3, 1, true},
+		{"prev above a lowered limit", 20000, 5, 9999, 0, false},
+		{"largest limit", ^uint64(0), 0, ^uint64(0), 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, ok := wrapped(tt.prev, tt.cur, tt.max)
+			if ok != tt.wantWrapped || (ok && n != tt.want) {
+				t.Fatalf("wrapped(%d, %d, %d) = %d, %v, want %d, %v", tt.prev, tt.cur, tt.max, n, ok, tt.want, tt.wantWrapped)
+			}
+		})
+	}
+}
+
+func TestTotalizerIncrement(t *testing.T) {
+	machine := machineid.Key{ID: 1}
+	at := func(sec int) time.Time { return testTime.Add(time.Duration(sec) * time.Second) }
+	type reading struct {
+		value uint64
+		sec   int
+		want  int
+	}
+	tests := []struct {
+		name     string
+		max      string
+		readings []reading
+	}{
+		{"first reading without history counts nothing", "0", []reading{{500, 0, 0}, {503, 1, 3}}},
+		{"steady increase", "0", []reading{{10, 0, 0}, {11, 1, 1}, {11, 2, 0}, {15, 3, 4}}},
+		{"wrap at TOTALIZER_MAX", "999", []reading{{997, 0, 0}, {999, 1, 2}, {2, 2, 3}, {4, 3, 2}}},
+		{"reset to 0", "999", []reading{{500, 0, 0}, {0, 1, 0}, {3, 2, 3}}},
+		{"reset to a small count", "0", []reading{{500, 0, 0}, {2, 1, 2}}},
+		{"redelivered reading", "0", []reading{{10, 0, 0}, {12, 1, 2}, {12, 1, 0}, {13, 2, 1}}},
+		{"out-of-order reading", "0", []reading{{10, 0, 0}, {14, 2, 4}, {12, 1, 0}, {15, 3, 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTest(t, map[string]string{"TOTALIZER_MAX": tt.max})
+			for i, r := range tt.readings {
+				reading := counterReading{r.value, at(r.sec)}
+				n, err := totalizer.increment(db, machine, reading)
+				if err != nil {
+					t.Fatalf("reading %d: %v", i, err)
+				}
+				if n != r.want {
+					t.Fatalf("reading %d (%d at %ds) counted %d, want %d", i, r.value, r.sec, n, r.want)
+				}
+				totalizer.record(machine, reading)
+			}
+		})
+	}
+}
+
+func TestTotalizerAboveMax(t *testing.T) {
+	db := setupTest(t, map[string]string{"TOTALIZER_MAX": "999"})
+	_, err := totalizer.increment(db, machineid.Key{ID: 1}, counterReading{1000, testTime})
+	if stageOf(err) != stageParse {
+		t.Fatalf("reading above TOTALIZER_MAX: %v, want a parse error", err)
+	}
+}
+
+// A restarted ingestor compares a machine's first reading with the last
+// one stored, so the parts made while it was down are counted.
+func TestTotalizerLoadsLastStoredReading(t *testing.T) {
+	db := setupTest(t, nil)
+	ctx := context.Background()
+	first := `{"machine_id": 1, "site": "plant-a", "parts_produced": 0, "parts_scrapped": 0, "parts_total": 100, "timestamp": "2025-11-05T10:00:00Z"}`
+	if err := handleMessage(ctx, db, "factory/plant-a/machine/1/production", []byte(first)); err != nil {
+		t.Fatalf("first: %v", err)
+	}
+
+	totalizer = newPartsTotalizer()
+	n, err := totalizer.increment(db, machineid.Key{Site: "plant-a", ID: 1}, counterReading{107, testTime.Add(time.Minute)})
+	if err != nil || n != 7 {
+		t.Fatalf("after restart counted %d, %v, want 7", n, err)
+	}
+	// Another site's machine 1 has no history
+	n, err = totalizer.increment(db, machineid.Key{Site: "plant-b", ID: 1}, counterReading{107, testTime.Add(time.Minute)})
+	if err != nil || n != 0 {
+		t.Fatalf("other site counted %d, %v, want 0", n, err)
+	}
+
+	var produced int
+	if err := db.QueryRow(`SELECT parts_produced FROM production_events WHERE site = 'plant-a' AND machine_id = 1`).Scan(&produced); err != nil {
+		t.Fatal(err)
+	}
+	if produced != 0 {
+		t.Fatalf("first reading stored %d parts, want 0", produced)
+	}
+}
+
+// A machine's last reading is looked up in the database without holding up
+// the readings of machines already known.
+func TestTotalizerLooksUpWithoutLock(t *testing.T) {
+	setupTest(t, nil)
+	db := stallingDB(t)
+	known, unknown := machineid.Key{ID: 1}, machineid.Key{ID: 2}
+	totalizer.record(known, counterReading{value: 10, time: testTime})
+
+	type result struct {
+		n   int
+		err error
+	}
+	first := make(chan result)
+	go func() {
+		n, err := totalizer.increment(db, unknown, counterReading{value: 7, time: testTime.Add(time.Second)})
+		first <- result{n, err}
+	}()
+	<-stalling.started
+	within(t, "increment", func() {
+		if n, err := totalizer.increment(db, known, counterReading{value: 12, time: testTime.Add(time.Second)}); err != nil || n != 2 {
+			t.Errorf("increment = %d, %v, want 2, nil", n, err)
+		}
+	})
+	// The machine's first reading is stored while its lookup is under way
+	totalizer.record(unknown, counterReading{value: 5, time: testTime})
+	close(stalling.release)
+	if r := <-first; r.err != nil || r.n != 2 {
+		t.Errorf("increment after the lookup = %d, %v, want 2, nil", r.n, r.err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/telemetry"
+)
+
+var tracer = otel.Tracer("github.com/SirNacou/OEE-Factory-Monitor/ingestion_service")
+
+// payloadTrace is the trace context a publisher may put in an event.
+type payloadTrace struct {
+	TraceID string `json:"trace_id"`
+	SpanID  string `json:"span_id"`
+}
+
+// payloadTraceID extracts the trace_id field from a raw payload, for
+// messages that failed before they could be parsed into an event. It
+// returns "" if there is none or the payload is not JSON.
+//
+// MQTT v5 publishers could carry the ID in a "trace_id" user property
+// instead, but the paho client used here speaks MQTT 3.1.1, which has no
+// properties, so the payload is the only source for now.
+func payloadTraceID(payload []byte) string {
+	var v payloadTrace
+	_ = json.Unmarshal(payload, &v)
+	return v.TraceID
+}
+
+// startReceiveSpan starts the span covering one message from receipt to
+// insert. When tracing is enabled and the payload carries the publisher's
+// trace_id and span_id, the span continues the publisher's trace.
+func startReceiveSpan(topic string, payload []byte) (context.Context, trace.Span) {
+	ctx := context.Background()
+	if telemetry.Enabled() {
+		var v payloadTrace
+		_ = json.Unmarshal(payload, &v)
+		traceID, err1 := trace.TraceIDFromHex(v.TraceID)
+		spanID, err2 := trace.SpanIDFromHex(v.SpanID)
+		if err1 == nil && err2 == nil {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID:    traceID,
+				SpanID:     spanID,
+				TraceFlags: trace.FlagsSampled,
+				Remote:     true,
+			}))
+		}
+	}
+	return tracer.Start(ctx, "receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("messaging.destination.name", topic)))
+}
+
+// parseEvent unmarshals payload, a kind event, into v inside a "parse"
+// span, counting failures by kind.
+func parseEvent(ctx context.Context, kind string, payload []byte, v any) error {
+	_, span := tracer.Start(ctx, "parse")
+	err := decode(payload, v)
+	if err != nil {
+		unmarshalErrors.WithLabelValues(kind, unmarshalReason(err)).Inc()
+	}
+	endSpan(span, err)
+	return err
+}
+
+// storeEvent writes r, a row for an event of machine, to every sink inside
+// an "insert" span, tagging it and the receive span with the machine.
+func storeEvent(ctx context.Context, machine machineid.Key, r record) error {
+	attrs := []attribute.KeyValue{attribute.String("site", machine.Site), attribute.Int("machine_id", machine.ID)}
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+	_, span := tracer.Start(ctx, "insert", trace.WithAttributes(attrs...))
+	err := sinks.Write(ctx, r)
+	endSpan(span, err)
+	return err
+}
+
+// endSpan marks span as failed if err is set, then ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// logStored logs a successfully stored event when INGEST_LOG_EVENTS is set,
+// so an event can be followed by its trace ID from the simulator's publish
+// log to the insert.
+func logStored(kind string, machine machineid.Key, traceID string) {
+	if !config.LogEvents {
+		return
+	}
+	if traceID == "" {
+		traceID = "-"
+	}
+	log.Printf("[trace %s] stored %s event for machine %s", traceID, kind, machine)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// transitionValidator flags status events whose transition from the
+// machine's last known status is not in the allowed set, such as
+// "stopped" -> "stopped" with no run in between. Flagged events are still
+// stored, with suspect = true, so they can be reviewed later.
+type transitionValidator struct {
+	allowed map[string]map[string]bool
+
+	mu   sync.Mutex
+	last map[machineid.Key]string
+}
+
+// newTransitionValidator returns a validator accepting the given transitions.
+func newTransitionValidator(allowed map[string]map[string]bool) *transitionValidator {
+	return &transitionValidator{allowed: allowed, last: map[machineid.Key]string{}}
+}
+
+// check records status as the machine's latest and reports whether the
+// transition into it is suspect. The first status seen for a machine is
+// compared with the latest one stored in the database, if any.
+func (v *transitionValidator) check(db *sql.DB, machine machineid.Key, status string) bool {
+	v.mu.Lock()
+	_, ok := v.last[machine]
+	v.mu.Unlock()
+	// The lookup runs without the lock, so other machines' events don't
+	// wait on the database
+	var stored string
+	if !ok {
+		var err error
+		if stored, err = v.stored(db, machine); err != nil {
+			log.Printf("failed to load last status for machine %s: %v", machine, err)
+		}
+	}
+
+	v.mu.Lock()
+	// Another event of the machine may have been checked meanwhile
+	prev, ok := v.last[machine]
+	if !ok {
+		prev = stored
+	}
+	v.last[machine] = status
+	v.mu.Unlock()
+
+	if prev == "" || v.allowed[prev][status] {
+		return false
+	}
+	suspectTransitions.WithLabelValues(prev, status).Inc()
+	log.Printf("suspect transition for machine %s: %s -> %s", machine, prev, status)
+	return true
+}
+
+// stored returns the latest status stored for machine, or "" if there is
+// none.
+func (v *transitionValidator) stored(db *sql.DB, machine machineid.Key) (string, error) {
+	// Status events may be written elsewhere with INGEST_MAP_STATUS_*, or
+	// without their status
+	table, columns := mapped("status_events", "status", "site", "machine_id", "time")
+	if columns[0] == "" {
+		return "", nil
+	}
+	var status string
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE %s = $1 AND %s = $2 ORDER BY %s DESC LIMIT 1`, columns[0], table, columns[1], columns[2], columns[3])
+	err := db.QueryRow(query, machine.Site, machine.ID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return status, err
+}
+
+// parseTransitions parses a comma-separated list of "from>to" pairs, e.g.
+// "running>stopped,stopped>running".
+func parseTransitions(s string) (map[string]map[string]bool, error) {
+	allowed := map[string]map[string]bool{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(pair, ">")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid transition %q: want from>to", pair)
+		}
+		if allowed[from] == nil {
+			allowed[from] = map[string]bool{}
+		}
+		allowed[from][to] = true
+	}
+	return allowed, nil
+}
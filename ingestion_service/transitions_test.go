@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/machineid"
+)
+
+// stallingDriver is a database/sql driver whose queries wait until release
+// is closed and then find no rows, so a test can tell whether the caller
+// holds a lock across its query. Each query announces itself on started.
+type stallingDriver struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (d *stallingDriver) Open(string) (driver.Conn, error) { return stallingConn{d}, nil }
+
+type stallingConn struct{ d *stallingDriver }
+
+func (c stallingConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	c.d.started <- struct{}{}
+	<-c.d.release
+	return noRows{}, nil
+}
+
+func (stallingConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (stallingConn) Close() error                        { return nil }
+func (stallingConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+type noRows struct{}
+
+func (noRows) Columns() []string         { return nil }
+func (noRows) Close() error              { return nil }
+func (noRows) Next([]driver.Value) error { return io.EOF }
+
+var stalling = &stallingDriver{}
+
+func init() {
+	sql.Register("stalling", stalling)
+}
+
+// stallingDB returns a database whose queries stall until the test closes
+// stalling.release.
+func stallingDB(t *testing.T) *sql.DB {
+	t.Helper()
+	*stalling = stallingDriver{started: make(chan struct{}, 1), release: make(chan struct{})}
+	db, err := sql.Open("stalling", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// within fails the test unless f returns within a second.
+func within(t *testing.T, what string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("%s waited on another machine's database lookup", what)
+	}
+}
+
+// A machine's first status is looked up in the database without holding up
+// the checks of machines already known.
+func TestTransitionValidatorLooksUpWithoutLock(t *testing.T) {
+	db := stallingDB(t)
+	v := newTransitionValidator(map[string]map[string]bool{"running": {"stopped": true}})
+	known, unknown := machineid.Key{ID: 1}, machineid.Key{ID: 2}
+	v.last[known] = "running"
+
+	first := make(chan bool)
+	go func() { first <- v.check(db, unknown, "running") }()
+	<-stalling.started
+	within(t, "check", func() {
+		if v.check(db, known, "stopped") {
+			t.Error("running -> stopped was suspect")
+		}
+	})
+	close(stalling.release)
+	if <-first {
+		t.Error("first status of a machine without history was suspect")
+	}
+	if got := v.last[unknown]; got != "running" {
+		t.Errorf("last status = %q, want running", got)
+	}
+}
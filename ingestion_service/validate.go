@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/events"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/jsonschema"
+)
+
+// schemas holds the compiled schema of each event kind; nil unless
+// VALIDATE_SCHEMA is set.
+var schemas map[string]*jsonschema.Schema
+
+// loadSchemas compiles the schemas the events package embeds.
+func loadSchemas() (map[string]*jsonschema.Schema, error) {
+	out := map[string]*jsonschema.Schema{}
+	for _, kind := range []string{events.KindStatus, events.KindProduction, events.KindLifecycle, events.KindOperator, events.KindInspection} {
+		raw, err := events.Schema(kind)
+		if err != nil {
+			return nil, err
+		}
+		if out[kind], err = jsonschema.Compile(raw); err != nil {
+			return nil, fmt.Errorf("%s schema: %w", kind, err)
+		}
+	}
+	return out, nil
+}
+
+// validateSchema checks payload, a kind event, against its schema inside a
+// "validate" span, so values that parse but make no sense, such as a
+// negative part count or an unknown status, are rejected before they reach
+// the analytics. A payload that isn't JSON passes, to fail parsing instead.
+func validateSchema(ctx context.Context, kind string, payload []byte) error {
+	schema, ok := schemas[kind]
+	if !ok {
+		return nil
+	}
+	_, span := tracer.Start(ctx, "validate")
+	violations, err := schema.Validate(payload)
+	if err != nil || len(violations) == 0 {
+		endSpan(span, nil)
+		return nil
+	}
+	msgs := make([]string, len(violations))
+	for i, v := range violations {
+		msgs[i] = v.String()
+	}
+	schemaViolations.WithLabelValues(kind).Inc()
+	err = fmt.Errorf("payload violates the %s schema (%s): %s", kind, events.SchemaVersion, strings.Join(msgs, "; "))
+	endSpan(span, err)
+	return err
+}
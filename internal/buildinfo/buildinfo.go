@@ -0,0 +1,121 @@
+// Package buildinfo reports which build of a service is running, for the
+// /version endpoint and the startup log.
+//
+// Version, Commit and BuildTime are set at build time with -ldflags, e.g.
+//
+//	go build -ldflags "-X github.com/SirNacou/OEE-Factory-Monitor/internal/buildinfo.Version=v1.2.0
+//	  -X github.com/SirNacou/OEE-Factory-Monitor/internal/buildinfo.Commit=$(git rev-parse HEAD)
+//	  -X github.com/SirNacou/OEE-Factory-Monitor/internal/buildinfo.BuildTime=$(date -u +%FT%TZ)"
+//
+// Without them, Commit and BuildTime fall back to the VCS details the Go
+// toolchain embeds when building inside a git checkout.
+package buildinfo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/configdump"
+)
+
+// Set with -ldflags "-X".
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+// Info is the body served by /version.
+type Info struct {
+	Service   string `json:"service"`
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+	// ConfigHash identifies the effective configuration, so instances
+	// running with different settings can be told apart. Secrets are
+	// redacted before hashing, so it doesn't reveal or track them.
+	ConfigHash string `json:"config_hash"`
+}
+
+// Get returns the build of service running with cfg.
+func Get(service string, cfg any) Info {
+	info := Info{
+		Service:    service,
+		Version:    Version,
+		Commit:     Commit,
+		BuildTime:  BuildTime,
+		GoVersion:  runtime.Version(),
+		ConfigHash: ConfigHash(cfg),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok && info.Commit == "" {
+		modified := false
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				info.Commit = s.Value
+			case "vcs.time":
+				if info.BuildTime == "" {
+					info.BuildTime = s.Value
+				}
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if modified && info.Commit != "" {
+			info.Commit += "-dirty"
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
+
+// String formats info for the startup log.
+func (info Info) String() string {
+	return fmt.Sprintf("%s %s (commit %s, built %s, %s, config %s)",
+		info.Service, info.Version, info.Commit, info.BuildTime, info.GoVersion, info.ConfigHash)
+}
+
+// ConfigHash returns a short hash of cfg as rendered by /debug/config.
+// Rendering sorts the keys, so equal configurations hash the same. Fields
+// tagged `instance:"true"`, such as an MQTT client ID that must differ
+// between replicas, are left out so identically configured replicas match.
+func ConfigHash(cfg any) string {
+	dumped := configdump.Dump(cfg)
+	if m, ok := dumped.(map[string]any); ok {
+		t := reflect.TypeOf(cfg)
+		for i := 0; t.Kind() == reflect.Struct && i < t.NumField(); i++ {
+			if t.Field(i).Tag.Get("instance") == "true" {
+				delete(m, t.Field(i).Name)
+			}
+		}
+	}
+	data, err := json.Marshal(dumped)
+	if err != nil {
+		return "unknown"
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// Handler serves the build of service as JSON. cfg is called on every
+// request so reloaded settings are reflected in the hash.
+func Handler(service string, cfg func() any) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(Get(service, cfg())); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
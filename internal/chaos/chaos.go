@@ -0,0 +1,49 @@
+// Package chaos degrades the services' I/O on purpose, so a test can check
+// that the retries, dead-lettering and backpressure meant to cope with a
+// slow or failing broker or database actually engage.
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// ErrInjected is the error of an injected failure.
+var ErrInjected = errors.New("chaos: injected failure")
+
+// Faults is how an operation is degraded: each call takes Latency longer
+// and fails with chance ErrorRate. The zero value injects nothing.
+type Faults struct {
+	Latency   time.Duration
+	ErrorRate float64
+}
+
+// Enabled reports whether f injects anything.
+func (f Faults) Enabled() bool {
+	return f.Latency > 0 || f.ErrorRate > 0
+}
+
+// Fail returns ErrInjected with chance f.ErrorRate, and nil otherwise. It
+// draws from the global source, so injecting faults doesn't disturb seeded
+// generators.
+func (f Faults) Fail() error {
+	if f.ErrorRate > 0 && rand.Float64() < f.ErrorRate {
+		return ErrInjected
+	}
+	return nil
+}
+
+// Inject sleeps for f.Latency, then fails as Fail does.
+func (f Faults) Inject() error {
+	if f.Latency > 0 {
+		time.Sleep(f.Latency)
+	}
+	return f.Fail()
+}
+
+// String describes f for the startup log.
+func (f Faults) String() string {
+	return fmt.Sprintf("%v added latency, %g%% failures", f.Latency, 100*f.ErrorRate)
+}
@@ -0,0 +1,82 @@
+// Package cliflags lets the services take their settings as command-line
+// flags as well as environment variables, for local runs where exporting a
+// dozen variables is tedious.
+//
+// Every flag is named after its environment variable, lower-cased with
+// dashes for underscores, so MQTT_BROKER_URL is -mqtt-broker-url. Parse
+// copies the flags given into the environment, overriding what is set
+// there, in .env and in CONFIG_FILE, and the services then read and
+// validate their settings exactly as they do without flags.
+package cliflags
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Option is a setting, held in the environment variable Env.
+type Option struct {
+	Env   string
+	Usage string
+	// Bool makes the flag a switch: -selftest alone means -selftest=true.
+	Bool bool
+}
+
+// Name returns the flag of the environment variable env.
+func Name(env string) string {
+	return strings.ReplaceAll(strings.ToLower(env), "_", "-")
+}
+
+// envValue sets an environment variable from a flag.
+type envValue struct {
+	env    string
+	isBool bool
+}
+
+func (v envValue) String() string     { return "" }
+func (v envValue) Set(s string) error { return os.Setenv(v.env, s) }
+func (v envValue) IsBoolFlag() bool   { return v.isBool }
+
+// Parse parses args, the command line without the program name, as flags
+// for options and sets the environment variable of each flag given. -h
+// prints every option with its variable and, if defaults knows it, its
+// default, then exits; an unknown flag or a stray argument exits with
+// status 2. defaults is only called for -h.
+func Parse(program string, args []string, options []Option, footer string, defaults func() map[string]string) {
+	fs := flag.NewFlagSet(program, flag.ExitOnError)
+	for _, o := range options {
+		fs.Var(envValue{env: o.Env, isBool: o.Bool}, Name(o.Env), o.Usage)
+	}
+	fs.Usage = func() {
+		printUsage(fs.Output(), program, options, footer, defaults())
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected argument %q; settings are given as -name=value flags\n", fs.Arg(0))
+		os.Exit(2)
+	}
+}
+
+// printUsage writes the -h text.
+func printUsage(w io.Writer, program string, options []Option, footer string, defaults map[string]string) {
+	fmt.Fprintf(w, "Usage: %s [flags]\n\n", program)
+	fmt.Fprintf(w, "Every flag sets the environment variable named after it, taking precedence\n")
+	fmt.Fprintf(w, "over the variable set anywhere else. Unset settings take their defaults.\n\n")
+	for _, o := range options {
+		arg := " value"
+		if o.Bool {
+			arg = ""
+		}
+		fmt.Fprintf(w, "  -%s%s\n    \t%s\n    \tenv %s", Name(o.Env), arg, o.Usage, o.Env)
+		if def := defaults[o.Env]; def != "" {
+			fmt.Fprintf(w, ", default %q", def)
+		}
+		fmt.Fprintln(w)
+	}
+	if footer != "" {
+		fmt.Fprintf(w, "\n%s\n", footer)
+	}
+}
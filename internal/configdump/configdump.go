@@ -0,0 +1,111 @@
+// Package configdump renders a service's resolved configuration for the
+// /debug/config endpoint, with secrets redacted.
+package configdump
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"reflect"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Dump converts cfg into a JSON-friendly value keyed by field name.
+// Durations are rendered as strings like "1m30s" and embedded structs are
+// flattened. Non-empty string fields tagged `secret:"true"` are replaced by
+// "[REDACTED]", and fields tagged `secret:"url"` keep the URL but have the
+// password in its userinfo masked.
+func Dump(cfg any) any {
+	return dump(reflect.ValueOf(cfg), "")
+}
+
+func dump(v reflect.Value, secret string) any {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return dump(v.Elem(), secret)
+	case reflect.Struct:
+		out := map[string]any{}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			val := dump(v.Field(i), f.Tag.Get("secret"))
+			if m, ok := val.(map[string]any); ok && f.Anonymous {
+				maps.Copy(out, m)
+				continue
+			}
+			out[f.Name] = val
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		out := make([]any, v.Len())
+		for i := range out {
+			out[i] = dump(v.Index(i), secret)
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		out := map[string]any{}
+		iter := v.MapRange()
+		for iter.Next() {
+			out[fmt.Sprint(iter.Key().Interface())] = dump(iter.Value(), secret)
+		}
+		return out
+	case reflect.String:
+		return redactString(v.String(), secret)
+	case reflect.Chan, reflect.Func:
+		return nil
+	default:
+		return v.Interface()
+	}
+}
+
+func redactString(s, secret string) string {
+	switch {
+	case s == "" || secret == "":
+		return s
+	case secret == "url":
+		u, err := url.Parse(s)
+		if err != nil {
+			return redacted
+		}
+		return u.Redacted()
+	default:
+		return redacted
+	}
+}
+
+// Handler serves the redacted configuration returned by cfg as JSON. cfg is
+// called on every request so reloaded settings are reflected.
+func Handler(cfg func() any) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(Dump(cfg())); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+// Package configfile loads service settings from a YAML or TOML file named by
+// CONFIG_FILE, as an alternative to setting dozens of environment variables.
+//
+// The file holds the same settings as the environment, under the same names:
+// each key is an environment variable name, matched case-insensitively, and
+// nested sections join their keys with underscores, so
+//
+//	mqtt:
+//	  broker_url: tcp://localhost:1883
+//
+// sets MQTT_BROKER_URL. Lists become the comma-separated form the variables
+// use. Load copies every value into the environment unless the variable is
+// already set there, so the environment overrides the file, and the services
+// then read and validate their settings exactly as they do without a file.
+// Calling Load again re-reads the file, replacing the values it set before.
+package configfile
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// applied lists the variables the last Load set from the file.
+var applied []string
+
+// Load applies the file named by CONFIG_FILE, if set, to the environment and
+// returns its path. Variables set by an earlier Load are unset first, so
+// settings removed from the file go back to their defaults; if the file
+// can't be read or parsed, they are left as they were.
+func Load() (string, error) {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return path, fmt.Errorf("read CONFIG_FILE: %w", err)
+	}
+	settings, err := Parse(path, data)
+	if err != nil {
+		return path, fmt.Errorf("invalid CONFIG_FILE %s: %w", path, err)
+	}
+	for _, key := range applied {
+		os.Unsetenv(key)
+	}
+	applied = nil
+	for key, value := range settings {
+		if os.Getenv(key) == "" {
+			os.Setenv(key, value)
+			applied = append(applied, key)
+		}
+	}
+	return path, nil
+}
+
+// Parse decodes a config file, in YAML or TOML according to name's
+// extension, into environment variable names and values.
+func Parse(name string, data []byte) (map[string]string, error) {
+	var doc map[string]any
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q: use .yaml, .yml or .toml", ext)
+	}
+
+	settings := map[string]string{}
+	if err := flatten("", doc, settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// envName is what a key must look like once flattened.
+var envName = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// flatten adds the settings in section to out, with their keys prefixed by
+// prefix.
+func flatten(prefix string, section map[string]any, out map[string]string) error {
+	for _, key := range slices.Sorted(maps.Keys(section)) {
+		name := strings.ToUpper(key)
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		if !envName.MatchString(name) {
+			return fmt.Errorf("key %s: must be letters, digits and underscores", name)
+		}
+		if _, dup := out[name]; dup {
+			return fmt.Errorf("key %s is set twice", name)
+		}
+
+		switch v := section[key].(type) {
+		case nil:
+			// An empty value leaves the setting at its default
+		case map[string]any:
+			if err := flatten(name, v, out); err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				s, err := scalar(item)
+				if err != nil {
+					return fmt.Errorf("key %s: %w", name, err)
+				}
+				items[i] = s
+			}
+			out[name] = strings.Join(items, ",")
+		default:
+			s, err := scalar(v)
+			if err != nil {
+				return fmt.Errorf("key %s: %w", name, err)
+			}
+			out[name] = s
+		}
+	}
+	return nil
+}
+
+// scalar formats a single value the way it would be written in an
+// environment variable.
+func scalar(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v of type %T", v, v)
+	}
+}
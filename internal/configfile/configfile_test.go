@@ -0,0 +1,177 @@
+package configfile
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const yamlDoc = `
+mqtt:
+  broker_url: tcp://broker:1883
+  qos: 1
+  topic_prefixes: [factory, factory/+]
+ingest_workers: 4
+validate_schema: true
+status_compact_window: 1.5
+api_token:
+db:
+  postgres:
+    host: db
+`
+
+const tomlDoc = `
+ingest_workers = 4
+validate_schema = true
+status_compact_window = 1.5
+
+[mqtt]
+broker_url = "tcp://broker:1883"
+qos = 1
+topic_prefixes = ["factory", "factory/+"]
+
+[db.postgres]
+host = "db"
+`
+
+var wantSettings = map[string]string{
+	"MQTT_BROKER_URL":       "tcp://broker:1883",
+	"MQTT_QOS":              "1",
+	"MQTT_TOPIC_PREFIXES":   "factory,factory/+",
+	"INGEST_WORKERS":        "4",
+	"VALIDATE_SCHEMA":       "true",
+	"STATUS_COMPACT_WINDOW": "1.5",
+	"DB_POSTGRES_HOST":      "db",
+}
+
+func TestParseFormatsAgree(t *testing.T) {
+	for _, tt := range []struct{ name, doc string }{
+		{"config.yaml", yamlDoc},
+		{"config.YML", yamlDoc},
+		{"config.toml", tomlDoc},
+	} {
+		got, err := Parse(tt.name, []byte(tt.doc))
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", tt.name, err)
+		}
+		if !maps.Equal(got, wantSettings) {
+			t.Fatalf("Parse(%s) = %v, want %v", tt.name, got, wantSettings)
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name, doc string
+		want      map[string]string
+	}{
+		{"case-insensitive", "Mqtt:\n  Broker_URL: tcp://a:1883\n", map[string]string{"MQTT_BROKER_URL": "tcp://a:1883"}},
+		{"deeply nested", "a:\n  b:\n    c:\n      d: x\n", map[string]string{"A_B_C_D": "x"}},
+		{"flat and nested side by side", "mqtt_qos: 1\nmqtt:\n  broker_url: x\n", map[string]string{"MQTT_QOS": "1", "MQTT_BROKER_URL": "x"}},
+		{"empty file", "", map[string]string{}},
+		{"empty section", "mqtt: {}\n", map[string]string{}},
+		{"quoted number", "ingest_workers: \"08\"\n", map[string]string{"INGEST_WORKERS": "08"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse("config.yaml", []byte(tt.doc))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Fatalf("Parse = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct{ name, file, doc string }{
+		{"unknown format", "config.json", `{"a": 1}`},
+		{"invalid YAML", "config.yaml", "mqtt: [\n"},
+		{"invalid TOML", "config.toml", "mqtt = \n"},
+		{"key with a dash", "config.yaml", "mqtt-broker-url: x\n"},
+		{"nested key with a dot", "config.toml", "[mqtt]\n\"broker.url\" = \"x\"\n"},
+		{"key starting with a digit", "config.yaml", "1mqtt: x\n"},
+		{"key set flat and nested", "config.yaml", "mqtt_qos: 1\nmqtt:\n  qos: 2\n"},
+		{"key set in two cases", "config.yaml", "mqtt_qos: 1\nMQTT_QOS: 2\n"},
+		{"list of sections", "config.yaml", "sites:\n  - name: a\n"},
+		{"nested list", "config.toml", "prefixes = [[\"a\"]]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Parse(tt.file, []byte(tt.doc)); err == nil {
+				t.Fatalf("Parse(%s) = %v, want error", tt.file, got)
+			}
+		})
+	}
+}
+
+// writeConfig writes doc to a file named name and points CONFIG_FILE at it.
+func writeConfig(t *testing.T, name, doc string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(doc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+}
+
+func TestLoad(t *testing.T) {
+	for key := range wantSettings {
+		t.Setenv(key, "")
+	}
+	// The environment overrides the file
+	t.Setenv("INGEST_WORKERS", "16")
+	t.Cleanup(func() { applied = nil })
+
+	writeConfig(t, "config.yaml", yamlDoc)
+	if _, err := Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for key, want := range wantSettings {
+		if key == "INGEST_WORKERS" {
+			want = "16"
+		}
+		if got := os.Getenv(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+
+	// Reloading drops the settings removed from the file, but not what the
+	// environment set
+	writeConfig(t, "config.toml", "[mqtt]\nqos = 2\n")
+	if _, err := Load(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got := os.Getenv("MQTT_QOS"); got != "2" {
+		t.Errorf("MQTT_QOS = %q after reload, want 2", got)
+	}
+	if got := os.Getenv("MQTT_BROKER_URL"); got != "" {
+		t.Errorf("MQTT_BROKER_URL = %q after it was removed from the file", got)
+	}
+	if got := os.Getenv("INGEST_WORKERS"); got != "16" {
+		t.Errorf("INGEST_WORKERS = %q after reload, want 16", got)
+	}
+
+	// A file that fails to parse leaves the settings as they were
+	writeConfig(t, "config.yaml", "mqtt: [\n")
+	if _, err := Load(); err == nil {
+		t.Fatal("Load of an invalid file succeeded")
+	}
+	if got := os.Getenv("MQTT_QOS"); got != "2" {
+		t.Errorf("MQTT_QOS = %q after a failed reload, want 2", got)
+	}
+}
+
+func TestLoadWithoutFile(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	if path, err := Load(); path != "" || err != nil {
+		t.Fatalf("Load = %q, %v, want no file", path, err)
+	}
+	t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+	if _, err := Load(); err == nil {
+		t.Fatal("Load of a missing file succeeded")
+	}
+}
@@ -0,0 +1,107 @@
+// Package connstr builds the broker URLs and Postgres connection strings the
+// services connect with, so IPv6 literals, non-default ports and unix
+// sockets work the same way everywhere.
+package connstr
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// defaultPorts are the ports assumed for a broker URL without one. Websocket
+// URLs are left alone: their dialer already defaults to 80 and 443.
+var defaultPorts = map[string]string{
+	"tcp":   "1883",
+	"mqtt":  "1883",
+	"ssl":   "8883",
+	"tls":   "8883",
+	"tcps":  "8883",
+	"mqtts": "8883",
+}
+
+// BrokerURL validates an MQTT broker address and returns it in the form the
+// MQTT client expects. It accepts:
+//
+//	tcp://broker:1883      as is
+//	broker:1883            tcp:// is assumed
+//	tcp://broker           the scheme's default port is added
+//	tcp://[::1]:1883       IPv6 literals, which must be bracketed
+//	unix:///run/mqtt.sock  unix sockets
+//
+// An unbracketed IPv6 literal is rejected rather than guessed at, since in
+// "::1:1883" the port can't be told apart from the address.
+func BrokerURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", fmt.Errorf("broker URL is empty")
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "tcp://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid broker URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		if u.Host == "" && u.Path == "" {
+			return "", fmt.Errorf("invalid broker URL %q: missing socket path", u.Redacted())
+		}
+		return u.String(), nil
+	case "ws", "wss":
+	default:
+		if _, ok := defaultPorts[u.Scheme]; !ok {
+			return "", fmt.Errorf("invalid broker URL %q: unsupported scheme %q", u.Redacted(), u.Scheme)
+		}
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return "", fmt.Errorf("invalid broker URL %q: missing host", u.Redacted())
+	}
+	if strings.Contains(host, ":") && !strings.HasPrefix(u.Host, "[") {
+		return "", fmt.Errorf("invalid broker URL %q: bracket IPv6 addresses, e.g. tcp://[::1]:1883", u.Redacted())
+	}
+	if u.Port() == "" && strings.HasSuffix(u.Host, ":") {
+		return "", fmt.Errorf("invalid broker URL %q: empty port", u.Redacted())
+	}
+	if port, ok := defaultPorts[u.Scheme]; ok && u.Port() == "" {
+		// JoinHostPort brackets IPv6 hosts again; Hostname keeps any zone.
+		u.Host = net.JoinHostPort(host, port)
+	}
+	return u.String(), nil
+}
+
+// Postgres returns a lib/pq connection string. host may be a hostname, an
+// IPv4 or IPv6 address with or without brackets, or a directory holding the
+// server's unix socket, such as /var/run/postgresql. Every value is quoted,
+// so passwords with spaces or quotes survive.
+func Postgres(host, port, user, password, dbname string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	pairs := []struct{ key, value string }{
+		{"host", host},
+		{"port", port},
+		{"user", user},
+		{"password", password},
+		{"dbname", dbname},
+		{"sslmode", "disable"},
+	}
+	parts := make([]string, len(pairs))
+	for i, p := range pairs {
+		parts[i] = p.key + "=" + quote(p.value)
+	}
+	return strings.Join(parts, " ")
+}
+
+// quote quotes a connection string value as libpq does: in single quotes,
+// with backslashes and single quotes escaped.
+func quote(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
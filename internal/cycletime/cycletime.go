@@ -0,0 +1,63 @@
+// Package cycletime parses the machine × product ideal cycle time matrix
+// shared by the simulator, which runs machines at these speeds, and the API,
+// which measures performance against them.
+package cycletime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AnyMachine in the machine position of an entry applies it to every machine
+// without a more specific entry for the product.
+const AnyMachine = "*"
+
+// Matrix maps a product to the ideal cycle time per machine ID, with
+// AnyMachine as the fallback for the product.
+type Matrix map[string]map[string]time.Duration
+
+// Parse reads a matrix from comma-separated machine:product=seconds entries,
+// e.g. "1:widget=2.5,*:widget=3,2:gadget=4". Seconds may be fractional.
+func Parse(s string) (Matrix, error) {
+	m := Matrix{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, secs, ok := strings.Cut(entry, "=")
+		machine, product, ok2 := strings.Cut(key, ":")
+		machine, product = strings.TrimSpace(machine), strings.TrimSpace(product)
+		if !ok || !ok2 || machine == "" || product == "" {
+			return nil, fmt.Errorf("invalid cycle time %q: want machine:product=seconds", entry)
+		}
+		if machine != AnyMachine {
+			if _, err := strconv.Atoi(machine); err != nil {
+				return nil, fmt.Errorf("invalid cycle time %q: machine must be an ID or %s", entry, AnyMachine)
+			}
+		}
+		sec, err := strconv.ParseFloat(strings.TrimSpace(secs), 64)
+		if err != nil || sec <= 0 {
+			return nil, fmt.Errorf("invalid cycle time %q: seconds must be a positive number", entry)
+		}
+		if m[product] == nil {
+			m[product] = map[string]time.Duration{}
+		}
+		m[product][machine] = time.Duration(sec * float64(time.Second))
+	}
+	return m, nil
+}
+
+// Lookup returns the ideal cycle time of product on machineID, falling back
+// to the product's AnyMachine entry. ok is false if neither exists. Entries
+// name machine IDs alone, so an entry applies to its ID at every site.
+func (m Matrix) Lookup(machineID int, product string) (d time.Duration, ok bool) {
+	byMachine := m[product]
+	if d, ok = byMachine[strconv.Itoa(machineID)]; ok {
+		return d, true
+	}
+	d, ok = byMachine[AnyMachine]
+	return d, ok
+}
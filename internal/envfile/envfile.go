@@ -0,0 +1,32 @@
+// Package envfile reads settings that can be given either in an environment
+// variable or in a file named by its _FILE variant, the way Docker and
+// Kubernetes mount secrets.
+package envfile
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Get returns the value of the environment variable key or, if key_FILE is
+// set instead, the contents of that file with surrounding whitespace, such
+// as a trailing newline, trimmed. If neither is set it returns def. Setting
+// both is an error, since it's unclear which was meant.
+func Get(key, def string) (string, error) {
+	path := os.Getenv(key + "_FILE")
+	if path == "" {
+		if v := os.Getenv(key); v != "" {
+			return v, nil
+		}
+		return def, nil
+	}
+	if os.Getenv(key) != "" {
+		return "", fmt.Errorf("%s and %s_FILE are both set", key, key)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read %s_FILE: %w", key, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
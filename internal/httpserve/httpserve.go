@@ -0,0 +1,25 @@
+// Package httpserve starts the HTTP servers the services run next to their
+// main work, for metrics and probes.
+package httpserve
+
+import (
+	"log"
+	"net"
+	"net/http"
+)
+
+// Start listens on addr and serves h in the background. It binds before
+// returning, so an address already in use is the caller's error to handle
+// rather than only a log line from the server goroutine.
+func Start(addr string, h http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := http.Serve(ln, h); err != nil {
+			log.Printf("HTTP server on %s stopped: %v", addr, err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,253 @@
+// Package jsonschema validates JSON documents against the subset of JSON
+// Schema the event schemas use: type, properties, required,
+// additionalProperties (as a boolean), items, enum, minimum, maximum,
+// exclusiveMinimum, minLength, pattern and the date-time format. A schema
+// using any other keyword fails to compile rather than going unenforced.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is a compiled schema, or one of its subschemas.
+type Schema struct {
+	// Annotations, which don't affect validation.
+	Meta        string `json:"$schema"`
+	ID          string `json:"$id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+
+	Type                 types              `json:"type"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []json.RawMessage  `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum"`
+	MinLength            *int               `json:"minLength"`
+	Pattern              string             `json:"pattern"`
+	Format               string             `json:"format"`
+
+	pattern *regexp.Regexp
+	enum    []string // Enum, compacted for comparison
+}
+
+// types is the "type" keyword: a single type name or a list of them.
+type types []string
+
+func (t *types) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*t = types{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+	*t = many
+	return nil
+}
+
+// knownTypes are the JSON Schema type names.
+var knownTypes = []string{"object", "array", "string", "integer", "number", "boolean", "null"}
+
+// Compile parses the JSON Schema in raw.
+func Compile(raw []byte) (*Schema, error) {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.DisallowUnknownFields()
+	var s Schema
+	if err := d.Decode(&s); err != nil {
+		return nil, err
+	}
+	if err := s.compile(""); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// compile checks s, the subschema at path, and prepares it for validation.
+func (s *Schema) compile(path string) error {
+	for _, t := range s.Type {
+		if !slices.Contains(knownTypes, t) {
+			return fmt.Errorf("%s: unknown type %q", pathOrRoot(path), t)
+		}
+	}
+	if s.Format != "" && s.Format != "date-time" {
+		return fmt.Errorf("%s: unsupported format %q", pathOrRoot(path), s.Format)
+	}
+	if s.Pattern != "" {
+		var err error
+		if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", pathOrRoot(path), err)
+		}
+	}
+	for _, v := range s.Enum {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, v); err != nil {
+			return fmt.Errorf("%s: invalid enum value: %w", pathOrRoot(path), err)
+		}
+		s.enum = append(s.enum, buf.String())
+	}
+	for name, p := range s.Properties {
+		if err := p.compile(path + "/" + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile(path + "/items")
+	}
+	return nil
+}
+
+// Violation is one way a document breaks its schema.
+type Violation struct {
+	// Path is the JSON Pointer of the offending value; empty for the
+	// document itself.
+	Path    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return pathOrRoot(v.Path) + ": " + v.Message
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// Validate checks the JSON document doc against s and returns every
+// violation, properties in name order, or none if it is valid. It returns an
+// error only if doc is not JSON. An integer is a number without a fraction
+// or exponent.
+func (s *Schema) Validate(doc []byte) ([]Violation, error) {
+	d := json.NewDecoder(bytes.NewReader(doc))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	var out []Violation
+	s.validate("", v, &out)
+	return out, nil
+}
+
+func (s *Schema) validate(path string, v any, out *[]Violation) {
+	fail := func(format string, args ...any) {
+		*out = append(*out, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(t string) bool { return isType(v, t) }) {
+		fail("must be %s, not %s", strings.Join(s.Type, " or "), typeOf(v))
+		return
+	}
+	if len(s.enum) > 0 {
+		b, _ := json.Marshal(v)
+		if !slices.Contains(s.enum, string(b)) {
+			fail("must be one of %s", strings.Join(s.enum, ", "))
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p, ok := s.Properties[name]
+			switch {
+			case ok:
+				p.validate(path+"/"+name, v[name], out)
+			case s.AdditionalProperties != nil && !*s.AdditionalProperties:
+				*out = append(*out, Violation{Path: path + "/" + name, Message: "is not allowed"})
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(path+"/"+strconv.Itoa(i), item, out)
+			}
+		}
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			fail("must be at least %g, not %s", *s.Minimum, v)
+		}
+		if s.ExclusiveMinimum != nil && f <= *s.ExclusiveMinimum {
+			fail("must be greater than %g, not %s", *s.ExclusiveMinimum, v)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			fail("must be at most %g, not %s", *s.Maximum, v)
+		}
+	case string:
+		if s.MinLength != nil && len([]rune(v)) < *s.MinLength {
+			fail("must be at least %d characters long", *s.MinLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match %s", s.Pattern)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				fail("must be an RFC 3339 date-time")
+			}
+		}
+	}
+}
+
+// isType reports whether v, decoded with UseNumber, is of JSON Schema type t.
+func isType(v any, t string) bool {
+	switch v := v.(type) {
+	case map[string]any:
+		return t == "object"
+	case []any:
+		return t == "array"
+	case string:
+		return t == "string"
+	case bool:
+		return t == "boolean"
+	case nil:
+		return t == "null"
+	case json.Number:
+		if t == "number" {
+			return true
+		}
+		if t == "integer" {
+			_, err := strconv.ParseInt(string(v), 10, 64)
+			return err == nil
+		}
+	}
+	return false
+}
+
+// typeOf names the type of v for a violation.
+func typeOf(v any) string {
+	for _, t := range knownTypes {
+		if isType(v, t) {
+			return t
+		}
+	}
+	return "number"
+}
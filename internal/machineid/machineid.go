@@ -0,0 +1,55 @@
+// Package machineid identifies machines across sites. Sites number their
+// machines independently, so a machine is known by its site and the ID it
+// reports there, shared by the ingestion service, which stores events under
+// both, and the API, which looks machines up by them.
+package machineid
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Key identifies a machine. Site is empty for machines that don't report
+// one, as in single-site deployments.
+type Key struct {
+	Site string
+	ID   int
+}
+
+// String returns the key as "<site>:<id>", or the ID alone without a site,
+// as Parse reads it.
+func (k Key) String() string {
+	if k.Site == "" {
+		return strconv.Itoa(k.ID)
+	}
+	return k.Site + ":" + strconv.Itoa(k.ID)
+}
+
+// Parse reads a key written by String. The ID follows the last colon, so
+// the site may contain colons itself.
+func Parse(s string) (Key, error) {
+	var k Key
+	raw := s
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		k.Site, raw = s[:i], s[i+1:]
+		if k.Site == "" {
+			return Key{}, fmt.Errorf("invalid machine %q: want <site>:<id> or <id>", s)
+		}
+	}
+	id, err := strconv.Atoi(raw)
+	if err != nil {
+		return Key{}, fmt.Errorf("invalid machine %q: ID must be an integer", s)
+	}
+	k.ID = id
+	return k, nil
+}
+
+// Compare orders keys by site, then by ID, for sorting.
+func Compare(a, b Key) int {
+	if c := strings.Compare(a.Site, b.Site); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
@@ -0,0 +1,36 @@
+// Package mqttmetrics exposes the MQTT connection health metrics the
+// services share, updated from the paho client callbacks.
+package mqttmetrics
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	connected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "mqtt_connected",
+		Help: "1 while connected to the MQTT broker, 0 otherwise.",
+	})
+	reconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mqtt_reconnects_total",
+		Help: "Successful reconnects to the MQTT broker after the initial connect.",
+	})
+	// connectedOnce distinguishes the initial connect from reconnects.
+	connectedOnce atomic.Bool
+)
+
+// Connect updates the connection metrics from OnConnect.
+func Connect() {
+	connected.Set(1)
+	if connectedOnce.Swap(true) {
+		reconnects.Inc()
+	}
+}
+
+// ConnectionLost updates the connection metrics from OnConnectionLost.
+func ConnectionLost() {
+	connected.Set(0)
+}
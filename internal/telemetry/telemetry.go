@@ -0,0 +1,52 @@
+// Package telemetry sets up OpenTelemetry tracing for the services. It is
+// opt-in: unless OTEL_EXPORTER_OTLP_ENDPOINT is set the global no-op tracer
+// provider stays in place, so instrumented code records nothing.
+package telemetry
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+var enabled atomic.Bool
+
+// Enabled reports whether Setup installed an exporter. Callers use it to
+// skip work that only feeds spans, such as re-reading trace IDs.
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Setup exports traces for serviceName over OTLP/HTTP when
+// OTEL_EXPORTER_OTLP_ENDPOINT is set; the exporter also honours the other
+// standard OTEL_EXPORTER_OTLP_* variables. The returned function flushes
+// pending spans and is a no-op when tracing is disabled.
+func Setup(ctx context.Context, serviceName string) (shutdown func(context.Context) error, err error) {
+	noop := func(context.Context) error { return nil }
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return noop, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return noop, err
+	}
+	res, err := resource.Merge(resource.Default(),
+		resource.NewSchemaless(attribute.String("service.name", serviceName)))
+	if err != nil {
+		return noop, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	enabled.Store(true)
+	return provider.Shutdown, nil
+}
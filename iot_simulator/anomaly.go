@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/events"
+)
+
+// Signatures of the injectable anomalies, documented on the events.Anomaly
+// constants.
+const (
+	anomalyScrapRate      = 0.5
+	anomalySlowdownFactor = 2
+	anomalyChatterMin     = time.Second
+	anomalyChatterMax     = 3 * time.Second
+	reasonChatter         = "chatter"
+)
+
+// defaultAnomalyDuration applies when a request gives no duration.
+const defaultAnomalyDuration = time.Minute
+
+// anomalies are the types that can be injected.
+var anomalies = map[string]bool{
+	events.AnomalyScrapSpike: true,
+	events.AnomalySlowdown:   true,
+	events.AnomalyChatter:    true,
+}
+
+// anomalyState is the anomaly injected into a machine, if any. It is shared
+// by the machine's goroutine and the command and HTTP handlers.
+type anomalyState struct {
+	mu    sync.Mutex
+	kind  string
+	until time.Time
+}
+
+// active returns the anomaly in effect at t, or "" if there is none.
+func (a *anomalyState) active(t time.Time) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if t.Before(a.until) {
+		return a.kind
+	}
+	return ""
+}
+
+// inject makes the machine show kind until d from now, replacing any
+// anomaly already in effect.
+func (m Machine) inject(kind string, d time.Duration) (time.Time, error) {
+	if !anomalies[kind] {
+		return time.Time{}, fmt.Errorf("unknown anomaly type %q: want %s, %s or %s",
+			kind, events.AnomalyScrapSpike, events.AnomalySlowdown, events.AnomalyChatter)
+	}
+	if d <= 0 {
+		d = defaultAnomalyDuration
+	}
+	until := time.Now().Add(d)
+	m.anomaly.mu.Lock()
+	m.anomaly.kind = kind
+	m.anomaly.until = until
+	m.anomaly.mu.Unlock()
+
+	anomaliesInjected.WithLabelValues(kind).Inc()
+	log.Printf("[Machine %d] Injected %s anomaly until %v", m.ID, kind, until.Format(time.TimeOnly))
+	return until, nil
+}
+
+// injectResponse is the body returned by POST /inject_anomaly.
+type injectResponse struct {
+	MachineID int       `json:"machine_id"`
+	Site      string    `json:"site,omitempty"`
+	Type      string    `json:"type"`
+	Until     time.Time `json:"until"`
+}
+
+// injectHandler serves POST /inject_anomaly?machine_id=1&type=slowdown
+// &duration=60. site picks the machine when several sites share its ID.
+func injectHandler(machines []Machine) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		id, err := strconv.Atoi(q.Get("machine_id"))
+		if err != nil {
+			http.Error(w, "machine_id must be an integer", http.StatusBadRequest)
+			return
+		}
+		var d time.Duration
+		if raw := q.Get("duration"); raw != "" {
+			sec, err := strconv.ParseFloat(raw, 64)
+			if err != nil || sec <= 0 {
+				http.Error(w, "duration must be a positive number of seconds", http.StatusBadRequest)
+				return
+			}
+			d = time.Duration(sec * float64(time.Second))
+		}
+
+		var matches []Machine
+		for _, m := range machines {
+			if m.ID == id && (q.Get("site") == "" || m.Site == q.Get("site")) {
+				matches = append(matches, m)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			http.Error(w, "no such machine", http.StatusNotFound)
+			return
+		case 1:
+		default:
+			http.Error(w, "machine_id exists at several sites; pass site", http.StatusBadRequest)
+			return
+		}
+
+		m := matches[0]
+		until, err := m.inject(q.Get("type"), d)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(injectResponse{MachineID: m.ID, Site: m.Site, Type: q.Get("type"), Until: until.UTC()})
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.opentelemetry.io/otel/codes"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/events"
+)
+
+// What PUBLISH_OVERFLOW does with a kind of event the broker can't take.
+const (
+	// overflowBlock holds the machine until the event can be handed over,
+	// as PUBLISH_MODE says.
+	overflowBlock = "block"
+	// overflowDrop discards the event instead, keeping the machine's
+	// cadence.
+	overflowDrop = "drop"
+)
+
+// Why an event was dropped, the reason label of
+// oee_simulator_publish_dropped_total.
+const (
+	dropDisconnected = "disconnected"
+	dropBacklog      = "backlog"
+)
+
+// parsePublishOverflow reads PUBLISH_OVERFLOW, comma-separated kind=policy
+// entries such as "production=drop". Kinds without an entry block.
+func parsePublishOverflow(s string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kind, policy, ok := strings.Cut(entry, "=")
+		kind, policy = strings.TrimSpace(kind), strings.TrimSpace(policy)
+		switch kind {
+		case events.KindStatus, events.KindProduction, events.KindLifecycle, events.KindOperator, events.KindInspection:
+		default:
+			return nil, fmt.Errorf("invalid PUBLISH_OVERFLOW entry %q: kind must be status, production, lifecycle, operator or inspection", entry)
+		}
+		if !ok || (policy != overflowBlock && policy != overflowDrop) {
+			return nil, fmt.Errorf("invalid PUBLISH_OVERFLOW entry %q: want kind=block or kind=drop", entry)
+		}
+		out[kind] = policy
+	}
+	return out, nil
+}
+
+// droppable reports whether events of kind are dropped rather than block.
+func droppable(kind string) bool {
+	return config.PublishOverflow[kind] == overflowDrop
+}
+
+// backpressure returns why an event from m can't be handed over without
+// blocking: the client is disconnected, so it would sit in the client's
+// unbounded store, or m already has PUBLISH_QUEUE_SIZE events queued or
+// waiting for their ack. It returns "" if the event can go.
+func backpressure(client mqtt.Client, m Machine) string {
+	switch {
+	case !client.IsConnectionOpen():
+		return dropDisconnected
+	case m.unacked.Load() >= int64(config.PublishQueueSize):
+		return dropBacklog
+	}
+	return ""
+}
+
+// qos returns the QoS a kind event from m is published at: 1, except for
+// production events once m has QOS_DOWNGRADE_AT events queued or waiting
+// for their ack. Those go out at 0, which the broker doesn't acknowledge,
+// until the backlog drains to QOS_RESTORE_AT. Status events always keep
+// QoS 1.
+func qos(m Machine, kind string) byte {
+	if config.QoSDowngradeAt == 0 || kind != events.KindProduction {
+		return 1
+	}
+	switch backlog := m.unacked.Load(); {
+	case backlog >= int64(config.QoSDowngradeAt) && m.downgraded.CompareAndSwap(false, true):
+		qosDowngradedMachines.Inc()
+		log.Printf("[Machine %d] %d events in flight, publishing production events at QoS 0", m.ID, backlog)
+	case backlog <= int64(config.QoSRestoreAt) && m.downgraded.CompareAndSwap(true, false):
+		qosDowngradedMachines.Dec()
+		log.Printf("[Machine %d] Backlog down to %d, publishing production events at QoS 1 again", m.ID, backlog)
+	}
+	if m.downgraded.Load() {
+		qosDowngraded.WithLabelValues(kind).Inc()
+		return 0
+	}
+	return 1
+}
+
+// drop discards msg, counting it and logging when m starts dropping.
+func drop(m Machine, msg message, reason string) {
+	publishDropped.WithLabelValues(msg.kind, reason).Inc()
+	msg.span.SetStatus(codes.Error, "dropped: "+reason)
+	msg.span.End()
+	if m.dropped.Add(1) == 1 {
+		log.Printf("[Machine %d] Broker can't keep up (%s), dropping %s events", m.ID, reason, msg.kind)
+	}
+}
+
+// resumed logs the end of a run of drops from m, if there was one.
+func resumed(m Machine) {
+	if n := m.dropped.Swap(0); n > 0 {
+		log.Printf("[Machine %d] Publishing again after dropping %d events", m.ID, n)
+	}
+}
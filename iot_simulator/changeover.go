@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reasonSetup is the planned stop to set a machine up for the next product
+// when CHANGEOVER_MATRIX covers the product pair.
+const reasonSetup = "setup"
+
+// changeoverMatrix holds the mean setup time from one product to the next,
+// keyed by the product the machine was making and then the one it switches
+// to.
+type changeoverMatrix map[string]map[string]time.Duration
+
+// parseChangeovers reads CHANGEOVER_MATRIX, comma-separated
+// from>to=seconds entries, e.g. "widget-a>widget-b=600,widget-b>widget-a=420".
+// Seconds may be fractional, and zero means the pair needs no setup.
+func parseChangeovers(s string) (changeoverMatrix, error) {
+	c := changeoverMatrix{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, secs, ok := strings.Cut(entry, "=")
+		from, to, ok2 := strings.Cut(key, ">")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || !ok2 || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid changeover %q: want from>to=seconds", entry)
+		}
+		sec, err := strconv.ParseFloat(strings.TrimSpace(secs), 64)
+		if err != nil || sec < 0 {
+			return nil, fmt.Errorf("invalid changeover %q: seconds must be a non-negative number", entry)
+		}
+		if _, dup := c[from][to]; dup {
+			return nil, fmt.Errorf("invalid changeover %q: %s>%s is set twice", entry, from, to)
+		}
+		if c[from] == nil {
+			c[from] = map[string]time.Duration{}
+		}
+		c[from][to] = time.Duration(sec * float64(time.Second))
+	}
+	return c, nil
+}
+
+// check makes sure the matrix is square over products: every switch between
+// two different products has an entry, and no entry names another product.
+// Entries from a product to itself are optional.
+func (c changeoverMatrix) check(products []string) error {
+	if len(c) == 0 {
+		return nil
+	}
+	if len(products) == 0 {
+		return fmt.Errorf("PRODUCTS must be set with it")
+	}
+	for from, row := range c {
+		for to := range row {
+			for _, p := range []string{from, to} {
+				if !slices.Contains(products, p) {
+					return fmt.Errorf("%s>%s: %q is not in PRODUCTS", from, to, p)
+				}
+			}
+		}
+	}
+	var missing []string
+	for _, from := range products {
+		for _, to := range products {
+			if _, ok := c[from][to]; !ok && from != to {
+				missing = append(missing, from+">"+to)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// lognormal draws a duration from the lognormal distribution with the given
+// mean and shape sigma, the standard deviation of its logarithm. Manual work
+// like a setup is usually close to its typical time but now and then takes
+// much longer, which the long right tail reproduces. A zero sigma always
+// returns mean.
+func lognormal(r *rand.Rand, mean time.Duration, sigma float64) time.Duration {
+	if sigma == 0 {
+		return mean
+	}
+	mu := math.Log(float64(mean)) - sigma*sigma/2
+	return time.Duration(math.Exp(mu + sigma*r.NormFloat64()))
+}
+
+// setupTime returns how long m takes to set up from product from to product
+// to, drawn around the CHANGEOVER_MATRIX mean for the pair. ok is false when
+// the matrix has no entry for the pair, so LOT_CHANGEOVER applies instead.
+func (m Machine) setupTime(r *rand.Rand, from, to string) (d time.Duration, ok bool) {
+	mean, ok := m.Changeovers[from][to]
+	if !ok || mean == 0 {
+		return 0, ok
+	}
+	return lognormal(r, mean, m.ChangeoverSigma), true
+}
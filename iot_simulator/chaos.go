@@ -0,0 +1,74 @@
+package main
+
+import (
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// chaosPublish publishes msg as CHAOS_PUBLISH_LATENCY_MS and
+// CHAOS_PUBLISH_ERROR_RATE say: its ack arrives the latency late, and a
+// publish picked to fail never reaches the broker and reports
+// chaos.ErrInjected. Without them it is client.Publish.
+func chaosPublish(client mqtt.Client, msg message) mqtt.Token {
+	f := config.PublishChaos
+	if !f.Enabled() {
+		return client.Publish(msg.topic, msg.qos, true, msg.payload)
+	}
+
+	t := &chaosToken{done: make(chan struct{})}
+	var token mqtt.Token
+	if t.err = f.Fail(); t.err != nil {
+		chaosFaults.WithLabelValues(msg.kind, "error").Inc()
+	} else {
+		token = client.Publish(msg.topic, msg.qos, true, msg.payload)
+	}
+	if f.Latency > 0 {
+		chaosFaults.WithLabelValues(msg.kind, "latency").Inc()
+	}
+	go func() {
+		if token != nil {
+			token.Wait()
+			t.err = token.Error()
+		}
+		time.Sleep(f.Latency)
+		close(t.done)
+	}()
+	return t
+}
+
+// chaosToken is the token of a publish degraded by chaosPublish.
+type chaosToken struct {
+	done chan struct{}
+	// err is set before done is closed.
+	err error
+}
+
+func (t *chaosToken) Wait() bool {
+	<-t.done
+	return true
+}
+
+func (t *chaosToken) WaitTimeout(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-t.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (t *chaosToken) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *chaosToken) Error() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
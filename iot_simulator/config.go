@@ -0,0 +1,873 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // TIMEZONE must resolve in images without a zoneinfo database
+
+	"github.com/joho/godotenv"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/chaos"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/configfile"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/connstr"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/cycletime"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/envfile"
+)
+
+// Behavior holds the parameters that shape a machine's simulated OEE losses.
+type Behavior struct {
+	IdealCycleTime time.Duration
+	ScrapRate      float64
+	// ReworkRate is the chance a part fails first inspection and is sent to
+	// rework, which saves it with chance ReworkSuccessRate and otherwise
+	// ends in scrap.
+	ReworkRate        float64
+	ReworkSuccessRate float64
+	// The first WarmupParts parts after the machine starts, comes back
+	// from a breakdown or finishes a changeover are scrapped at
+	// WarmupScrapRate if that is higher than the usual rate.
+	WarmupParts     int
+	WarmupScrapRate float64
+	// Breakdowns follow DowntimeChance, a per-cycle chance of a stop lasting
+	// DowntimeMin to DowntimeMax, unless MTBF is set: then run time between
+	// breakdowns and repair times are exponential with means MTBF and MTTR.
+	DowntimeChance          float64
+	DowntimeMin             time.Duration
+	DowntimeMax             time.Duration
+	MTBF                    time.Duration
+	MTTR                    time.Duration
+	PerformanceLossChance   float64
+	PerformanceLossMaxDelay time.Duration
+	LotSize                 int
+	LotChangeover           time.Duration
+	// Changeovers, when set, replace LotChangeover between lots of
+	// different products with a planned setup stop, lasting a lognormal
+	// time around the pair's mean with shape ChangeoverSigma.
+	Changeovers     changeoverMatrix
+	ChangeoverSigma float64
+	// Planned maintenance stops for MaintenanceDuration after every
+	// MaintenanceInterval of run time; a zero interval disables it.
+	MaintenanceInterval time.Duration
+	MaintenanceDuration time.Duration
+	// Maintenance lowers the failure rate to MaintenanceFailureFactor of
+	// its usual value, from where it climbs back with a time constant of
+	// MaintenanceRecovery of run time; a factor of 1 leaves it alone. Each
+	// maintenance due is skipped with chance MaintenanceSkipChance.
+	MaintenanceFailureFactor float64
+	MaintenanceRecovery      time.Duration
+	MaintenanceSkipChance    float64
+	// Products are run in turn, one per lot; empty means events carry no
+	// product.
+	Products []string
+	// ProfileFile names the fitted profile whose empirical distributions
+	// replace the parametric ones above, loaded into profile.
+	ProfileFile string
+	profile     *profile
+}
+
+// defaultBehavior is used for any parameter not set in the environment.
+var defaultBehavior = Behavior{
+	IdealCycleTime:          3 * time.Second,
+	ScrapRate:               0.05,
+	ReworkRate:              0.03,
+	ReworkSuccessRate:       1,
+	WarmupScrapRate:         0.5,
+	DowntimeChance:          0.1,
+	DowntimeMin:             10 * time.Second,
+	DowntimeMax:             30 * time.Second,
+	PerformanceLossChance:   0.20,
+	PerformanceLossMaxDelay: 2 * time.Second,
+	LotSize:                 500,
+	ChangeoverSigma:         0.25,
+	MaintenanceDuration:     30 * time.Minute,
+
+	MaintenanceFailureFactor: 1,
+	MaintenanceRecovery:      4 * time.Hour,
+}
+
+// Site is a group of machines published under their own topic prefix.
+type Site struct {
+	Name        string
+	TopicPrefix string
+	MachineIDs  []int
+	Behavior    Behavior
+}
+
+// MachineGroup is a set of machines sharing a utility (power feed, compressed
+// air) that stop together when it fails.
+type MachineGroup struct {
+	Name       string
+	MachineIDs []int
+}
+
+// Configuration loaded from environment variables
+type Config struct {
+	// ConfigFile is the CONFIG_FILE the settings were loaded from, if any;
+	// environment variables override it.
+	ConfigFile    string
+	MQTTBrokerURL string `secret:"url"`
+	MQTTClientID  string `instance:"true"`
+	// MQTTUsername and MQTTPassword authenticate with the broker; each can
+	// also be read from the file named by its _FILE variable.
+	MQTTUsername string
+	MQTTPassword string `secret:"true"`
+	MachineIDs   []int
+	Behavior
+	Fleet Fleet
+	// LoadTest, when its Rate is set, benchmarks the broker instead of
+	// simulating the machines.
+	LoadTest LoadTest
+	// Replay, when its File is set, publishes recorded events instead of
+	// simulating the machines.
+	Replay Replay
+	// Seed makes the generated fleet and the random behavior reproducible;
+	// zero seeds from the clock.
+	Seed                  int64
+	Sites                 []Site
+	Groups                []MachineGroup
+	SharedFailureChance   float64
+	SharedFailureInterval time.Duration
+	SharedFailureMin      time.Duration
+	SharedFailureMax      time.Duration
+	// Location is the TIMEZONE shift starts are read in. Event timestamps
+	// are always UTC.
+	Location *time.Location
+	// Shift handover losses: within HandoverWindow of a shift start,
+	// performance losses are up to HandoverLossFactor times more likely and
+	// short "handover" stops occur, both peaking at the shift change.
+	ShiftStarts             []time.Duration
+	HandoverWindow          time.Duration
+	HandoverLossFactor      float64
+	HandoverMicroStopChance float64
+	HandoverMicroStopMax    time.Duration
+	// Operators, when set, are rotated across the machines at every shift
+	// start, and each assignment is published as an operator event.
+	Operators []string
+	// ReducedSpeed are the daily windows in which machines are run slower
+	// on purpose. Their parts are marked as made at a planned slowdown.
+	ReducedSpeed []SpeedWindow
+	// Quality interventions: a machine's scrap rate drops to
+	// InterventionScrapFactor of its normal value and drifts back over
+	// InterventionRecovery. They are triggered by a command, and also every
+	// InterventionInterval when that is set.
+	InterventionInterval    time.Duration
+	InterventionScrapFactor float64
+	InterventionRecovery    time.Duration
+	// Part measurements for SPC: with MeasurementNominal set, every part's
+	// diameter is measured. Its mean drifts up by MeasurementWearRate per
+	// hour of run time as the tool wears, until the tool is replaced after
+	// MeasurementToolLife or at planned maintenance. Parts outside
+	// ±MeasurementTolerance are scrapped, or reworked when oversize by no
+	// more than MeasurementReworkBand.
+	MeasurementNominal       float64
+	MeasurementTolerance     float64
+	MeasurementReworkBand    float64
+	MeasurementSigma         float64
+	MeasurementWearRate      float64
+	MeasurementToolLife      time.Duration
+	MeasurementOutlierChance float64
+	// Clock drift: each machine's clock, and so its event timestamps, is off
+	// by up to ClockDriftMax, swinging back and forth over roughly
+	// ClockDriftPeriod. Zero keeps every clock exact.
+	ClockDriftMax    time.Duration
+	ClockDriftPeriod time.Duration
+	// PartSerials numbers every part that ships with a serial, as
+	// serialsSequence or serialsUUID; empty gives parts no serial.
+	PartSerials string
+	// InspectionDelay is how long after each part is made an inspection
+	// station downstream publishes its verdict on it; zero inspects nothing.
+	InspectionDelay time.Duration
+	// AvailabilityOnly lists the machines that run and stop as usual but
+	// make no parts, so their OEE is availability alone.
+	AvailabilityOnly []int
+	// QualityModel is "rate", where parts are scrapped and reworked at
+	// random, or "measured", where the measurement alone grades them.
+	QualityModel       string
+	PublishWaitTimeout time.Duration
+	PublishMode        string
+	// PublishQueueSize is how many messages each machine can queue for its
+	// publisher when PublishMode is ordered, and the backlog beyond which
+	// kinds PublishOverflow drops are dropped in any mode.
+	PublishQueueSize int
+	// MaxConcurrentPublishes caps the publishes in flight across all
+	// machines; zero leaves them unlimited.
+	MaxConcurrentPublishes int
+	// PublishOverflow maps event kinds to overflowDrop or overflowBlock.
+	PublishOverflow map[string]string
+	// A machine is quarantined after PublishQuarantineAfter failed
+	// publishes in a row (zero never), retrying with a backoff from
+	// PublishQuarantineBackoff to PublishQuarantineMaxBackoff.
+	PublishQuarantineAfter      int
+	PublishQuarantineBackoff    time.Duration
+	PublishQuarantineMaxBackoff time.Duration
+	// Production events go out at QoS 0 while a machine's backlog is
+	// above QoSDowngradeAt (zero never) until it drains to QoSRestoreAt.
+	QoSDowngradeAt int
+	QoSRestoreAt   int
+	// PublishChaos delays the ack of every publish and fails some of
+	// them, for chaos testing.
+	PublishChaos chaos.Faults
+	TraceIDs     bool
+	// PublishTimestamps stamps status and production events with when they
+	// were published, so the ingestor can measure end-to-end latency.
+	PublishTimestamps bool
+	// CycleIndex numbers each machine's production events by cycle.
+	CycleIndex bool
+	// PartsTotalizer adds each machine's good parts counter to its
+	// production events, wrapping to 0 after PartsTotalizerMax unless
+	// that is zero.
+	PartsTotalizer    bool
+	PartsTotalizerMax uint64
+	// StatusSummary adds to each status event what the machine did since
+	// its previous one.
+	StatusSummary bool
+	MetricsAddr   string
+	// MetricsRequired exits when MetricsAddr can't be served, instead of
+	// simulating without metrics.
+	MetricsRequired bool
+	// CycleTimes overrides IdealCycleTime per machine and product.
+	CycleTimes cycletime.Matrix
+	// RunDuration and TargetEventCount bound the run; zero means unbounded.
+	RunDuration      time.Duration
+	TargetEventCount int
+	// TargetGoodParts stops each machine once it has made that many good
+	// parts; zero means never.
+	TargetGoodParts int
+	// DebugEndpoints exposes /debug/config next to /metrics.
+	DebugEndpoints bool
+	// APIToken is the bearer token required on /metrics and /debug/config;
+	// empty leaves them open.
+	APIToken string `secret:"true"`
+}
+
+// Global config instance
+var config Config
+
+// loadConfig loads configuration from environment variables
+func loadConfig() (Config, error) {
+	// Load .env file if it exists (ignored in Docker, where env vars are set directly)
+	_ = godotenv.Load()
+
+	// Settings from CONFIG_FILE fill in what the environment leaves unset
+	path, err := configfile.Load()
+	if err != nil {
+		return Config{}, err
+	}
+
+	cfg := Config{
+		ConfigFile:    path,
+		MQTTBrokerURL: getEnv("MQTT_BROKER_URL", "tcp://localhost:1883"),
+		MQTTClientID:  getEnv("MQTT_CLIENT_ID", "oee-simulator"),
+	}
+
+	if cfg.MQTTUsername, err = envfile.Get("MQTT_USERNAME", ""); err != nil {
+		return cfg, err
+	}
+	if cfg.MQTTPassword, err = envfile.Get("MQTT_PASSWORD", ""); err != nil {
+		return cfg, err
+	}
+	if cfg.MQTTBrokerURL, err = connstr.BrokerURL(cfg.MQTTBrokerURL); err != nil {
+		return cfg, fmt.Errorf("invalid MQTT_BROKER_URL: %w", err)
+	}
+
+	// Parse machine IDs
+	cfg.MachineIDs, err = parseMachineIDs(getEnv("MACHINE_IDS", "1,2,3"))
+	if err != nil {
+		return cfg, err
+	}
+
+	// A generated fleet replaces MACHINE_IDS with machines 1..MACHINE_COUNT
+	if cfg.Fleet, err = loadFleet(); err != nil {
+		return cfg, err
+	}
+	if cfg.Fleet.Count > 0 {
+		if getEnv("SITES", "") != "" {
+			return cfg, fmt.Errorf("MACHINE_COUNT cannot be combined with SITES")
+		}
+		cfg.MachineIDs = make([]int, cfg.Fleet.Count)
+		for i := range cfg.MachineIDs {
+			cfg.MachineIDs[i] = i + 1
+		}
+	}
+	// A load test likewise sizes its own set of machines
+	if cfg.LoadTest, err = loadLoadTest(); err != nil {
+		return cfg, err
+	}
+	if cfg.LoadTest.Rate > 0 {
+		if getEnv("SITES", "") != "" || cfg.Fleet.Count > 0 {
+			return cfg, fmt.Errorf("LOAD_TEST_RATE cannot be combined with SITES or MACHINE_COUNT")
+		}
+		cfg.MachineIDs = make([]int, cfg.LoadTest.Machines)
+		for i := range cfg.MachineIDs {
+			cfg.MachineIDs[i] = i + 1
+		}
+	}
+	if cfg.Replay, err = loadReplay(); err != nil {
+		return cfg, err
+	}
+	if cfg.Replay.File != "" && cfg.LoadTest.Rate > 0 {
+		return cfg, fmt.Errorf("REPLAY_FILE cannot be combined with LOAD_TEST_RATE")
+	}
+	if raw := getEnv("SEED", ""); raw != "" {
+		if cfg.Seed, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			return cfg, fmt.Errorf("invalid SEED: %w", err)
+		}
+	}
+
+	// Parse machine behavior
+	cfg.Behavior, err = loadBehavior("", defaultBehavior)
+	if err != nil {
+		return cfg, err
+	}
+
+	if cfg.Fleet.MTBF != nil && cfg.Behavior.MTTR == 0 && cfg.Fleet.MTTR == nil && cfg.Behavior.profile.downtime() == nil {
+		return cfg, fmt.Errorf("invalid FLEET_MTBF: MTTR or FLEET_MTTR must be set with it")
+	}
+
+	// Parse the machine × product cycle time matrix
+	if cfg.CycleTimes, err = cycletime.Parse(getEnv("CYCLE_TIMES", "")); err != nil {
+		return cfg, fmt.Errorf("invalid CYCLE_TIMES: %w", err)
+	}
+
+	// Parse sites. Without SITES every machine belongs to one unnamed site
+	// publishing under the original "factory" prefix.
+	cfg.Sites, err = parseSites(getEnv("SITES", ""), cfg.Behavior)
+	if err != nil {
+		return cfg, err
+	}
+	if len(cfg.Sites) == 0 {
+		cfg.Sites = []Site{{TopicPrefix: "factory", MachineIDs: cfg.MachineIDs, Behavior: cfg.Behavior}}
+	}
+
+	// Parse shared-utility groups and how often their utility fails
+	cfg.Groups, err = parseGroups(getEnv("MACHINE_GROUPS", ""))
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.SharedFailureChance, err = envFloat("SHARED_FAILURE_CHANCE", 0.02); err != nil {
+		return cfg, err
+	}
+	if cfg.SharedFailureInterval, err = envSeconds("SHARED_FAILURE_INTERVAL", 60*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.SharedFailureMin, err = envSeconds("SHARED_FAILURE_MIN", 30*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.SharedFailureMax, err = envSeconds("SHARED_FAILURE_MAX", 120*time.Second); err != nil {
+		return cfg, err
+	}
+	if len(cfg.Groups) > 0 && (cfg.SharedFailureInterval <= 0 || cfg.SharedFailureMax <= cfg.SharedFailureMin) {
+		return cfg, fmt.Errorf("invalid shared failure timing: need SHARED_FAILURE_INTERVAL > 0 and SHARED_FAILURE_MAX > SHARED_FAILURE_MIN")
+	}
+
+	if cfg.Location, err = time.LoadLocation(getEnv("TIMEZONE", "Local")); err != nil {
+		return cfg, fmt.Errorf("invalid TIMEZONE: %w", err)
+	}
+
+	// Parse the shift pattern and the losses around each handover
+	if cfg.ShiftStarts, err = parseShiftStarts(getEnv("SHIFT_STARTS", "07:00,15:00,23:00")); err != nil {
+		return cfg, err
+	}
+	if cfg.HandoverWindow, err = envSeconds("HANDOVER_WINDOW", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.HandoverLossFactor, err = envFloat("HANDOVER_LOSS_FACTOR", 3); err != nil {
+		return cfg, err
+	}
+	if cfg.HandoverMicroStopChance, err = envFloat("HANDOVER_MICRO_STOP_CHANCE", 0.1); err != nil {
+		return cfg, err
+	}
+	if cfg.HandoverMicroStopMax, err = envSeconds("HANDOVER_MICRO_STOP_MAX", 20*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.HandoverWindow > 0 && (cfg.HandoverLossFactor < 1 || cfg.HandoverMicroStopMax < time.Second) {
+		return cfg, fmt.Errorf("invalid handover settings: need HANDOVER_LOSS_FACTOR >= 1 and HANDOVER_MICRO_STOP_MAX >= 1")
+	}
+	cfg.Operators = parseOperators(getEnv("OPERATORS", ""))
+	if len(cfg.Operators) > 0 && len(cfg.ShiftStarts) == 0 {
+		return cfg, fmt.Errorf("OPERATORS needs SHIFT_STARTS to rotate them")
+	}
+	if cfg.ReducedSpeed, err = parseSpeedWindows(getEnv("REDUCED_SPEED_WINDOWS", "")); err != nil {
+		return cfg, err
+	}
+
+	// Quality interventions and the recovery of scrap afterwards
+	if cfg.InterventionInterval, err = envSeconds("QUALITY_INTERVENTION_INTERVAL", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.InterventionScrapFactor, err = envFloat("QUALITY_INTERVENTION_SCRAP_FACTOR", 0.05); err != nil {
+		return cfg, err
+	}
+	if cfg.InterventionRecovery, err = envSeconds("QUALITY_INTERVENTION_RECOVERY", 15*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.InterventionInterval < 0 || cfg.InterventionScrapFactor < 0 || cfg.InterventionScrapFactor > 1 || cfg.InterventionRecovery <= 0 {
+		return cfg, fmt.Errorf("invalid quality intervention settings: need QUALITY_INTERVENTION_INTERVAL >= 0, QUALITY_INTERVENTION_SCRAP_FACTOR in [0, 1] and QUALITY_INTERVENTION_RECOVERY > 0")
+	}
+
+	// Part measurements and tool wear
+	if cfg.MeasurementNominal, err = envFloat("MEASUREMENT_NOMINAL", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.MeasurementTolerance, err = envFloat("MEASUREMENT_TOLERANCE", 0.05); err != nil {
+		return cfg, err
+	}
+	if cfg.MeasurementReworkBand, err = envFloat("MEASUREMENT_REWORK_BAND", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.MeasurementSigma, err = envFloat("MEASUREMENT_SIGMA", 0.01); err != nil {
+		return cfg, err
+	}
+	if cfg.MeasurementWearRate, err = envFloat("MEASUREMENT_WEAR_RATE", 0.01); err != nil {
+		return cfg, err
+	}
+	if cfg.MeasurementToolLife, err = envSeconds("MEASUREMENT_TOOL_LIFE", 4*time.Hour); err != nil {
+		return cfg, err
+	}
+	if cfg.MeasurementOutlierChance, err = envFloat("MEASUREMENT_OUTLIER_CHANCE", 0.001); err != nil {
+		return cfg, err
+	}
+	if cfg.MeasurementNominal < 0 || cfg.MeasurementTolerance <= 0 || cfg.MeasurementReworkBand < 0 || cfg.MeasurementSigma < 0 ||
+		cfg.MeasurementToolLife < 0 || cfg.MeasurementOutlierChance < 0 || cfg.MeasurementOutlierChance > 1 {
+		return cfg, fmt.Errorf("invalid measurement settings: need MEASUREMENT_NOMINAL >= 0, MEASUREMENT_TOLERANCE > 0, MEASUREMENT_REWORK_BAND >= 0, MEASUREMENT_SIGMA >= 0, MEASUREMENT_TOOL_LIFE >= 0 and MEASUREMENT_OUTLIER_CHANCE in [0, 1]")
+	}
+	cfg.QualityModel = getEnv("QUALITY_MODEL", qualityRate)
+	if cfg.QualityModel != qualityRate && cfg.QualityModel != qualityMeasured {
+		return cfg, fmt.Errorf("invalid QUALITY_MODEL %q: must be %s or %s", cfg.QualityModel, qualityRate, qualityMeasured)
+	}
+	if cfg.QualityModel == qualityMeasured && cfg.MeasurementNominal == 0 {
+		return cfg, fmt.Errorf("QUALITY_MODEL=%s needs MEASUREMENT_NOMINAL", qualityMeasured)
+	}
+
+	cfg.PartSerials = getEnv("PART_SERIALS", "")
+	if cfg.PartSerials != "" && cfg.PartSerials != serialsSequence && cfg.PartSerials != serialsUUID {
+		return cfg, fmt.Errorf("invalid PART_SERIALS %q: must be %s or %s", cfg.PartSerials, serialsSequence, serialsUUID)
+	}
+	inspectionDelaySec, err := envFloat("INSPECTION_DELAY", 0)
+	if err != nil {
+		return cfg, err
+	}
+	if inspectionDelaySec < 0 {
+		return cfg, fmt.Errorf("invalid INSPECTION_DELAY %g: must not be negative", inspectionDelaySec)
+	}
+	cfg.InspectionDelay = time.Duration(inspectionDelaySec * float64(time.Second))
+	if raw := getEnv("AVAILABILITY_ONLY_MACHINES", ""); raw != "" {
+		if cfg.AvailabilityOnly, err = parseMachineIDs(raw); err != nil {
+			return cfg, fmt.Errorf("invalid AVAILABILITY_ONLY_MACHINES: %w", err)
+		}
+	}
+
+	// Unsynchronized machine clocks. Fractional seconds are allowed, since
+	// real drift is often well under a second
+	clockDriftMaxSec, err := envFloat("CLOCK_DRIFT_MAX", 0)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.ClockDriftMax = time.Duration(clockDriftMaxSec * float64(time.Second))
+	if cfg.ClockDriftPeriod, err = envSeconds("CLOCK_DRIFT_PERIOD", time.Hour); err != nil {
+		return cfg, err
+	}
+	if cfg.ClockDriftMax < 0 || cfg.ClockDriftPeriod <= 0 {
+		return cfg, fmt.Errorf("invalid clock drift: need CLOCK_DRIFT_MAX >= 0 and CLOCK_DRIFT_PERIOD > 0")
+	}
+	// A machine's period can be half CLOCK_DRIFT_PERIOD, and its clock then
+	// runs at up to 4π·max/period fast or slow; past 1 it would run backwards
+	if cfg.ClockDriftPeriod < 13*cfg.ClockDriftMax {
+		return cfg, fmt.Errorf("invalid clock drift: CLOCK_DRIFT_PERIOD must be at least 13 times CLOCK_DRIFT_MAX, or clocks could run backwards")
+	}
+
+	// Fractional seconds are allowed so sub-second caps can be configured
+	publishWaitTimeoutSec, err := strconv.ParseFloat(getEnv("PUBLISH_WAIT_TIMEOUT", "5"), 64)
+	if err != nil {
+		return cfg, fmt.Errorf("invalid PUBLISH_WAIT_TIMEOUT: %w", err)
+	}
+	if publishWaitTimeoutSec < 0 {
+		return cfg, fmt.Errorf("invalid PUBLISH_WAIT_TIMEOUT: must not be negative")
+	}
+	cfg.PublishWaitTimeout = time.Duration(publishWaitTimeoutSec * float64(time.Second))
+
+	cfg.PublishMode = getEnv("PUBLISH_MODE", publishSync)
+	if cfg.PublishMode != publishSync && cfg.PublishMode != publishAsync && cfg.PublishMode != publishOrdered {
+		return cfg, fmt.Errorf("invalid PUBLISH_MODE %q: must be sync, async or ordered", cfg.PublishMode)
+	}
+	if cfg.PublishQueueSize, err = strconv.Atoi(getEnv("PUBLISH_QUEUE_SIZE", "100")); err != nil || cfg.PublishQueueSize < 1 {
+		return cfg, fmt.Errorf("invalid PUBLISH_QUEUE_SIZE: must be a positive integer")
+	}
+	if cfg.MaxConcurrentPublishes, err = strconv.Atoi(getEnv("MAX_CONCURRENT_PUBLISHES", "0")); err != nil || cfg.MaxConcurrentPublishes < 0 {
+		return cfg, fmt.Errorf("invalid MAX_CONCURRENT_PUBLISHES: must be a non-negative integer")
+	}
+	if cfg.PublishOverflow, err = parsePublishOverflow(getEnv("PUBLISH_OVERFLOW", "")); err != nil {
+		return cfg, err
+	}
+	if cfg.PublishQuarantineAfter, err = envInt("PUBLISH_QUARANTINE_AFTER", 10); err != nil {
+		return cfg, err
+	}
+	if cfg.PublishQuarantineBackoff, err = envSeconds("PUBLISH_QUARANTINE_BACKOFF", time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.PublishQuarantineMaxBackoff, err = envSeconds("PUBLISH_QUARANTINE_MAX_BACKOFF", 5*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.PublishQuarantineAfter < 0 || cfg.PublishQuarantineBackoff <= 0 || cfg.PublishQuarantineMaxBackoff < cfg.PublishQuarantineBackoff {
+		return cfg, fmt.Errorf("invalid PUBLISH_QUARANTINE_*: AFTER must not be negative, BACKOFF must be positive and MAX_BACKOFF at least BACKOFF")
+	}
+	if cfg.QoSDowngradeAt, err = envInt("QOS_DOWNGRADE_AT", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.QoSRestoreAt, err = envInt("QOS_RESTORE_AT", cfg.QoSDowngradeAt/2); err != nil {
+		return cfg, err
+	}
+	if cfg.QoSDowngradeAt < 0 || cfg.QoSRestoreAt < 0 || (cfg.QoSDowngradeAt > 0 && cfg.QoSRestoreAt >= cfg.QoSDowngradeAt) {
+		return cfg, fmt.Errorf("invalid QOS_DOWNGRADE_AT/QOS_RESTORE_AT: must not be negative, and QOS_RESTORE_AT must be below QOS_DOWNGRADE_AT")
+	}
+	chaosLatencyMs, err := strconv.Atoi(getEnv("CHAOS_PUBLISH_LATENCY_MS", "0"))
+	if err != nil || chaosLatencyMs < 0 {
+		return cfg, fmt.Errorf("invalid CHAOS_PUBLISH_LATENCY_MS: must be a non-negative integer")
+	}
+	cfg.PublishChaos.Latency = time.Duration(chaosLatencyMs) * time.Millisecond
+	if cfg.PublishChaos.ErrorRate, err = envFloat("CHAOS_PUBLISH_ERROR_RATE", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.PublishChaos.ErrorRate < 0 || cfg.PublishChaos.ErrorRate > 1 {
+		return cfg, fmt.Errorf("invalid CHAOS_PUBLISH_ERROR_RATE: must be between 0 and 1")
+	}
+
+	if cfg.TraceIDs, err = strconv.ParseBool(getEnv("TRACE_IDS", "true")); err != nil {
+		return cfg, fmt.Errorf("invalid TRACE_IDS: %w", err)
+	}
+	if cfg.CycleIndex, err = strconv.ParseBool(getEnv("CYCLE_INDEX", "true")); err != nil {
+		return cfg, fmt.Errorf("invalid CYCLE_INDEX: %w", err)
+	}
+	if cfg.PartsTotalizer, err = strconv.ParseBool(getEnv("PARTS_TOTALIZER", "false")); err != nil {
+		return cfg, fmt.Errorf("invalid PARTS_TOTALIZER: %w", err)
+	}
+	if cfg.PartsTotalizerMax, err = strconv.ParseUint(getEnv("PARTS_TOTALIZER_MAX", "0"), 10, 64); err != nil {
+		return cfg, fmt.Errorf("invalid PARTS_TOTALIZER_MAX: must be a non-negative integer")
+	}
+	if cfg.StatusSummary, err = strconv.ParseBool(getEnv("STATUS_SUMMARY", "false")); err != nil {
+		return cfg, fmt.Errorf("invalid STATUS_SUMMARY: %w", err)
+	}
+	if cfg.PublishTimestamps, err = strconv.ParseBool(getEnv("PUBLISH_TIMESTAMPS", "false")); err != nil {
+		return cfg, fmt.Errorf("invalid PUBLISH_TIMESTAMPS: %w", err)
+	}
+
+	// Bounded runs for CI and fixture generation
+	if cfg.RunDuration, err = envSeconds("RUN_DURATION", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.TargetEventCount, err = envInt("TARGET_EVENT_COUNT", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.TargetGoodParts, err = envInt("TARGET_GOOD_PARTS", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.RunDuration < 0 || cfg.TargetEventCount < 0 || cfg.TargetGoodParts < 0 {
+		return cfg, fmt.Errorf("invalid run bounds: RUN_DURATION, TARGET_EVENT_COUNT and TARGET_GOOD_PARTS must not be negative")
+	}
+	if cfg.TargetGoodParts > 0 && len(cfg.AvailabilityOnly) > 0 {
+		return cfg, fmt.Errorf("TARGET_GOOD_PARTS cannot be combined with AVAILABILITY_ONLY_MACHINES, whose machines never make a part")
+	}
+
+	cfg.MetricsAddr = getEnv("METRICS_ADDR", ":8080")
+	if cfg.MetricsRequired, err = strconv.ParseBool(getEnv("METRICS_REQUIRED", "false")); err != nil {
+		return cfg, fmt.Errorf("invalid METRICS_REQUIRED: %w", err)
+	}
+	cfg.APIToken = os.Getenv("API_TOKEN")
+	if cfg.DebugEndpoints, err = strconv.ParseBool(getEnv("DEBUG_ENDPOINTS", "false")); err != nil {
+		return cfg, fmt.Errorf("invalid DEBUG_ENDPOINTS: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// loadBehavior reads the behavior parameters from environment variables
+// named prefix+"IDEAL_CYCLE_TIME" and so on, falling back to def.
+func loadBehavior(prefix string, def Behavior) (Behavior, error) {
+	// A profile's scrap rate stands in for the default, so SCRAP_RATE can
+	// still override it
+	if path := getEnv(prefix+"PROFILE_FILE", ""); path != "" {
+		p, err := loadProfile(path)
+		if err != nil {
+			return def, fmt.Errorf("invalid %sPROFILE_FILE: %w", prefix, err)
+		}
+		def.ProfileFile, def.profile = path, p
+		if p.ScrapRate != nil {
+			def.ScrapRate = *p.ScrapRate
+		}
+	}
+	b := def
+	var err error
+	if b.IdealCycleTime, err = envSeconds(prefix+"IDEAL_CYCLE_TIME", def.IdealCycleTime); err != nil {
+		return b, err
+	}
+	if b.ScrapRate, err = envFloat(prefix+"SCRAP_RATE", def.ScrapRate); err != nil {
+		return b, err
+	}
+	if b.ReworkRate, err = envFloat(prefix+"REWORK_RATE", def.ReworkRate); err != nil {
+		return b, err
+	}
+	if b.ReworkSuccessRate, err = envFloat(prefix+"REWORK_SUCCESS_RATE", def.ReworkSuccessRate); err != nil {
+		return b, err
+	}
+	if b.ReworkSuccessRate < 0 || b.ReworkSuccessRate > 1 {
+		return b, fmt.Errorf("invalid %sREWORK_SUCCESS_RATE: must be between 0 and 1", prefix)
+	}
+	if b.WarmupParts, err = envInt(prefix+"WARMUP_PARTS", def.WarmupParts); err != nil {
+		return b, err
+	}
+	if b.WarmupScrapRate, err = envFloat(prefix+"WARMUP_SCRAP_RATE", def.WarmupScrapRate); err != nil {
+		return b, err
+	}
+	if b.WarmupParts < 0 || b.WarmupScrapRate < 0 || b.WarmupScrapRate > 1 {
+		return b, fmt.Errorf("invalid %sWARMUP_PARTS/%sWARMUP_SCRAP_RATE: parts must not be negative and the rate must be between 0 and 1", prefix, prefix)
+	}
+	if b.DowntimeChance, err = envFloat(prefix+"DOWNTIME_CHANCE", def.DowntimeChance); err != nil {
+		return b, err
+	}
+	if b.DowntimeMin, err = envSeconds(prefix+"DOWNTIME_MIN", def.DowntimeMin); err != nil {
+		return b, err
+	}
+	if b.DowntimeMax, err = envSeconds(prefix+"DOWNTIME_MAX", def.DowntimeMax); err != nil {
+		return b, err
+	}
+	if b.MTBF, err = envSeconds(prefix+"MTBF", def.MTBF); err != nil {
+		return b, err
+	}
+	if b.MTTR, err = envSeconds(prefix+"MTTR", def.MTTR); err != nil {
+		return b, err
+	}
+	if b.MTBF < 0 || b.MTTR < 0 || (b.MTBF > 0 && b.MTTR == 0 && b.profile.downtime() == nil) {
+		return b, fmt.Errorf("invalid %sMTBF/%sMTTR: must not be negative, and MTTR or a downtime profile must be set with MTBF", prefix, prefix)
+	}
+	if b.PerformanceLossChance, err = envFloat(prefix+"PERFORMANCE_LOSS_CHANCE", def.PerformanceLossChance); err != nil {
+		return b, err
+	}
+	if b.PerformanceLossMaxDelay, err = envSeconds(prefix+"PERFORMANCE_LOSS_MAX_DELAY", def.PerformanceLossMaxDelay); err != nil {
+		return b, err
+	}
+	if b.LotSize, err = envInt(prefix+"LOT_SIZE", def.LotSize); err != nil {
+		return b, err
+	}
+	if b.LotChangeover, err = envSeconds(prefix+"LOT_CHANGEOVER", def.LotChangeover); err != nil {
+		return b, err
+	}
+	if b.MaintenanceInterval, err = envSeconds(prefix+"MAINTENANCE_INTERVAL", def.MaintenanceInterval); err != nil {
+		return b, err
+	}
+	if b.MaintenanceDuration, err = envSeconds(prefix+"MAINTENANCE_DURATION", def.MaintenanceDuration); err != nil {
+		return b, err
+	}
+	if b.MaintenanceInterval < 0 || (b.MaintenanceInterval > 0 && b.MaintenanceDuration <= 0) {
+		return b, fmt.Errorf("invalid %sMAINTENANCE_INTERVAL/%sMAINTENANCE_DURATION: interval must not be negative and duration must be positive", prefix, prefix)
+	}
+	if b.MaintenanceFailureFactor, err = envFloat(prefix+"MAINTENANCE_FAILURE_FACTOR", def.MaintenanceFailureFactor); err != nil {
+		return b, err
+	}
+	if b.MaintenanceFailureFactor < 0 || b.MaintenanceFailureFactor > 1 {
+		return b, fmt.Errorf("invalid %sMAINTENANCE_FAILURE_FACTOR: must be between 0 and 1", prefix)
+	}
+	if b.MaintenanceRecovery, err = envSeconds(prefix+"MAINTENANCE_RECOVERY", def.MaintenanceRecovery); err != nil {
+		return b, err
+	}
+	if b.MaintenanceRecovery <= 0 {
+		return b, fmt.Errorf("invalid %sMAINTENANCE_RECOVERY: must be positive", prefix)
+	}
+	if b.MaintenanceSkipChance, err = envFloat(prefix+"MAINTENANCE_SKIP_CHANCE", def.MaintenanceSkipChance); err != nil {
+		return b, err
+	}
+	if b.MaintenanceSkipChance < 0 || b.MaintenanceSkipChance > 1 {
+		return b, fmt.Errorf("invalid %sMAINTENANCE_SKIP_CHANCE: must be between 0 and 1", prefix)
+	}
+	if products := getEnv(prefix+"PRODUCTS", ""); products != "" {
+		b.Products = nil
+		for _, p := range strings.Split(products, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				b.Products = append(b.Products, p)
+			}
+		}
+	}
+	if raw := getEnv(prefix+"CHANGEOVER_MATRIX", ""); raw != "" {
+		if b.Changeovers, err = parseChangeovers(raw); err != nil {
+			return b, fmt.Errorf("invalid %sCHANGEOVER_MATRIX: %w", prefix, err)
+		}
+	}
+	if err := b.Changeovers.check(b.Products); err != nil {
+		return b, fmt.Errorf("invalid %sCHANGEOVER_MATRIX: %w", prefix, err)
+	}
+	if b.ChangeoverSigma, err = envFloat(prefix+"CHANGEOVER_SIGMA", def.ChangeoverSigma); err != nil {
+		return b, err
+	}
+	if b.ChangeoverSigma < 0 {
+		return b, fmt.Errorf("invalid %sCHANGEOVER_SIGMA: must not be negative", prefix)
+	}
+	return b, nil
+}
+
+// parseMachineIDs parses a comma-separated list of machine IDs.
+func parseMachineIDs(s string) ([]int, error) {
+	ids := strings.Split(s, ",")
+	out := make([]int, 0, len(ids))
+	for _, idStr := range ids {
+		id, err := strconv.Atoi(strings.TrimSpace(idStr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid machine ID '%s': %w", idStr, err)
+		}
+		out = append(out, id)
+	}
+	return out, nil
+}
+
+// parseSites parses SITES, a semicolon-separated list of
+// "name:machine_ids[:topic_prefix]" entries, e.g.
+//
+//	plant-a:1,2,3;plant-b:4,5:acme/plant-b
+//
+// The topic prefix defaults to "factory/<name>". Each site's behavior starts
+// from def and can be overridden with env vars prefixed by the upper-cased
+// site name, e.g. PLANT_B_SCRAP_RATE.
+func parseSites(s string, def Behavior) ([]Site, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var sites []Site
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fields := strings.Split(entry, ":")
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid SITES entry %q: want name:machine_ids[:topic_prefix]", entry)
+		}
+		site := Site{Name: strings.TrimSpace(fields[0])}
+		if site.Name == "" || strings.ContainsAny(site.Name, "/+#") {
+			return nil, fmt.Errorf("invalid site name %q", site.Name)
+		}
+		if seen[site.Name] {
+			return nil, fmt.Errorf("duplicate site name %q", site.Name)
+		}
+		seen[site.Name] = true
+
+		ids, err := parseMachineIDs(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("site %s: %w", site.Name, err)
+		}
+		site.MachineIDs = ids
+
+		site.TopicPrefix = "factory/" + site.Name
+		if len(fields) == 3 {
+			site.TopicPrefix = strings.Trim(strings.TrimSpace(fields[2]), "/")
+		}
+		if site.TopicPrefix == "" || strings.ContainsAny(site.TopicPrefix, "+#") {
+			return nil, fmt.Errorf("site %s: invalid topic prefix %q", site.Name, site.TopicPrefix)
+		}
+
+		site.Behavior, err = loadBehavior(siteEnvPrefix(site.Name), def)
+		if err != nil {
+			return nil, fmt.Errorf("site %s: %w", site.Name, err)
+		}
+		sites = append(sites, site)
+	}
+	return sites, nil
+}
+
+// parseGroups parses MACHINE_GROUPS, a semicolon-separated list of
+// "name:machine_ids" entries, e.g. "compressor-1:1,2;feeder-b:3,4,5".
+// A machine may belong to several groups.
+func parseGroups(s string) ([]MachineGroup, error) {
+	var groups []MachineGroup
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, ids, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid MACHINE_GROUPS entry %q: want name:machine_ids", entry)
+		}
+		machineIDs, err := parseMachineIDs(ids)
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %w", name, err)
+		}
+		groups = append(groups, MachineGroup{Name: name, MachineIDs: machineIDs})
+	}
+	return groups, nil
+}
+
+// siteEnvPrefix turns a site name into its env var prefix, e.g.
+// "plant-b" becomes "PLANT_B_".
+func siteEnvPrefix(name string) string {
+	return strings.Map(func(r rune) rune {
+		if ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		if 'a' <= r && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		return '_'
+	}, name) + "_"
+}
+
+// getEnv retrieves an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	recordDefault(key, defaultValue)
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// envSeconds reads a whole number of seconds from key, or returns def.
+func envSeconds(key string, def time.Duration) (time.Duration, error) {
+	recordDefault(key, strconv.Itoa(int(def/time.Second)))
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def, nil
+	}
+	sec, err := strconv.Atoi(raw)
+	if err != nil {
+		return def, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return time.Duration(sec) * time.Second, nil
+}
+
+// envInt reads an integer from key, or returns def.
+func envInt(key string, def int) (int, error) {
+	recordDefault(key, strconv.Itoa(def))
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return def, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return v, nil
+}
+
+// envFloat reads a float from key, or returns def.
+func envFloat(key string, def float64) (float64, error) {
+	recordDefault(key, strconv.FormatFloat(def, 'g', -1, 64))
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return def, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return v, nil
+}
+
+// recordDefault notes def as the default of key while flagDefaults
+// collects them.
+func recordDefault(key, def string) {
+	if envDefaults != nil {
+		envDefaults[key] = def
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// clockDrift is the error of a machine's clock, which like a field device's
+// isn't synchronized: its offset from real time swings slowly between
+// -amplitude and +amplitude over period.
+type clockDrift struct {
+	amplitude time.Duration
+	period    time.Duration
+	phase     float64 // radians, at runStarted
+}
+
+// newClockDrift draws a machine's drift within CLOCK_DRIFT_MAX. Machines get
+// different amplitudes, periods and phases so their clocks drift apart as
+// well as away from real time.
+func newClockDrift(r *rand.Rand) *clockDrift {
+	return &clockDrift{
+		amplitude: time.Duration(r.Float64() * float64(config.ClockDriftMax)),
+		period:    time.Duration((0.5 + r.Float64()) * float64(config.ClockDriftPeriod)),
+		phase:     2 * math.Pi * r.Float64(),
+	}
+}
+
+// offset is how far the clock is ahead of real time at t; a nil drift is
+// always exact.
+func (d *clockDrift) offset(t time.Time) time.Duration {
+	if d == nil {
+		return 0
+	}
+	angle := 2*math.Pi*float64(t.Sub(runStarted))/float64(d.period) + d.phase
+	return time.Duration(math.Sin(angle) * float64(d.amplitude))
+}
+
+// now is the time on m's own clock, in UTC, which is what its events carry.
+func (m Machine) now() time.Time {
+	now := time.Now()
+	offset := m.clock.offset(now)
+	if m.clock != nil {
+		clockOffset.WithLabelValues(m.Site, strconv.Itoa(m.ID)).Set(offset.Seconds())
+	}
+	return now.Add(offset).UTC()
+}
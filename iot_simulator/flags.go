@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/cliflags"
+)
+
+// options are the settings the simulator takes as flags, one per
+// environment variable, in the order of .env.example.
+var options = []cliflags.Option{
+	{Env: "CONFIG_FILE", Usage: "YAML or TOML file of settings keyed by variable name"},
+	{Env: "MQTT_BROKER_URL", Usage: "MQTT broker to publish to"},
+	{Env: "MQTT_CLIENT_ID", Usage: "MQTT client ID"},
+	{Env: "MQTT_USERNAME", Usage: "broker user name"},
+	{Env: "MQTT_USERNAME_FILE", Usage: "file holding the broker user name"},
+	{Env: "MQTT_PASSWORD", Usage: "broker password; visible to other users, prefer -mqtt-password-file"},
+	{Env: "MQTT_PASSWORD_FILE", Usage: "file holding the broker password"},
+	{Env: "MACHINE_IDS", Usage: "comma-separated machine IDs to simulate"},
+	{Env: "AVAILABILITY_ONLY_MACHINES", Usage: "comma-separated machines that run and stop but never make a part"},
+	{Env: "SITES", Usage: `semicolon-separated "name:machine_ids[:topic_prefix]" sites, replacing MACHINE_IDS`},
+	{Env: "IDEAL_CYCLE_TIME", Usage: "ideal time to make one part, in seconds"},
+	{Env: "SCRAP_RATE", Usage: "chance of a part being scrap (0.0 - 1.0)"},
+	{Env: "REWORK_RATE", Usage: "chance of a part going to rework (0.0 - 1.0)"},
+	{Env: "REWORK_SUCCESS_RATE", Usage: "chance the rework saves the part (0.0 - 1.0)"},
+	{Env: "WARMUP_PARTS", Usage: "parts after a start, breakdown or changeover scrapped at the warm-up rate (0 = none)"},
+	{Env: "WARMUP_SCRAP_RATE", Usage: "scrap rate of warm-up parts (0.0 - 1.0)"},
+	{Env: "DOWNTIME_CHANCE", Usage: "chance to go down after a cycle (0.0 - 1.0)"},
+	{Env: "DOWNTIME_MIN", Usage: "minimum downtime, in seconds"},
+	{Env: "DOWNTIME_MAX", Usage: "maximum downtime, in seconds"},
+	{Env: "MTBF", Usage: "mean run time between failures in seconds, replacing the downtime chance"},
+	{Env: "MTTR", Usage: "mean time to repair, in seconds; required with -mtbf"},
+	{Env: "PROFILE_FILE", Usage: "JSON profile fitted from plant data replacing the cycle, downtime and scrap settings"},
+	{Env: "LOT_SIZE", Usage: "parts per production lot (0 = no lots)"},
+	{Env: "LOT_CHANGEOVER", Usage: "changeover stop between lots, in seconds (0 = none)"},
+	{Env: "MAINTENANCE_INTERVAL", Usage: "run time between planned maintenance stops, in seconds (0 = none)"},
+	{Env: "MAINTENANCE_DURATION", Usage: "length of each maintenance stop, in seconds"},
+	{Env: "MAINTENANCE_FAILURE_FACTOR", Usage: "failure rate right after maintenance, as a fraction of the usual rate (1 = unchanged)"},
+	{Env: "MAINTENANCE_RECOVERY", Usage: "run time, in seconds, over which the failure rate climbs back after maintenance (time constant)"},
+	{Env: "MAINTENANCE_SKIP_CHANCE", Usage: "chance a maintenance due is skipped (0.0 - 1.0)"},
+	{Env: "PRODUCTS", Usage: "comma-separated products made in turn, one per lot"},
+	{Env: "CYCLE_TIMES", Usage: "ideal cycle times as machine:product=seconds entries"},
+	{Env: "CHANGEOVER_MATRIX", Usage: "mean setup times between products as from>to=seconds entries"},
+	{Env: "CHANGEOVER_SIGMA", Usage: "lognormal spread of setup times around their mean"},
+	{Env: "MACHINE_GROUPS", Usage: `semicolon-separated "name:machine_ids" groups sharing a utility`},
+	{Env: "SHARED_FAILURE_CHANCE", Usage: "chance a shared utility fails at each check (0.0 - 1.0)"},
+	{Env: "SHARED_FAILURE_INTERVAL", Usage: "time between shared failure checks, in seconds"},
+	{Env: "SHARED_FAILURE_MIN", Usage: "minimum shared outage, in seconds"},
+	{Env: "SHARED_FAILURE_MAX", Usage: "maximum shared outage, in seconds"},
+	{Env: "PERFORMANCE_LOSS_CHANCE", Usage: "chance of a slow cycle (0.0 - 1.0)"},
+	{Env: "PERFORMANCE_LOSS_MAX_DELAY", Usage: "maximum extra delay of a slow cycle, in seconds"},
+	{Env: "MACHINE_COUNT", Usage: "simulate machines 1..N instead of MACHINE_IDS (0 = off)"},
+	{Env: "FLEET_IDEAL_CYCLE_TIME", Usage: `"min-max" range of generated machines' ideal cycle times`},
+	{Env: "FLEET_SCRAP_RATE", Usage: `"min-max" range of generated machines' scrap rates`},
+	{Env: "FLEET_REWORK_RATE", Usage: `"min-max" range of generated machines' rework rates`},
+	{Env: "FLEET_DOWNTIME_CHANCE", Usage: `"min-max" range of generated machines' downtime chances`},
+	{Env: "FLEET_PERFORMANCE_LOSS_CHANCE", Usage: `"min-max" range of generated machines' slow cycle chances`},
+	{Env: "FLEET_MTBF", Usage: `"min-max" range of generated machines' MTBF`},
+	{Env: "FLEET_MTTR", Usage: `"min-max" range of generated machines' MTTR`},
+	{Env: "SEED", Usage: "random seed (0 = from the clock)"},
+	{Env: "TIMEZONE", Usage: "IANA time zone of the shift start times"},
+	{Env: "SHIFT_STARTS", Usage: "comma-separated local shift start times"},
+	{Env: "HANDOVER_WINDOW", Usage: "seconds either side of a shift start during which losses ramp up (0 = off)"},
+	{Env: "HANDOVER_LOSS_FACTOR", Usage: "multiplier on the slow cycle chance at the shift change"},
+	{Env: "HANDOVER_MICRO_STOP_CHANCE", Usage: "per-cycle chance of a handover stop at the shift change"},
+	{Env: "HANDOVER_MICRO_STOP_MAX", Usage: "maximum handover stop, in seconds"},
+	{Env: "OPERATORS", Usage: "comma-separated operator IDs rotated across the machines each shift"},
+	{Env: "REDUCED_SPEED_WINDOWS", Usage: `daily windows running machines slower on purpose, as "machines@HH:MM-HH:MM=speed" entries`},
+	{Env: "QUALITY_INTERVENTION_INTERVAL", Usage: "seconds between scheduled quality interventions (0 = command only)"},
+	{Env: "QUALITY_INTERVENTION_SCRAP_FACTOR", Usage: "fraction of the scrap rate right after an intervention"},
+	{Env: "QUALITY_INTERVENTION_RECOVERY", Usage: "seconds for the scrap rate to drift back after an intervention"},
+	{Env: "MEASUREMENT_NOMINAL", Usage: "nominal part diameter in mm (0 = no measurements)"},
+	{Env: "MEASUREMENT_TOLERANCE", Usage: "specification tolerance around the nominal, in mm"},
+	{Env: "MEASUREMENT_REWORK_BAND", Usage: "oversize band above the upper limit that is reworked, in mm"},
+	{Env: "MEASUREMENT_SIGMA", Usage: "standard deviation of measurements, in mm"},
+	{Env: "MEASUREMENT_WEAR_RATE", Usage: "drift of the mean per hour of run time, in mm"},
+	{Env: "MEASUREMENT_TOOL_LIFE", Usage: "run time after which the tool is replaced, in seconds"},
+	{Env: "MEASUREMENT_OUTLIER_CHANCE", Usage: "chance of a gross measurement error"},
+	{Env: "QUALITY_MODEL", Usage: `how parts are graded: "rate" or "measured"`},
+	{Env: "PART_SERIALS", Usage: `serialize shipped parts: "sequence" or "uuid" (empty = none)`},
+	{Env: "INSPECTION_DELAY", Usage: "seconds after each part that a downstream station inspects it (0 = none)"},
+	{Env: "CLOCK_DRIFT_MAX", Usage: "maximum offset of each machine's timestamps, in seconds (0 = exact)"},
+	{Env: "CLOCK_DRIFT_PERIOD", Usage: "seconds a machine's clock drift takes to swing back and forth"},
+	{Env: "LOAD_TEST_RATE", Usage: "publish this many production events per second instead of simulating (0 = off)"},
+	{Env: "LOAD_TEST_DURATION", Usage: "length of the load test, in seconds"},
+	{Env: "LOAD_TEST_MACHINES", Usage: "machines to spread the load test over (0 = one per 10 msg/s)"},
+	{Env: "RUN_DURATION", Usage: "stop after this many seconds (0 = run forever)"},
+	{Env: "TARGET_EVENT_COUNT", Usage: "stop after this many production events (0 = no limit)"},
+	{Env: "TARGET_GOOD_PARTS", Usage: "stop each machine after this many good parts (0 = no limit)"},
+	{Env: "PUBLISH_MODE", Usage: `how publishes are confirmed: "sync", "async" or "ordered"`},
+	{Env: "PUBLISH_QUEUE_SIZE", Usage: "events each machine may queue in ordered mode"},
+	{Env: "MAX_CONCURRENT_PUBLISHES", Usage: "publishes awaiting their ack across all machines (0 = unlimited)"},
+	{Env: "PUBLISH_OVERFLOW", Usage: "event kinds dropped when the broker is behind, as kind=drop|block entries"},
+	{Env: "QOS_DOWNGRADE_AT", Usage: "events in flight at which production events go out at QoS 0 (0 = never)"},
+	{Env: "QOS_RESTORE_AT", Usage: "events in flight at which QoS 1 is restored (default half of the downgrade level)"},
+	{Env: "PUBLISH_QUARANTINE_AFTER", Usage: "failed publishes in a row after which a machine is quarantined (0 = never)"},
+	{Env: "PUBLISH_QUARANTINE_BACKOFF", Usage: "first quarantine backoff, in seconds"},
+	{Env: "PUBLISH_QUARANTINE_MAX_BACKOFF", Usage: "longest quarantine backoff, in seconds"},
+	{Env: "CHAOS_PUBLISH_LATENCY_MS", Usage: "delay every publish ack by this many milliseconds (0 = off)"},
+	{Env: "CHAOS_PUBLISH_ERROR_RATE", Usage: "fraction of publishes failed without sending them (0 = off)"},
+	{Env: "PUBLISH_WAIT_TIMEOUT", Usage: "maximum wait for a publish ack, in seconds (0 = no cap)"},
+	{Env: "TRACE_IDS", Usage: "attach a random trace_id to every event", Bool: true},
+	{Env: "CYCLE_INDEX", Usage: "number production events by cycle", Bool: true},
+	{Env: "PARTS_TOTALIZER", Usage: "add each machine's good parts counter to production events as parts_total", Bool: true},
+	{Env: "PARTS_TOTALIZER_MAX", Usage: "value after which the parts counter wraps to 0 (0 = never)"},
+	{Env: "STATUS_SUMMARY", Usage: "add the parts made and uptime since the previous status event to each status event", Bool: true},
+	{Env: "PUBLISH_TIMESTAMPS", Usage: "stamp status and production events with their publish time, for latency measurement", Bool: true},
+	{Env: "METRICS_ADDR", Usage: "address of the /metrics endpoint (empty = disabled)"},
+	{Env: "METRICS_REQUIRED", Usage: "exit if the metrics address can't be bound", Bool: true},
+	{Env: "API_TOKEN", Usage: "bearer token required on /metrics and the HTTP endpoints"},
+	{Env: "DEBUG_ENDPOINTS", Usage: "serve the effective configuration on /debug/config", Bool: true},
+	{Env: "OTEL_EXPORTER_OTLP_ENDPOINT", Usage: "OTLP/HTTP endpoint to export spans to (unset = no tracing)"},
+}
+
+// optionsFooter lists the settings taken from the environment only.
+const optionsFooter = `Per-site overrides of the behavior settings (e.g. PLANT_B_SCRAP_RATE) and
+the other OTEL_EXPORTER_OTLP_* variables are read from the environment only.`
+
+// parseFlags applies the command-line flags to the environment, before
+// loadConfig reads it.
+func parseFlags() {
+	cliflags.Parse("oee-simulator", os.Args[1:], options, optionsFooter, flagDefaults)
+}
+
+// envDefaults, when not nil, collects the defaults the settings are read
+// with, for -h.
+var envDefaults map[string]string
+
+// flagDefaults returns the defaults of the settings. It loads the
+// configuration from an emptied environment, so it is only for -h, which
+// exits right after.
+func flagDefaults() map[string]string {
+	envDefaults = map[string]string{}
+	os.Clearenv()
+	loadConfig()
+	return envDefaults
+}
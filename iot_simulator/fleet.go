@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Range is a uniform distribution over [Min, Max].
+type Range struct {
+	Min, Max float64
+}
+
+// parseRange reads "min-max", or a single value for a fixed parameter.
+// Both bounds must be non-negative.
+func parseRange(s string) (Range, error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	if !isRange {
+		hi = lo
+	}
+	min, err1 := strconv.ParseFloat(strings.TrimSpace(lo), 64)
+	max, err2 := strconv.ParseFloat(strings.TrimSpace(hi), 64)
+	if err1 != nil || err2 != nil || min < 0 || max < min {
+		return Range{}, fmt.Errorf("invalid range %q: want min-max with 0 <= min <= max", s)
+	}
+	return Range{Min: min, Max: max}, nil
+}
+
+func (r Range) draw(rng *rand.Rand) float64 {
+	return r.Min + rng.Float64()*(r.Max-r.Min)
+}
+
+// Fleet spawns MACHINE_COUNT machines whose parameters are drawn from
+// ranges instead of being configured one by one. A nil range leaves that
+// parameter at the base behavior for every machine.
+type Fleet struct {
+	Count                 int
+	IdealCycleTime        *Range // seconds
+	ScrapRate             *Range
+	ReworkRate            *Range
+	DowntimeChance        *Range
+	PerformanceLossChance *Range
+	MTBF                  *Range // seconds
+	MTTR                  *Range // seconds
+}
+
+// loadFleet reads MACHINE_COUNT and the FLEET_* ranges.
+func loadFleet() (Fleet, error) {
+	var f Fleet
+	var err error
+	if f.Count, err = envInt("MACHINE_COUNT", 0); err != nil {
+		return f, err
+	}
+	if f.Count < 0 {
+		return f, fmt.Errorf("invalid MACHINE_COUNT: must not be negative")
+	}
+	for key, dst := range map[string]**Range{
+		"FLEET_IDEAL_CYCLE_TIME":        &f.IdealCycleTime,
+		"FLEET_SCRAP_RATE":              &f.ScrapRate,
+		"FLEET_REWORK_RATE":             &f.ReworkRate,
+		"FLEET_DOWNTIME_CHANCE":         &f.DowntimeChance,
+		"FLEET_PERFORMANCE_LOSS_CHANCE": &f.PerformanceLossChance,
+		"FLEET_MTBF":                    &f.MTBF,
+		"FLEET_MTTR":                    &f.MTTR,
+	} {
+		raw := getEnv(key, "")
+		if raw == "" {
+			continue
+		}
+		r, err := parseRange(raw)
+		if err != nil {
+			return f, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		*dst = &r
+	}
+	if f.IdealCycleTime != nil && f.IdealCycleTime.Min <= 0 {
+		return f, fmt.Errorf("invalid FLEET_IDEAL_CYCLE_TIME: cycle times must be positive")
+	}
+	if (f.MTBF != nil && f.MTBF.Min <= 0) || (f.MTTR != nil && f.MTTR.Min <= 0) {
+		return f, fmt.Errorf("invalid FLEET_MTBF/FLEET_MTTR: times must be positive")
+	}
+	return f, nil
+}
+
+// vary returns base with every ranged parameter replaced by a draw from
+// rng. Draws happen in a fixed order so a given seed always yields the same
+// fleet.
+func (f Fleet) vary(base Behavior, rng *rand.Rand) Behavior {
+	b := base
+	if r := f.IdealCycleTime; r != nil {
+		b.IdealCycleTime = time.Duration(r.draw(rng) * float64(time.Second))
+	}
+	if r := f.ScrapRate; r != nil {
+		b.ScrapRate = r.draw(rng)
+	}
+	if r := f.ReworkRate; r != nil {
+		b.ReworkRate = r.draw(rng)
+	}
+	if r := f.DowntimeChance; r != nil {
+		b.DowntimeChance = r.draw(rng)
+	}
+	if r := f.PerformanceLossChance; r != nil {
+		b.PerformanceLossChance = r.draw(rng)
+	}
+	if r := f.MTBF; r != nil {
+		b.MTBF = time.Duration(r.draw(rng) * float64(time.Second))
+	}
+	if r := f.MTTR; r != nil {
+		b.MTTR = time.Duration(r.draw(rng) * float64(time.Second))
+	}
+	return b
+}
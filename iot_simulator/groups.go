@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// Reason codes attached to "stopped" status events.
+const (
+	reasonBreakdown      = "breakdown"
+	reasonUtilityFailure = "utility_failure"
+	reasonChangeover     = "changeover"
+	reasonHandover       = "handover"
+	reasonMaintenance    = "maintenance"
+)
+
+// outage is a stop imposed on a machine from outside its own loop, such as a
+// shared utility failure.
+type outage struct {
+	reason string
+	until  time.Time
+}
+
+// simulateGroup periodically rolls for a failure of the utility shared by a
+// group of machines. On failure every member receives the same outage, so
+// they stop together and recover together.
+func simulateGroup(ctx context.Context, g MachineGroup, members []Machine, r *rand.Rand) {
+	for {
+		select {
+		case <-time.After(config.SharedFailureInterval):
+		case <-ctx.Done():
+			return
+		}
+		if r.Float64() >= config.SharedFailureChance {
+			continue
+		}
+
+		duration := time.Duration(r.Int63n(int64(config.SharedFailureMax-config.SharedFailureMin))) + config.SharedFailureMin
+		log.Printf("[Group %s] Shared utility failure: stopping %d machines for %v", g.Name, len(members), duration)
+
+		o := outage{reason: reasonUtilityFailure, until: time.Now().Add(duration)}
+		for _, m := range members {
+			// Never block the group on a busy machine; a pending outage
+			// already covers it.
+			select {
+			case m.outages <- o:
+			default:
+			}
+		}
+	}
+}
+
+// stopUntil keeps the machine stopped until the given time, extending the
+// stop if an outage that lasts longer arrives in the meantime. It returns
+// false if the run ends first.
+func (m Machine) stopUntil(ctx context.Context, until time.Time) bool {
+	for {
+		select {
+		case <-time.After(time.Until(until)):
+			return true
+		case o := <-m.outages:
+			if o.until.After(until) {
+				until = o.until
+			}
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/events"
+)
+
+// Defect codes of the parts the inspection station fails.
+const (
+	defectOutOfSpec     = "out_of_spec"
+	defectStartupReject = "startup_reject"
+	defectVisual        = "visual"
+)
+
+// inspectLater has the inspection station downstream of m inspect part,
+// the production event m just published, INSPECTION_DELAY later. The
+// station agrees with the machine: scrap fails and the rest passes.
+// Inspections still pending when ctx is done are never published.
+func inspectLater(ctx context.Context, client mqtt.Client, m Machine, part events.ProductionEvent) {
+	go func() {
+		timer := time.NewTimer(config.InspectionDelay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			sendInspectionEvent(client, m, part)
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// sendInspectionEvent publishes the inspection station's verdict on part.
+func sendInspectionEvent(client mqtt.Client, m Machine, part events.ProductionEvent) {
+	msg := newMessage(m, events.KindInspection)
+	event := events.InspectionEvent{
+		MachineID:  m.ID,
+		Site:       m.Site,
+		ProducedAt: part.Timestamp,
+		Serial:     part.Serial,
+		Result:     events.InspectionPass,
+		TraceID:    msg.traceID,
+		SpanID:     msg.spanID,
+		Timestamp:  m.now(),
+	}
+	if part.PartsScrapped > 0 {
+		event.Result = events.InspectionFail
+		switch {
+		case part.Measurement != nil && part.Measurement.OutOfSpec():
+			event.DefectCode = defectOutOfSpec
+		case part.Warmup:
+			event.DefectCode = defectStartupReject
+		default:
+			event.DefectCode = defectVisual
+		}
+	}
+	msg.payload, _ = json.Marshal(event)
+	publish(client, m, msg)
+}
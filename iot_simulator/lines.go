@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// reasonLineStop is the reason of the stops a line stop imposes.
+const reasonLineStop = "line_stop"
+
+// Defaults for a line stop that doesn't give its timing.
+const (
+	defaultLineStopDuration = 5 * time.Minute
+	defaultLineStopStagger  = 30 * time.Second
+)
+
+// lineRestart is when one machine of a stopped line runs again.
+type lineRestart struct {
+	MachineID int       `json:"machine_id"`
+	Site      string    `json:"site,omitempty"`
+	RestartAt time.Time `json:"restart_at"`
+}
+
+// stopLine stops every machine of the MACHINE_GROUPS group named line at
+// once, rehearsing a major incident. After d the machines restart one by
+// one, stagger apart in the order they were configured, each warming up as
+// after any restart. Machines already stopped stay stopped until their
+// restart.
+func stopLine(line string, d, stagger time.Duration, machines []Machine) ([]lineRestart, error) {
+	i := slices.IndexFunc(config.Groups, func(g MachineGroup) bool { return g.Name == line })
+	if i < 0 {
+		return nil, fmt.Errorf("no MACHINE_GROUPS group %q", line)
+	}
+	if d <= 0 {
+		d = defaultLineStopDuration
+	}
+	if stagger < 0 {
+		stagger = defaultLineStopStagger
+	}
+
+	now := time.Now()
+	var restarts []lineRestart
+	for _, m := range machines {
+		if !slices.Contains(config.Groups[i].MachineIDs, m.ID) {
+			continue
+		}
+		o := outage{reason: reasonLineStop, until: now.Add(d + time.Duration(len(restarts))*stagger)}
+		restarts = append(restarts, lineRestart{MachineID: m.ID, Site: m.Site, RestartAt: o.until.UTC()})
+		// Unlike a utility failure this is delivered even to a machine with
+		// a shorter outage pending, unless the line restarts first.
+		go func() {
+			select {
+			case m.outages <- o:
+			case <-time.After(time.Until(o.until)):
+			}
+		}()
+	}
+	log.Printf("[Group %s] Line stop: stopping %d machines for %v, restarting %v apart", line, len(restarts), d, stagger)
+	return restarts, nil
+}
+
+// lineOf returns the one group m belongs to, for a stop_line command that
+// names no line.
+func lineOf(m Machine) (string, error) {
+	var names []string
+	for _, g := range config.Groups {
+		if slices.Contains(g.MachineIDs, m.ID) {
+			names = append(names, g.Name)
+		}
+	}
+	if len(names) != 1 {
+		return "", fmt.Errorf("machine is in %d groups; name the line", len(names))
+	}
+	return names[0], nil
+}
+
+// stopLineResponse is the body returned by POST /stop_line.
+type stopLineResponse struct {
+	Group    string        `json:"group"`
+	Reason   string        `json:"reason"`
+	Machines []lineRestart `json:"machines"`
+}
+
+// stopLineHandler serves POST /stop_line?group=line-1&duration=300
+// &stagger=20, with duration and stagger in seconds.
+func stopLineHandler(machines []Machine) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		d, stagger := time.Duration(0), time.Duration(-1)
+		for _, p := range []struct {
+			name string
+			into *time.Duration
+		}{{"duration", &d}, {"stagger", &stagger}} {
+			raw := q.Get(p.name)
+			if raw == "" {
+				continue
+			}
+			sec, err := strconv.ParseFloat(raw, 64)
+			if err != nil || sec < 0 {
+				http.Error(w, p.name+" must be a non-negative number of seconds", http.StatusBadRequest)
+				return
+			}
+			*p.into = time.Duration(sec * float64(time.Second))
+		}
+
+		restarts, err := stopLine(q.Get("group"), d, stagger, machines)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(stopLineResponse{Group: q.Get("group"), Reason: reasonLineStop, Machines: restarts})
+	})
+}
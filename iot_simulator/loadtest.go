@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/events"
+)
+
+// loadTestMachineRate is the rate, in messages per second, each machine
+// publishes at when LOAD_TEST_MACHINES is not set.
+const loadTestMachineRate = 10
+
+// LoadTest replaces the simulation with a benchmark: production events are
+// published at Rate messages per second for Duration, spread evenly over
+// Machines machines, and a summary is printed at the end.
+type LoadTest struct {
+	Rate     float64 // messages per second; zero disables the load test
+	Duration time.Duration
+	Machines int
+}
+
+// loadLoadTest reads LOAD_TEST_RATE, LOAD_TEST_DURATION and
+// LOAD_TEST_MACHINES.
+func loadLoadTest() (LoadTest, error) {
+	var lt LoadTest
+	var err error
+	if lt.Rate, err = envFloat("LOAD_TEST_RATE", 0); err != nil {
+		return lt, err
+	}
+	if lt.Duration, err = envSeconds("LOAD_TEST_DURATION", time.Minute); err != nil {
+		return lt, err
+	}
+	if lt.Machines, err = envInt("LOAD_TEST_MACHINES", 0); err != nil {
+		return lt, err
+	}
+	if lt.Rate < 0 || lt.Duration <= 0 || lt.Machines < 0 {
+		return lt, fmt.Errorf("invalid load test: LOAD_TEST_RATE and LOAD_TEST_MACHINES must not be negative and LOAD_TEST_DURATION must be positive")
+	}
+	if lt.Rate > 0 && lt.Machines == 0 {
+		lt.Machines = int(math.Ceil(lt.Rate / loadTestMachineRate))
+	}
+	return lt, nil
+}
+
+// loadStats collects the outcome of every production publish during a load
+// test. It is nil otherwise, and its methods do nothing.
+var loadStats *loadTestStats
+
+type loadTestStats struct {
+	sent     atomic.Int64
+	timeouts atomic.Int64
+
+	mu        sync.Mutex
+	errors    int64
+	latencies []time.Duration // of acked publishes
+}
+
+// published records that a publish of msg completed after latency, with err
+// if it failed.
+func (s *loadTestStats) published(msg message, latency time.Duration, err error) {
+	if s == nil || msg.kind != events.KindProduction {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.errors++
+		return
+	}
+	s.latencies = append(s.latencies, latency)
+}
+
+// timedOut records that PUBLISH_WAIT_TIMEOUT expired waiting for msg's ack.
+// The publish is still recorded by published if the ack arrives later.
+func (s *loadTestStats) timedOut(msg message) {
+	if s == nil || msg.kind != events.KindProduction {
+		return
+	}
+	s.timeouts.Add(1)
+}
+
+// LoadTestReport is the JSON summary printed at the end of a load test.
+// Latencies run from handing the event to the MQTT client to the broker's
+// ack, so they include the client's outbound queue but not time an event
+// waits for its machine's publisher in ordered mode.
+type LoadTestReport struct {
+	TargetRate   float64 `json:"target_rate"`
+	Machines     int     `json:"machines"`
+	PublishMode  string  `json:"publish_mode"`
+	DurationSec  float64 `json:"duration_sec"`
+	Sent         int64   `json:"sent"`
+	Acked        int64   `json:"acked"`
+	Errors       int64   `json:"errors"`
+	Timeouts     int64   `json:"timeouts"`
+	Unconfirmed  int64   `json:"unconfirmed"`
+	AchievedRate float64 `json:"achieved_rate"`
+	ErrorRate    float64 `json:"error_rate"`
+	LatencyMs    struct {
+		P50  float64 `json:"p50"`
+		P99  float64 `json:"p99"`
+		Max  float64 `json:"max"`
+		Mean float64 `json:"mean"`
+	} `json:"latency_ms"`
+}
+
+// runLoadTest publishes production events from machines at the configured
+// rate until LOAD_TEST_DURATION elapses or ctx ends, waits for outstanding
+// acks and prints the report to stdout.
+//
+// Each machine publishes on its own ticker. A ticker drops ticks rather than
+// queueing them, so when the publish path can't keep up the achieved rate
+// falls below the target instead of the backlog growing.
+func runLoadTest(ctx context.Context, client mqtt.Client, machines []Machine) {
+	lt := config.LoadTest
+	loadStats = &loadTestStats{}
+	interval := time.Duration(float64(len(machines)) / lt.Rate * float64(time.Second))
+	log.Printf("Load test: %.1f msg/s across %d machines (one every %v each) for %v", lt.Rate, len(machines), interval, lt.Duration)
+
+	ctx, cancel := context.WithTimeout(ctx, lt.Duration)
+	defer cancel()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i, m := range machines {
+		startedAt := start.UTC()
+		sendLifecycleEvent(client, m, events.LifecycleBirth, startedAt)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer sendLifecycleEvent(client, m, events.LifecycleDeath, startedAt)
+			// Stagger the machines so their publishes spread over the interval
+			select {
+			case <-time.After(interval * time.Duration(i) / time.Duration(len(machines))):
+			case <-ctx.Done():
+				return
+			}
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				loadStats.sent.Add(1)
+				sendProductionEvent(client, m, events.ProductionEvent{
+					PartsProduced: 1,
+				})
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	pendingPublishes.Wait()
+
+	report := loadStats.report(lt, len(machines), time.Since(start))
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		log.Printf("failed to write load test report: %v", err)
+	}
+}
+
+// report summarizes the run. Publishes still waiting for an ack, which is
+// only possible after a PUBLISH_WAIT_TIMEOUT, count as unconfirmed.
+func (s *loadTestStats) report(lt LoadTest, machines int, elapsed time.Duration) LoadTestReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := LoadTestReport{
+		TargetRate:  lt.Rate,
+		Machines:    machines,
+		PublishMode: config.PublishMode,
+		DurationSec: elapsed.Seconds(),
+		Sent:        s.sent.Load(),
+		Acked:       int64(len(s.latencies)),
+		Errors:      s.errors,
+		Timeouts:    s.timeouts.Load(),
+	}
+	r.Unconfirmed = r.Sent - r.Acked - r.Errors
+	r.AchievedRate = float64(r.Acked) / elapsed.Seconds()
+	if r.Sent > 0 {
+		r.ErrorRate = float64(r.Errors) / float64(r.Sent)
+	}
+	if len(s.latencies) == 0 {
+		return r
+	}
+
+	slices.Sort(s.latencies)
+	var total time.Duration
+	for _, l := range s.latencies {
+		total += l
+	}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	r.LatencyMs.P50 = ms(percentile(s.latencies, 0.50))
+	r.LatencyMs.P99 = ms(percentile(s.latencies, 0.99))
+	r.LatencyMs.Max = ms(s.latencies[len(s.latencies)-1])
+	r.LatencyMs.Mean = ms(total / time.Duration(len(s.latencies)))
+	return r
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted, which must
+// not be empty.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
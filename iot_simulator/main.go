@@ -1,139 +1,144 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"math/rand"
 	"os"
+	"slices"
 	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/joho/godotenv"
-)
-
-// Configuration loaded from environment variables
-type Config struct {
-	MQTTBrokerURL           string
-	MQTTClientID            string
-	MachineIDs              []int
-	IdealCycleTime          time.Duration
-	ScrapRate               float64
-	DowntimeChance          float64
-	DowntimeMin             time.Duration
-	DowntimeMax             time.Duration
-	PerformanceLossChance   float64
-	PerformanceLossMaxDelay time.Duration
-}
+	"go.opentelemetry.io/otel/codes"
 
-// Global config instance
-var config Config
+	"github.com/SirNacou/OEE-Factory-Monitor/events"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/buildinfo"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/cycletime"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/mqttmetrics"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/telemetry"
+)
 
 // Mutex to synchronize MQTT publishes from multiple goroutines
 var publishMutex sync.Mutex
 
-// loadConfig loads configuration from environment variables
-func loadConfig() (Config, error) {
-	// Load .env file if it exists (ignored in Docker, where env vars are set directly)
-	_ = godotenv.Load()
-
-	cfg := Config{
-		MQTTBrokerURL: getEnv("MQTT_BROKER_URL", "tcp://localhost:1883"),
-		MQTTClientID:  getEnv("MQTT_CLIENT_ID", "oee-simulator"),
-	}
-
-	// Parse machine IDs
-	machineIDsStr := getEnv("MACHINE_IDS", "1,2,3")
-	ids := strings.Split(machineIDsStr, ",")
-	cfg.MachineIDs = make([]int, 0, len(ids))
-	for _, idStr := range ids {
-		id, err := strconv.Atoi(strings.TrimSpace(idStr))
-		if err != nil {
-			return cfg, fmt.Errorf("invalid machine ID '%s': %w", idStr, err)
-		}
-		cfg.MachineIDs = append(cfg.MachineIDs, id)
-	}
-
-	// Parse timing values
-	idealCycleTimeSec, err := strconv.Atoi(getEnv("IDEAL_CYCLE_TIME", "3"))
-	if err != nil {
-		return cfg, fmt.Errorf("invalid IDEAL_CYCLE_TIME: %w", err)
-	}
-	cfg.IdealCycleTime = time.Duration(idealCycleTimeSec) * time.Second
-
-	cfg.ScrapRate, err = strconv.ParseFloat(getEnv("SCRAP_RATE", "0.05"), 64)
-	if err != nil {
-		return cfg, fmt.Errorf("invalid SCRAP_RATE: %w", err)
-	}
-
-	cfg.DowntimeChance, err = strconv.ParseFloat(getEnv("DOWNTIME_CHANCE", "0.1"), 64)
-	if err != nil {
-		return cfg, fmt.Errorf("invalid DOWNTIME_CHANCE: %w", err)
-	}
-
-	downtimeMinSec, err := strconv.Atoi(getEnv("DOWNTIME_MIN", "10"))
-	if err != nil {
-		return cfg, fmt.Errorf("invalid DOWNTIME_MIN: %w", err)
-	}
-	cfg.DowntimeMin = time.Duration(downtimeMinSec) * time.Second
+// Machine is a single simulated machine and the site it belongs to.
+type Machine struct {
+	ID          int
+	Site        string
+	TopicPrefix string
+	Behavior
+
+	// outages delivers shared stops from the machine's groups. It is nil
+	// for machines that don't belong to a group.
+	outages chan outage
+	// quality tracks the machine's last quality intervention.
+	quality *intervention
+	// anomaly is the anomaly injected into the machine, if any.
+	anomaly *anomalyState
+	// clock is the drift of the machine's clock; nil keeps it exact.
+	clock *clockDrift
+	// seq is the sequence number of the machine's last status, production
+	// or lifecycle event.
+	seq *atomic.Uint64
+	// cycle is the cycle index of the machine's last production event.
+	cycle *atomic.Uint64
+	// partsTotal is the machine's good parts counter, as PARTS_TOTALIZER
+	// reports it.
+	partsTotal *atomic.Uint64
+	// cycleTimes overrides IdealCycleTime per product, as CYCLE_TIMES.
+	cycleTimes cycletime.Matrix
+	// live holds the machine's behavior and cycle times as last loaded,
+	// which the machine picks up at the start of each cycle.
+	live *liveSettings
+	// totals counts the parts the machine has made in this run.
+	totals *events.PartTotals
+	// summary accumulates what the machine did since its last status
+	// event, for STATUS_SUMMARY.
+	summary *statusSummary
+	// queue holds messages for the machine's publisher; it is nil unless
+	// PUBLISH_MODE is ordered.
+	queue chan message
+	// unacked counts the machine's messages queued or waiting for their
+	// ack, and dropped the events dropped since it last published one.
+	unacked *atomic.Int64
+	dropped *atomic.Uint64
+	health  *publishHealth
+	// downgraded is set while the machine's production events go out at
+	// QoS 0 because of its backlog.
+	downgraded *atomic.Bool
+	// availabilityOnly machines run and stop but publish no production
+	// events, as AVAILABILITY_ONLY_MACHINES.
+	availabilityOnly bool
+}
 
-	downtimeMaxSec, err := strconv.Atoi(getEnv("DOWNTIME_MAX", "30"))
-	if err != nil {
-		return cfg, fmt.Errorf("invalid DOWNTIME_MAX: %w", err)
-	}
-	cfg.DowntimeMax = time.Duration(downtimeMaxSec) * time.Second
+// runStarted identifies this simulator run in lot IDs, so lots from a
+// restarted simulator don't collide with earlier ones.
+var runStarted = time.Now().UTC()
 
-	cfg.PerformanceLossChance, err = strconv.ParseFloat(getEnv("PERFORMANCE_LOSS_CHANCE", "0.20"), 64)
-	if err != nil {
-		return cfg, fmt.Errorf("invalid PERFORMANCE_LOSS_CHANCE: %w", err)
-	}
+// lotID returns the ID of the machine's seq-th lot in this run, e.g.
+// "1-20251105T090000-0003", or "plant-a-1-20251105T090000-0003" at site
+// plant-a.
+func (m Machine) lotID(seq int) string {
+	return fmt.Sprintf("%s-%s-%04d", m.runPrefix(), runStarted.Format("20060102T150405"), seq)
+}
 
-	perfLossMaxDelaySec, err := strconv.Atoi(getEnv("PERFORMANCE_LOSS_MAX_DELAY", "2"))
-	if err != nil {
-		return cfg, fmt.Errorf("invalid PERFORMANCE_LOSS_MAX_DELAY: %w", err)
+// runPrefix starts the machine's lot IDs and serials: its ID, after its
+// site if it has one, so machines sharing an ID at different sites don't
+// issue the same ones.
+func (m Machine) runPrefix() string {
+	if m.Site == "" {
+		return strconv.Itoa(m.ID)
 	}
-	cfg.PerformanceLossMaxDelay = time.Duration(perfLossMaxDelaySec) * time.Second
-
-	return cfg, nil
+	return m.Site + "-" + strconv.Itoa(m.ID)
 }
 
-// getEnv retrieves an environment variable or returns a default value
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// product returns the product made in the machine's seq-th lot, rotating
+// through Products. It is empty when no products are configured.
+func (m Machine) product(seq int) string {
+	if len(m.Products) == 0 {
+		return ""
 	}
-	return defaultValue
+	return m.Products[(seq-1)%len(m.Products)]
 }
 
-// StatusEvent represents a machine changing its operational state.
-type StatusEvent struct {
-	MachineID int       `json:"machine_id"`
-	Status    string    `json:"status"` // e.g., "running", "stopped"
-	Timestamp time.Time `json:"timestamp"`
+// cycleTime returns the ideal cycle time for product on this machine: the
+// CYCLE_TIMES entry if there is one, otherwise the machine's IdealCycleTime.
+func (m Machine) cycleTime(product string) time.Duration {
+	if d, ok := m.cycleTimes.Lookup(m.ID, product); ok {
+		return d
+	}
+	return m.IdealCycleTime
 }
 
-// ProductionEvent represents a machine producing parts.
-type ProductionEvent struct {
-	MachineID     int       `json:"machine_id"`
-	PartsProduced int       `json:"parts_produced"`
-	PartsScrapped int       `json:"parts_scrapped"`
-	Timestamp     time.Time `json:"timestamp"`
+// topic returns the topic for the given event kind, e.g.
+// "factory/machine/1/status".
+func (m Machine) topic(kind string) string {
+	return events.MarshalTopic(m.TopicPrefix, m.ID, kind)
 }
 
-// connectMQTT establishes a connection to the MQTT broker.
-func connectMQTT(brokerURL, clientID string) (mqtt.Client, error) {
+// connectMQTT establishes a connection to the MQTT broker, calling
+// onConnect after every (re)connect.
+func connectMQTT(brokerURL, clientID string, onConnect func(mqtt.Client)) (mqtt.Client, error) {
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(brokerURL)
 	opts.SetClientID(clientID)
+	opts.SetUsername(config.MQTTUsername)
+	opts.SetPassword(config.MQTTPassword)
 	opts.SetAutoReconnect(true)
 	opts.SetConnectRetry(true)
+	netPartition.enable(opts, brokerURL)
 	opts.OnConnect = func(c mqtt.Client) {
+		mqttmetrics.Connect()
 		log.Printf("Connected to MQTT broker at %s", brokerURL)
+		onConnect(c)
 	}
 	opts.OnConnectionLost = func(c mqtt.Client, err error) {
+		mqttmetrics.ConnectionLost()
 		log.Printf("MQTT connection lost: %v", err)
 	}
 
@@ -146,144 +151,796 @@ func connectMQTT(brokerURL, clientID string) (mqtt.Client, error) {
 
 // main is the entry point. It connects to MQTT and launches machine goroutines.
 func main() {
-	// Load configuration from environment variables
+	// Load configuration from flags and environment variables
+	parseFlags()
 	var err error
 	config, err = loadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	log.Printf("Starting %s", buildinfo.Get("oee-simulator", config))
 	log.Printf("Configuration loaded:")
+	if config.ConfigFile != "" {
+		log.Printf("  Config file: %s (environment variables take precedence)", config.ConfigFile)
+	}
 	log.Printf("  MQTT Broker: %s", config.MQTTBrokerURL)
-	log.Printf("  Machine IDs: %v", config.MachineIDs)
+	for _, site := range config.Sites {
+		if config.Fleet.Count > 0 {
+			log.Printf("  Fleet: %d generated machines", config.Fleet.Count)
+		} else if config.LoadTest.Rate > 0 {
+			log.Printf("  Load test: %d generated machines", config.LoadTest.Machines)
+		} else if site.Name != "" {
+			log.Printf("  Site %s (%s): machines %v", site.Name, site.TopicPrefix, site.MachineIDs)
+		} else {
+			log.Printf("  Machine IDs: %v", site.MachineIDs)
+		}
+	}
+	if len(config.AvailabilityOnly) > 0 {
+		log.Printf("  Availability only (no parts): machines %v", config.AvailabilityOnly)
+	}
+	log.Printf("  Publish mode: %s (wait timeout %v)", config.PublishMode, config.PublishWaitTimeout)
+	if config.PublishMode == publishOrdered {
+		log.Printf("  Publish queue: %d messages per machine", config.PublishQueueSize)
+	}
+	if config.MaxConcurrentPublishes > 0 {
+		publishSlots = make(chan struct{}, config.MaxConcurrentPublishes)
+		log.Printf("  Publishes in flight: at most %d", config.MaxConcurrentPublishes)
+	}
+	if config.PublishChaos.Enabled() {
+		log.Printf("  WARNING: chaos testing, publishes get %s", config.PublishChaos)
+	}
+	for _, site := range config.Sites {
+		b, name := site.Behavior, ""
+		if site.Name != "" {
+			name = " at " + site.Name
+		}
+		if b.ProfileFile != "" {
+			log.Printf("  Profile%s: %s", name, b.ProfileFile)
+		}
+		mtbf, mttr := b.reliability()
+		switch {
+		case config.Fleet.MTBF != nil || config.Fleet.DowntimeChance != nil:
+			// Drawn per machine
+		case mtbf == 0:
+			log.Printf("  Breakdowns%s: none", name)
+		case b.MTBF > 0:
+			log.Printf("  Breakdowns%s: MTBF %v, MTTR %v, %.1f%% availability before other stops", name, mtbf, mttr, 100*b.availability())
+		default:
+			log.Printf("  Breakdowns%s: DOWNTIME_CHANCE %g, about MTBF %v, MTTR %v, %.1f%% availability before other stops",
+				name, b.DowntimeChance, mtbf.Round(time.Second), mttr, 100*b.availability())
+		}
+	}
+	log.Printf("  Time zone: %s", config.Location)
+	if len(config.ReducedSpeed) > 0 {
+		log.Printf("  Reduced speed windows: %d daily, parts marked as a planned slowdown", len(config.ReducedSpeed))
+	}
+	if config.QualityModel == qualityMeasured {
+		log.Printf("  Quality from measurements: %.3f%% scrap expected with a new tool", 100*expectedScrapRate())
+	}
+
+	// Seed the random number generators. Each machine and group gets its
+	// own, derived from the run seed, since a rand.Rand is not safe for
+	// concurrent use. Logging the seed lets a run be repeated.
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Printf("  Seed: %d", seed)
+
+	fleetRand := rand.New(rand.NewSource(seed))
+	behaviors := machineBehaviors(config, fleetRand)
+	var machines []Machine
+	for _, site := range config.Sites {
+		for _, id := range site.MachineIDs {
+			b := behaviors[len(machines)]
+			machines = append(machines, Machine{
+				ID:          id,
+				Site:        site.Name,
+				TopicPrefix: site.TopicPrefix,
+				Behavior:    b,
+				cycleTimes:  config.CycleTimes,
+				live:        &liveSettings{behavior: b, cycleTimes: config.CycleTimes},
+				quality:     &intervention{},
+				anomaly:     &anomalyState{},
+				queue:       newPublishQueue(),
+				seq:         new(atomic.Uint64),
+				cycle:       new(atomic.Uint64),
+				partsTotal:  new(atomic.Uint64),
+				unacked:     new(atomic.Int64),
+				dropped:     new(atomic.Uint64),
+				health:      &publishHealth{},
+				downgraded:  new(atomic.Bool),
+				totals:      &events.PartTotals{},
+				summary:     &statusSummary{},
+
+				availabilityOnly: slices.Contains(config.AvailabilityOnly, id),
+			})
+		}
+	}
 
-	// Seed the random number generator
-	source := rand.NewSource(time.Now().UnixNano())
-	r := rand.New(source)
+	// Clocks drift only after the fleet is drawn, so setting CLOCK_DRIFT_MAX
+	// doesn't change the fleet a seed gives
+	if config.ClockDriftMax > 0 {
+		for i := range machines {
+			machines[i].clock = newClockDrift(fleetRand)
+		}
+		log.Printf("  Clock drift: up to ±%v over about %v", config.ClockDriftMax, config.ClockDriftPeriod)
+	}
+
+	// Wire up shared-utility groups. Membership is by machine ID, so the
+	// same ID at several sites joins the group at every site.
+	groupMembers := make([][]Machine, len(config.Groups))
+	for i := range machines {
+		for g, group := range config.Groups {
+			if slices.Contains(group.MachineIDs, machines[i].ID) {
+				if machines[i].outages == nil {
+					machines[i].outages = make(chan outage, 1)
+				}
+				groupMembers[g] = append(groupMembers[g], machines[i])
+			}
+		}
+	}
+
+	serveHTTP(config.MetricsAddr, machines)
 
 	// Connect to MQTT
-	client, err := connectMQTT(config.MQTTBrokerURL, config.MQTTClientID)
+	client, err := connectMQTT(config.MQTTBrokerURL, config.MQTTClientID, func(c mqtt.Client) {
+		subscribeCommands(c, machines)
+	})
 	if err != nil {
 		log.Fatalf("Fatal error: %v. Is your MQTT broker running?", err)
 		os.Exit(1)
 	}
 	// Disconnect gracefully on exit
 	defer client.Disconnect(250)
+	startPublishers(client, machines)
+
+	ctx, stop := runContext()
+	defer stop()
+
+	shutdownTracing, err := telemetry.Setup(ctx, "oee-simulator")
+	if err != nil {
+		log.Fatalf("Failed to set up OpenTelemetry: %v", err)
+	}
+	defer func() {
+		// Flush spans from the final status events before exiting
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			log.Printf("failed to flush traces: %v", err)
+		}
+	}()
+
+	if config.Replay.File != "" {
+		if err := runReplay(ctx, client); err != nil {
+			log.Fatalf("Replay of %s failed: %v", config.Replay.File, err)
+		}
+		return
+	}
+	if config.LoadTest.Rate > 0 {
+		runLoadTest(ctx, client, machines)
+		return
+	}
 
-	log.Printf("Starting IoT simulator for %d machines...", len(config.MachineIDs))
+	log.Printf("Starting IoT simulator for %d machines across %d site(s)...", len(machines), len(config.Sites))
+
+	for g, group := range config.Groups {
+		log.Printf("  Group %s: %d machines share a utility", group.Name, len(groupMembers[g]))
+		go simulateGroup(ctx, group, groupMembers[g], rand.New(rand.NewSource(seed-int64(g)-1)))
+	}
 
-	for _, id := range config.MachineIDs {
+	var wg, machinesDone sync.WaitGroup
+	if len(config.Operators) > 0 {
+		log.Printf("  Operators: %d rotating across machines each shift", len(config.Operators))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			simulateOperators(ctx, client, machines)
+		}()
+	}
+	go reloadOnHangup(ctx, machines, seed)
+	for i, m := range machines {
 		// Launch a new goroutine for each machine.
-		// Pass the MQTT client to each one.
-		go simulateMachine(client, id, r)
+		// All sites share the one MQTT connection.
+		r := rand.New(rand.NewSource(seed + int64(i) + 1))
+		machinesDone.Add(1)
+		go func() {
+			defer machinesDone.Done()
+			simulateMachine(ctx, client, m, r)
+		}()
 	}
 
-	// Block the main goroutine forever so the program doesn't exit.
-	select {}
+	// With TARGET_GOOD_PARTS machines also stop on their own, and the run
+	// ends once they all have
+	go func() {
+		machinesDone.Wait()
+		if ctx.Err() == nil {
+			log.Printf("TARGET_GOOD_PARTS of %d reached on every machine, shutting down", config.TargetGoodParts)
+			stop()
+		}
+	}()
+
+	// Run until the context ends (forever unless RUN_DURATION,
+	// TARGET_EVENT_COUNT, TARGET_GOOD_PARTS or a signal stops it), then let
+	// every machine send its final status and wait for outstanding acks
+	// before disconnecting.
+	machinesDone.Wait()
+	wg.Wait()
+	pendingPublishes.Wait()
+	log.Printf("Simulator stopped after %d production events", productionEvents.Load())
+	logTotals(machines)
 }
 
-// simulateMachine runs an infinite loop for a single machine's lifecycle.
-func simulateMachine(client mqtt.Client, machineID int, r *rand.Rand) {
-	// All machines start in the "running" state
-	currentState := "running"
-	sendStatusEvent(client, machineID, currentState)
+// simulateMachine runs a single machine's lifecycle until ctx is done, then
+// reports it stopped.
+func simulateMachine(ctx context.Context, client mqtt.Client, m Machine, r *rand.Rand) {
+	machineID := m.ID
+
+	// Announce the machine, and retire it once its final status is out
+	startedAt := m.now()
+	sendLifecycleEvent(client, m, events.LifecycleBirth, startedAt)
+	defer sendLifecycleEvent(client, m, events.LifecycleDeath, startedAt)
+
+	// All machines start in the "running" state, and end stopped with the
+	// parts they made
+	currentState := events.StatusRunning
+	sendStatusEvent(client, m, currentState, "")
+	final := events.StatusEvent{Status: events.StatusStopped, Reason: reasonShutdown, Totals: m.totals}
+	defer func() { sendStatus(client, m, final) }()
+
+	// Lot tracking: a new lot opens every LotSize parts
+	lotSeq := 1
+	partsInLot := 0
+
+	// Parts left to make before the process settles after a start,
+	// breakdown or changeover
+	warmup := m.WarmupParts
+
+	// Parts serialized so far, numbering the next one
+	serials := 0
+
+	// Run time since the last planned maintenance, counted in cycles
+	// actually completed, whether there has been one, and how many were
+	// skipped since
+	var sinceMaintenance time.Duration
+	serviced, skipped := false, 0
+
+	// Cutting tool wear drifts the measured diameter of each part
+	cutter := newTool(r)
+
+	// Scheduled quality interventions start at a random point in the first
+	// interval so machines don't all intervene at once
+	var nextIntervention time.Time
+	if config.InterventionInterval > 0 {
+		nextIntervention = startedAt.Add(time.Duration(r.Int63n(int64(config.InterventionInterval))))
+	}
+
+	// With MTBF set, run time left until the next breakdown
+	var untilFailure time.Duration
+	if m.MTBF > 0 {
+		untilFailure = exponential(r, m.MTBF)
+	}
+
+	// The rate a reduced-speed window last ran the machine at
+	speed := 1.0
 
 	for {
-		if currentState == "running" {
+		// Settings reloaded on SIGHUP apply from here on. A new MTBF
+		// restarts the run time to failure.
+		mtbf := m.MTBF
+		m.Behavior, m.cycleTimes = m.live.get()
+		if m.MTBF != mtbf && m.MTBF > 0 {
+			untilFailure = exponential(r, m.MTBF)
+		}
+
+		if currentState == events.StatusRunning {
 			// --- RUNNING STATE ---
 
+			// The ideal speed depends on the product this lot is making
+			product := m.product(lotSeq)
+
+			// Around a shift change operators are still ramping up or
+			// handing over, so slow cycles and short stops are more likely
+			handover := handoverIntensity(time.Now())
+
+			// An injected anomaly overrides the machine's normal behavior
+			anomaly := m.anomaly.active(time.Now())
+
 			// --- Simulate Performance Loss ---
-			actualCycleTime := config.IdealCycleTime
-			if r.Float64() < config.PerformanceLossChance {
+			// A fitted profile's cycle times already include the slow ones
+			actualCycleTime := m.cycleTime(product)
+			cycles := m.profile.cycleTime()
+			if cycles != nil {
+				actualCycleTime = cycles.sample(r)
+			}
+			if anomaly == events.AnomalySlowdown {
+				actualCycleTime *= anomalySlowdownFactor
+			}
+			// A reduced-speed window slows the machine on purpose, before
+			// any unexpected loss
+			if s := plannedSpeed(machineID, time.Now()); s != speed {
+				speed = s
+				if speed < 1 {
+					log.Printf("[Machine %d] Planned slowdown to %g%% of ideal speed", machineID, speed*100)
+				} else {
+					log.Printf("[Machine %d] Planned slowdown over, back to ideal speed", machineID)
+				}
+			}
+			actualCycleTime = time.Duration(float64(actualCycleTime) / speed)
+			if cycles == nil && r.Float64() < m.PerformanceLossChance*(1+(config.HandoverLossFactor-1)*handover) {
 				// Machine is running slow
-				delay := time.Duration(r.Intn(int(config.PerformanceLossMaxDelay)))
+				delay := time.Duration(r.Intn(int(m.PerformanceLossMaxDelay)))
 				actualCycleTime += delay
 				// Optional: log the performance loss
 				// log.Printf("[Machine %d] Performance loss: +%v", machineID, delay)
 			}
 
-			// Wait for the (potentially slower) cycle time
-			time.Sleep(actualCycleTime)
+			// Wait for the (potentially slower) cycle time, unless a shared
+			// utility fails first
+			select {
+			case <-time.After(actualCycleTime):
+			case o := <-m.outages:
+				// The part in progress is lost
+				currentState = events.StatusStopped
+				sendStatusEvent(client, m, currentState, o.reason)
+				log.Printf("[Machine %d] is DOWN (%s) until %v", machineID, o.reason, o.until.Format(time.TimeOnly))
+				if !m.stopUntil(ctx, o.until) {
+					return
+				}
+
+				warmup = m.WarmupParts
+				currentState = events.StatusRunning
+				sendStatusEvent(client, m, currentState, "")
+				continue
+			case <-ctx.Done():
+				// The part in progress is never finished
+				return
+			}
 
-			// Decide if it's a good part or scrap
-			partsProduced := 0
-			partsScrapped := 0
-			if r.Float64() < config.ScrapRate {
-				partsScrapped = 1 // It's a bad part
-			} else {
-				partsProduced = 1 // It's a good part
+			// An availability-only machine goes through its cycles, and wears
+			// and stops as usual, but makes no parts
+			if !m.availabilityOnly {
+				// Scrap is lower for a while after a quality intervention
+				now := time.Now()
+				if !nextIntervention.IsZero() && !now.Before(nextIntervention) {
+					m.intervene(triggerSchedule)
+					nextIntervention = now.Add(config.InterventionInterval)
+				}
+				scrapRate, reworkRate := m.ScrapRate*m.quality.scrapFactor(now), m.ReworkRate
+				if config.QualityModel == qualityMeasured {
+					// Quality follows from the measurement alone
+					scrapRate, reworkRate = 0, 0
+				}
+				if anomaly == events.AnomalyScrapSpike {
+					scrapRate = anomalyScrapRate
+				}
+
+				// First-off parts after a restart are likelier to be bad
+				var event events.ProductionEvent
+				if warmup > 0 {
+					warmup--
+					event.Warmup = true
+					scrapRate = max(scrapRate, m.WarmupScrapRate)
+				}
+
+				// Decide if it's a good part, a reworked part or scrap
+				switch q := r.Float64(); {
+				case q < scrapRate:
+					event.PartsScrapped = 1 // It's a bad part
+				case q < scrapRate+reworkRate:
+					m.rework(r, &event) // Failed first inspection
+				default:
+					event.PartsProduced = 1 // It's a good part
+				}
+				// Measure the part; one outside the limits is reworked or
+				// scrapped whatever else happened to it
+				if config.MeasurementNominal > 0 {
+					measurement := cutter.measure(r)
+					event.Measurement = &measurement
+					if measurement.OutOfSpec() && event.PartsScrapped == 0 {
+						if grade(measurement) == gradeRework {
+							m.rework(r, &event)
+						} else {
+							event.PartsProduced, event.PartsReworked, event.PartsScrapped = 0, 0, 1
+						}
+						partsOutOfSpec.WithLabelValues(m.Site, strconv.Itoa(machineID)).Inc()
+					}
+					cutter.use(m, actualCycleTime)
+				}
+				// Parts that ship pass the serialization station
+				if config.PartSerials != "" && event.PartsProduced+event.PartsReworked > 0 {
+					serials++
+					event.Serial = m.serial(serials)
+				}
+				if m.LotSize > 0 {
+					event.LotID = m.lotID(lotSeq)
+				}
+				if speed < 1 {
+					event.PlannedSlowdown = speed
+				}
+				event.Product = product
+				if !claimProductionEvent() {
+					return
+				}
+				event = sendProductionEvent(client, m, event)
+				if config.InspectionDelay > 0 {
+					inspectLater(ctx, client, m, event)
+				}
+				m.totals.PartsProduced += event.PartsProduced
+				m.totals.PartsReworked += event.PartsReworked
+				m.totals.PartsScrapped += event.PartsScrapped
+				if config.StatusSummary {
+					m.summary.count(event)
+				}
+				if config.TargetGoodParts > 0 && m.totals.PartsProduced >= config.TargetGoodParts {
+					log.Printf("[Machine %d] Made %d good parts, stopping", machineID, m.totals.PartsProduced)
+					final.Reason = reasonTargetReached
+					return
+				}
+			}
+			// Wear since the last maintenance brings the next failure
+			// closer faster
+			sinceService := time.Duration(-1)
+			if serviced {
+				sinceService = sinceMaintenance
+			}
+			failureFactor := m.failureFactor(sinceService)
+			sinceMaintenance += actualCycleTime
+			untilFailure -= time.Duration(float64(actualCycleTime) * failureFactor)
+			runtimeSinceMaintenance.WithLabelValues(m.Site, strconv.Itoa(machineID)).Set(sinceMaintenance.Seconds())
+			failureRateFactor.WithLabelValues(m.Site, strconv.Itoa(machineID)).Set(failureFactor)
+
+			// A chattering machine stops briefly after every part
+			if anomaly == events.AnomalyChatter {
+				pause := anomalyChatterMin + time.Duration(r.Int63n(int64(anomalyChatterMax-anomalyChatterMin)+1))
+				currentState = events.StatusStopped
+				sendStatusEvent(client, m, currentState, reasonChatter)
+				if !m.stopUntil(ctx, time.Now().Add(pause)) {
+					return
+				}
+				currentState = events.StatusRunning
+				sendStatusEvent(client, m, currentState, "")
+				continue
+			}
+
+			// Planned maintenance is due after enough run time, whatever
+			// else happened since, unless it is skipped until the next
+			// interval. Only draw when it can be skipped, so a seed replays
+			// the same run as before MAINTENANCE_SKIP_CHANCE existed
+			due := m.MaintenanceInterval > 0 && sinceMaintenance >= time.Duration(skipped+1)*m.MaintenanceInterval
+			if due && m.MaintenanceSkipChance > 0 && r.Float64() < m.MaintenanceSkipChance {
+				skipped++
+				log.Printf("[Machine %d] Skipping planned maintenance after %v of run time", machineID, sinceMaintenance.Round(time.Second))
+				due = false
+			}
+			if due {
+				until := time.Now().Add(m.MaintenanceDuration)
+				currentState = events.StatusStopped
+				sendStatus(client, m, events.StatusEvent{Status: currentState, Reason: reasonMaintenance, PlannedUntil: &until})
+				log.Printf("[Machine %d] Planned maintenance after %v of run time, until %v", machineID, sinceMaintenance.Round(time.Second), until.Format(time.TimeOnly))
+				sinceMaintenance, serviced, skipped = 0, true, 0
+				runtimeSinceMaintenance.WithLabelValues(m.Site, strconv.Itoa(machineID)).Set(0)
+				cutter.wear = 0 // the tool is replaced during maintenance
+				if !m.stopUntil(ctx, until) {
+					return
+				}
+				warmup = m.WarmupParts
+				currentState = events.StatusRunning
+				sendStatusEvent(client, m, currentState, "")
+				continue
+			}
+
+			// Close the lot once it is full, optionally stopping for a changeover
+			partsInLot++
+			if m.LotSize > 0 && partsInLot >= m.LotSize {
+				log.Printf("[Machine %d] Lot %s complete (%d parts)", machineID, m.lotID(lotSeq), partsInLot)
+				lotSeq++
+				partsInLot = 0
+				// Setting up for the next product is planned, and takes as
+				// long as the product pair and the operator make it
+				if setup, ok := m.setupTime(r, product, m.product(lotSeq)); ok {
+					if setup > 0 {
+						until := time.Now().Add(setup)
+						currentState = events.StatusStopped
+						sendStatus(client, m, events.StatusEvent{Status: currentState, Reason: reasonSetup, PlannedUntil: &until})
+						log.Printf("[Machine %d] Setup from %s to %s for %v", machineID, product, m.product(lotSeq), setup.Round(time.Second))
+						if !m.stopUntil(ctx, until) {
+							return
+						}
+						warmup = m.WarmupParts
+						currentState = events.StatusRunning
+						sendStatusEvent(client, m, currentState, "")
+						continue
+					}
+				} else if m.LotChangeover > 0 {
+					currentState = events.StatusStopped
+					sendStatusEvent(client, m, currentState, reasonChangeover)
+					if !m.stopUntil(ctx, time.Now().Add(m.LotChangeover)) {
+						return
+					}
+					warmup = m.WarmupParts
+					currentState = events.StatusRunning
+					sendStatusEvent(client, m, currentState, "")
+					continue
+				}
+			}
+
+			// Short handover stops, most frequent right at the shift change
+			if handover > 0 && r.Float64() < config.HandoverMicroStopChance*handover {
+				pause := time.Second + time.Duration(r.Int63n(int64(config.HandoverMicroStopMax-time.Second)+1))
+				currentState = events.StatusStopped
+				sendStatusEvent(client, m, currentState, reasonHandover)
+				if !m.stopUntil(ctx, time.Now().Add(pause)) {
+					return
+				}
+				currentState = events.StatusRunning
+				sendStatusEvent(client, m, currentState, "")
+				continue
 			}
-			sendProductionEvent(client, machineID, partsProduced, partsScrapped)
 
-			// After a cycle, check if the machine should go down (Availability loss)
-			if r.Float64() < config.DowntimeChance {
-				currentState = "stopped"
-				sendStatusEvent(client, machineID, currentState)
+			// After a cycle, check if the machine should go down (Availability
+			// loss): once its run time to failure is used up with MTBF set,
+			// and otherwise by chance
+			if m.MTBF > 0 && untilFailure <= 0 {
+				currentState = events.StatusStopped
+				sendStatusEvent(client, m, currentState, reasonBreakdown)
+				untilFailure = exponential(r, m.MTBF)
+			} else if m.MTBF == 0 && r.Float64() < m.DowntimeChance*failureFactor {
+				currentState = events.StatusStopped
+				sendStatusEvent(client, m, currentState, reasonBreakdown)
 			}
 
 		} else {
 			// --- STOPPED STATE ---
 			// Simulate a random downtime duration
-			downtime := time.Duration(r.Intn(int(config.DowntimeMax-config.DowntimeMin)) + int(config.DowntimeMin))
+			var downtime time.Duration
+			if repairs := m.profile.downtime(); repairs != nil {
+				downtime = repairs.sample(r)
+			} else if m.MTBF > 0 {
+				downtime = exponential(r, m.MTTR)
+			} else {
+				downtime = time.Duration(r.Intn(int(m.DowntimeMax-m.DowntimeMin)) + int(m.DowntimeMin))
+			}
 			log.Printf("[Machine %d] is DOWN for %v", machineID, downtime)
-			time.Sleep(downtime)
+			if !m.stopUntil(ctx, time.Now().Add(downtime)) {
+				return
+			}
 
 			// Time to come back online
-			currentState = "running"
-			sendStatusEvent(client, machineID, currentState)
+			warmup = m.WarmupParts
+			currentState = events.StatusRunning
+			sendStatusEvent(client, m, currentState, "")
 		}
 	}
 }
 
 // sendStatusEvent publishes a status event to MQTT.
-func sendStatusEvent(client mqtt.Client, machineID int, status string) {
-	topic := fmt.Sprintf("factory/machine/%d/status", machineID)
-	event := StatusEvent{
-		MachineID: machineID,
-		Status:    status,
-		Timestamp: time.Now().UTC(),
+func sendStatusEvent(client mqtt.Client, m Machine, status, reason string) {
+	sendStatus(client, m, events.StatusEvent{Status: status, Reason: reason})
+}
+
+// sendStatus publishes event to MQTT. The caller fills in the status and
+// any reason; machine, site and timestamp are set here.
+func sendStatus(client mqtt.Client, m Machine, event events.StatusEvent) {
+	machineID := m.ID
+	msg := newMessage(m, events.KindStatus)
+	event.MachineID = machineID
+	event.Site = m.Site
+	event.Anomaly = m.anomaly.active(time.Now())
+	event.Seq = m.seq.Add(1)
+	event.TraceID = msg.traceID
+	event.SpanID = msg.spanID
+	event.PublishedAt = publishedAt()
+	event.Timestamp = m.now()
+	if config.StatusSummary {
+		event.Summary = m.summary.next(event.Status, time.Now())
+	}
+	if event.PlannedUntil != nil {
+		// The machine schedules by its own clock
+		until := event.PlannedUntil.Add(m.clock.offset(time.Now())).UTC()
+		event.PlannedUntil = &until
 	}
-	payload, _ := json.Marshal(event)
+	msg.payload, _ = json.Marshal(event)
 
-	log.Printf("[Machine %d] Publishing to %s: %s", machineID, topic, status)
+	log.Printf("[Machine %d] Publishing to %s: %s%s", machineID, msg.topic, event.Status, traceSuffix(msg.traceID))
 
 	// Use QoS=1 and retained=true so EMQX will persist the latest status per topic.
 	// QoS=1 ensures delivery at least once; retained=true stores the last message on the broker.
-	// Use mutex to prevent concurrent publishes from causing connection issues
-	publishMutex.Lock()
-	token := client.Publish(topic, 1, true, payload)
-	// We use .Wait() to make sure the message is sent before proceeding
-	token.Wait()
-	publishMutex.Unlock()
+	publish(client, m, msg)
+}
 
-	if token.Error() != nil {
-		log.Printf("[Machine %d] ERROR publishing status: %v", machineID, token.Error())
+// rework sends the part in event, which failed first inspection, to rework
+// and records the outcome: reworked if the rework saves it, scrapped if not.
+// Either way it no longer counts towards first-pass yield.
+func (m Machine) rework(r *rand.Rand, event *events.ProductionEvent) {
+	event.PartsProduced, event.PartsReworked, event.PartsScrapped = 0, 1, 0
+	// Only draw when rework can fail, so a seed replays the same run as
+	// before REWORK_SUCCESS_RATE existed
+	if m.ReworkSuccessRate < 1 && r.Float64() >= m.ReworkSuccessRate {
+		event.PartsReworked, event.PartsScrapped = 0, 1
 	}
 }
 
-// sendProductionEvent publishes a production event to MQTT.
-func sendProductionEvent(client mqtt.Client, machineID, produced, scrapped int) {
-	topic := fmt.Sprintf("factory/machine/%d/production", machineID)
-	event := ProductionEvent{
-		MachineID:     machineID,
-		PartsProduced: produced,
-		PartsScrapped: scrapped,
-		Timestamp:     time.Now().UTC(),
+// sendProductionEvent publishes a production event to MQTT and returns it.
+// The caller fills in the part counts and lot; machine, site, cycle index and
+// timestamp are set here.
+func sendProductionEvent(client mqtt.Client, m Machine, event events.ProductionEvent) events.ProductionEvent {
+	msg := newMessage(m, events.KindProduction)
+	event.MachineID = m.ID
+	event.Site = m.Site
+	event.Anomaly = m.anomaly.active(time.Now())
+	if config.CycleIndex {
+		event.Cycle = m.cycle.Add(1)
+	}
+	if config.PartsTotalizer {
+		total := m.countParts(event.PartsProduced)
+		event.PartsTotal = &total
 	}
-	payload, _ := json.Marshal(event)
+	event.Seq = m.seq.Add(1)
+	event.TraceID = msg.traceID
+	event.SpanID = msg.spanID
+	event.PublishedAt = publishedAt()
+	event.Timestamp = m.now()
+	msg.payload, _ = json.Marshal(event)
 
 	// Don't log every part, it's too noisy.
 	// log.Printf("[Machine %d] Publishing to %s: %d good, %d scrap", machineID, topic, payload)
 
 	// For production events we also use QoS=1 and set retained=true so the broker keeps
 	// the last production event per machine (useful for immediate consumers after restart).
+	publish(client, m, msg)
+	return event
+}
+
+// countParts adds n good parts to the machine's counter and returns its new
+// value. Like a PLC totalizer it starts at 0 on every run and wraps to 0
+// after PARTS_TOTALIZER_MAX, if set. Only the machine's own loop counts, so
+// a load and store can't race.
+func (m Machine) countParts(n int) uint64 {
+	total := m.partsTotal.Load() + uint64(n)
+	if limit := config.PartsTotalizerMax; limit > 0 && total > limit {
+		total -= limit + 1
+	}
+	m.partsTotal.Store(total)
+	return total
+}
+
+// publishedAt returns the time to stamp an event's published_at with: now,
+// by the host's clock, with PUBLISH_TIMESTAMPS, and nil without.
+func publishedAt() *time.Time {
+	if !config.PublishTimestamps {
+		return nil
+	}
+	now := time.Now().UTC()
+	return &now
+}
+
+// sendLifecycleEvent publishes a retained birth or death message for m.
+// Machines share one MQTT connection, and a connection has a single last
+// will, so there is no per-machine will: a death is only published on a
+// clean shutdown.
+func sendLifecycleEvent(client mqtt.Client, m Machine, state string, startedAt time.Time) {
+	msg := newMessage(m, events.KindLifecycle)
+	event := events.LifecycleEvent{
+		MachineID:         m.ID,
+		Site:              m.Site,
+		State:             state,
+		IdealCycleTimeSec: m.IdealCycleTime.Seconds(),
+		Products:          m.Products,
+		StartedAt:         startedAt,
+		Seq:               m.seq.Add(1),
+		TraceID:           msg.traceID,
+		SpanID:            msg.spanID,
+		Timestamp:         m.now(),
+	}
+	msg.payload, _ = json.Marshal(event)
+
+	log.Printf("[Machine %d] Publishing to %s: %s%s", m.ID, msg.topic, state, traceSuffix(msg.traceID))
+	publish(client, m, msg)
+}
+
+// publish sends msg from m with retained=true at QoS=1, or QoS=0 if m's
+// backlog has it downgraded, then confirms delivery according to
+// PUBLISH_MODE. In sync mode the machine loop waits
+// for the ack (capped by PUBLISH_WAIT_TIMEOUT); in async mode the ack is
+// checked by a callback goroutine so the loop keeps its cadence; in ordered
+// mode msg is queued for m's publisher, which waits for each ack in turn.
+// A kind PUBLISH_OVERFLOW drops is dropped instead when the broker can't
+// take it, and any kind while m is quarantined.
+func publish(client mqtt.Client, m Machine, msg message) {
+	if !m.health.admit(time.Now()) {
+		skip(msg)
+		return
+	}
+	if droppable(msg.kind) {
+		if reason := backpressure(client, m); reason != "" {
+			drop(m, msg, reason)
+			return
+		}
+		resumed(m)
+	}
+	publishTotal.WithLabelValues(msg.kind).Inc()
+	msg.qos = qos(m, msg.kind)
+	m.unacked.Add(1)
+	if m.queue != nil {
+		enqueue(m, msg)
+		return
+	}
+	publishNow(client, msg)
+}
+
+// publishNow publishes msg. It waits for the ack unless PUBLISH_MODE is
+// async, or in sync mode when msg may be dropped: waiting is what dropping
+// avoids.
+func publishNow(client mqtt.Client, msg message) {
+	mode := config.PublishMode
+	if mode == publishSync && droppable(msg.kind) {
+		mode = publishAsync
+	}
+
+	// Wait for a slot before taking the mutex, so a full set of slots
+	// blocks only the machines trying to publish
+	acquirePublishSlot()
 	// Use mutex to prevent concurrent publishes from causing connection issues
 	publishMutex.Lock()
-	token := client.Publish(topic, 1, true, payload)
-	token.Wait()
+	msg.sent = time.Now()
+	token := chaosPublish(client, msg)
+	switch mode {
+	case publishSync:
+		awaitPublish(msg, token)
+		publishMutex.Unlock()
+		return
+	case publishOrdered:
+		// Only this machine's publisher waits; the others carry on
+		publishMutex.Unlock()
+		awaitPublish(msg, token)
+		return
+	}
 	publishMutex.Unlock()
+	pendingPublishes.Add(1)
+	go func() {
+		defer pendingPublishes.Done()
+		awaitPublish(msg, token)
+	}()
+}
+
+// awaitPublish waits for the broker to acknowledge a publish and reports any
+// error. If the ack does not arrive within PublishWaitTimeout the timeout is
+// counted and the token is handed to a background goroutine, so a late
+// failure is still logged rather than silently dropped.
+func awaitPublish(msg message, token mqtt.Token) {
+	if config.PublishWaitTimeout > 0 && !token.WaitTimeout(config.PublishWaitTimeout) {
+		publishTimeouts.WithLabelValues(msg.kind).Inc()
+		loadStats.timedOut(msg)
+		log.Printf("[Machine %d] Timed out after %v waiting for %s publish ack%s", msg.machineID, config.PublishWaitTimeout, msg.kind, traceSuffix(msg.traceID))
+		go func() {
+			token.Wait()
+			reportPublishError(msg, token)
+		}()
+		return
+	}
+	token.Wait()
+	reportPublishError(msg, token)
+}
 
+// reportPublishError logs and counts a failed publish and ends its span.
+// During a load test every completed publish is also recorded. Either way
+// msg no longer counts towards its machine's backlog, and the outcome
+// counts towards its quarantine.
+func reportPublishError(msg message, token mqtt.Token) {
+	defer msg.span.End()
+	releasePublishSlot()
+	msg.unacked.Add(-1)
+	loadStats.published(msg, time.Since(msg.sent), token.Error())
 	if token.Error() != nil {
-		log.Printf("[Machine %d] ERROR publishing production: %v", machineID, token.Error())
+		publishErrors.WithLabelValues(msg.kind).Inc()
+		msg.span.RecordError(token.Error())
+		msg.span.SetStatus(codes.Error, "publish failed")
+		if msg.health.failed(msg.machineID, time.Now()) {
+			log.Printf("[Machine %d] ERROR publishing %s%s: %v", msg.machineID, msg.kind, traceSuffix(msg.traceID), token.Error())
+		}
+		return
 	}
+	msg.health.succeeded(msg.machineID)
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/events"
+)
+
+// The characteristic measured on every part.
+const (
+	characteristicDiameter = "diameter"
+	unitMillimetre         = "mm"
+)
+
+// Values of QUALITY_MODEL.
+const (
+	// qualityRate scraps and reworks parts at random at SCRAP_RATE and
+	// REWORK_RATE, and scraps measured parts outside the limits on top.
+	qualityRate = "rate"
+	// qualityMeasured grades every part by its measurement alone.
+	qualityMeasured = "measured"
+)
+
+// partGrade is the outcome of grading a part by its measurement.
+type partGrade int
+
+const (
+	gradeGood partGrade = iota
+	gradeRework
+	gradeScrap
+)
+
+// grade sorts a measured part into tolerance bands: good within the
+// specification limits, rework when it is oversize by no more than
+// MeasurementReworkBand, since the excess can be machined off, and scrap
+// otherwise. An undersize part can't be saved.
+func grade(ms events.Measurement) partGrade {
+	switch {
+	case !ms.OutOfSpec():
+		return gradeGood
+	case ms.Value > ms.UpperSpec && ms.Value <= ms.UpperSpec+config.MeasurementReworkBand:
+		return gradeRework
+	default:
+		return gradeScrap
+	}
+}
+
+// expectedScrapRate is the share of parts scrapped by grade with a new tool,
+// before wear moves the mean off nominal: the normal tails beyond the lower
+// limit and beyond the rework band, plus the gross errors. It only gets
+// worse as the tool wears.
+func expectedScrapRate() float64 {
+	tail := func(limit float64) float64 {
+		return 0.5 * math.Erfc(limit/(config.MeasurementSigma*math.Sqrt2))
+	}
+	normal := tail(config.MeasurementTolerance) + tail(config.MeasurementTolerance+config.MeasurementReworkBand)
+	return normal*(1-config.MeasurementOutlierChance) + config.MeasurementOutlierChance
+}
+
+// tool tracks the wear of a machine's cutting tool as run time since it was
+// last replaced. It is only used by the machine's own goroutine.
+type tool struct {
+	wear time.Duration
+}
+
+// newTool returns the tool a machine starts with. It is part-way through
+// its life, so the machines' tool changes don't all line up.
+func newTool(r *rand.Rand) *tool {
+	t := &tool{}
+	if config.MeasurementToolLife > 0 {
+		t.wear = time.Duration(r.Int63n(int64(config.MeasurementToolLife)))
+	}
+	return t
+}
+
+// measure returns the diameter of a part just made. The mean sits above
+// nominal by the wear so far and the value scatters around it with
+// MeasurementSigma; rarely, a gross error such as a badly clamped part puts
+// it well outside the limits on either side.
+func (t *tool) measure(r *rand.Rand) events.Measurement {
+	mean := config.MeasurementNominal + config.MeasurementWearRate*t.wear.Hours()
+	value := mean + r.NormFloat64()*config.MeasurementSigma
+	if r.Float64() < config.MeasurementOutlierChance {
+		offset := config.MeasurementTolerance * (1.5 + r.Float64())
+		if r.Intn(2) == 0 {
+			offset = -offset
+		}
+		value = config.MeasurementNominal + offset
+	}
+	return events.Measurement{
+		Characteristic: characteristicDiameter,
+		Unit:           unitMillimetre,
+		Value:          math.Round(value*1e4) / 1e4, // the gauge reads to 0.1 µm
+		LowerSpec:      config.MeasurementNominal - config.MeasurementTolerance,
+		UpperSpec:      config.MeasurementNominal + config.MeasurementTolerance,
+	}
+}
+
+// use adds a cycle of run time to the tool, replacing it once it reaches
+// MeasurementToolLife.
+func (t *tool) use(m Machine, d time.Duration) {
+	t.wear += d
+	if config.MeasurementToolLife > 0 && t.wear >= config.MeasurementToolLife {
+		log.Printf("[Machine %d] Tool change after %v of run time", m.ID, t.wear.Round(time.Second))
+		t.wear = 0
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/buildinfo"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/configdump"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/httpauth"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/httpserve"
+)
+
+// Prometheus metrics exposed on /metrics. Labelled by event type
+// ("status", "production", "lifecycle", "operator" or "inspection").
+var (
+	publishTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oee_simulator_publish_total",
+		Help: "Events handed to the MQTT client for publishing.",
+	}, []string{"type"})
+	publishErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oee_simulator_publish_errors_total",
+		Help: "Publishes the broker or client reported as failed.",
+	}, []string{"type"})
+	publishTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oee_simulator_publish_timeouts_total",
+		Help: "Publishes not acknowledged within PUBLISH_WAIT_TIMEOUT.",
+	}, []string{"type"})
+	publishDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oee_simulator_publish_dropped_total",
+		Help: "Events dropped under PUBLISH_OVERFLOW because the broker was disconnected or behind, or because their machine was quarantined.",
+	}, []string{"type", "reason"})
+	publishesInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "oee_simulator_publishes_in_flight",
+		Help: "Publishes holding one of the MAX_CONCURRENT_PUBLISHES slots: sent or about to be, and not yet acknowledged.",
+	})
+	publishSlotWait = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "oee_simulator_publish_slot_wait_seconds_total",
+		Help: "Time machines spent waiting for one of the MAX_CONCURRENT_PUBLISHES slots.",
+	})
+	qosDowngraded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oee_simulator_qos_downgraded_total",
+		Help: "Events published at QoS 0 instead of 1 because their machine's backlog reached QOS_DOWNGRADE_AT.",
+	}, []string{"type"})
+	qosDowngradedMachines = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "oee_simulator_qos_downgraded_machines",
+		Help: "Machines currently publishing production events at QoS 0.",
+	})
+	quarantinedMachines = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "oee_simulator_quarantined_machines",
+		Help: "Machines quarantined after PUBLISH_QUARANTINE_AFTER failed publishes in a row.",
+	})
+	qualityInterventions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oee_simulator_quality_interventions_total",
+		Help: "Quality interventions, by what triggered them (\"command\" or \"schedule\").",
+	}, []string{"trigger"})
+	anomaliesInjected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oee_simulator_anomalies_injected_total",
+		Help: "Anomalies injected into machines, by type.",
+	}, []string{"type"})
+	partsOutOfSpec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oee_simulator_parts_out_of_spec_total",
+		Help: "Parts scrapped because their measurement was outside the specification limits.",
+	}, []string{"site", "machine_id"})
+	runtimeSinceMaintenance = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "oee_simulator_runtime_since_maintenance_seconds",
+		Help: "Run time each machine has accumulated since its last planned maintenance.",
+	}, []string{"site", "machine_id"})
+	failureRateFactor = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "oee_simulator_failure_rate_factor",
+		Help: "Each machine's failure rate as a fraction of its usual rate, lowered by maintenance (MAINTENANCE_FAILURE_FACTOR).",
+	}, []string{"site", "machine_id"})
+	chaosFaults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oee_simulator_chaos_faults_total",
+		Help: "Faults injected into publishes for chaos testing, by fault (latency or error).",
+	}, []string{"type", "fault"})
+	clockOffset = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "oee_simulator_clock_offset_seconds",
+		Help: "How far each machine's simulated clock was ahead of real time at its last event (CLOCK_DRIFT_MAX).",
+	}, []string{"site", "machine_id"})
+)
+
+// Simulated network partitions.
+var (
+	networkPartitioned = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "oee_simulator_network_partitioned",
+		Help: "1 while a simulated network partition cuts the simulator off from the broker (POST /partition).",
+	})
+)
+
+// serveHTTP exposes /metrics, /version, /inject_anomaly, /stop_line and
+// /partition for machines on addr, plus /debug/config when DEBUG_ENDPOINTS is set, all
+// behind API_TOKEN, and an open /healthz. An empty addr disables the server.
+func serveHTTP(addr string, machines []Machine) {
+	if addr == "" {
+		return
+	}
+	auth := httpauth.Require(config.APIToken)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", httpauth.Healthz)
+	mux.Handle("/metrics", auth(promhttp.Handler()))
+	mux.Handle("/version", auth(buildinfo.Handler("oee-simulator", func() any { return activeConfig() })))
+	mux.Handle("/inject_anomaly", auth(injectHandler(machines)))
+	mux.Handle("/stop_line", auth(stopLineHandler(machines)))
+	mux.Handle("/partition", auth(partitionHandler()))
+	if config.DebugEndpoints {
+		mux.Handle("/debug/config", auth(configdump.Handler(func() any { return activeConfig() })))
+		log.Printf("Serving effective configuration on %s/debug/config", addr)
+	}
+	if err := httpserve.Start(addr, mux); err != nil {
+		if config.MetricsRequired {
+			log.Fatalf("failed to serve metrics on %s: %v", addr, err)
+		}
+		log.Printf("WARNING: not serving metrics, carrying on without them (set METRICS_REQUIRED=true to exit instead): %v", err)
+		return
+	}
+	log.Printf("Serving metrics on %s/metrics", addr)
+}
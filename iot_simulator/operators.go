@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/events"
+)
+
+// parseOperators parses comma-separated operator IDs.
+func parseOperators(s string) []string {
+	var ids []string
+	for _, id := range strings.Split(s, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// currentShift returns the start of the shift t falls in and its sequence
+// number, which counts shifts from the Unix epoch so it is the same for
+// every run and advances by one at each shift change.
+func currentShift(t time.Time) (time.Time, int64) {
+	starts := slices.Sorted(slices.Values(config.ShiftStarts))
+	// The latest start at or before t is today's, or else yesterday's last
+	for day := 0; day >= -1; day-- {
+		for i := len(starts) - 1; i >= 0; i-- {
+			start := shiftStart(t, day, starts[i], config.Location)
+			if !start.After(t) {
+				y, m, d := start.Date()
+				days := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / int64(24*time.Hour/time.Second)
+				return start, days*int64(len(starts)) + int64(i)
+			}
+		}
+	}
+	panic("unreachable: a shift starts within any 24 hours")
+}
+
+// nextShiftStart returns when the shift after the one t falls in starts.
+func nextShiftStart(t time.Time) time.Time {
+	starts := slices.Sorted(slices.Values(config.ShiftStarts))
+	for day := 0; day <= 1; day++ {
+		for _, offset := range starts {
+			if start := shiftStart(t, day, offset, config.Location); start.After(t) {
+				return start
+			}
+		}
+	}
+	panic("unreachable: a shift starts within any 24 hours")
+}
+
+// operator returns who runs m in shift number seq. Each shift every machine
+// moves on to the next operator in OPERATORS, so crews rotate across the
+// machines.
+func (m Machine) operator(seq int64) string {
+	n := int64(len(config.Operators))
+	return config.Operators[((seq+int64(m.ID))%n+n)%n]
+}
+
+// simulateOperators publishes the operator of every machine now, and again
+// at each shift change, until ctx is done.
+func simulateOperators(ctx context.Context, client mqtt.Client, machines []Machine) {
+	for {
+		start, seq := currentShift(time.Now())
+		for _, m := range machines {
+			sendOperatorEvent(client, m, m.operator(seq), start)
+		}
+		select {
+		case <-time.After(time.Until(nextShiftStart(time.Now()))):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendOperatorEvent publishes that operator runs m in the shift that began
+// at shift.
+func sendOperatorEvent(client mqtt.Client, m Machine, operator string, shift time.Time) {
+	msg := newMessage(m, events.KindOperator)
+	event := events.OperatorEvent{
+		MachineID:  m.ID,
+		Site:       m.Site,
+		OperatorID: operator,
+		Shift:      shift.Format("15:04"),
+		TraceID:    msg.traceID,
+		SpanID:     msg.spanID,
+		Timestamp:  m.now(),
+	}
+	msg.payload, _ = json.Marshal(event)
+
+	log.Printf("[Machine %d] Publishing to %s: operator %s for the %s shift%s", m.ID, msg.topic, operator, event.Shift, traceSuffix(msg.traceID))
+	publish(client, m, msg)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Values of PUBLISH_MODE.
+const (
+	publishSync    = "sync"
+	publishAsync   = "async"
+	publishOrdered = "ordered"
+)
+
+// newPublishQueue returns the queue a machine's messages wait in for its
+// publisher in ordered mode, or nil in the other modes.
+func newPublishQueue() chan message {
+	if config.PublishMode != publishOrdered {
+		return nil
+	}
+	return make(chan message, config.PublishQueueSize)
+}
+
+// startPublishers starts one publisher per machine in ordered mode. Each
+// sends its machine's messages one at a time, waiting for the ack of one
+// before sending the next, so a machine never has more than one message in
+// flight and the broker receives them in the order they were queued, even
+// across a reconnect. Machines publish independently of each other.
+func startPublishers(client mqtt.Client, machines []Machine) {
+	for _, m := range machines {
+		if m.queue == nil {
+			continue
+		}
+		go func() {
+			for msg := range m.queue {
+				publishNow(client, msg)
+				pendingPublishes.Done()
+			}
+		}()
+	}
+}
+
+// enqueue hands msg to m's publisher. The machine loop only blocks when
+// PUBLISH_QUEUE_SIZE messages are already waiting, which slows it to the
+// rate the broker acknowledges them.
+func enqueue(m Machine, msg message) {
+	pendingPublishes.Add(1)
+	m.queue <- msg
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeClient is an mqtt.Client that records what is published and acks
+// each publish after ackDelay returns, or when release is closed if the
+// topic is in stalled.
+type fakeClient struct {
+	mqtt.Client
+
+	ackDelay func() time.Duration
+	stalled  map[string]bool
+	release  chan struct{}
+
+	mu        sync.Mutex
+	published map[string][]string // payloads by topic, in publish order
+	inFlight  map[string]int
+	maxFlight map[string]int
+}
+
+func newFakeClient(ackDelay func() time.Duration) *fakeClient {
+	return &fakeClient{
+		ackDelay:  ackDelay,
+		stalled:   map[string]bool{},
+		release:   make(chan struct{}),
+		published: map[string][]string{},
+		inFlight:  map[string]int{},
+		maxFlight: map[string]int{},
+	}
+}
+
+func (c *fakeClient) Publish(topic string, _ byte, _ bool, payload any) mqtt.Token {
+	c.mu.Lock()
+	c.published[topic] = append(c.published[topic], string(payload.([]byte)))
+	c.inFlight[topic]++
+	c.maxFlight[topic] = max(c.maxFlight[topic], c.inFlight[topic])
+	stalled := c.stalled[topic]
+	c.mu.Unlock()
+
+	t := &fakeToken{done: make(chan struct{})}
+	go func() {
+		if stalled {
+			<-c.release
+		}
+		time.Sleep(c.ackDelay())
+		c.mu.Lock()
+		c.inFlight[topic]--
+		c.mu.Unlock()
+		close(t.done)
+	}()
+	return t
+}
+
+// payloads returns what was published to topic so far.
+func (c *fakeClient) payloads(topic string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.published[topic]...)
+}
+
+type fakeToken struct{ done chan struct{} }
+
+func (t *fakeToken) Wait() bool { <-t.done; return true }
+func (t *fakeToken) WaitTimeout(d time.Duration) bool {
+	select {
+	case <-t.done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+func (t *fakeToken) Done() <-chan struct{} { return t.done }
+func (t *fakeToken) Error() error          { return nil }
+
+// setupOrdered configures ordered publishing and returns n machines with
+// their queues. The publishers stop when the test ends.
+func setupOrdered(t *testing.T, n int) []Machine {
+	t.Helper()
+	saved := config
+	config = Config{PublishMode: publishOrdered, PublishQueueSize: 4}
+	machines := make([]Machine, n)
+	for i := range machines {
+		machines[i] = Machine{
+			ID:      i + 1,
+			queue:   newPublishQueue(),
+			unacked: new(atomic.Int64),
+			health:  &publishHealth{},
+		}
+	}
+	t.Cleanup(func() {
+		for _, m := range machines {
+			close(m.queue)
+		}
+		config = saved
+	})
+	return machines
+}
+
+// enqueueAll queues count numbered status messages for each machine, the
+// machines taking turns as their loops would.
+func enqueueAll(machines []Machine, count int) {
+	var wg sync.WaitGroup
+	for _, m := range machines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range count {
+				msg := newMessage(m, "status")
+				msg.payload = []byte(strconv.Itoa(i))
+				m.unacked.Add(1)
+				enqueue(m, msg)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestOrderedPublishKeepsEnqueueOrder(t *testing.T) {
+	machines := setupOrdered(t, 8)
+	// Acks take random times, so a later publish would often be acked
+	// before an earlier one if both were in flight
+	client := newFakeClient(func() time.Duration { return time.Duration(rand.IntN(500)) * time.Microsecond })
+	startPublishers(client, machines)
+
+	const count = 50
+	enqueueAll(machines, count)
+	pendingPublishes.Wait()
+
+	for _, m := range machines {
+		topic := m.topic("status")
+		got := client.payloads(topic)
+		if len(got) != count {
+			t.Fatalf("machine %d published %d messages, want %d", m.ID, len(got), count)
+		}
+		for i, p := range got {
+			if p != strconv.Itoa(i) {
+				t.Fatalf("machine %d published %v, want 0-%d in order", m.ID, got, count-1)
+			}
+		}
+		if n := client.maxFlight[topic]; n != 1 {
+			t.Errorf("machine %d had %d publishes in flight, want 1", m.ID, n)
+		}
+		if n := m.unacked.Load(); n != 0 {
+			t.Errorf("machine %d has %d unacked messages", m.ID, n)
+		}
+	}
+}
+
+// A machine whose ack is delayed holds back only its own messages.
+func TestOrderedPublishStalledMachine(t *testing.T) {
+	machines := setupOrdered(t, 3)
+	client := newFakeClient(func() time.Duration { return 0 })
+	stalled := machines[0].topic("status")
+	client.stalled[stalled] = true
+	startPublishers(client, machines)
+
+	// The stalled machine's first message is in flight and the queue holds
+	// the next PUBLISH_QUEUE_SIZE
+	enqueueAll(machines[:1], 1+config.PublishQueueSize)
+	enqueueAll(machines[1:], 20)
+	for _, m := range machines[1:] {
+		deadline := time.Now().Add(2 * time.Second)
+		for len(client.payloads(m.topic("status"))) < 20 {
+			if time.Now().After(deadline) {
+				t.Fatalf("machine %d was held up by machine %d's ack", m.ID, machines[0].ID)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if got := client.payloads(stalled); len(got) != 1 || got[0] != "0" {
+		t.Fatalf("stalled machine published %v before its first ack, want [0]", got)
+	}
+
+	close(client.release)
+	pendingPublishes.Wait()
+	got := client.payloads(stalled)
+	for i, p := range got {
+		if p != strconv.Itoa(i) {
+			t.Fatalf("stalled machine published %v after its ack, want 0-%d in order", got, config.PublishQueueSize)
+		}
+	}
+	if len(got) != 1+config.PublishQueueSize {
+		t.Fatalf("stalled machine published %d messages, want %d", len(got), 1+config.PublishQueueSize)
+	}
+}
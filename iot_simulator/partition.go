@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// defaultPartitionDuration is how long a partition lasts if it doesn't say.
+const defaultPartitionDuration = time.Minute
+
+// errPartitioned fails the client's connection attempts during a partition.
+var errPartitioned = errors.New("simulated network partition")
+
+// partition cuts the simulator off from the broker for a while, as if the
+// network between them had failed, to rehearse the reconnect, gap detection
+// and staleness handling end to end. The client's connection is closed
+// under it and its reconnects fail until the partition is over. Events made
+// meanwhile meet a disconnected client, so PUBLISH_OVERFLOW decides whether
+// they are dropped or wait.
+type partition struct {
+	mu sync.Mutex
+	// supported is whether the client connects through dial, which only
+	// plain TCP brokers do.
+	supported bool
+	conn      *cutConn
+	until     time.Time
+	timer     *time.Timer
+}
+
+// netPartition is the simulator's one connection to the broker.
+var netPartition partition
+
+// enable routes the client's connections for brokerURL through dial, so
+// they can be cut. Brokers on other schemes, such as WebSockets, connect
+// as usual and can't be partitioned.
+func (p *partition) enable(opts *mqtt.ClientOptions, brokerURL string) {
+	u, err := url.Parse(brokerURL)
+	if err != nil || (u.Scheme != "tcp" && u.Scheme != "mqtt") {
+		return
+	}
+	p.supported = true
+	opts.SetCustomOpenConnectionFn(p.dial)
+}
+
+// dial opens the client's connection to the broker, unless partitioned.
+func (p *partition) dial(uri *url.URL, opts mqtt.ClientOptions) (net.Conn, error) {
+	if p.active() {
+		return nil, errPartitioned
+	}
+	conn, err := opts.Dialer.Dial("tcp", uri.Host)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Now().Before(p.until) {
+		// The partition started while dialling
+		conn.Close()
+		return nil, errPartitioned
+	}
+	p.conn = &cutConn{Conn: conn}
+	return p.conn, nil
+}
+
+// cutConn is a connection to the broker that a partition can cut. The
+// client ignores errors from a connection closed under it, taking them for
+// its own disconnect, so once cut the connection fails with errPartitioned
+// instead, which the client treats as a lost connection.
+type cutConn struct {
+	net.Conn
+	cut atomic.Bool
+}
+
+func (c *cutConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil && c.cut.Load() {
+		err = errPartitioned
+	}
+	return n, err
+}
+
+func (c *cutConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if err != nil && c.cut.Load() {
+		err = errPartitioned
+	}
+	return n, err
+}
+
+func (p *partition) active() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return time.Now().Before(p.until)
+}
+
+// start partitions the simulator from the broker for d, or extends the
+// partition in progress to d from now, and returns when it ends.
+func (p *partition) start(d time.Duration) (time.Time, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.supported {
+		return time.Time{}, errors.New("only a tcp:// or mqtt:// broker connection can be partitioned")
+	}
+	if d <= 0 {
+		d = defaultPartitionDuration
+	}
+	p.until = time.Now().Add(d)
+	if p.timer != nil && p.timer.Stop() {
+		log.Printf("Network partition extended until %v", p.until.Format(time.TimeOnly))
+		p.timer.Reset(d)
+		return p.until, nil
+	}
+	log.Printf("Network partition: cut off from the broker until %v", p.until.Format(time.TimeOnly))
+	networkPartitioned.Set(1)
+	if p.conn != nil {
+		p.conn.cut.Store(true)
+		p.conn.Close()
+		p.conn = nil
+	}
+	p.timer = time.AfterFunc(d, func() {
+		networkPartitioned.Set(0)
+		log.Printf("Network partition over after %v, the client reconnects on its next attempt", d)
+	})
+	return p.until, nil
+}
+
+// partitionResponse is the body returned by POST /partition.
+type partitionResponse struct {
+	Until time.Time `json:"until"`
+}
+
+// partitionHandler serves POST /partition?duration=60, with duration in
+// seconds.
+func partitionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		var d time.Duration
+		if raw := r.URL.Query().Get("duration"); raw != "" {
+			sec, err := strconv.ParseFloat(raw, 64)
+			if err != nil || sec <= 0 {
+				http.Error(w, "duration must be a positive number of seconds", http.StatusBadRequest)
+				return
+			}
+			d = time.Duration(sec * float64(time.Second))
+		}
+		until, err := netPartition.start(d)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(partitionResponse{Until: until.UTC()})
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+// profile is a statistical profile of a real machine, fitted from plant
+// data. Read from PROFILE_FILE, it replaces the parametric distributions
+// with the empirical ones:
+//
+//	{
+//	  "cycle_time": {"edges": [2.8, 3.0, 3.2, 4.0, 8.0], "counts": [120, 860, 95, 12]},
+//	  "downtime": {"edges": [30, 60, 300, 1800], "counts": [40, 25, 3]},
+//	  "scrap_rate": 0.021
+//	}
+//
+// Every part is optional.
+type profile struct {
+	// CycleTime is the distribution of actual cycle times, slow cycles
+	// included, in seconds.
+	CycleTime *histogram `json:"cycle_time"`
+	// Downtime is the distribution of breakdown durations in seconds.
+	Downtime  *histogram `json:"downtime"`
+	ScrapRate *float64   `json:"scrap_rate"`
+}
+
+// histogram is an empirical distribution: Counts[i] observations fell
+// between Edges[i] and Edges[i+1]. Counts need not be whole numbers, so
+// relative frequencies work as well.
+type histogram struct {
+	Edges  []float64 `json:"edges"`
+	Counts []float64 `json:"counts"`
+	// cumulative holds the running totals of Counts, for sampling.
+	cumulative []float64
+}
+
+// cycleTime returns the profile's cycle time distribution, nil without a
+// profile or without one in it.
+func (p *profile) cycleTime() *histogram {
+	if p == nil {
+		return nil
+	}
+	return p.CycleTime
+}
+
+// downtime returns the profile's breakdown duration distribution, nil
+// without a profile or without one in it.
+func (p *profile) downtime() *histogram {
+	if p == nil {
+		return nil
+	}
+	return p.Downtime
+}
+
+// loadProfile reads and checks the profile in the JSON file at path.
+func loadProfile(path string) (*profile, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p profile
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, err
+	}
+	for name, h := range map[string]*histogram{"cycle_time": p.CycleTime, "downtime": p.Downtime} {
+		if h == nil {
+			continue
+		}
+		if err := h.check(); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if p.CycleTime != nil && p.CycleTime.Edges[0] <= 0 {
+		return nil, fmt.Errorf("cycle_time: cycle times must be positive")
+	}
+	if s := p.ScrapRate; s != nil && (*s < 0 || *s > 1) {
+		return nil, fmt.Errorf("scrap_rate must be between 0 and 1")
+	}
+	return &p, nil
+}
+
+// check makes sure h is a usable distribution and sums up its counts.
+func (h *histogram) check() error {
+	if len(h.Edges) < 2 || len(h.Counts) != len(h.Edges)-1 {
+		return fmt.Errorf("need one more edge than counts, and at least one bin")
+	}
+	if h.Edges[0] < 0 {
+		return fmt.Errorf("edges must not be negative")
+	}
+	h.cumulative = make([]float64, len(h.Counts))
+	var total float64
+	for i, c := range h.Counts {
+		if h.Edges[i+1] <= h.Edges[i] {
+			return fmt.Errorf("edges must increase")
+		}
+		if c < 0 {
+			return fmt.Errorf("counts must not be negative")
+		}
+		total += c
+		h.cumulative[i] = total
+	}
+	if total == 0 {
+		return fmt.Errorf("counts must not all be zero")
+	}
+	return nil
+}
+
+// sample draws a duration from h: a bin with chance proportional to its
+// count, then a point uniformly within the bin.
+func (h *histogram) sample(r *rand.Rand) time.Duration {
+	u := r.Float64() * h.cumulative[len(h.cumulative)-1]
+	// The first bin whose running total passes u, which skips empty bins
+	i := sort.Search(len(h.cumulative), func(i int) bool { return h.cumulative[i] > u })
+	i = min(i, len(h.Counts)-1) // u can round up to the total
+	lo, hi := h.Edges[i], h.Edges[i+1]
+	return time.Duration((lo + r.Float64()*(hi-lo)) * float64(time.Second))
+}
+
+// mean returns the mean of h, taking each bin's observations to lie at its
+// midpoint.
+func (h *histogram) mean() time.Duration {
+	var sum float64
+	for i, c := range h.Counts {
+		sum += c * (h.Edges[i] + h.Edges[i+1]) / 2
+	}
+	return time.Duration(sum / h.cumulative[len(h.cumulative)-1] * float64(time.Second))
+}
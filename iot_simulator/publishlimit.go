@@ -0,0 +1,35 @@
+package main
+
+import "time"
+
+// publishSlots holds a token for every publish sent and not yet
+// acknowledged, across all machines, when MAX_CONCURRENT_PUBLISHES is set;
+// nil leaves publishes unlimited. In async mode each publish in flight
+// costs a goroutine waiting for its ack, so a large fleet on a slow broker
+// would otherwise pile up goroutines and buffered messages without bound.
+var publishSlots chan struct{}
+
+// acquirePublishSlot blocks until fewer than MAX_CONCURRENT_PUBLISHES
+// publishes are in flight, and takes a slot for the next one.
+func acquirePublishSlot() {
+	if publishSlots == nil {
+		return
+	}
+	select {
+	case publishSlots <- struct{}{}:
+	default:
+		start := time.Now()
+		publishSlots <- struct{}{}
+		publishSlotWait.Add(time.Since(start).Seconds())
+	}
+	publishesInFlight.Inc()
+}
+
+// releasePublishSlot frees the slot of a publish that has completed.
+func releasePublishSlot() {
+	if publishSlots == nil {
+		return
+	}
+	<-publishSlots
+	publishesInFlight.Dec()
+}
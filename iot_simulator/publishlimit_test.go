@@ -0,0 +1,112 @@
+package main
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// slowBroker is an mqtt.Client whose broker acks every publish after delay,
+// without a goroutine of its own per publish, so what piles up while acks
+// are outstanding is the simulator's.
+type slowBroker struct {
+	mqtt.Client
+	delay time.Duration
+}
+
+func (c slowBroker) Publish(string, byte, bool, any) mqtt.Token {
+	t := &fakeToken{done: make(chan struct{})}
+	time.AfterFunc(c.delay, func() { close(t.done) })
+	return t
+}
+
+// BenchmarkAsyncPublish5000Machines has 5,000 machines publish at once in
+// async mode to a broker taking 50 ms per ack, with publishes in flight
+// unlimited and capped by MAX_CONCURRENT_PUBLISHES. Each iteration is one
+// cycle of the fleet, so ns/op is the time all of it took to be acked, and
+// peak-goroutines and peak-heap-MB what it cost at most:
+//
+//	go test -run '^$' -bench AsyncPublish5000Machines -benchmem ./iot_simulator
+func BenchmarkAsyncPublish5000Machines(b *testing.B) {
+	const machines = 5000
+	for _, limit := range []int{0, 1000, 200} {
+		name := "unlimited"
+		if limit > 0 {
+			name = "max-" + strconv.Itoa(limit)
+		}
+		b.Run(name, func(b *testing.B) {
+			saved, savedSlots := config, publishSlots
+			defer func() { config, publishSlots = saved, savedSlots }()
+			config = Config{PublishMode: publishAsync, MaxConcurrentPublishes: limit}
+			publishSlots = nil
+			if limit > 0 {
+				publishSlots = make(chan struct{}, limit)
+			}
+			client := slowBroker{delay: 50 * time.Millisecond}
+			fleet := make([]Machine, machines)
+			for i := range fleet {
+				fleet[i] = Machine{ID: i + 1, unacked: new(atomic.Int64), health: &publishHealth{}}
+			}
+
+			var peakGoroutines, peakHeap atomic.Uint64
+			stop := make(chan struct{})
+			sampled := make(chan struct{})
+			go func() {
+				defer close(sampled)
+				var ms runtime.MemStats
+				for {
+					peakGoroutines.Store(max(peakGoroutines.Load(), uint64(runtime.NumGoroutine())))
+					runtime.ReadMemStats(&ms)
+					peakHeap.Store(max(peakHeap.Load(), ms.HeapInuse))
+					select {
+					case <-stop:
+						return
+					case <-time.After(5 * time.Millisecond):
+					}
+				}
+			}()
+
+			// Each machine is a goroutine for the whole run, as in the
+			// simulator, publishing once per tick
+			var published sync.WaitGroup
+			ticks := make([]chan struct{}, machines)
+			for i, m := range fleet {
+				ticks[i] = make(chan struct{}, 1)
+				go func() {
+					for range ticks[i] {
+						msg := newMessage(m, "production")
+						m.unacked.Add(1)
+						publishNow(client, msg)
+						published.Done()
+					}
+				}()
+			}
+			defer func() {
+				for _, tick := range ticks {
+					close(tick)
+				}
+			}()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				published.Add(machines)
+				for _, tick := range ticks {
+					tick <- struct{}{}
+				}
+				published.Wait()
+				pendingPublishes.Wait()
+			}
+			b.StopTimer()
+			close(stop)
+			<-sampled
+			b.ReportMetric(float64(peakGoroutines.Load()), "peak-goroutines")
+			b.ReportMetric(float64(peakHeap.Load())/(1<<20), "peak-heap-MB")
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/events"
+)
+
+// What triggered a quality intervention, used as the metric label.
+const (
+	triggerCommand  = "command"
+	triggerSchedule = "schedule"
+)
+
+// intervention is the time of a machine's last quality intervention. It is
+// shared by the machine's goroutine and the command handler.
+type intervention struct {
+	at atomic.Int64 // Unix nanoseconds, zero before the first one
+}
+
+// trigger records an intervention at t.
+func (iv *intervention) trigger(t time.Time) {
+	iv.at.Store(t.UnixNano())
+}
+
+// scrapFactor returns how much of its normal scrap rate a machine has at t:
+// InterventionScrapFactor right after an intervention, rising linearly back
+// to 1 over InterventionRecovery as the effect wears off.
+func (iv *intervention) scrapFactor(t time.Time) float64 {
+	at := iv.at.Load()
+	if at == 0 {
+		return 1
+	}
+	elapsed := t.Sub(time.Unix(0, at))
+	if elapsed >= config.InterventionRecovery {
+		return 1
+	}
+	floor := config.InterventionScrapFactor
+	return floor + (1-floor)*float64(elapsed)/float64(config.InterventionRecovery)
+}
+
+// intervene starts a quality intervention on the machine.
+func (m Machine) intervene(trigger string) {
+	m.quality.trigger(time.Now())
+	qualityInterventions.WithLabelValues(trigger).Inc()
+	log.Printf("[Machine %d] Quality intervention (%s): scrap rate down to %.4f, recovering over %v",
+		m.ID, trigger, m.ScrapRate*config.InterventionScrapFactor, config.InterventionRecovery)
+}
+
+// subscribeCommands subscribes to the command topic of every machine. It is
+// called on each (re)connect, since the session does not keep subscriptions.
+func subscribeCommands(client mqtt.Client, machines []Machine) {
+	byTopic := make(map[string]Machine, len(machines))
+	prefixes := make(map[string]bool)
+	for _, m := range machines {
+		byTopic[m.topic(events.KindCommand)] = m
+		prefixes[m.TopicPrefix] = true
+	}
+
+	handle := func(_ mqtt.Client, msg mqtt.Message) {
+		m, ok := byTopic[msg.Topic()]
+		if !ok {
+			log.Printf("Ignoring command for unknown machine on %s", msg.Topic())
+			return
+		}
+		var cmd events.Command
+		if err := json.Unmarshal(msg.Payload(), &cmd); err != nil {
+			log.Printf("[Machine %d] Ignoring malformed command: %v", m.ID, err)
+			return
+		}
+		switch cmd.Command {
+		case events.CommandQualityIntervention:
+			m.intervene(triggerCommand)
+		case events.CommandInjectAnomaly:
+			if _, err := m.inject(cmd.Type, time.Duration(cmd.DurationSec*float64(time.Second))); err != nil {
+				log.Printf("[Machine %d] Ignoring command: %v", m.ID, err)
+			}
+		case events.CommandStopLine:
+			line, stagger := cmd.Line, time.Duration(-1)
+			if cmd.StaggerSec != nil {
+				stagger = time.Duration(*cmd.StaggerSec * float64(time.Second))
+			}
+			var err error
+			if line == "" {
+				line, err = lineOf(m)
+			}
+			if err == nil {
+				_, err = stopLine(line, time.Duration(cmd.DurationSec*float64(time.Second)), stagger, machines)
+			}
+			if err != nil {
+				log.Printf("[Machine %d] Ignoring command: %v", m.ID, err)
+			}
+		default:
+			log.Printf("[Machine %d] Ignoring unknown command %q", m.ID, cmd.Command)
+		}
+	}
+
+	for prefix := range prefixes {
+		topic := events.Wildcard(prefix, events.KindCommand)
+		if token := client.Subscribe(topic, 1, handle); token.Wait() && token.Error() != nil {
+			log.Printf("ERROR: failed to subscribe to %s: %v", topic, token.Error())
+		} else {
+			log.Printf("Subscribed to commands on %s", topic)
+		}
+	}
+}
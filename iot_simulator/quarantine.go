@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// dropQuarantined is the drop reason of events a quarantined machine skips.
+const dropQuarantined = "quarantined"
+
+// publishHealth tracks a machine's consecutive failed publishes, so a
+// machine whose publishes keep failing, e.g. because the broker's ACL denies
+// its topics, doesn't log every failure. After PUBLISH_QUARANTINE_AFTER
+// failures in a row the machine is quarantined: it logs once, then tries
+// one publish every backoff and drops the events in between. The backoff
+// starts at PUBLISH_QUARANTINE_BACKOFF and doubles with every failed try,
+// up to PUBLISH_QUARANTINE_MAX_BACKOFF. The first publish that succeeds
+// lifts the quarantine.
+type publishHealth struct {
+	mu          sync.Mutex
+	failures    int
+	quarantined bool
+	backoff     time.Duration
+	// next is when a quarantined machine may try its next publish.
+	next    time.Time
+	skipped int
+}
+
+// admit reports whether the machine may publish now. A quarantined machine
+// may once per backoff, which doubles with every try; the events it may not
+// publish are dropped.
+func (h *publishHealth) admit(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.quarantined {
+		return true
+	}
+	if now.Before(h.next) {
+		h.skipped++
+		return false
+	}
+	h.next = now.Add(h.backoff)
+	h.backoff = min(2*h.backoff, config.PublishQuarantineMaxBackoff)
+	return true
+}
+
+// failed records a failed publish of machine m's and reports whether to log
+// it: failures are logged until the machine is quarantined, after which it
+// is silent until a publish succeeds.
+func (h *publishHealth) failed(m int, now time.Time) bool {
+	if config.PublishQuarantineAfter == 0 {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures++
+	if h.quarantined {
+		return false
+	}
+	if h.failures >= config.PublishQuarantineAfter {
+		h.quarantined = true
+		h.backoff = config.PublishQuarantineBackoff
+		h.next = now.Add(h.backoff)
+		quarantinedMachines.Inc()
+		log.Printf("[Machine %d] Quarantined after %d failed publishes in a row, retrying after %v and backing off up to %v", m, h.failures, h.backoff, config.PublishQuarantineMaxBackoff)
+	}
+	return true
+}
+
+// succeeded records a successful publish of machine m's, lifting its
+// quarantine.
+func (h *publishHealth) succeeded(m int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.quarantined {
+		quarantinedMachines.Dec()
+		log.Printf("[Machine %d] Out of quarantine after %d failed publishes, %d events dropped", m, h.failures, h.skipped)
+	}
+	h.failures, h.quarantined, h.skipped = 0, false, 0
+}
+
+// skip drops msg because its machine is quarantined.
+func skip(msg message) {
+	publishDropped.WithLabelValues(msg.kind, dropQuarantined).Inc()
+	msg.span.SetStatus(codes.Error, "dropped: "+dropQuarantined)
+	msg.span.End()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// exponential draws a duration from the exponential distribution with the
+// given mean, how the time to the next failure or to a repair is commonly
+// modelled.
+func exponential(r *rand.Rand, mean time.Duration) time.Duration {
+	return time.Duration(r.ExpFloat64() * float64(mean))
+}
+
+// reliability returns the mean run time between breakdowns and the mean
+// repair time of b. With MTBF set these are MTBF and MTTR; otherwise they
+// are converted from DOWNTIME_CHANCE, which on average stops a machine
+// after 1/DowntimeChance cycles, each lasting the ideal cycle time plus the
+// average performance loss delay. A profile's histograms replace the cycle
+// and repair times with their means. A zero MTBF means the machine never
+// breaks down.
+func (b Behavior) reliability() (mtbf, mttr time.Duration) {
+	switch {
+	case b.MTBF > 0:
+		mtbf, mttr = b.MTBF, b.MTTR
+	case b.DowntimeChance > 0:
+		cycle := b.IdealCycleTime + time.Duration(b.PerformanceLossChance*float64(b.PerformanceLossMaxDelay)/2)
+		if h := b.profile.cycleTime(); h != nil {
+			cycle = h.mean()
+		}
+		mtbf, mttr = time.Duration(float64(cycle)/b.DowntimeChance), (b.DowntimeMin+b.DowntimeMax)/2
+	default:
+		return 0, 0
+	}
+	if h := b.profile.downtime(); h != nil {
+		mttr = h.mean()
+	}
+	return mtbf, mttr
+}
+
+// availability returns the share of time b is up going by breakdowns
+// alone, MTBF / (MTBF + MTTR); planned stops and other losses come on top.
+func (b Behavior) availability() float64 {
+	mtbf, mttr := b.reliability()
+	if mtbf == 0 {
+		return 1
+	}
+	return float64(mtbf) / float64(mtbf+mttr)
+}
+
+// failureFactor scales b's failure rate by how much run time has passed
+// since the machine was last maintained: MaintenanceFailureFactor right
+// after, climbing back towards 1 as 1 - (1 - factor) * e^(-t/recovery). A
+// negative sinceService, before the first maintenance, gives the usual rate.
+func (b Behavior) failureFactor(sinceService time.Duration) float64 {
+	if sinceService < 0 || b.MaintenanceFailureFactor >= 1 {
+		return 1
+	}
+	return 1 - (1-b.MaintenanceFailureFactor)*math.Exp(-float64(sinceService)/float64(b.MaintenanceRecovery))
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/configdump"
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/cycletime"
+)
+
+// liveSettings is the part of a machine's configuration a reload can change
+// while it runs.
+type liveSettings struct {
+	mu         sync.Mutex
+	behavior   Behavior
+	cycleTimes cycletime.Matrix
+}
+
+func (l *liveSettings) get() (Behavior, cycletime.Matrix) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.behavior, l.cycleTimes
+}
+
+func (l *liveSettings) set(b Behavior, cycleTimes cycletime.Matrix) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.behavior, l.cycleTimes = b, cycleTimes
+}
+
+// reloadable are the Config fields a reload applies. The rest, and Behavior
+// fields outside Sites, need a restart.
+var reloadable = map[string]bool{"Sites": true, "Fleet": true, "CycleTimes": true}
+
+// reloaded is the configuration in effect after the last reload, for
+// /debug/config and /version; nil until the first one.
+var reloaded atomic.Pointer[Config]
+
+// activeConfig returns the configuration in effect: config with the settings
+// of the last reload applied.
+func activeConfig() Config {
+	if cfg := reloaded.Load(); cfg != nil {
+		return *cfg
+	}
+	return config
+}
+
+// machineBehaviors returns the behavior of each machine in cfg, site by
+// site in machine order: the site's behavior, drawn from the FLEET_* ranges
+// with MACHINE_COUNT. Draws follow machine order, so the same seed gives the
+// same fleet.
+func machineBehaviors(cfg Config, r *rand.Rand) []Behavior {
+	var out []Behavior
+	for _, site := range cfg.Sites {
+		for range site.MachineIDs {
+			b := site.Behavior
+			if cfg.Fleet.Count > 0 {
+				b = cfg.Fleet.vary(b, r)
+			}
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// reloadOnHangup reloads the machines' settings on every SIGHUP until ctx is
+// done. seed is the run seed, so a generated fleet is drawn the same way as
+// at startup and only machines whose FLEET_* ranges changed get new values.
+func reloadOnHangup(ctx context.Context, machines []Machine, seed int64) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+	for {
+		select {
+		case <-hangups:
+			if err := reload(machines, seed); err != nil {
+				log.Printf("Reload failed, keeping the current settings: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reload re-reads CONFIG_FILE and hands each machine its new behavior and
+// cycle times, which it picks up from its next cycle on. What changed is
+// logged, grouping machines with the same changes; settings that need a
+// restart are logged and left as they are.
+func reload(machines []Machine, seed int64) error {
+	log.Printf("Received SIGHUP, reloading configuration")
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for _, site := range cfg.Sites {
+		for _, id := range site.MachineIDs {
+			ids = append(ids, fmt.Sprintf("%s/%d", site.TopicPrefix, id))
+		}
+	}
+	if len(ids) != len(machines) || slices.ContainsFunc(machines, func(m Machine) bool {
+		return !slices.Contains(ids, fmt.Sprintf("%s/%d", m.TopicPrefix, m.ID))
+	}) {
+		return fmt.Errorf("the machines or sites changed, which needs a restart")
+	}
+
+	// Fields of Config that differ, leaving out Behavior, whose reloadable
+	// part reaches the machines through Sites
+	active := activeConfig()
+	var restart []string
+	cycleTimesChanged := false
+	before := configdump.Dump(active).(map[string]any)
+	after := configdump.Dump(cfg).(map[string]any)
+	behaviorFields := configdump.Dump(Behavior{}).(map[string]any)
+	for name := range after {
+		if _, ok := behaviorFields[name]; ok || reflect.DeepEqual(before[name], after[name]) {
+			continue
+		}
+		switch {
+		case name == "CycleTimes":
+			cycleTimesChanged = true
+		case !reloadable[name]:
+			restart = append(restart, name)
+		}
+	}
+
+	// Machines with the same changes are logged together
+	behaviors := machineBehaviors(cfg, rand.New(rand.NewSource(seed)))
+	var changes []string
+	changedMachines := map[string][]string{}
+	for i, m := range machines {
+		old, _ := m.live.get()
+		if diff := behaviorChanges(old, behaviors[i]); diff != "" {
+			if _, ok := changedMachines[diff]; !ok {
+				changes = append(changes, diff)
+			}
+			changedMachines[diff] = append(changedMachines[diff], fmt.Sprint(m.ID))
+		}
+		m.live.set(behaviors[i], cfg.CycleTimes)
+	}
+
+	// Only the reloadable settings take effect
+	applied := active
+	applied.Sites, applied.Fleet, applied.CycleTimes = cfg.Sites, cfg.Fleet, cfg.CycleTimes
+	reloaded.Store(&applied)
+
+	for _, diff := range changes {
+		log.Printf("  Machines %s: %s", strings.Join(changedMachines[diff], ", "), diff)
+	}
+	if cycleTimesChanged && len(cfg.CycleTimes) == 0 {
+		log.Printf("  CycleTimes: none")
+	} else if cycleTimesChanged {
+		log.Printf("  CycleTimes: %v", cfg.CycleTimes)
+	}
+	if len(changes) == 0 && !cycleTimesChanged {
+		log.Printf("  No machine settings changed")
+	}
+	if len(restart) > 0 {
+		slices.Sort(restart)
+		log.Printf("  Not applied, these need a restart: %s", strings.Join(restart, ", "))
+	}
+	return nil
+}
+
+// behaviorChanges describes how b differs from old, e.g.
+// "ScrapRate 0.05 -> 0.1, MTBF 0s -> 10m0s", or returns "" if it doesn't.
+func behaviorChanges(old, b Behavior) string {
+	before := configdump.Dump(old).(map[string]any)
+	after := configdump.Dump(b).(map[string]any)
+	var diffs []string
+	for _, f := range reflect.VisibleFields(reflect.TypeOf(b)) {
+		if !reflect.DeepEqual(before[f.Name], after[f.Name]) {
+			diffs = append(diffs, fmt.Sprintf("%s %v -> %v", f.Name, before[f.Name], after[f.Name]))
+		}
+	}
+	return strings.Join(diffs, ", ")
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/events"
+)
+
+// Replay, when File is set, publishes the events recorded in a JSONL file
+// instead of simulating the machines.
+type Replay struct {
+	File string
+	// Speed divides the gaps between recorded timestamps: 1 replays in real
+	// time, 60 an hour a minute, and 0 publishes as fast as possible.
+	Speed float64
+	// Rebase shifts every time in the events by the same amount, so the
+	// first is stamped with the start of the replay. Otherwise they are
+	// published as recorded.
+	Rebase bool
+}
+
+// loadReplay reads REPLAY_FILE, REPLAY_SPEED and REPLAY_REBASE_TIMESTAMPS.
+func loadReplay() (Replay, error) {
+	r := Replay{File: getEnv("REPLAY_FILE", "")}
+	var err error
+	if r.Speed, err = envFloat("REPLAY_SPEED", 1); err != nil {
+		return r, err
+	}
+	if r.Speed < 0 {
+		return r, fmt.Errorf("invalid REPLAY_SPEED: must not be negative")
+	}
+	if r.Rebase, err = strconv.ParseBool(getEnv("REPLAY_REBASE_TIMESTAMPS", "false")); err != nil {
+		return r, fmt.Errorf("invalid REPLAY_REBASE_TIMESTAMPS: %w", err)
+	}
+	return r, nil
+}
+
+// recorded is one line of a replay file: an event as it was published, in
+// the format mosquitto_sub -F '{"topic":"%t","payload":%p}' writes.
+type recorded struct {
+	Topic   string          `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// runReplay publishes the events in REPLAY_FILE in file order, each when
+// its timestamp is due at REPLAY_SPEED, until the file or ctx ends, then
+// waits for outstanding acks. Events without a timestamp go out right after
+// the one before.
+func runReplay(ctx context.Context, client mqtt.Client) error {
+	r := config.Replay
+	f, err := os.Open(r.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	log.Printf("Replaying %s at speed %g", r.File, r.Speed)
+
+	start := time.Now()
+	var first time.Time                  // the first recorded timestamp
+	machines := make(map[string]Machine) // by topic prefix and ID
+	n := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; ctx.Err() == nil && scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var rec recorded
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		topic, err := events.ParseTopic(rec.Topic)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(rec.Payload, &fields); err != nil {
+			return fmt.Errorf("line %d: payload: %w", line, err)
+		}
+
+		if at, ok := recordedTime(fields["timestamp"]); ok {
+			if first.IsZero() {
+				first = at
+			}
+			if r.Speed > 0 {
+				due := start.Add(time.Duration(float64(at.Sub(first)) / r.Speed))
+				select {
+				case <-time.After(time.Until(due)):
+				case <-ctx.Done():
+					continue // and stop there
+				}
+			}
+		}
+
+		key := fmt.Sprintf("%s/%d", topic.Prefix, topic.MachineID)
+		m, ok := machines[key]
+		if !ok {
+			m = Machine{ID: topic.MachineID, TopicPrefix: topic.Prefix, unacked: new(atomic.Int64), health: &publishHealth{}}
+			machines[key] = m
+		}
+		m.unacked.Add(1)
+		msg := newMessage(m, topic.Kind)
+		msg.payload = rec.Payload
+		if r.Rebase && !first.IsZero() {
+			if msg.payload, err = rebase(fields, start.Sub(first)); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		publishNow(client, msg)
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	pendingPublishes.Wait()
+	log.Printf("Replayed %d events in %v", n, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// recordedTime returns the time in a JSON string field, if it holds one.
+func recordedTime(raw json.RawMessage) (time.Time, bool) {
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	return t, err == nil
+}
+
+// rebase returns the payload with every top-level time in it, such as
+// timestamp, started_at or planned_until, moved by shift.
+func rebase(fields map[string]json.RawMessage, shift time.Duration) ([]byte, error) {
+	for k, raw := range fields {
+		if t, ok := recordedTime(raw); ok {
+			b, err := json.Marshal(t.Add(shift).UTC())
+			if err != nil {
+				return nil, err
+			}
+			fields[k] = b
+		}
+	}
+	return json.Marshal(fields)
+}
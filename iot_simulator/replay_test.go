@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// recording is three events a second apart on two machines, with a line
+// without a timestamp between them.
+const recording = `{"topic":"factory/machine/1/status","payload":{"machine_id":1,"status":"running","timestamp":"2025-11-05T10:00:00Z"}}
+{"topic":"factory/plant-a/machine/2/production","payload":{"machine_id":2,"parts_produced":1,"parts_scrapped":0,"timestamp":"2025-11-05T10:00:01Z"}}
+
+{"topic":"factory/machine/1/lifecycle","payload":{"machine_id":1,"state":"birth","ideal_cycle_time_sec":2,"started_at":"2025-11-05T09:00:00Z"}}
+{"topic":"factory/machine/1/status","payload":{"machine_id":1,"status":"stopped","timestamp":"2025-11-05T10:00:02Z"}}
+`
+
+// setupReplay writes doc to a replay file and configures replaying it.
+func setupReplay(t *testing.T, doc string, speed float64, rebase bool) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	if err := os.WriteFile(path, []byte(doc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config = Config{PublishMode: publishSync, Replay: Replay{File: path, Speed: speed, Rebase: rebase}}
+}
+
+// timestamps returns the timestamp field of each payload.
+func timestamps(t *testing.T, payloads []string) []time.Time {
+	t.Helper()
+	var out []time.Time
+	for _, p := range payloads {
+		var e struct{ Timestamp time.Time }
+		if err := json.Unmarshal([]byte(p), &e); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, e.Timestamp)
+	}
+	return out
+}
+
+func TestReplayAsFastAsPossible(t *testing.T) {
+	setupReplay(t, recording, 0, false)
+	client := newFakeClient(func() time.Duration { return 0 })
+	start := time.Now()
+	if err := runReplay(context.Background(), client); err != nil {
+		t.Fatalf("runReplay: %v", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("replay took %v at speed 0", d)
+	}
+	status := client.payloads("factory/machine/1/status")
+	want := []string{
+		`{"machine_id":1,"status":"running","timestamp":"2025-11-05T10:00:00Z"}`,
+		`{"machine_id":1,"status":"stopped","timestamp":"2025-11-05T10:00:02Z"}`,
+	}
+	if len(status) != 2 || status[0] != want[0] || status[1] != want[1] {
+		t.Fatalf("published %q, want the recorded payloads %q", status, want)
+	}
+	if n := len(client.payloads("factory/plant-a/machine/2/production")); n != 1 {
+		t.Fatalf("published %d production events, want 1", n)
+	}
+	if n := len(client.payloads("factory/machine/1/lifecycle")); n != 1 {
+		t.Fatalf("published %d lifecycle events, want 1", n)
+	}
+}
+
+// At speed 10 the two seconds between the first and last event take 200ms.
+func TestReplaySpeed(t *testing.T) {
+	setupReplay(t, recording, 10, false)
+	client := newFakeClient(func() time.Duration { return 0 })
+	start := time.Now()
+	if err := runReplay(context.Background(), client); err != nil {
+		t.Fatalf("runReplay: %v", err)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond || d > time.Second {
+		t.Fatalf("replay took %v, want about 200ms", d)
+	}
+}
+
+func TestReplayRebase(t *testing.T) {
+	setupReplay(t, recording, 0, true)
+	client := newFakeClient(func() time.Duration { return 0 })
+	before := time.Now()
+	if err := runReplay(context.Background(), client); err != nil {
+		t.Fatalf("runReplay: %v", err)
+	}
+	got := timestamps(t, client.payloads("factory/machine/1/status"))
+	if len(got) != 2 {
+		t.Fatalf("published %d status events, want 2", len(got))
+	}
+	if got[0].Before(before.Add(-time.Second)) || got[0].After(time.Now()) {
+		t.Fatalf("first event stamped %v, want the start of the replay", got[0])
+	}
+	if gap := got[1].Sub(got[0]); gap != 2*time.Second {
+		t.Fatalf("events %v apart after rebasing, want 2s", gap)
+	}
+
+	// Every time in the payload moves with the timestamp
+	var birth struct {
+		StartedAt time.Time `json:"started_at"`
+	}
+	if err := json.Unmarshal([]byte(client.payloads("factory/machine/1/lifecycle")[0]), &birth); err != nil {
+		t.Fatal(err)
+	}
+	if d := got[0].Sub(birth.StartedAt); d != time.Hour {
+		t.Fatalf("started_at %v before the first event, want 1h", d)
+	}
+}
+
+func TestReplayStopsWithContext(t *testing.T) {
+	setupReplay(t, recording, 0.001, false)
+	client := newFakeClient(func() time.Duration { return 0 })
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := runReplay(ctx, client); err != nil {
+		t.Fatalf("runReplay: %v", err)
+	}
+	if n := len(client.payloads("factory/machine/1/status")); n != 1 {
+		t.Fatalf("published %d status events before stopping, want 1", n)
+	}
+}
+
+func TestReplayErrors(t *testing.T) {
+	for name, doc := range map[string]string{
+		"not JSON":        "topic payload\n",
+		"malformed topic": `{"topic":"factory/status","payload":{}}` + "\n",
+		"payload array":   `{"topic":"factory/machine/1/status","payload":[1]}` + "\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			setupReplay(t, doc, 0, false)
+			if err := runReplay(context.Background(), newFakeClient(func() time.Duration { return 0 })); err == nil {
+				t.Fatal("runReplay succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Reasons attached to the final "stopped" event each machine sends.
+const (
+	// reasonShutdown is used when the simulator exits.
+	reasonShutdown = "shutdown"
+	// reasonTargetReached is used when the machine has made
+	// TARGET_GOOD_PARTS good parts.
+	reasonTargetReached = "target_reached"
+)
+
+var (
+	// productionEvents counts production events claimed in this run.
+	productionEvents atomic.Int64
+	// targetReached is closed once TARGET_EVENT_COUNT production events
+	// have been claimed.
+	targetReached = make(chan struct{})
+	targetOnce    sync.Once
+	// pendingPublishes tracks async publish acks so shutdown can wait for
+	// them before disconnecting.
+	pendingPublishes sync.WaitGroup
+)
+
+// runContext returns a context that is cancelled when the run should end:
+// on SIGINT or SIGTERM, after RUN_DURATION, or once TARGET_EVENT_COUNT
+// production events have been published.
+func runContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	// A nil channel never fires, leaving the run unbounded
+	var deadline <-chan time.Time
+	if config.RunDuration > 0 {
+		deadline = time.After(config.RunDuration)
+	}
+
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			log.Printf("Received %v, shutting down", sig)
+		case <-deadline:
+			log.Printf("RUN_DURATION of %v elapsed, shutting down", config.RunDuration)
+		case <-targetReached:
+			log.Printf("TARGET_EVENT_COUNT of %d reached, shutting down", config.TargetEventCount)
+		case <-ctx.Done():
+			return
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// claimProductionEvent reserves one production event under
+// TARGET_EVENT_COUNT. It returns false once the target has been used up, so
+// the count is exact even with many machines publishing at once.
+func claimProductionEvent() bool {
+	target := int64(config.TargetEventCount)
+	for {
+		n := productionEvents.Load()
+		if target > 0 && n >= target {
+			return false
+		}
+		if productionEvents.CompareAndSwap(n, n+1) {
+			if n+1 == target {
+				targetOnce.Do(func() { close(targetReached) })
+			}
+			return true
+		}
+	}
+}
+
+// logTotals reports the parts each machine made in the run.
+func logTotals(machines []Machine) {
+	for _, m := range machines {
+		name := fmt.Sprintf("Machine %d", m.ID)
+		if m.Site != "" {
+			name = fmt.Sprintf("Machine %d (%s)", m.ID, m.Site)
+		}
+		t := m.totals
+		log.Printf("  %s: %d good, %d reworked, %d scrapped", name, t.PartsProduced, t.PartsReworked, t.PartsScrapped)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// PART_SERIALS values: how the serialization station numbers good parts.
+const (
+	// serialsSequence numbers each machine's parts in order, e.g.
+	// "1-20251105T090000-000042", unique across simulator restarts and
+	// sites like lot IDs.
+	serialsSequence = "sequence"
+	// serialsUUID gives each part a random (version 4) UUID. They come from
+	// crypto/rand rather than the seeded generator, so a repeated seed
+	// doesn't reissue the serials of an earlier run.
+	serialsUUID = "uuid"
+)
+
+// serial returns the serial of the n-th part m made in this run under
+// PART_SERIALS.
+func (m Machine) serial(n int) string {
+	if config.PartSerials == serialsSequence {
+		return fmt.Sprintf("%s-%s-%06d", m.runPrefix(), runStarted.Format("20060102T150405"), n)
+	}
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
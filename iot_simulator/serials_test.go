@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+// Machines sharing an ID at two sites issue different lot IDs and serials,
+// since part_serials keeps serials unique across sites.
+func TestSerialsAcrossSites(t *testing.T) {
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config.PartSerials = serialsSequence
+
+	a, b := Machine{ID: 1, Site: "a"}, Machine{ID: 1, Site: "b"}
+	if a.serial(1) == b.serial(1) {
+		t.Errorf("both sites issued serial %s", a.serial(1))
+	}
+	if a.lotID(1) == b.lotID(1) {
+		t.Errorf("both sites issued lot ID %s", a.lotID(1))
+	}
+
+	run := runStarted.Format("20060102T150405")
+	tests := []struct {
+		m           Machine
+		serial, lot string
+	}{
+		{Machine{ID: 1}, "1-" + run + "-000042", "1-" + run + "-0003"},
+		{a, "a-1-" + run + "-000042", "a-1-" + run + "-0003"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.serial(42); got != tt.serial {
+			t.Errorf("serial of machine %d at site %q = %s, want %s", tt.m.ID, tt.m.Site, got, tt.serial)
+		}
+		if got := tt.m.lotID(3); got != tt.lot {
+			t.Errorf("lot ID of machine %d at site %q = %s, want %s", tt.m.ID, tt.m.Site, got, tt.lot)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// parseShiftStarts parses comma-separated "HH:MM" shift start times, wall
+// clock times in TIMEZONE, into offsets from midnight.
+func parseShiftStarts(s string) ([]time.Duration, error) {
+	var starts []time.Duration
+	for _, raw := range strings.Split(s, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse("15:04", raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shift start %q: want HH:MM", raw)
+		}
+		starts = append(starts, time.Duration(t.Hour())*time.Hour+time.Duration(t.Minute())*time.Minute)
+	}
+	return starts, nil
+}
+
+// handoverIntensity returns how deep t is in a shift handover: 1 exactly at
+// a shift change, falling linearly to 0 at HandoverWindow before or after
+// it. The ramp on both sides models the outgoing shift winding down and the
+// incoming one getting up to speed.
+func handoverIntensity(t time.Time) float64 {
+	window := config.HandoverWindow
+	if window <= 0 || len(config.ShiftStarts) == 0 {
+		return 0
+	}
+	nearest := window
+	// Check yesterday and tomorrow too, so a window spans midnight
+	for day := -1; day <= 1; day++ {
+		for _, start := range config.ShiftStarts {
+			if d := t.Sub(shiftStart(t, day, start, config.Location)).Abs(); d < nearest {
+				nearest = d
+			}
+		}
+	}
+	return 1 - float64(nearest)/float64(window)
+}
+
+// shiftStart returns when the shift starting at offset past midnight begins
+// on the day days after t's, both read in loc. The start is built from the
+// wall clock rather than by adding offset to midnight, so on the days DST
+// begins or ends a 07:00 shift still starts at 07:00 and not at 06:00 or
+// 08:00.
+func shiftStart(t time.Time, days int, offset time.Duration, loc *time.Location) time.Time {
+	t = t.In(loc)
+	hour, minute := int(offset/time.Hour), int(offset%time.Hour/time.Minute)
+	return time.Date(t.Year(), t.Month(), t.Day()+days, hour, minute, 0, 0, loc)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShiftStartDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		date   string
+		days   int
+		offset time.Duration
+		want   string
+	}{
+		{"morning shift on an ordinary day", "2025-03-20", 0, 7 * time.Hour, "2025-03-20T06:00:00Z"},
+		{"morning shift on spring-forward day", "2025-03-30", 0, 7 * time.Hour, "2025-03-30T05:00:00Z"},
+		{"morning shift on fall-back day", "2025-10-26", 0, 7 * time.Hour, "2025-10-26T06:00:00Z"},
+		{"night shift before spring-forward day", "2025-03-29", 0, 23 * time.Hour, "2025-03-29T22:00:00Z"},
+		{"next day's shift across spring forward", "2025-03-29", 1, 7 * time.Hour, "2025-03-30T05:00:00Z"},
+		{"previous day's shift across fall back", "2025-10-27", -1, 23 * time.Hour, "2025-10-26T22:00:00Z"},
+		{"shift at 00:30", "2025-03-30", 0, 30 * time.Minute, "2025-03-29T23:30:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			day, err := time.ParseInLocation(time.DateOnly, tt.date, berlin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The day is read in loc whatever zone t is in
+			got := shiftStart(day.Add(12*time.Hour).UTC(), tt.days, tt.offset, berlin)
+			if want, _ := time.Parse(time.RFC3339, tt.want); !got.Equal(want) {
+				t.Fatalf("shiftStart = %s, want %s", got.UTC().Format(time.RFC3339), tt.want)
+			}
+			if local := got.Hour()*60 + got.Minute(); time.Duration(local)*time.Minute != tt.offset {
+				t.Fatalf("starts at %s local", got.Format(time.TimeOnly))
+			}
+		})
+	}
+}
+
+// The handover ramp is centred on the local shift change on the day DST
+// begins, not an hour off.
+func TestHandoverIntensityDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := config
+	defer func() { config = saved }()
+	config = Config{Location: berlin, ShiftStarts: []time.Duration{7 * time.Hour}, HandoverWindow: 30 * time.Minute}
+
+	change := time.Date(2025, 3, 30, 7, 0, 0, 0, berlin)
+	tests := []struct {
+		at   time.Time
+		want float64
+	}{
+		{change, 1},
+		{change.Add(-15 * time.Minute), 0.5},
+		{change.Add(15 * time.Minute), 0.5},
+		{change.Add(-time.Hour), 0},
+		{change.Add(time.Hour), 0},
+	}
+	for _, tt := range tests {
+		if got := handoverIntensity(tt.at.UTC()); got != tt.want {
+			t.Errorf("handoverIntensity(%s) = %v, want %v", tt.at.Format(time.RFC3339), got, tt.want)
+		}
+	}
+}
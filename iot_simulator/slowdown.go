@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SpeedWindow is a daily period in which machines are deliberately run
+// below their ideal rate, such as during a trial.
+type SpeedWindow struct {
+	// MachineIDs are the machines the window applies to; nil means all.
+	MachineIDs []int
+	// Start and End are offsets from midnight in TIMEZONE; a window whose
+	// End is before its Start runs past midnight.
+	Start, End time.Duration
+	// Speed is the rate the machines run at, as a fraction of ideal.
+	Speed float64
+}
+
+// parseSpeedWindows parses semicolon-separated "machines@HH:MM-HH:MM=speed"
+// entries, where machines is "*" or comma-separated machine IDs and speed is
+// a fraction of the ideal rate, e.g. "2,3@10:00-12:00=0.7;*@22:00-02:00=0.9".
+func parseSpeedWindows(s string) ([]SpeedWindow, error) {
+	var windows []SpeedWindow
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		invalid := fmt.Errorf("invalid REDUCED_SPEED_WINDOWS entry %q: want machines@HH:MM-HH:MM=speed", entry)
+		machines, rest, ok := strings.Cut(entry, "@")
+		if !ok {
+			return nil, invalid
+		}
+		span, rawSpeed, ok := strings.Cut(rest, "=")
+		if !ok {
+			return nil, invalid
+		}
+		rawStart, rawEnd, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, invalid
+		}
+
+		var w SpeedWindow
+		if machines = strings.TrimSpace(machines); machines != "*" {
+			for _, raw := range strings.Split(machines, ",") {
+				id, err := strconv.Atoi(strings.TrimSpace(raw))
+				if err != nil {
+					return nil, invalid
+				}
+				w.MachineIDs = append(w.MachineIDs, id)
+			}
+		}
+		for _, t := range []struct {
+			raw string
+			to  *time.Duration
+		}{{rawStart, &w.Start}, {rawEnd, &w.End}} {
+			parsed, err := time.Parse("15:04", strings.TrimSpace(t.raw))
+			if err != nil {
+				return nil, invalid
+			}
+			*t.to = time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute
+		}
+		var err error
+		if w.Speed, err = strconv.ParseFloat(strings.TrimSpace(rawSpeed), 64); err != nil || w.Speed <= 0 || w.Speed >= 1 {
+			return nil, fmt.Errorf("invalid REDUCED_SPEED_WINDOWS entry %q: the speed must be a fraction between 0 and 1", entry)
+		}
+		if w.Start == w.End {
+			return nil, fmt.Errorf("invalid REDUCED_SPEED_WINDOWS entry %q: the window is empty", entry)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// plannedSpeed returns the rate machine runs at t under the reduced-speed
+// windows, as a fraction of ideal: the slowest of the windows it is in, or
+// 1 outside them. Windows follow the wall clock in TIMEZONE.
+func plannedSpeed(machine int, t time.Time) float64 {
+	t = t.In(config.Location)
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	speed := 1.0
+	for _, w := range config.ReducedSpeed {
+		if w.MachineIDs != nil && !slices.Contains(w.MachineIDs, machine) {
+			continue
+		}
+		in := w.Start <= now && now < w.End
+		if w.End < w.Start {
+			in = now >= w.Start || now < w.End
+		}
+		if in {
+			speed = min(speed, w.Speed)
+		}
+	}
+	return speed
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/events"
+)
+
+// statusSummary accumulates what a machine does between its status events,
+// for STATUS_SUMMARY. Only the machine's own loop uses it.
+type statusSummary struct {
+	// since is when the machine last sent a status event, by the host's
+	// clock, so clock drift doesn't skew the durations; zero before its
+	// first. status is the status it sent.
+	since  time.Time
+	status string
+	parts  events.PartTotals
+}
+
+// count adds the parts in event.
+func (s *statusSummary) count(event events.ProductionEvent) {
+	s.parts.PartsProduced += event.PartsProduced
+	s.parts.PartsScrapped += event.PartsScrapped
+	s.parts.PartsReworked += event.PartsReworked
+}
+
+// next returns the summary for a status event sent at now, nil for the
+// machine's first, and starts the next summary from it.
+func (s *statusSummary) next(status string, now time.Time) *events.StatusSummary {
+	var sum *events.StatusSummary
+	if !s.since.IsZero() {
+		elapsed := math.Round(now.Sub(s.since).Seconds()*1000) / 1000
+		sum = &events.StatusSummary{PartTotals: s.parts, ElapsedSeconds: elapsed}
+		if s.status == events.StatusRunning {
+			sum.UptimeSeconds = elapsed
+		}
+	}
+	*s = statusSummary{since: now, status: status}
+	return sum
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/SirNacou/OEE-Factory-Monitor/internal/telemetry"
+)
+
+var tracer = otel.Tracer("github.com/SirNacou/OEE-Factory-Monitor/iot_simulator")
+
+// message is one event on its way to the broker, with the span that covers
+// its publish up to the broker ack.
+type message struct {
+	machineID int
+	kind      string // "status", "production", "lifecycle" or "operator"
+	topic     string
+	payload   []byte
+	traceID   string
+	spanID    string
+	span      trace.Span
+	sent      time.Time // when it was handed to the MQTT client
+	qos       byte
+	// unacked is the backlog of the machine that sent it, and health its
+	// publish failures.
+	unacked *atomic.Int64
+	health  *publishHealth
+}
+
+// newMessage starts the publish span for a kind event from m. With
+// OpenTelemetry enabled the trace and span IDs come from the span, so the
+// ingestion service can continue the trace; otherwise the span is a no-op
+// and a random trace ID is used when TRACE_IDS is set.
+func newMessage(m Machine, kind string) message {
+	msg := message{machineID: m.ID, kind: kind, topic: m.topic(kind), qos: 1, unacked: m.unacked, health: m.health}
+	_, msg.span = tracer.Start(context.Background(), "publish "+kind,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.Int("machine_id", m.ID),
+			attribute.String("messaging.destination.name", msg.topic),
+		))
+	switch sc := msg.span.SpanContext(); {
+	case telemetry.Enabled() && sc.IsValid():
+		msg.traceID, msg.spanID = sc.TraceID().String(), sc.SpanID().String()
+	case config.TraceIDs:
+		msg.traceID = newTraceID()
+	}
+	return msg
+}
+
+// newTraceID returns a random 16-byte trace ID as 32 hex characters. This is
+// the W3C Trace Context format, so the same ID can become an OpenTelemetry
+// trace ID later.
+func newTraceID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// traceSuffix formats a trace ID for the end of a log line, or returns ""
+// when there is none.
+func traceSuffix(traceID string) string {
+	if traceID == "" {
+		return ""
+	}
+	return " (trace " + traceID + ")"
+}
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS planned_downtime (
+    id SERIAL PRIMARY KEY,
+    machine_id INT NOT NULL REFERENCES machines (id),
+    start_time timestamptz NOT NULL,
+    end_time timestamptz NOT NULL,
+    reason text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CHECK (end_time > start_time)
+  );
+
+CREATE INDEX IF NOT EXISTS planned_downtime_machine_time_idx ON planned_downtime (machine_id, start_time, end_time);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS planned_downtime;
+
+-- +goose StatementEnd
\ No newline at end of file
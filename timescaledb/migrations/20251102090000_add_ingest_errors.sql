@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS ingest_errors (
+    time timestamptz NOT NULL,
+    topic text NOT NULL,
+    payload bytea NOT NULL,
+    stage text NOT NULL,
+    error text NOT NULL
+  )
+WITH
+  (tsdb.hypertable, tsdb.partition_column = 'time');
+
+SELECT
+  add_retention_policy ('ingest_errors', INTERVAL '30 days');
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS ingest_errors;
+
+-- +goose StatementEnd
\ No newline at end of file
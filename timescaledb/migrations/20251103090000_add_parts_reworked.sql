@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE production_events
+ADD COLUMN IF NOT EXISTS parts_reworked integer NOT NULL DEFAULT 0;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE production_events
+DROP COLUMN IF EXISTS parts_reworked;
+
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE status_events
+ADD COLUMN IF NOT EXISTS reason text NOT NULL DEFAULT '';
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE status_events
+DROP COLUMN IF EXISTS reason;
+
+-- +goose StatementEnd
\ No newline at end of file
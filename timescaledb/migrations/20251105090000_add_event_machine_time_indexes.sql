@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS status_events_machine_time_idx ON status_events (machine_id, time);
+
+CREATE INDEX IF NOT EXISTS production_events_machine_time_idx ON production_events (machine_id, time);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS status_events_machine_time_idx;
+
+DROP INDEX IF EXISTS production_events_machine_time_idx;
+
+-- +goose StatementEnd
\ No newline at end of file
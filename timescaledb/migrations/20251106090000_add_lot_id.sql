@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE production_events
+ADD COLUMN IF NOT EXISTS lot_id text NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS production_events_lot_idx ON production_events (lot_id, time)
+WHERE
+  lot_id <> '';
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS production_events_lot_idx;
+
+ALTER TABLE production_events
+DROP COLUMN IF EXISTS lot_id;
+
+-- +goose StatementEnd
\ No newline at end of file
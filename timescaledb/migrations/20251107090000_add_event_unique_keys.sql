@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Remove existing duplicates so the unique indexes can be built.
+DELETE FROM status_events a USING status_events b
+WHERE
+  a.machine_id = b.machine_id
+  AND a.time = b.time
+  AND a.ctid < b.ctid;
+
+DELETE FROM production_events a USING production_events b
+WHERE
+  a.machine_id = b.machine_id
+  AND a.time = b.time
+  AND a.ctid < b.ctid;
+
+-- The unique indexes replace the plain (machine_id, time) ones and back the
+-- ON CONFLICT (machine_id, time) clauses used by the ingestion service.
+DROP INDEX IF EXISTS status_events_machine_time_idx;
+
+DROP INDEX IF EXISTS production_events_machine_time_idx;
+
+CREATE UNIQUE INDEX IF NOT EXISTS status_events_machine_time_key ON status_events (machine_id, time);
+
+CREATE UNIQUE INDEX IF NOT EXISTS production_events_machine_time_key ON production_events (machine_id, time);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS status_events_machine_time_key;
+
+DROP INDEX IF EXISTS production_events_machine_time_key;
+
+CREATE INDEX IF NOT EXISTS status_events_machine_time_idx ON status_events (machine_id, time);
+
+CREATE INDEX IF NOT EXISTS production_events_machine_time_idx ON production_events (machine_id, time);
+
+-- +goose StatementEnd
\ No newline at end of file
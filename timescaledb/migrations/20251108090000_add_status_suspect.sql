@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE status_events
+ADD COLUMN IF NOT EXISTS suspect boolean NOT NULL DEFAULT false;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE status_events
+DROP COLUMN IF EXISTS suspect;
+
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE machines
+ADD COLUMN IF NOT EXISTS site text NOT NULL DEFAULT '',
+ADD COLUMN IF NOT EXISTS started_at timestamptz,
+ADD COLUMN IF NOT EXISTS online boolean NOT NULL DEFAULT false;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE machines
+DROP COLUMN IF EXISTS online,
+DROP COLUMN IF EXISTS started_at,
+DROP COLUMN IF EXISTS site;
+
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS oee_hourly (
+    machine_id INT NOT NULL REFERENCES machines (id),
+    bucket timestamptz NOT NULL,
+    planned_seconds double precision NOT NULL,
+    run_seconds double precision NOT NULL,
+    micro_stop_seconds double precision NOT NULL,
+    ideal_cycle_time_sec double precision NOT NULL,
+    good_count INT NOT NULL,
+    reworked_count INT NOT NULL,
+    scrap_count INT NOT NULL,
+    availability double precision NOT NULL,
+    performance double precision NOT NULL,
+    quality double precision NOT NULL,
+    oee double precision NOT NULL,
+    computed_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (machine_id, bucket)
+  );
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS oee_hourly;
+
+-- +goose StatementEnd
\ No newline at end of file
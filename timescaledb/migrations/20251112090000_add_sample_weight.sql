@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE production_events
+ADD COLUMN IF NOT EXISTS sample_weight INT NOT NULL DEFAULT 1;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE production_events
+DROP COLUMN IF EXISTS sample_weight;
+
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS part_measurements (
+    time timestamptz NOT NULL,
+    machine_id integer NOT NULL,
+    characteristic text NOT NULL,
+    unit text NOT NULL DEFAULT '',
+    value double precision NOT NULL,
+    lower_spec double precision NOT NULL,
+    upper_spec double precision NOT NULL,
+    out_of_spec boolean NOT NULL
+  )
+WITH
+  (tsdb.hypertable, tsdb.partition_column = 'time');
+
+-- One measurement per production event, which shares its key.
+CREATE UNIQUE INDEX IF NOT EXISTS part_measurements_machine_time_key ON part_measurements (machine_id, time);
+
+SELECT
+  add_retention_policy ('part_measurements', INTERVAL '30 days');
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS part_measurements;
+
+-- +goose StatementEnd
\ No newline at end of file
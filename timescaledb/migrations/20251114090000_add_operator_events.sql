@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS operator_events (
+    time timestamptz NOT NULL,
+    machine_id integer NOT NULL,
+    operator_id text NOT NULL,
+    shift text NOT NULL DEFAULT ''
+  )
+WITH
+  (tsdb.hypertable, tsdb.partition_column = 'time');
+
+-- An operator runs the machine from time until the machine's next row.
+CREATE UNIQUE INDEX IF NOT EXISTS operator_events_machine_time_key ON operator_events (machine_id, time);
+
+-- A few rows a shift, needed to attribute older events to operators, so
+-- there is no retention policy.
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS operator_events;
+
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE machines
+ADD COLUMN IF NOT EXISTS last_seq bigint NOT NULL DEFAULT 0;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE machines
+DROP COLUMN IF EXISTS last_seq;
+
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE production_events
+ADD COLUMN IF NOT EXISTS warmup boolean NOT NULL DEFAULT false;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE production_events
+DROP COLUMN IF EXISTS warmup;
+
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE status_events
+ADD COLUMN IF NOT EXISTS raw_payload jsonb;
+
+ALTER TABLE production_events
+ADD COLUMN IF NOT EXISTS raw_payload jsonb;
+
+ALTER TABLE operator_events
+ADD COLUMN IF NOT EXISTS raw_payload jsonb;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE status_events
+DROP COLUMN IF EXISTS raw_payload;
+
+ALTER TABLE production_events
+DROP COLUMN IF EXISTS raw_payload;
+
+ALTER TABLE operator_events
+DROP COLUMN IF EXISTS raw_payload;
+
+-- +goose StatementEnd
\ No newline at end of file
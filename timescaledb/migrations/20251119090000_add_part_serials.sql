@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Serials of the parts that shipped, for traceability. A plain table rather
+-- than a hypertable, so serials can be unique on their own and are kept
+-- after the events they came with are dropped by retention.
+CREATE TABLE IF NOT EXISTS part_serials (
+    time timestamptz NOT NULL,
+    machine_id integer NOT NULL,
+    serial text NOT NULL,
+    lot_id text NOT NULL DEFAULT '',
+    product text NOT NULL DEFAULT '',
+    UNIQUE (machine_id, time)
+  );
+
+CREATE UNIQUE INDEX IF NOT EXISTS part_serials_serial_key ON part_serials (serial);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS part_serials;
+
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,39 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Summaries of the part measurements the ingestor's decimation job rolls
+-- up: the mean, min and max of each characteristic per bucket, kept long
+-- after the raw measurements are deleted. bucket_width is part of the key,
+-- so a change of MEASUREMENT_DECIMATE_BUCKET_SEC starts new summaries rather
+-- than merging into buckets of another width.
+CREATE TABLE IF NOT EXISTS part_measurements_rollup (
+    bucket timestamptz NOT NULL,
+    bucket_width interval NOT NULL,
+    machine_id integer NOT NULL,
+    characteristic text NOT NULL,
+    unit text NOT NULL DEFAULT '',
+    mean_value double precision NOT NULL,
+    min_value double precision NOT NULL,
+    max_value double precision NOT NULL,
+    samples bigint NOT NULL,
+    out_of_spec_samples bigint NOT NULL
+  )
+WITH
+  (tsdb.hypertable, tsdb.partition_column = 'bucket');
+
+CREATE UNIQUE INDEX IF NOT EXISTS part_measurements_rollup_key ON part_measurements_rollup (machine_id, characteristic, unit, bucket_width, bucket);
+
+-- The decimation job deletes measurements once they are rolled up. The
+-- 30-day retention would drop them unsummarized whenever
+-- MEASUREMENT_DECIMATE_AFTER_HOURS is longer.
+SELECT
+  remove_retention_policy ('part_measurements', if_exists => true);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS part_measurements_rollup;
+
+SELECT
+  add_retention_policy ('part_measurements', INTERVAL '30 days', if_not_exists => true);
+
+-- +goose StatementEnd
\ No newline at end of file
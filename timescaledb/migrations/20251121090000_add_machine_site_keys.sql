@@ -0,0 +1,184 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Machines are identified by their site and the ID they report there, so
+-- sites that number their machines independently can share the database.
+-- Rows written before this migration, like those of single-site
+-- deployments, belong to the default site ''.
+ALTER TABLE status_events
+ADD COLUMN IF NOT EXISTS site text NOT NULL DEFAULT '';
+
+ALTER TABLE production_events
+ADD COLUMN IF NOT EXISTS site text NOT NULL DEFAULT '';
+
+ALTER TABLE part_measurements
+ADD COLUMN IF NOT EXISTS site text NOT NULL DEFAULT '';
+
+ALTER TABLE part_serials
+ADD COLUMN IF NOT EXISTS site text NOT NULL DEFAULT '';
+
+ALTER TABLE operator_events
+ADD COLUMN IF NOT EXISTS site text NOT NULL DEFAULT '';
+
+ALTER TABLE planned_downtime
+ADD COLUMN IF NOT EXISTS site text NOT NULL DEFAULT '';
+
+ALTER TABLE oee_hourly
+ADD COLUMN IF NOT EXISTS site text NOT NULL DEFAULT '';
+
+ALTER TABLE production_plan
+ADD COLUMN IF NOT EXISTS site text NOT NULL DEFAULT '';
+
+ALTER TABLE part_measurements_rollup
+ADD COLUMN IF NOT EXISTS site text NOT NULL DEFAULT '';
+
+-- The event keys, which back the ingestion service's ON CONFLICT clauses,
+-- take the site in.
+DROP INDEX IF EXISTS status_events_machine_time_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS status_events_site_machine_time_key ON status_events (site, machine_id, time);
+
+DROP INDEX IF EXISTS production_events_machine_time_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS production_events_site_machine_time_key ON production_events (site, machine_id, time);
+
+DROP INDEX IF EXISTS part_measurements_machine_time_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS part_measurements_site_machine_time_key ON part_measurements (site, machine_id, time);
+
+ALTER TABLE part_serials
+DROP CONSTRAINT IF EXISTS part_serials_machine_id_time_key,
+ADD CONSTRAINT part_serials_site_machine_id_time_key UNIQUE (site, machine_id, time);
+
+DROP INDEX IF EXISTS operator_events_machine_time_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS operator_events_site_machine_time_key ON operator_events (site, machine_id, time);
+
+DROP INDEX IF EXISTS planned_downtime_machine_time_idx;
+
+CREATE INDEX IF NOT EXISTS planned_downtime_machine_time_idx ON planned_downtime (site, machine_id, start_time, end_time);
+
+DROP INDEX IF EXISTS part_measurements_rollup_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS part_measurements_rollup_key ON part_measurements_rollup (site, machine_id, characteristic, unit, bucket_width, bucket);
+
+-- The machines registry is keyed on both, and so are the tables that refer
+-- to it.
+ALTER TABLE production_plan
+DROP CONSTRAINT IF EXISTS production_plan_machine_id_fkey;
+
+ALTER TABLE planned_downtime
+DROP CONSTRAINT IF EXISTS planned_downtime_machine_id_fkey;
+
+ALTER TABLE oee_hourly
+DROP CONSTRAINT IF EXISTS oee_hourly_machine_id_fkey;
+
+ALTER TABLE machines
+DROP CONSTRAINT machines_pkey,
+ADD PRIMARY KEY (site, id);
+
+DROP INDEX IF EXISTS production_plan_machine_id_shift_id_plan_date_idx;
+
+ALTER TABLE production_plan
+DROP CONSTRAINT IF EXISTS production_plan_machine_id_shift_id_plan_date_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS production_plan_site_machine_shift_date_key ON production_plan (site, machine_id, shift_id, plan_date);
+
+ALTER TABLE production_plan
+ADD FOREIGN KEY (site, machine_id) REFERENCES machines (site, id);
+
+ALTER TABLE planned_downtime
+ADD FOREIGN KEY (site, machine_id) REFERENCES machines (site, id);
+
+ALTER TABLE oee_hourly
+DROP CONSTRAINT oee_hourly_pkey,
+ADD PRIMARY KEY (site, machine_id, bucket),
+ADD FOREIGN KEY (site, machine_id) REFERENCES machines (site, id);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+-- Fails if two sites have registered a machine with the same ID.
+ALTER TABLE oee_hourly
+DROP CONSTRAINT IF EXISTS oee_hourly_site_machine_id_fkey,
+DROP CONSTRAINT oee_hourly_pkey;
+
+ALTER TABLE planned_downtime
+DROP CONSTRAINT IF EXISTS planned_downtime_site_machine_id_fkey;
+
+ALTER TABLE production_plan
+DROP CONSTRAINT IF EXISTS production_plan_site_machine_id_fkey;
+
+DROP INDEX IF EXISTS production_plan_site_machine_shift_date_key;
+
+ALTER TABLE machines
+DROP CONSTRAINT machines_pkey,
+ADD PRIMARY KEY (id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS production_plan_machine_id_shift_id_plan_date_idx ON production_plan (machine_id, shift_id, plan_date);
+
+ALTER TABLE production_plan
+ADD FOREIGN KEY (machine_id) REFERENCES machines (id);
+
+ALTER TABLE planned_downtime
+ADD FOREIGN KEY (machine_id) REFERENCES machines (id);
+
+ALTER TABLE oee_hourly
+ADD PRIMARY KEY (machine_id, bucket),
+ADD FOREIGN KEY (machine_id) REFERENCES machines (id);
+
+DROP INDEX IF EXISTS part_measurements_rollup_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS part_measurements_rollup_key ON part_measurements_rollup (machine_id, characteristic, unit, bucket_width, bucket);
+
+DROP INDEX IF EXISTS planned_downtime_machine_time_idx;
+
+CREATE INDEX IF NOT EXISTS planned_downtime_machine_time_idx ON planned_downtime (machine_id, start_time, end_time);
+
+DROP INDEX IF EXISTS operator_events_site_machine_time_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS operator_events_machine_time_key ON operator_events (machine_id, time);
+
+ALTER TABLE part_serials
+DROP CONSTRAINT IF EXISTS part_serials_site_machine_id_time_key,
+ADD CONSTRAINT part_serials_machine_id_time_key UNIQUE (machine_id, time);
+
+DROP INDEX IF EXISTS part_measurements_site_machine_time_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS part_measurements_machine_time_key ON part_measurements (machine_id, time);
+
+DROP INDEX IF EXISTS production_events_site_machine_time_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS production_events_machine_time_key ON production_events (machine_id, time);
+
+DROP INDEX IF EXISTS status_events_site_machine_time_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS status_events_machine_time_key ON status_events (machine_id, time);
+
+ALTER TABLE part_measurements_rollup
+DROP COLUMN IF EXISTS site;
+
+ALTER TABLE production_plan
+DROP COLUMN IF EXISTS site;
+
+ALTER TABLE oee_hourly
+DROP COLUMN IF EXISTS site;
+
+ALTER TABLE planned_downtime
+DROP COLUMN IF EXISTS site;
+
+ALTER TABLE operator_events
+DROP COLUMN IF EXISTS site;
+
+ALTER TABLE part_serials
+DROP COLUMN IF EXISTS site;
+
+ALTER TABLE part_measurements
+DROP COLUMN IF EXISTS site;
+
+ALTER TABLE production_events
+DROP COLUMN IF EXISTS site;
+
+ALTER TABLE status_events
+DROP COLUMN IF EXISTS site;
+
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Verdicts of inspection stations downstream of the machines, each on the
+-- part whose production event has the same site, machine_id and time
+-- produced_at.
+CREATE TABLE IF NOT EXISTS inspection_events (
+    time timestamptz NOT NULL,
+    site text NOT NULL DEFAULT '',
+    machine_id integer NOT NULL,
+    produced_at timestamptz NOT NULL,
+    serial text NOT NULL DEFAULT '',
+    result text NOT NULL CHECK (result IN ('pass', 'fail')),
+    defect_code text NOT NULL DEFAULT '',
+    raw_payload jsonb
+  )
+WITH
+  (tsdb.hypertable, tsdb.partition_column = 'time');
+
+CREATE UNIQUE INDEX IF NOT EXISTS inspection_events_site_machine_time_key ON inspection_events (site, machine_id, time);
+
+-- The API joins a part's latest inspection to its production event.
+CREATE INDEX IF NOT EXISTS inspection_events_part_idx ON inspection_events (site, machine_id, produced_at, time DESC);
+
+-- Kept as long as the production events they grade.
+SELECT
+  add_retention_policy ('inspection_events', INTERVAL '30 days');
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS inspection_events;
+
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE machines
+ADD COLUMN IF NOT EXISTS last_seen_at timestamp with time zone;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE machines
+DROP COLUMN IF EXISTS last_seen_at;
+
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS downtime_annotations (
+    site text NOT NULL DEFAULT '',
+    machine_id INT NOT NULL,
+    start_time timestamptz NOT NULL,
+    reason text NOT NULL DEFAULT '',
+    comment text NOT NULL DEFAULT '',
+    planned boolean,
+    annotated_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (site, machine_id, start_time),
+    FOREIGN KEY (site, machine_id) REFERENCES machines (site, id)
+  );
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS downtime_annotations;
+
+-- +goose StatementEnd
\ No newline at end of file
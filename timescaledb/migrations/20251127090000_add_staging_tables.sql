@@ -0,0 +1,81 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Staging tables for INGEST_STAGING. Events land here, numbered by staged_id,
+-- and the ingestion service moves them to the event table of the same name
+-- without the prefix. Plain tables with no unique key, so inserts don't wait
+-- on hypertable chunks or indexes; duplicates are handled on promotion. They
+-- are logged, not UNLOGGED, since an unlogged table is emptied after a crash.
+-- Rows are deleted as fast as they arrive, so vacuum runs on a fixed number
+-- of dead rows rather than a fraction of a table that is usually near empty.
+CREATE TABLE IF NOT EXISTS staged_status_events (
+    staged_id bigserial PRIMARY KEY,
+    time timestamptz NOT NULL,
+    site text NOT NULL DEFAULT '',
+    machine_id integer NOT NULL,
+    status text NOT NULL,
+    reason text NOT NULL DEFAULT '',
+    suspect boolean NOT NULL DEFAULT false,
+    raw_payload jsonb
+  )
+WITH
+  (autovacuum_vacuum_scale_factor = 0, autovacuum_vacuum_threshold = 10000);
+
+CREATE TABLE IF NOT EXISTS staged_production_events (
+    staged_id bigserial PRIMARY KEY,
+    time timestamptz NOT NULL,
+    site text NOT NULL DEFAULT '',
+    machine_id integer NOT NULL,
+    parts_produced integer NOT NULL,
+    parts_scrapped integer NOT NULL,
+    parts_reworked integer NOT NULL DEFAULT 0,
+    lot_id text NOT NULL DEFAULT '',
+    product text NOT NULL DEFAULT '',
+    sample_weight integer NOT NULL DEFAULT 1,
+    cycle bigint,
+    warmup boolean NOT NULL DEFAULT false,
+    planned_slowdown double precision,
+    parts_total bigint,
+    raw_payload jsonb
+  )
+WITH
+  (autovacuum_vacuum_scale_factor = 0, autovacuum_vacuum_threshold = 10000);
+
+CREATE TABLE IF NOT EXISTS staged_part_measurements (
+    staged_id bigserial PRIMARY KEY,
+    time timestamptz NOT NULL,
+    site text NOT NULL DEFAULT '',
+    machine_id integer NOT NULL,
+    characteristic text NOT NULL,
+    unit text NOT NULL DEFAULT '',
+    value double precision NOT NULL,
+    lower_spec double precision NOT NULL,
+    upper_spec double precision NOT NULL,
+    out_of_spec boolean NOT NULL
+  )
+WITH
+  (autovacuum_vacuum_scale_factor = 0, autovacuum_vacuum_threshold = 10000);
+
+CREATE TABLE IF NOT EXISTS staged_operator_events (
+    staged_id bigserial PRIMARY KEY,
+    time timestamptz NOT NULL,
+    site text NOT NULL DEFAULT '',
+    machine_id integer NOT NULL,
+    operator_id text NOT NULL,
+    shift text NOT NULL DEFAULT '',
+    raw_payload jsonb
+  )
+WITH
+  (autovacuum_vacuum_scale_factor = 0, autovacuum_vacuum_threshold = 10000);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS staged_status_events;
+
+DROP TABLE IF EXISTS staged_production_events;
+
+DROP TABLE IF EXISTS staged_part_measurements;
+
+DROP TABLE IF EXISTS staged_operator_events;
+
+-- +goose StatementEnd
\ No newline at end of file